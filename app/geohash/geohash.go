@@ -0,0 +1,183 @@
+// Package geohash encodes coordinates as the 52 bit geohashes Redis stores
+// as sorted set scores, and finds the geohash areas covering a search shape.
+//
+// A geohash of step n splits longitude and latitude in 2^n intervals each and
+// interleaves the n bits of both interval indexes, latitude in the even bits
+// and longitude in the odd ones.
+package geohash
+
+import (
+	"math"
+)
+
+const (
+	// Bits per coordinate of the geohashes stored as scores
+	MaxStep = 26
+
+	LonMin = -180.0
+	LonMax = 180.0
+	// Latitudes are limited to the ones of the Web Mercator projection
+	LatMin = -85.05112878
+	LatMax = 85.05112878
+
+	// Earth's quadratic mean radius for WGS-84, the one Redis uses
+	EarthRadius = 6372797.560856
+	// Half the circumference of the Web Mercator projection in meters
+	mercatorMax = 20037726.37
+)
+
+// Hash is a geohash with step bits per coordinate.
+type Hash struct {
+	Bits uint64
+	Step uint
+}
+
+func (h Hash) zero() bool {
+	return h.Bits == 0 && h.Step == 0
+}
+
+// Range is an interval of longitudes or latitudes.
+type Range struct {
+	Min, Max float64
+}
+
+var (
+	lonRange = Range{LonMin, LonMax}
+	latRange = Range{LatMin, LatMax}
+)
+
+// Area is the rectangle of coordinates a geohash stands for.
+type Area struct {
+	Lon, Lat Range
+}
+
+// Valid reports whether the coordinates can be encoded.
+func Valid(lon, lat float64) bool {
+	return lon >= LonMin && lon <= LonMax && lat >= LatMin && lat <= LatMax
+}
+
+// Spreads the 32 bits of x to the even bits of the result
+func spread(x uint32) uint64 {
+	v := uint64(x)
+	v = (v | v<<16) & 0x0000ffff0000ffff
+	v = (v | v<<8) & 0x00ff00ff00ff00ff
+	v = (v | v<<4) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v<<2) & 0x3333333333333333
+	v = (v | v<<1) & 0x5555555555555555
+	return v
+}
+
+// Gathers the even bits of v, the inverse of spread
+func squash(v uint64) uint32 {
+	v &= 0x5555555555555555
+	v = (v | v>>1) & 0x3333333333333333
+	v = (v | v>>2) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v>>4) & 0x00ff00ff00ff00ff
+	v = (v | v>>8) & 0x0000ffff0000ffff
+	v = (v | v>>16) & 0x00000000ffffffff
+	return uint32(v)
+}
+
+func encode(lonR, latR Range, lon, lat float64, step uint) (Hash, bool) {
+	if !Valid(lon, lat) || lon < lonR.Min || lon > lonR.Max || lat < latR.Min || lat > latR.Max {
+		return Hash{}, false
+	}
+	latOffset := (lat - latR.Min) / (latR.Max - latR.Min) * float64(uint64(1)<<step)
+	lonOffset := (lon - lonR.Min) / (lonR.Max - lonR.Min) * float64(uint64(1)<<step)
+	return Hash{Bits: spread(uint32(latOffset)) | spread(uint32(lonOffset))<<1, Step: step}, true
+}
+
+// Encode returns the geohash of the coordinates with step bits per
+// coordinate. ok is false for coordinates out of range.
+func Encode(lon, lat float64, step uint) (Hash, bool) {
+	return encode(lonRange, latRange, lon, lat, step)
+}
+
+func decode(lonR, latR Range, h Hash) Area {
+	latIndex, lonIndex := float64(squash(h.Bits)), float64(squash(h.Bits>>1))
+	n := float64(uint64(1) << h.Step)
+	return Area{
+		Lon: Range{lonR.Min + lonIndex/n*(lonR.Max-lonR.Min), lonR.Min + (lonIndex+1)/n*(lonR.Max-lonR.Min)},
+		Lat: Range{latR.Min + latIndex/n*(latR.Max-latR.Min), latR.Min + (latIndex+1)/n*(latR.Max-latR.Min)},
+	}
+}
+
+// Decode returns the area of a geohash.
+func Decode(h Hash) Area {
+	return decode(lonRange, latRange, h)
+}
+
+// Center returns the coordinates at the center of the area.
+func (a Area) Center() (lon, lat float64) {
+	lon = max(min((a.Lon.Min+a.Lon.Max)/2, LonMax), LonMin)
+	lat = max(min((a.Lat.Min+a.Lat.Max)/2, LatMax), LatMin)
+	return lon, lat
+}
+
+// Score returns the sorted set score of the coordinates.
+func Score(lon, lat float64) (float64, bool) {
+	h, ok := Encode(lon, lat, MaxStep)
+	return float64(h.Bits), ok
+}
+
+// FromScore returns the coordinates stored as a sorted set score, the
+// center of the area of its geohash.
+func FromScore(score float64) (lon, lat float64) {
+	return Decode(Hash{Bits: uint64(score), Step: MaxStep}).Center()
+}
+
+// ScoreRange returns the scores of the coordinates within the area of h,
+// from min inclusive to max exclusive.
+func (h Hash) ScoreRange() (min, max float64) {
+	shift := 2 * (MaxStep - h.Step)
+	return float64(h.Bits << shift), float64((h.Bits + 1) << shift)
+}
+
+const base32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// String returns the standard 11 character geohash of the coordinates
+// stored as a sorted set score. Standard geohashes span latitudes from -90
+// to 90, so the score is decoded and encoded again.
+func String(score float64) string {
+	lon, lat := FromScore(score)
+	h, _ := encode(lonRange, Range{-90, 90}, lon, lat, MaxStep)
+	buf := make([]byte, 11)
+	for i := range buf {
+		// The 52 bits fill 10 characters and a bit, the last one is 0
+		idx := 0
+		if i < 10 {
+			idx = int(h.Bits>>(52-(i+1)*5)) & 0x1f
+		}
+		buf[i] = base32[idx]
+	}
+	return string(buf)
+}
+
+// Radians per degree
+const radians = math.Pi / 180
+
+func toRadians(d float64) float64 {
+	return d * radians
+}
+
+func toDegrees(r float64) float64 {
+	return r / radians
+}
+
+// latDistance returns the distance in meters between two latitudes along a
+// meridian.
+func latDistance(lat1, lat2 float64) float64 {
+	return EarthRadius * math.Abs(toRadians(lat2)-toRadians(lat1))
+}
+
+// Distance returns the haversine distance in meters between two points.
+func Distance(lon1, lat1, lon2, lat2 float64) float64 {
+	v := math.Sin((toRadians(lon2) - toRadians(lon1)) / 2)
+	if v == 0 {
+		return latDistance(lat1, lat2)
+	}
+	lat1r, lat2r := toRadians(lat1), toRadians(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * EarthRadius * math.Asin(math.Sqrt(a))
+}
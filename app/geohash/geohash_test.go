@@ -0,0 +1,89 @@
+package geohash
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	score, ok := Score(13.361389, 38.115556)
+	if !ok || score != 3479099956230698 {
+		t.Fatalf("Unexpected score: %v", score)
+	}
+	lon, lat := FromScore(score)
+	if math.Abs(lon-13.361389) > 1e-5 || math.Abs(lat-38.115556) > 1e-5 {
+		t.Errorf("Unexpected coordinates decoded: %v, %v", lon, lat)
+	}
+	if _, ok := Score(181, 0); ok {
+		t.Error("Encoded a longitude out of range")
+	}
+	if _, ok := Score(0, 86); ok {
+		t.Error("Encoded a latitude out of range")
+	}
+	if s := String(score); s != "sqc8b49rny0" {
+		t.Errorf("Unexpected geohash string: %s", s)
+	}
+}
+
+func TestNeighbors(t *testing.T) {
+	h, _ := Encode(10, 10, 5)
+	n := h.neighbors()
+	for _, c := range []struct {
+		name       string
+		h          Hash
+		dLon, dLat float64
+	}{
+		{"north", n.north, 0, 1},
+		{"south", n.south, 0, -1},
+		{"east", n.east, 1, 0},
+		{"west", n.west, -1, 0},
+		{"north east", n.northEast, 1, 1},
+		{"south west", n.southWest, -1, -1},
+	} {
+		a, b := Decode(h), Decode(c.h)
+		width, height := a.Lon.Max-a.Lon.Min, a.Lat.Max-a.Lat.Min
+		if math.Abs(b.Lon.Min-a.Lon.Min-c.dLon*width) > 1e-9 || math.Abs(b.Lat.Min-a.Lat.Min-c.dLat*height) > 1e-9 {
+			t.Errorf("Unexpected %s neighbor area: %+v of %+v", c.name, b, a)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	palermo, _ := Score(13.361389, 38.115556)
+	catania, _ := Score(15.087269, 37.502669)
+	lon1, lat1 := FromScore(palermo)
+	lon2, lat2 := FromScore(catania)
+	if d := Distance(lon1, lat1, lon2, lat2); math.Abs(d-166274.1516) > 1e-4 {
+		t.Errorf("Unexpected distance: %f", d)
+	}
+}
+
+func TestShape(t *testing.T) {
+	circle := Shape{Lon: 15, Lat: 37, Radius: 200000}
+	if d, ok := circle.Contains(15.087269, 37.502669); !ok || math.Abs(d-56441.3) > 1 {
+		t.Errorf("Unexpected distance within the circle: %f, %v", d, ok)
+	}
+	if _, ok := circle.Contains(17.24151, 38.788135); ok {
+		t.Error("Point out of the circle is within it")
+	}
+	box := Shape{Lon: 15, Lat: 37, Box: true, Width: 400000, Height: 400000}
+	if _, ok := box.Contains(17.24151, 38.788135); !ok {
+		t.Error("Point within the box is out of it")
+	}
+	areas := circle.Areas()
+	if len(areas) == 0 || len(areas) > 9 {
+		t.Fatalf("Unexpected number of areas: %d", len(areas))
+	}
+	// Every point within the shape is in one of the areas
+	for _, p := range [][2]float64{{15, 37}, {15.087269, 37.502669}, {13.361389, 38.115556}, {15, 35.3}, {17.2, 37}} {
+		score, _ := Score(p[0], p[1])
+		found := false
+		for _, a := range areas {
+			min, max := a.ScoreRange()
+			found = found || (score >= min && score < max)
+		}
+		if !found {
+			t.Errorf("No area covers %v", p)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package geohash
+
+import (
+	"math"
+)
+
+// Shape is the area of a geo search around a center: a circle of Radius,
+// or with Box a rectangle of Width by Height, all in meters.
+type Shape struct {
+	Lon, Lat      float64
+	Box           bool
+	Radius        float64
+	Width, Height float64
+}
+
+// Contains reports whether the point is within the shape, and its distance
+// from the center in meters.
+func (s Shape) Contains(lon, lat float64) (float64, bool) {
+	if !s.Box {
+		d := Distance(s.Lon, s.Lat, lon, lat)
+		return d, d <= s.Radius
+	}
+	// The latitude distance is cheaper, so it is checked first
+	if latDistance(lat, s.Lat) > s.Height/2 {
+		return 0, false
+	}
+	if Distance(lon, lat, s.Lon, lat) > s.Width/2 {
+		return 0, false
+	}
+	return Distance(s.Lon, s.Lat, lon, lat), true
+}
+
+// Returns the longitudes and latitudes bounding the shape
+func (s Shape) bounds() (lonR, latR Range) {
+	width, height := s.Radius, s.Radius
+	if s.Box {
+		width, height = s.Width/2, s.Height/2
+	}
+	latDelta := toDegrees(height / EarthRadius)
+	lonDeltaTop := toDegrees(width / EarthRadius / math.Cos(toRadians(s.Lat+latDelta)))
+	lonDeltaBottom := toDegrees(width / EarthRadius / math.Cos(toRadians(s.Lat-latDelta)))
+	// Meridians converge towards the pole, so the edge closer to the
+	// equator is the narrower one
+	lonDelta := lonDeltaTop
+	if s.Lat < 0 {
+		lonDelta = lonDeltaBottom
+	}
+	return Range{s.Lon - lonDelta, s.Lon + lonDelta}, Range{s.Lat - latDelta, s.Lat + latDelta}
+}
+
+// Returns the step of the geohash areas to search for a radius: the
+// smallest areas that still cover it in most cases
+func estimateStep(radius, lat float64) uint {
+	if radius == 0 {
+		return MaxStep
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	step -= 2
+	// Areas get narrower towards the poles
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	return uint(min(max(step, 1), MaxStep))
+}
+
+// Moves h by d areas east or west
+func (h Hash) moveX(d int) Hash {
+	x := h.Bits & 0xaaaaaaaaaaaaaaaa
+	y := h.Bits & 0x5555555555555555
+	zz := uint64(0x5555555555555555) >> (64 - h.Step*2)
+	if d > 0 {
+		x += zz + 1
+	} else {
+		x |= zz
+		x -= zz + 1
+	}
+	x &= 0xaaaaaaaaaaaaaaaa >> (64 - h.Step*2)
+	return Hash{Bits: x | y, Step: h.Step}
+}
+
+// Moves h by d areas north or south
+func (h Hash) moveY(d int) Hash {
+	x := h.Bits & 0xaaaaaaaaaaaaaaaa
+	y := h.Bits & 0x5555555555555555
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - h.Step*2)
+	if d > 0 {
+		y += zz + 1
+	} else {
+		y |= zz
+		y -= zz + 1
+	}
+	y &= 0x5555555555555555 >> (64 - h.Step*2)
+	return Hash{Bits: x | y, Step: h.Step}
+}
+
+// The eight neighbors of an area
+type neighbors struct {
+	north, south, east, west                   Hash
+	northEast, northWest, southEast, southWest Hash
+}
+
+func (h Hash) neighbors() neighbors {
+	return neighbors{
+		north:     h.moveY(1),
+		south:     h.moveY(-1),
+		east:      h.moveX(1),
+		west:      h.moveX(-1),
+		northEast: h.moveX(1).moveY(1),
+		northWest: h.moveX(-1).moveY(1),
+		southEast: h.moveX(1).moveY(-1),
+		southWest: h.moveX(-1).moveY(-1),
+	}
+}
+
+// Areas returns the geohash areas to search for the points within the
+// shape, in the order Redis searches them: the area of the center, then
+// its neighbors that overlap the shape.
+func (s Shape) Areas() []Hash {
+	lonR, latR := s.bounds()
+	radius := s.Radius
+	if s.Box {
+		// The distance from the center to a corner
+		radius = math.Sqrt(s.Width/2*s.Width/2 + s.Height/2*s.Height/2)
+	}
+	step := estimateStep(radius, s.Lat)
+	h, _ := Encode(s.Lon, s.Lat, step)
+	n := h.neighbors()
+	// Near the edge of the center area the neighbors may not reach far
+	// enough, then larger areas are needed
+	if step > 1 && (Decode(n.north).Lat.Max < latR.Max || Decode(n.south).Lat.Min > latR.Min ||
+		Decode(n.east).Lon.Max < lonR.Max || Decode(n.west).Lon.Min > lonR.Min) {
+		step--
+		h, _ = Encode(s.Lon, s.Lat, step)
+		n = h.neighbors()
+	}
+	// Leave out the neighbors beyond the shape
+	if step >= 2 {
+		area := Decode(h)
+		if area.Lat.Min < latR.Min {
+			n.south, n.southWest, n.southEast = Hash{}, Hash{}, Hash{}
+		}
+		if area.Lat.Max > latR.Max {
+			n.north, n.northEast, n.northWest = Hash{}, Hash{}, Hash{}
+		}
+		if area.Lon.Min < lonR.Min {
+			n.west, n.southWest, n.northWest = Hash{}, Hash{}, Hash{}
+		}
+		if area.Lon.Max > lonR.Max {
+			n.east, n.southEast, n.northEast = Hash{}, Hash{}, Hash{}
+		}
+	}
+	// With huge radiuses adjacent neighbors can be the same area, then a
+	// neighbor equal to the previous one searched is skipped, as in Redis
+	areas := []Hash{h}
+	for _, a := range []Hash{n.north, n.south, n.east, n.west, n.northEast, n.northWest, n.southEast, n.southWest} {
+		if a.zero() || (len(areas) > 1 && areas[len(areas)-1] == a) {
+			continue
+		}
+		areas = append(areas, a)
+	}
+	return areas
+}
@@ -0,0 +1,336 @@
+// Package hyperloglog implements the HyperLogLog cardinality estimator with
+// the same binary layout Redis uses, so the values stored by PFADD can be
+// read and written as plain strings by both.
+//
+// A value starts with a 16 byte header: the "HYLL" magic, the encoding, three
+// unused bytes and the cached cardinality as a little endian uint64 whose
+// most significant bit marks the cache as stale. The 16384 registers follow,
+// either dense as 6 bit integers or sparse as a run length encoding.
+package hyperloglog
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+const (
+	// Bits of the hash that select a register
+	precision = 14
+	// Number of registers
+	Registers = 1 << precision
+	// Bits of the hash that are counted into a register
+	hashBits = 64 - precision
+	// Bits per register of the dense encoding
+	registerBits = 6
+	registerMax  = 1<<registerBits - 1
+
+	headerSize = 16
+	denseSize  = headerSize + (Registers*registerBits+7)/8
+	// Sparse values grow into the dense encoding beyond this size, like
+	// Redis' hll-sparse-max-bytes
+	SparseMaxBytes = 3000
+
+	encodingDense  = 0
+	encodingSparse = 1
+
+	// Bias correction constant for an infinite number of registers
+	alphaInf = 0.721347520444481703680
+)
+
+var magic = []byte("HYLL")
+
+var (
+	// ErrInvalid is returned for strings that are not HyperLogLog values.
+	ErrInvalid = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	// ErrCorrupted is returned for sparse values whose registers don't add
+	// up.
+	ErrCorrupted = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+// HLL is a HyperLogLog value.
+type HLL struct {
+	b []byte
+}
+
+// New returns an empty HyperLogLog in the sparse encoding.
+func New() *HLL {
+	b := make([]byte, headerSize, headerSize+2)
+	copy(b, magic)
+	b[4] = encodingSparse
+	return &HLL{b: appendZeros(b, Registers)}
+}
+
+// Parse checks that s holds a HyperLogLog value and returns a copy of it.
+// Corruption of sparse registers is only detected when they are read.
+func Parse(s string) (*HLL, error) {
+	if len(s) < headerSize || s[:4] != string(magic) {
+		return nil, ErrInvalid
+	}
+	switch s[4] {
+	case encodingDense:
+		if len(s) != denseSize {
+			return nil, ErrInvalid
+		}
+	case encodingSparse:
+	default:
+		return nil, ErrInvalid
+	}
+	return &HLL{b: []byte(s)}, nil
+}
+
+// String returns the binary value.
+func (h *HLL) String() string {
+	return string(h.b)
+}
+
+// Dense reports whether h uses the dense encoding.
+func (h *HLL) Dense() bool {
+	return h.b[4] == encodingDense
+}
+
+// Marks the cached cardinality as stale
+func (h *HLL) invalidate() {
+	h.b[15] |= 1 << 7
+}
+
+// Hashes an element into the index of its register and the count to store
+// there: the position of the first set bit of the remaining hash bits
+func hash(elem string) (int, uint8) {
+	x := murmurHash64A(elem, 0xadc83b19)
+	index := int(x & (Registers - 1))
+	x >>= precision
+	// Caps the count when all remaining bits are 0
+	x |= 1 << hashBits
+	return index, uint8(bits.TrailingZeros64(x) + 1)
+}
+
+// Add adds an element and reports whether a register changed.
+func (h *HLL) Add(elem string) (bool, error) {
+	index, count := hash(elem)
+	changed, err := h.set(index, count)
+	if changed {
+		h.invalidate()
+	}
+	return changed, err
+}
+
+// Raises register index to count
+func (h *HLL) set(index int, count uint8) (bool, error) {
+	if h.Dense() {
+		regs := h.b[headerSize:]
+		if denseGet(regs, index) >= count {
+			return false, nil
+		}
+		denseSet(regs, index, count)
+		return true, nil
+	}
+	return h.sparseSet(index, count)
+}
+
+// Count returns the estimated cardinality. The estimate is cached in the
+// header, so h changes when the cache was stale.
+func (h *HLL) Count() (uint64, error) {
+	card := h.b[8:headerSize]
+	if card[7]&(1<<7) == 0 {
+		return binary.LittleEndian.Uint64(card), nil
+	}
+	var histogram [64]int
+	if h.Dense() {
+		regs := h.b[headerSize:]
+		for i := 0; i < Registers; i++ {
+			histogram[denseGet(regs, i)]++
+		}
+	} else {
+		err := h.sparseRuns(func(first, n int, v uint8) {
+			histogram[v] += n
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := estimate(&histogram)
+	binary.LittleEndian.PutUint64(card, n)
+	return n, nil
+}
+
+// Merge raises every register of regs to the one of h.
+func (h *HLL) Merge(regs *RegisterSet) error {
+	if h.Dense() {
+		b := h.b[headerSize:]
+		for i := range regs {
+			regs[i] = max(regs[i], denseGet(b, i))
+		}
+		return nil
+	}
+	return h.sparseRuns(func(first, n int, v uint8) {
+		if v == 0 {
+			return
+		}
+		for i := first; i < first+n; i++ {
+			regs[i] = max(regs[i], v)
+		}
+	})
+}
+
+// Store raises the registers of h to the ones of regs, in the dense
+// encoding when dense is set. Like PFMERGE, the dense registers are
+// overwritten, so regs should already include the ones of h.
+func (h *HLL) Store(regs *RegisterSet, dense bool) error {
+	if dense {
+		if err := h.ToDense(); err != nil {
+			return err
+		}
+		b := h.b[headerSize:]
+		for i, v := range regs {
+			denseSet(b, i, v)
+		}
+	} else {
+		for i, v := range regs {
+			if v == 0 {
+				continue
+			}
+			if _, err := h.set(i, v); err != nil {
+				return err
+			}
+		}
+	}
+	h.invalidate()
+	return nil
+}
+
+// ToDense converts h to the dense encoding.
+func (h *HLL) ToDense() error {
+	if h.Dense() {
+		return nil
+	}
+	b := make([]byte, denseSize)
+	copy(b, h.b[:headerSize])
+	b[4] = encodingDense
+	regs := b[headerSize:]
+	err := h.sparseRuns(func(first, n int, v uint8) {
+		if v == 0 {
+			return
+		}
+		for i := first; i < first+n; i++ {
+			denseSet(regs, i, v)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	h.b = b
+	return nil
+}
+
+// RegisterSet holds the registers of one or more merged HyperLogLogs.
+type RegisterSet [Registers]uint8
+
+// Count returns the estimated cardinality of the union of the merged
+// HyperLogLogs.
+func (r *RegisterSet) Count() uint64 {
+	var histogram [64]int
+	for _, v := range r {
+		histogram[v]++
+	}
+	return estimate(&histogram)
+}
+
+// Estimates the cardinality from the histogram of register values, with the
+// improved estimator of Otmar Ertl's "New cardinality estimation algorithms
+// for HyperLogLog sketches" that Redis uses
+func estimate(histogram *[64]int) uint64 {
+	m := float64(Registers)
+	z := m * tau((m-float64(histogram[hashBits+1]))/m)
+	for j := hashBits; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * sigma(float64(histogram[0])/m)
+	return uint64(math.Round(alphaInf * m * m / z))
+}
+
+func tau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if z == prev {
+			return z / 3
+		}
+	}
+}
+
+func sigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if z == prev {
+			return z
+		}
+	}
+}
+
+// Registers of the dense encoding are packed 6 bits each, least significant
+// bit first.
+
+func denseGet(regs []byte, i int) uint8 {
+	pos := i * registerBits
+	b, shift := pos/8, pos&7
+	v := uint(regs[b]) >> shift
+	if b+1 < len(regs) {
+		v |= uint(regs[b+1]) << (8 - shift)
+	}
+	return uint8(v & registerMax)
+}
+
+func denseSet(regs []byte, i int, v uint8) {
+	pos := i * registerBits
+	b, shift := pos/8, pos&7
+	regs[b] &^= registerMax << shift
+	regs[b] |= v << shift
+	if b+1 < len(regs) {
+		regs[b+1] &^= registerMax >> (8 - shift)
+		regs[b+1] |= v >> (8 - shift)
+	}
+}
+
+// MurmurHash64A by Austin Appleby, reading the input as little endian like
+// Redis does on every platform
+func murmurHash64A(s string, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ uint64(len(s))*m
+	for len(s) >= 8 {
+		k := uint64(s[0]) | uint64(s[1])<<8 | uint64(s[2])<<16 | uint64(s[3])<<24 |
+			uint64(s[4])<<32 | uint64(s[5])<<40 | uint64(s[6])<<48 | uint64(s[7])<<56
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		s = s[8:]
+	}
+	if len(s) > 0 {
+		for i := len(s) - 1; i >= 0; i-- {
+			h ^= uint64(s[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
@@ -0,0 +1,149 @@
+package hyperloglog
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestMurmurHash64A(t *testing.T) {
+	// Values of the reference implementation with the seed Redis uses
+	cases := map[string]uint64{
+		"":                  15627466953755236146,
+		"a":                 6039968161137406375,
+		"foo":               16592960565925911732,
+		"hello world":       12184977182547125431,
+		"0123456789abcdef!": 13337350489090520692,
+	}
+	for s, expected := range cases {
+		if got := murmurHash64A(s, 0xadc83b19); got != expected {
+			t.Errorf("%q: unexpected hash %d, expected %d", s, got, expected)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if got := New().String(); got != "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff" {
+		t.Errorf("Unexpected empty value: %q", got)
+	}
+	if n, err := New().Count(); err != nil || n != 0 {
+		t.Errorf("Unexpected count of an empty value: %d, %v", n, err)
+	}
+}
+
+func TestSparseLayout(t *testing.T) {
+	h := New()
+	// "a" sets register 12711 to 2
+	if changed, err := h.Add("a"); !changed || err != nil {
+		t.Fatalf("Unexpected result of adding: %v, %v", changed, err)
+	}
+	if got := h.String()[16:]; got != "\x71\xa6\x84\x4e\x57" {
+		t.Errorf("Unexpected opcodes: %q", got)
+	}
+	var regs RegisterSet
+	h.Merge(&regs)
+	if regs[12711] != 2 {
+		t.Errorf("Unexpected register value: %d", regs[12711])
+	}
+}
+
+func TestAddAndCount(t *testing.T) {
+	h := New()
+	for _, n := range []int{10, 100, 1000, 10000, 100000} {
+		for i := 0; i < n; i++ {
+			h.Add(strconv.Itoa(i))
+		}
+		got, err := h.Count()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The standard error with 16384 registers is 0.81%
+		if math.Abs(float64(got)-float64(n)) > float64(n)*0.03 {
+			t.Errorf("Estimate %d too far from %d", got, n)
+		}
+		if n <= 100 && h.Dense() {
+			t.Errorf("Converted to dense with %d elements", n)
+		}
+	}
+	if !h.Dense() {
+		t.Error("Still sparse with 100000 elements")
+	}
+	if changed, _ := h.Add("1"); changed {
+		t.Error("Adding an element twice changed a register")
+	}
+}
+
+func TestCountCache(t *testing.T) {
+	h := New()
+	h.Add("a")
+	if h.b[15]&0x80 == 0 {
+		t.Error("Cache is valid after adding")
+	}
+	n, _ := h.Count()
+	if n != 1 || h.b[8] != 1 || h.b[15] != 0 {
+		t.Errorf("Unexpected cache after counting: %q", h.b[8:16])
+	}
+}
+
+func TestSparseMatchesDense(t *testing.T) {
+	sparse, dense := New(), New()
+	dense.ToDense()
+	for i := 0; i < 3000; i++ {
+		sparse.Add("e" + strconv.Itoa(i))
+		dense.Add("e" + strconv.Itoa(i))
+	}
+	var r1, r2 RegisterSet
+	if err := sparse.Merge(&r1); err != nil {
+		t.Fatal(err)
+	}
+	dense.Merge(&r2)
+	if r1 != r2 {
+		t.Error("Sparse and dense registers differ")
+	}
+	if !sparse.Dense() && len(sparse.b) > SparseMaxBytes {
+		t.Errorf("Sparse value grew to %d bytes", len(sparse.b))
+	}
+	sparse.ToDense()
+	if sparse.String()[16:] != dense.String()[16:] {
+		t.Error("Converted sparse value differs from the dense one")
+	}
+}
+
+func TestMergeAndStore(t *testing.T) {
+	a, b := New(), New()
+	for i := 0; i < 500; i++ {
+		a.Add(strconv.Itoa(i))
+		b.Add(strconv.Itoa(i + 250))
+	}
+	var regs RegisterSet
+	a.Merge(&regs)
+	b.Merge(&regs)
+	if n := regs.Count(); n < 735 || n > 765 {
+		t.Errorf("Unexpected count of the union: %d", n)
+	}
+	dst := New()
+	dst.Store(&regs, false)
+	if n, _ := dst.Count(); n != regs.Count() || dst.Dense() {
+		t.Errorf("Unexpected count of the sparse merge: %d", n)
+	}
+	dst = New()
+	dst.Store(&regs, true)
+	if n, _ := dst.Count(); n != regs.Count() || !dst.Dense() {
+		t.Errorf("Unexpected count of the dense merge: %d", n)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{"", "HYLL", "HYLX\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff", "HYLL\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff"} {
+		if _, err := Parse(s); err != ErrInvalid {
+			t.Errorf("%q: unexpected error %v", s, err)
+		}
+	}
+	h, err := Parse("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x7f\xfe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Count(); err != ErrCorrupted {
+		t.Errorf("Unexpected error counting a corrupted value: %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package hyperloglog
+
+// The sparse encoding is a sequence of opcodes covering all registers in
+// order:
+//
+//	00xxxxxx          ZERO: xxxxxx+1 registers set to 0, up to 64
+//	01xxxxxx yyyyyyyy XZERO: xxxxxxyyyyyyyy+1 registers set to 0, up to 16384
+//	1vvvvvxx          VAL: xx+1 registers set to vvvvv+1, up to 4 of up to 32
+//
+// Registers set to a value above 32 need the dense encoding.
+
+const (
+	sparseZeroMaxLen  = 64
+	sparseXZeroMaxLen = 16384
+	sparseValMaxLen   = 4
+	sparseValMaxValue = 32
+)
+
+func isZero(op byte) bool {
+	return op&0xc0 == 0
+}
+
+func isXZero(op byte) bool {
+	return op&0xc0 == 0x40
+}
+
+func isVal(op byte) bool {
+	return op&0x80 != 0
+}
+
+func valValue(op byte) uint8 {
+	return (op>>2)&0x1f + 1
+}
+
+func valLen(op byte) int {
+	return int(op&0x3) + 1
+}
+
+func valOp(v uint8, n int) byte {
+	return (v-1)<<2 | byte(n-1) | 0x80
+}
+
+// Appends ZERO or XZERO opcodes for n registers
+func appendZeros(b []byte, n int) []byte {
+	for n > 0 {
+		l := min(n, sparseXZeroMaxLen)
+		if l > sparseZeroMaxLen {
+			b = append(b, byte((l-1)>>8)|0x40, byte(l-1))
+		} else {
+			b = append(b, byte(l-1))
+		}
+		n -= l
+	}
+	return b
+}
+
+// Decodes the opcode at the start of b into its length in bytes, the number
+// of registers it covers and their value
+func sparseOp(b []byte) (size, n int, v uint8) {
+	switch op := b[0]; {
+	case isZero(op):
+		return 1, int(op&0x3f) + 1, 0
+	case isXZero(op):
+		if len(b) < 2 {
+			return 1, 0, 0
+		}
+		return 2, (int(op&0x3f)<<8 | int(b[1])) + 1, 0
+	default:
+		return 1, valLen(op), valValue(op)
+	}
+}
+
+// Calls f with the runs of registers of the sparse encoding, starting at
+// register first
+func (h *HLL) sparseRuns(f func(first, n int, v uint8)) error {
+	ops := h.b[headerSize:]
+	i := 0
+	for len(ops) > 0 {
+		size, n, v := sparseOp(ops)
+		if n == 0 || i+n > Registers {
+			return ErrCorrupted
+		}
+		f(i, n, v)
+		i += n
+		ops = ops[size:]
+	}
+	if i != Registers {
+		return ErrCorrupted
+	}
+	return nil
+}
+
+// Raises register index of the sparse encoding to count, rewriting the
+// opcode that covers it the way Redis does so both produce the same bytes.
+// The value is converted to the dense encoding when count doesn't fit or
+// when it would grow beyond SparseMaxBytes.
+func (h *HLL) sparseSet(index int, count uint8) (bool, error) {
+	if count > sparseValMaxValue {
+		return h.promote(index, count)
+	}
+	// Find the opcode covering index, and the one before it
+	start, end := headerSize, len(h.b)
+	p, prev, first := start, -1, 0
+	var size, span int
+	var v uint8
+	for p < end {
+		size, span, v = sparseOp(h.b[p:end])
+		if span == 0 {
+			return false, ErrCorrupted
+		}
+		if index <= first+span-1 {
+			break
+		}
+		prev = p
+		p += size
+		first += span
+	}
+	if p >= end {
+		return false, ErrCorrupted
+	}
+	op := h.b[p]
+
+	switch {
+	case isVal(op) && v >= count:
+		return false, nil
+	case span == 1 && !isXZero(op):
+		// A single register ZERO or VAL opcode is updated in place
+		h.b[p] = valOp(count, 1)
+	default:
+		// Split the run into up to three opcodes: the registers before
+		// index, index itself and the ones after it
+		var seq []byte
+		last := first + span - 1
+		if isVal(op) {
+			if index != first {
+				seq = append(seq, valOp(v, index-first))
+			}
+			seq = append(seq, valOp(count, 1))
+			if index != last {
+				seq = append(seq, valOp(v, last-index))
+			}
+		} else {
+			seq = appendZeros(seq, index-first)
+			seq = append(seq, valOp(count, 1))
+			seq = appendZeros(seq, last-index)
+		}
+		if len(seq) > size && len(h.b)+len(seq)-size > SparseMaxBytes {
+			return h.promote(index, count)
+		}
+		b := make([]byte, 0, len(h.b)+len(seq)-size)
+		b = append(b, h.b[:p]...)
+		b = append(b, seq...)
+		h.b = append(b, h.b[p+size:]...)
+	}
+
+	// Merge adjacent VAL opcodes of the same value, scanning up to five
+	// opcodes from the one before the change
+	p = start
+	if prev >= 0 {
+		p = prev
+	}
+	for scan := 0; p < len(h.b) && scan < 5; scan++ {
+		op := h.b[p]
+		if isXZero(op) {
+			p += 2
+			continue
+		}
+		if isZero(op) {
+			p++
+			continue
+		}
+		if p+1 < len(h.b) && isVal(h.b[p+1]) && valValue(op) == valValue(h.b[p+1]) {
+			if n := valLen(op) + valLen(h.b[p+1]); n <= sparseValMaxLen {
+				h.b[p+1] = valOp(valValue(op), n)
+				h.b = append(h.b[:p], h.b[p+1:]...)
+				continue
+			}
+		}
+		p++
+	}
+	return true, nil
+}
+
+// Converts to the dense encoding and sets register index there
+func (h *HLL) promote(index int, count uint8) (bool, error) {
+	if err := h.ToDense(); err != nil {
+		return false, err
+	}
+	return h.set(index, count)
+}
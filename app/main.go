@@ -1,17 +1,22 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"io/fs"
 	"log/slog"
 	"os"
+	"path/filepath"
 
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
 	"github.com/codecrafters-io/redis-starter-go/app/server"
 	"github.com/codecrafters-io/redis-starter-go/app/service"
 	"github.com/codecrafters-io/redis-starter-go/app/storage"
 )
 
 func main() {
-	var port int
+	var port, databases int
+	var dir, dbfilename, save string
 	var replica server.Replica
 
 	for i, arg := range os.Args {
@@ -22,12 +27,37 @@ func main() {
 	}
 
 	flag.IntVar(&port, "port", 6379, "set port of the server")
+	flag.IntVar(&databases, "databases", 16, "set number of logical databases")
+	flag.StringVar(&dir, "dir", ".", "set directory of the RDB file")
+	flag.StringVar(&dbfilename, "dbfilename", "dump.rdb", "set name of the RDB file")
+	flag.StringVar(&save, "save", "3600 1 300 100 60 10000", "set save policies as pairs of seconds and changes, empty to disable")
 	flag.String("replicaof", "replicaof", "replicaof")
 	flag.Parse()
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
-	kv := storage.NewKeyValue()
-	service := service.NewServerService(port, *logger, kv, replica)
-	err := service.Start()
+	if databases < 1 {
+		logger.Error("databases must be at least 1", "databases", databases)
+		os.Exit(1)
+	}
+	policies, err := server.ParseSavePolicies(save)
+	if err != nil {
+		logger.Error("invalid save policies", "error", err.Error())
+		os.Exit(1)
+	}
+	dbs := storage.NewDatabases(databases)
+	path := filepath.Join(dir, dbfilename)
+	err = rdb.LoadFile(path, dbs)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		logger.Info("no RDB file to load, starting empty", "path", path)
+	case err != nil:
+		logger.Error("error loading RDB file", "path", path, "error", err.Error())
+		os.Exit(1)
+	default:
+		logger.Info("DB loaded from disk", "path", path)
+	}
+	persistence := server.Persistence{Dir: dir, DBFilename: dbfilename, Save: policies}
+	service := service.NewServerService(port, *logger, dbs, replica, persistence)
+	err = service.Start()
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,12 @@
+package rdb
+
+import "hash/crc64"
+
+// Redis checksums with the Jones polynomial, reflected, without the initial
+// and final inversion of hash/crc64. The table takes the polynomial in
+// reversed bit order.
+var crcTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+func crcUpdate(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crcTable, p)
+}
@@ -0,0 +1,317 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+)
+
+// The compact encodings Redis keeps small values in are saved as blobs.
+// The decoders below return their elements as strings, integers in decimal.
+
+// Decodes a listpack: a header with the total bytes and the element count,
+// the elements, each followed by its length for backward traversal, and an
+// end byte.
+func decodeListpack(b []byte) ([]string, error) {
+	if len(b) < 7 || int(binary.LittleEndian.Uint32(b)) != len(b) {
+		return nil, ErrCorrupt
+	}
+	var res []string
+	p := 6
+	for {
+		if p >= len(b) {
+			return nil, ErrCorrupt
+		}
+		enc := b[p]
+		if enc == 0xff {
+			break
+		}
+		v, size, err := listpackEntry(b[p:])
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+		p += size + listpackBacklenSize(size)
+	}
+	// The count saturates at 65535, then it has to be counted
+	if n := binary.LittleEndian.Uint16(b[4:]); n != 65535 && int(n) != len(res) {
+		return nil, ErrCorrupt
+	}
+	return res, nil
+}
+
+// Returns the element at the start of b and the bytes of its encoding and
+// data
+func listpackEntry(b []byte) (string, int, error) {
+	enc := b[0]
+	var header, n int
+	switch {
+	case enc&0x80 == 0:
+		return strconv.Itoa(int(enc & 0x7f)), 1, nil
+	case enc&0xc0 == 0x80:
+		header, n = 1, int(enc&0x3f)
+	case enc&0xe0 == 0xc0:
+		if len(b) < 2 {
+			return "", 0, ErrCorrupt
+		}
+		return strconv.FormatInt(signExtend(uint64(enc&0x1f)<<8|uint64(b[1]), 13), 10), 2, nil
+	case enc&0xf0 == 0xe0:
+		if len(b) < 2 {
+			return "", 0, ErrCorrupt
+		}
+		header, n = 2, int(enc&0x0f)<<8|int(b[1])
+	case enc == 0xf0:
+		if len(b) < 5 {
+			return "", 0, ErrCorrupt
+		}
+		header, n = 5, int(binary.LittleEndian.Uint32(b[1:]))
+	case enc >= 0xf1 && enc <= 0xf4:
+		size := [...]int{2, 3, 4, 8}[enc-0xf1]
+		if len(b) < 1+size {
+			return "", 0, ErrCorrupt
+		}
+		return strconv.FormatInt(signExtend(littleEndian(b[1:1+size]), size*8), 10), 1 + size, nil
+	default:
+		return "", 0, ErrCorrupt
+	}
+	if n < 0 || len(b) < header+n {
+		return "", 0, ErrCorrupt
+	}
+	return string(b[header : header+n]), header + n, nil
+}
+
+// Bytes of the backward length of an element of size bytes, 7 bits each.
+// The limits are off by one from the powers of two, as in Redis.
+func listpackBacklenSize(size int) int {
+	switch {
+	case size <= 127:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	}
+	return 5
+}
+
+func littleEndian(b []byte) uint64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
+
+// Interprets the low bits of v as a two's complement number
+func signExtend(v uint64, bits int) int64 {
+	shift := 64 - bits
+	return int64(v<<shift) >> shift
+}
+
+// Decodes a ziplist, the predecessor of the listpack: a header with the
+// total bytes, the offset of the last element and the count, then elements
+// prefixed by the length of the previous one, and an end byte.
+func decodeZiplist(b []byte) ([]string, error) {
+	if len(b) < 11 || int(binary.LittleEndian.Uint32(b)) != len(b) {
+		return nil, ErrCorrupt
+	}
+	var res []string
+	p := 10
+	for {
+		if p >= len(b) {
+			return nil, ErrCorrupt
+		}
+		if b[p] == 0xff {
+			break
+		}
+		// Skip the length of the previous element
+		if b[p] == 0xfe {
+			p += 5
+		} else {
+			p++
+		}
+		if p >= len(b) {
+			return nil, ErrCorrupt
+		}
+		v, size, err := ziplistEntry(b[p:])
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+		p += size
+	}
+	if n := binary.LittleEndian.Uint16(b[8:]); n != 65535 && int(n) != len(res) {
+		return nil, ErrCorrupt
+	}
+	return res, nil
+}
+
+// Returns the element at the start of b, after its previous length, and
+// the bytes of its encoding and data
+func ziplistEntry(b []byte) (string, int, error) {
+	enc := b[0]
+	var header, n int
+	switch enc >> 6 {
+	case 0:
+		header, n = 1, int(enc&0x3f)
+	case 1:
+		if len(b) < 2 {
+			return "", 0, ErrCorrupt
+		}
+		header, n = 2, int(enc&0x3f)<<8|int(b[1])
+	case 2:
+		if len(b) < 5 {
+			return "", 0, ErrCorrupt
+		}
+		header, n = 5, int(binary.BigEndian.Uint32(b[1:]))
+	default:
+		var size int
+		switch enc {
+		case 0xc0:
+			size = 2
+		case 0xd0:
+			size = 4
+		case 0xe0:
+			size = 8
+		case 0xf0:
+			size = 3
+		case 0xfe:
+			size = 1
+		default:
+			// 4 bit immediates from 0 to 12 are stored as 1 to 13
+			if enc >= 0xf1 && enc <= 0xfd {
+				return strconv.Itoa(int(enc&0x0f) - 1), 1, nil
+			}
+			return "", 0, ErrCorrupt
+		}
+		if len(b) < 1+size {
+			return "", 0, ErrCorrupt
+		}
+		return strconv.FormatInt(signExtend(littleEndian(b[1:1+size]), size*8), 10), 1 + size, nil
+	}
+	if n < 0 || len(b) < header+n {
+		return "", 0, ErrCorrupt
+	}
+	return string(b[header : header+n]), header + n, nil
+}
+
+// Decodes an intset: the byte width of the integers, their count, and the
+// sorted integers in little endian.
+func decodeIntset(b []byte) ([]string, error) {
+	if len(b) < 8 {
+		return nil, ErrCorrupt
+	}
+	width := int(binary.LittleEndian.Uint32(b))
+	n := int(binary.LittleEndian.Uint32(b[4:]))
+	if (width != 2 && width != 4 && width != 8) || len(b) != 8+width*n {
+		return nil, ErrCorrupt
+	}
+	res := make([]string, n)
+	for i := range res {
+		v := littleEndian(b[8+i*width : 8+(i+1)*width])
+		res[i] = strconv.FormatInt(signExtend(v, width*8), 10)
+	}
+	return res, nil
+}
+
+// Decodes a zipmap, the hash encoding of RDB versions before 4: a count,
+// then keys and values prefixed by their length, values also by the free
+// bytes after them, and an end byte. The fields and values are returned
+// interleaved.
+func decodeZipmap(b []byte) ([]string, error) {
+	var res []string
+	p := 1
+	readLen := func() (int, bool) {
+		if p >= len(b) {
+			return 0, false
+		}
+		if b[p] < 254 {
+			p++
+			return int(b[p-1]), true
+		}
+		if b[p] == 254 && p+5 <= len(b) {
+			n := int(binary.LittleEndian.Uint32(b[p+1:]))
+			p += 5
+			return n, true
+		}
+		return 0, false
+	}
+	for p < len(b) && b[p] != 0xff {
+		n, ok := readLen()
+		if !ok || p+n > len(b) {
+			return nil, ErrCorrupt
+		}
+		field := string(b[p : p+n])
+		p += n
+		n, ok = readLen()
+		if !ok || p+1+n > len(b) {
+			return nil, ErrCorrupt
+		}
+		free := int(b[p])
+		p++
+		res = append(res, field, string(b[p:p+n]))
+		p += n + free
+	}
+	if p >= len(b) {
+		return nil, ErrCorrupt
+	}
+	return res, nil
+}
+
+// Encodes elements as a listpack, integers in their shortest encoding
+func encodeListpack(elements []string) []byte {
+	b := make([]byte, 6, 16)
+	for _, e := range elements {
+		start := len(b)
+		b = appendListpackElement(b, e)
+		b = appendListpackBacklen(b, len(b)-start)
+	}
+	b = append(b, 0xff)
+	binary.LittleEndian.PutUint32(b, uint32(len(b)))
+	binary.LittleEndian.PutUint16(b[4:], uint16(min(len(elements), 65535)))
+	return b
+}
+
+func appendListpackElement(b []byte, e string) []byte {
+	if v, err := strconv.ParseInt(e, 10, 64); err == nil && strconv.FormatInt(v, 10) == e {
+		switch {
+		case v >= 0 && v <= 127:
+			return append(b, byte(v))
+		case v >= -4096 && v <= 4095:
+			return append(b, 0xc0|byte(v>>8)&0x1f, byte(v))
+		case v >= math.MinInt16 && v <= math.MaxInt16:
+			return binary.LittleEndian.AppendUint16(append(b, 0xf1), uint16(v))
+		case v >= -1<<23 && v < 1<<23:
+			return append(b, 0xf2, byte(v), byte(v>>8), byte(v>>16))
+		case v >= math.MinInt32 && v <= math.MaxInt32:
+			return binary.LittleEndian.AppendUint32(append(b, 0xf3), uint32(v))
+		}
+		return binary.LittleEndian.AppendUint64(append(b, 0xf4), uint64(v))
+	}
+	switch n := len(e); {
+	case n < 1<<6:
+		b = append(b, 0x80|byte(n))
+	case n < 1<<12:
+		b = append(b, 0xe0|byte(n>>8), byte(n))
+	default:
+		b = binary.LittleEndian.AppendUint32(append(b, 0xf0), uint32(n))
+	}
+	return append(b, e...)
+}
+
+// Appends the backward length of an element of size bytes, most
+// significant 7 bits first, all bytes but the first one flagged with the
+// high bit
+func appendListpackBacklen(b []byte, size int) []byte {
+	n := listpackBacklenSize(size)
+	for i := n - 1; i >= 0; i-- {
+		v := byte(size>>(7*i)) & 0x7f
+		if i < n-1 {
+			v |= 0x80
+		}
+		b = append(b, v)
+	}
+	return b
+}
@@ -0,0 +1,126 @@
+package rdb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCRC64(t *testing.T) {
+	// The check value of Redis' crc64.c
+	if got := crcUpdate(0, []byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("Unexpected checksum %016x", got)
+	}
+	// Checksumming in parts gives the same result
+	if got := crcUpdate(crcUpdate(0, []byte("1234")), []byte("56789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("Unexpected checksum in parts %016x", got)
+	}
+}
+
+func TestLZFDecompress(t *testing.T) {
+	tests := []struct {
+		in       []byte
+		expected string
+	}{
+		// A literal run
+		{[]byte{0x02, 'a', 'b', 'c'}, "abc"},
+		// A literal and a long back reference overlapping its own output
+		{[]byte{0x00, 'a', 0xe0, 0x0a, 0x00}, strings.Repeat("a", 20)},
+		// A short back reference three bytes back
+		{[]byte{0x02, 'a', 'b', 'c', 0x20, 0x02}, "abcabc"},
+	}
+	for _, tt := range tests {
+		got, err := lzfDecompress(tt.in, len(tt.expected))
+		if err != nil || string(got) != tt.expected {
+			t.Errorf("%x: Expected: %q, Got: %q, %v", tt.in, tt.expected, got, err)
+		}
+	}
+	for _, in := range [][]byte{{0x05, 'a'}, {0x20, 0x00}, {0x00, 'a', 0x20}} {
+		if _, err := lzfDecompress(in, 8); err == nil {
+			t.Errorf("%x: expected an error", in)
+		}
+	}
+	// A literal and the longest back reference
+	in := []byte{0x00, 'a', 0xe0, 0xff, 0x00}
+	if got, err := lzfDecompress(in, 265); err != nil || got[264] != 'a' {
+		t.Errorf("Unexpected result for the longest back reference: %v", err)
+	}
+	if _, err := lzfDecompress(in, 1<<31-1); err != ErrCorrupt {
+		t.Errorf("Expected an error for a length the input can't produce, got %v", err)
+	}
+}
+
+func TestDecodeListpack(t *testing.T) {
+	lp := []byte{
+		0x1b, 0, 0, 0, 5, 0,
+		0x81, 'a', 0x02, // 6 bit string
+		0x0c, 0x01, // 7 bit unsigned
+		0xdf, 0xff, 0x02, // 13 bit signed
+		0x85, 'h', 'e', 'l', 'l', 'o', 0x06,
+		0xf2, 0x40, 0x42, 0x0f, 0x04, // 24 bit signed
+		0xff,
+	}
+	got, err := decodeListpack(lp)
+	expected := []string{"a", "12", "-1", "hello", "1000000"}
+	if err != nil || !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %q, Got: %q, %v", expected, got, err)
+	}
+	// A wrong element count
+	lp[4] = 4
+	if _, err := decodeListpack(lp); err == nil {
+		t.Error("Expected an error for the wrong count")
+	}
+	if _, err := decodeListpack(lp[:20]); err == nil {
+		t.Error("Expected an error for a truncated listpack")
+	}
+}
+
+func TestDecodeListpack_LongString(t *testing.T) {
+	s := strings.Repeat("x", 200)
+	lp := []byte{0, 0, 0, 0, 1, 0, 0xe0, 200}
+	lp = append(lp, s...)
+	// 202 bytes need two bytes of backward length
+	lp = append(lp, 0x01, 0xca, 0xff)
+	lp[0] = byte(len(lp))
+	got, err := decodeListpack(lp)
+	if err != nil || len(got) != 1 || got[0] != s {
+		t.Errorf("Unexpected elements %q, %v", got, err)
+	}
+}
+
+func TestDecodeZiplist(t *testing.T) {
+	zl := []byte{
+		0x17, 0, 0, 0, 0x12, 0, 0, 0, 4, 0,
+		0x00, 0x01, 'a', // 6 bit string
+		0x03, 0xf6, // 4 bit immediate
+		0x02, 0xfe, 0xfe, // 8 bit
+		0x03, 0xc0, 0x2c, 0x01, // 16 bit
+		0xff,
+	}
+	got, err := decodeZiplist(zl)
+	expected := []string{"a", "5", "-2", "300"}
+	if err != nil || !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %q, Got: %q, %v", expected, got, err)
+	}
+}
+
+func TestDecodeIntset(t *testing.T) {
+	is := []byte{2, 0, 0, 0, 3, 0, 0, 0, 0xfb, 0xff, 0x01, 0x00, 0x2c, 0x01}
+	got, err := decodeIntset(is)
+	expected := []string{"-5", "1", "300"}
+	if err != nil || !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %q, Got: %q, %v", expected, got, err)
+	}
+	if _, err := decodeIntset(is[:12]); err == nil {
+		t.Error("Expected an error for a truncated intset")
+	}
+}
+
+func TestDecodeZipmap(t *testing.T) {
+	zm := []byte{2, 1, 'a', 1, 0, '1', 2, 'b', 'b', 2, 1, '2', '2', 0, 0xff}
+	got, err := decodeZipmap(zm)
+	expected := []string{"a", "1", "bb", "22"}
+	if err != nil || !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %q, Got: %q, %v", expected, got, err)
+	}
+}
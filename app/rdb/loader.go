@@ -0,0 +1,709 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// LoadFile loads the RDB file at path into dbs. The error wraps
+// fs.ErrNotExist when there is no such file.
+func LoadFile(path string, dbs []*storage.KeyValue) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return Load(f, dbs)
+}
+
+// Load reads an RDB file into dbs, database n of the file into dbs[n]. Keys
+// that expired already are left out, unless the keyspace keeps them for
+// its master to delete. The checksum is verified unless the file was saved
+// without one.
+func Load(r io.Reader, dbs []*storage.KeyValue) error {
+	l := &loader{r: newReader(r), dbs: dbs, now: time.Now().UnixMilli()}
+	if err := l.load(); err != nil {
+		return fmt.Errorf("loading RDB: %w", err)
+	}
+	return nil
+}
+
+type loader struct {
+	r       *reader
+	dbs     []*storage.KeyValue
+	now     int64
+	version int
+	db      int
+}
+
+func (l *loader) load() error {
+	header, err := l.r.readFull(9)
+	if err != nil {
+		return err
+	}
+	if string(header[:5]) != "REDIS" {
+		return fmt.Errorf("wrong signature %q", header[:5])
+	}
+	l.version, err = strconv.Atoi(string(header[5:]))
+	if err != nil || l.version < 1 || l.version > Version {
+		return fmt.Errorf("unsupported version %q", header[5:])
+	}
+	var expireAt int64
+	for {
+		op, err := l.r.readByte()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case opEOF:
+			return l.verifyChecksum()
+		case opSelectDB:
+			db, err := l.r.readLength()
+			if err != nil {
+				return err
+			}
+			if db >= uint64(len(l.dbs)) {
+				return fmt.Errorf("database %d is out of range, only %d are configured", db, len(l.dbs))
+			}
+			l.db = int(db)
+		case opResizeDB:
+			// Size hints of the keyspace and the expiry index
+			for range 2 {
+				if _, err := l.r.readLength(); err != nil {
+					return err
+				}
+			}
+		case opAux:
+			// Fields like redis-ver and ctime, nothing to restore
+			for range 2 {
+				if _, err := l.r.readString(); err != nil {
+					return err
+				}
+			}
+		case opFunction2:
+			// The code of a function library, functions aren't supported
+			if _, err := l.r.readString(); err != nil {
+				return err
+			}
+		case opExpireTime:
+			sec, err := l.r.readUint32()
+			if err != nil {
+				return err
+			}
+			expireAt = int64(sec) * 1000
+		case opExpireTimeMs:
+			ms, err := l.r.readUint64()
+			if err != nil {
+				return err
+			}
+			expireAt = int64(ms)
+		case opFreq:
+			if _, err := l.r.readByte(); err != nil {
+				return err
+			}
+		case opIdle:
+			if _, err := l.r.readLength(); err != nil {
+				return err
+			}
+		case opModuleAux, typeModule, typeModule2:
+			return fmt.Errorf("modules are not supported")
+		default:
+			if err := l.loadKey(op, expireAt); err != nil {
+				return err
+			}
+			expireAt = 0
+		}
+	}
+}
+
+// The checksum follows the EOF opcode from version 5 on, 0 when the file
+// was saved without one
+func (l *loader) verifyChecksum() error {
+	if l.version < 5 {
+		return nil
+	}
+	computed := l.r.crc
+	b, err := l.r.readFull(8)
+	if err != nil {
+		return err
+	}
+	if sum := binary.LittleEndian.Uint64(b); sum != 0 && sum != computed {
+		return fmt.Errorf("wrong checksum %016x, expected %016x", sum, computed)
+	}
+	return nil
+}
+
+func (l *loader) loadKey(typ byte, expireAt int64) error {
+	key, err := l.r.readString()
+	if err != nil {
+		return err
+	}
+	vt, v, err := l.readValue(typ)
+	if err != nil {
+		return fmt.Errorf("key %q: %w", key, err)
+	}
+	// Redis never stores empty collections, only streams may be empty
+	if c, ok := v.(interface{ Len() int }); ok && c.Len() == 0 && vt != storage.TypeStream {
+		return nil
+	}
+	if l.expired(expireAt) {
+		return nil
+	}
+	return l.dbs[l.db].Update([]string{key}, func(tx *storage.Tx) error {
+		tx.PutExpireAt(key, vt, v, expireAt)
+		return nil
+	})
+}
+
+// Reports whether a key or field that expires at unix time at in
+// milliseconds is left out: its time is over and the keyspace doesn't keep
+// such keys, see storage.KeyValue.KeepExpired
+func (l *loader) expired(at int64) bool {
+	return at != 0 && at <= l.now && !l.dbs[l.db].KeepsExpired()
+}
+
+// Reads a value of the given type
+func (l *loader) readValue(typ byte) (storage.ValueType, any, error) {
+	switch typ {
+	case typeString:
+		s, err := l.r.readString()
+		return storage.TypeString, s, err
+	case typeList:
+		elements, err := l.readStrings(1)
+		return storage.TypeList, newList(elements), err
+	case typeListZiplist:
+		elements, err := l.readBlob(decodeZiplist)
+		return storage.TypeList, newList(elements), err
+	case typeListQuicklist, typeListQuicklist2:
+		elements, err := l.readQuicklist(typ)
+		return storage.TypeList, newList(elements), err
+	case typeSet:
+		members, err := l.readStrings(1)
+		return storage.TypeSet, newSet(members), err
+	case typeSetIntset:
+		members, err := l.readBlob(decodeIntset)
+		return storage.TypeSet, newSet(members), err
+	case typeSetListpack:
+		members, err := l.readBlob(decodeListpack)
+		return storage.TypeSet, newSet(members), err
+	case typeZSet, typeZSet2:
+		z, err := l.readZSet(typ)
+		return storage.TypeZSet, z, err
+	case typeZSetZiplist:
+		pairs, err := l.readBlob(decodeZiplist)
+		if err != nil {
+			return storage.TypeZSet, nil, err
+		}
+		z, err := newZSet(pairs)
+		return storage.TypeZSet, z, err
+	case typeZSetListpack:
+		pairs, err := l.readBlob(decodeListpack)
+		if err != nil {
+			return storage.TypeZSet, nil, err
+		}
+		z, err := newZSet(pairs)
+		return storage.TypeZSet, z, err
+	case typeHash:
+		pairs, err := l.readStrings(2)
+		if err != nil {
+			return storage.TypeHash, nil, err
+		}
+		h, err := newHash(pairs)
+		return storage.TypeHash, h, err
+	case typeHashZipmap:
+		pairs, err := l.readBlob(decodeZipmap)
+		if err != nil {
+			return storage.TypeHash, nil, err
+		}
+		h, err := newHash(pairs)
+		return storage.TypeHash, h, err
+	case typeHashZiplist:
+		pairs, err := l.readBlob(decodeZiplist)
+		if err != nil {
+			return storage.TypeHash, nil, err
+		}
+		h, err := newHash(pairs)
+		return storage.TypeHash, h, err
+	case typeHashListpack:
+		pairs, err := l.readBlob(decodeListpack)
+		if err != nil {
+			return storage.TypeHash, nil, err
+		}
+		h, err := newHash(pairs)
+		return storage.TypeHash, h, err
+	case typeHashMetadata:
+		h, err := l.readHashMetadata()
+		return storage.TypeHash, h, err
+	case typeHashListpackEx:
+		h, err := l.readHashListpackEx()
+		return storage.TypeHash, h, err
+	case typeStreamListpacks, typeStreamListpacks2, typeStreamListpacks3:
+		s, err := l.readStream(typ)
+		return storage.TypeStream, s, err
+	}
+	return 0, nil, fmt.Errorf("unknown value type %d", typ)
+}
+
+// Reads a count and then count*per strings
+func (l *loader) readStrings(per int) ([]string, error) {
+	n, err := l.r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, min(n*per, 1<<16))
+	for range n * per {
+		s, err := l.r.readString()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+// Reads a string and decodes the blob it holds
+func (l *loader) readBlob(decode func([]byte) ([]string, error)) ([]string, error) {
+	s, err := l.r.readString()
+	if err != nil {
+		return nil, err
+	}
+	return decode([]byte(s))
+}
+
+// Reads the nodes of a quicklist: ziplists, or from quicklist 2 on either
+// listpacks or single plain elements
+func (l *loader) readQuicklist(typ byte) ([]string, error) {
+	n, err := l.r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for range n {
+		container := uint64(quicklistNodePacked)
+		if typ == typeListQuicklist2 {
+			if container, err = l.r.readLength(); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case container == quicklistNodePlain:
+			s, err := l.r.readString()
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, s)
+		case container == quicklistNodePacked && typ == typeListQuicklist:
+			elements, err := l.readBlob(decodeZiplist)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, elements...)
+		case container == quicklistNodePacked:
+			elements, err := l.readBlob(decodeListpack)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, elements...)
+		default:
+			return nil, ErrCorrupt
+		}
+	}
+	return res, nil
+}
+
+func (l *loader) readZSet(typ byte) (*storage.ZSet, error) {
+	n, err := l.r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	z := storage.NewZSet()
+	for range n {
+		member, err := l.r.readString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if typ == typeZSet2 {
+			score, err = l.r.readDouble()
+		} else {
+			score, err = l.r.readDoubleString()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(score) {
+			return nil, ErrCorrupt
+		}
+		z.Add(member, score)
+	}
+	return z, nil
+}
+
+func newList(elements []string) *storage.List {
+	list := storage.NewList()
+	for _, e := range elements {
+		list.PushBack(e)
+	}
+	return list
+}
+
+func newSet(members []string) *storage.Set {
+	s := storage.NewSet()
+	for _, m := range members {
+		s.Add(m)
+	}
+	return s
+}
+
+// Builds a sorted set from members interleaved with their scores
+func newZSet(pairs []string) (*storage.ZSet, error) {
+	if len(pairs)%2 != 0 {
+		return nil, ErrCorrupt
+	}
+	z := storage.NewZSet()
+	for i := 0; i < len(pairs); i += 2 {
+		score, err := strconv.ParseFloat(pairs[i+1], 64)
+		if err != nil || math.IsNaN(score) {
+			return nil, ErrCorrupt
+		}
+		z.Add(pairs[i], score)
+	}
+	return z, nil
+}
+
+// Builds a hash from fields interleaved with their values
+func newHash(pairs []string) (*storage.Hash, error) {
+	if len(pairs)%2 != 0 {
+		return nil, ErrCorrupt
+	}
+	h := storage.NewHash()
+	for i := 0; i < len(pairs); i += 2 {
+		h.Set(pairs[i], pairs[i+1])
+	}
+	return h, nil
+}
+
+// Reads a hash with field TTLs: the earliest TTL of its fields, then every
+// field preceded by its TTL relative to that one, 0 for no TTL. Fields whose
+// TTL is over are left out.
+func (l *loader) readHashMetadata() (*storage.Hash, error) {
+	minExpire, err := l.r.readUint64()
+	if err != nil {
+		return nil, err
+	}
+	n, err := l.r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	h := storage.NewHash()
+	for range n {
+		ttl, err := l.r.readLength()
+		if err != nil {
+			return nil, err
+		}
+		field, err := l.r.readString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := l.r.readString()
+		if err != nil {
+			return nil, err
+		}
+		var at int64
+		if ttl != 0 {
+			at = int64(ttl + minExpire - 1)
+		}
+		l.setHashField(h, field, value, at)
+	}
+	return h, nil
+}
+
+// Reads a hash with field TTLs in a listpack: the earliest TTL of its
+// fields, then the listpack of fields, values and absolute TTLs, 0 for no
+// TTL
+func (l *loader) readHashListpackEx() (*storage.Hash, error) {
+	if _, err := l.r.readUint64(); err != nil {
+		return nil, err
+	}
+	triples, err := l.readBlob(decodeListpack)
+	if err != nil {
+		return nil, err
+	}
+	if len(triples)%3 != 0 {
+		return nil, ErrCorrupt
+	}
+	h := storage.NewHash()
+	for i := 0; i < len(triples); i += 3 {
+		at, err := strconv.ParseInt(triples[i+2], 10, 64)
+		if err != nil || at < 0 {
+			return nil, ErrCorrupt
+		}
+		l.setHashField(h, triples[i], triples[i+1], at)
+	}
+	return h, nil
+}
+
+// Sets a field that expires at unix time at in milliseconds, 0 for none,
+// unless it expired
+func (l *loader) setHashField(h *storage.Hash, field, value string, at int64) {
+	if l.expired(at) {
+		return
+	}
+	h.Set(field, value)
+	if at != 0 {
+		h.SetFieldExpireAt(field, at)
+	}
+}
+
+// Reads a stream ID saved as 16 big endian bytes, as in the keys of the
+// radix tree of listpacks
+func (l *loader) readRawStreamID() (storage.StreamID, error) {
+	b, err := l.r.readFull(16)
+	if err != nil {
+		return storage.StreamID{}, err
+	}
+	return rawStreamID(b), nil
+}
+
+func rawStreamID(b []byte) storage.StreamID {
+	return storage.StreamID{Ms: binary.BigEndian.Uint64(b), Seq: binary.BigEndian.Uint64(b[8:])}
+}
+
+func (l *loader) readStreamID() (storage.StreamID, error) {
+	ms, err := l.r.readLength()
+	if err != nil {
+		return storage.StreamID{}, err
+	}
+	seq, err := l.r.readLength()
+	return storage.StreamID{Ms: ms, Seq: seq}, err
+}
+
+// Reads a stream: its listpacks of entries, the metadata and the consumer
+// groups. Metadata added by later versions is derived for older types.
+func (l *loader) readStream(typ byte) (*storage.Stream, error) {
+	s := storage.NewStream()
+	nodes, err := l.r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	for range nodes {
+		key, err := l.r.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 16 {
+			return nil, ErrCorrupt
+		}
+		lp, err := l.readBlob(decodeListpack)
+		if err != nil {
+			return nil, err
+		}
+		if err := addStreamEntries(s, rawStreamID([]byte(key)), lp); err != nil {
+			return nil, err
+		}
+	}
+	length, err := l.r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	if length != s.Len() {
+		return nil, ErrCorrupt
+	}
+	if s.LastID, err = l.readStreamID(); err != nil {
+		return nil, err
+	}
+	s.EntriesAdded = uint64(length)
+	if typ >= typeStreamListpacks2 {
+		// The first ID is known from the entries
+		if _, err := l.readStreamID(); err != nil {
+			return nil, err
+		}
+		if s.MaxDeletedID, err = l.readStreamID(); err != nil {
+			return nil, err
+		}
+		if s.EntriesAdded, err = l.r.readLength(); err != nil {
+			return nil, err
+		}
+	}
+	groups, err := l.r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	for range groups {
+		if err := l.readConsumerGroup(s, typ); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Adds the entries of a listpack of a stream node. The listpack starts with
+// a master entry: the count of valid and deleted entries and the fields of
+// the first entry. Every entry stores its ID relative to master, and only
+// values when its fields are the master fields. Entries are terminated by
+// the count of their listpack elements.
+func addStreamEntries(s *storage.Stream, master storage.StreamID, lp []string) error {
+	ints := make([]int64, 0, 4)
+	next := func() (int64, bool) {
+		if len(lp) == 0 {
+			return 0, false
+		}
+		v, err := strconv.ParseInt(lp[0], 10, 64)
+		lp = lp[1:]
+		return v, err == nil
+	}
+	header := func(n int) bool {
+		ints = ints[:0]
+		for range n {
+			v, ok := next()
+			if !ok {
+				return false
+			}
+			ints = append(ints, v)
+		}
+		return true
+	}
+	// count, deleted, master fields
+	if !header(3) || ints[2] < 0 || int64(len(lp)) < ints[2]+1 {
+		return ErrCorrupt
+	}
+	masterFields := lp[:ints[2]]
+	lp = lp[ints[2]+1:]
+	for len(lp) > 0 {
+		// flags, ms diff, seq diff
+		if !header(3) {
+			return ErrCorrupt
+		}
+		flags := ints[0]
+		id := storage.StreamID{Ms: master.Ms + uint64(ints[1]), Seq: master.Seq + uint64(ints[2])}
+		var fields []string
+		if flags&streamItemSameFields != 0 {
+			if len(lp) < len(masterFields) {
+				return ErrCorrupt
+			}
+			fields = make([]string, 0, 2*len(masterFields))
+			for i, f := range masterFields {
+				fields = append(fields, f, lp[i])
+			}
+			lp = lp[len(masterFields):]
+		} else {
+			n, ok := next()
+			if !ok || n < 0 || int64(len(lp)) < 2*n {
+				return ErrCorrupt
+			}
+			fields = slices.Clone(lp[:2*n])
+			lp = lp[2*n:]
+		}
+		if _, ok := next(); !ok {
+			return ErrCorrupt
+		}
+		if flags&streamItemDeleted != 0 {
+			continue
+		}
+		if s.Len() > 0 && !s.LastID.Less(id) {
+			return ErrCorrupt
+		}
+		s.Add(id, fields)
+	}
+	return nil
+}
+
+// Reads a consumer group. The pending entries of the group come first with
+// their delivery metadata, then the consumers with the IDs they own.
+func (l *loader) readConsumerGroup(s *storage.Stream, typ byte) error {
+	name, err := l.r.readString()
+	if err != nil {
+		return err
+	}
+	lastID, err := l.readStreamID()
+	if err != nil {
+		return err
+	}
+	entriesRead := int64(storage.InvalidEntriesRead)
+	if typ >= typeStreamListpacks2 {
+		n, err := l.r.readLength()
+		if err != nil {
+			return err
+		}
+		// Saved as unsigned, -1 wraps around
+		entriesRead = int64(n)
+	}
+	g, ok := s.CreateGroup(name, lastID, entriesRead)
+	if !ok {
+		return fmt.Errorf("duplicate consumer group %q", name)
+	}
+	pending, err := l.r.readCount()
+	if err != nil {
+		return err
+	}
+	type delivery struct {
+		time  int64
+		count uint64
+	}
+	deliveries := make(map[storage.StreamID]delivery, min(pending, 1<<16))
+	for range pending {
+		id, err := l.readRawStreamID()
+		if err != nil {
+			return err
+		}
+		t, err := l.r.readUint64()
+		if err != nil {
+			return err
+		}
+		count, err := l.r.readLength()
+		if err != nil {
+			return err
+		}
+		deliveries[id] = delivery{int64(t), count}
+	}
+	consumers, err := l.r.readCount()
+	if err != nil {
+		return err
+	}
+	for range consumers {
+		name, err := l.r.readString()
+		if err != nil {
+			return err
+		}
+		seen, err := l.r.readUint64()
+		if err != nil {
+			return err
+		}
+		active := seen
+		if typ >= typeStreamListpacks3 {
+			if active, err = l.r.readUint64(); err != nil {
+				return err
+			}
+		}
+		c, _ := g.CreateConsumer(name, int64(seen))
+		c.ActiveTime = int64(active)
+		owned, err := l.r.readCount()
+		if err != nil {
+			return err
+		}
+		for range owned {
+			id, err := l.readRawStreamID()
+			if err != nil {
+				return err
+			}
+			d, ok := deliveries[id]
+			if !ok || g.Pending(id) != nil {
+				return fmt.Errorf("consumer %q owns %s, which isn't pending in group %q", name, id, g.Name)
+			}
+			p := g.AddPending(id, c, d.time)
+			p.DeliveryCount = d.count
+		}
+	}
+	if g.PendingLen() != len(deliveries) {
+		return fmt.Errorf("group %q has pending entries without consumer", g.Name)
+	}
+	return nil
+}
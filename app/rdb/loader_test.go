@@ -0,0 +1,440 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// The empty RDB file of Redis 7.2 with its auxiliary fields and checksum
+const emptyRDB = "524544495330303131fa0972656469732d76657205372e322e30fa0a72656469732d62697473c040fa056374696d65c26d08bc65fa08757365642d6d656dc2b0c41000fa08616f662d62617365c000fff06e3bfec0ff5aa2"
+
+// Builds RDB files for tests
+type rdbFile struct {
+	bytes.Buffer
+}
+
+func newRDBFile(version string) *rdbFile {
+	f := &rdbFile{}
+	f.WriteString("REDIS" + version)
+	return f
+}
+
+func (f *rdbFile) length(n int) {
+	switch {
+	case n < 1<<6:
+		f.WriteByte(byte(n))
+	case n < 1<<14:
+		f.WriteByte(0x40 | byte(n>>8))
+		f.WriteByte(byte(n))
+	default:
+		f.WriteByte(0x80)
+		f.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+}
+
+func (f *rdbFile) str(s string) {
+	f.length(len(s))
+	f.WriteString(s)
+}
+
+func (f *rdbFile) key(typ byte, key string) {
+	f.WriteByte(typ)
+	f.str(key)
+}
+
+func (f *rdbFile) ms(t int64) {
+	f.Write(binary.LittleEndian.AppendUint64(nil, uint64(t)))
+}
+
+func (f *rdbFile) rawID(ms, seq uint64) {
+	f.Write(binary.BigEndian.AppendUint64(nil, ms))
+	f.Write(binary.BigEndian.AppendUint64(nil, seq))
+}
+
+// Ends the file with the EOF opcode and the checksum
+func (f *rdbFile) finish() []byte {
+	f.WriteByte(opEOF)
+	f.Write(binary.LittleEndian.AppendUint64(nil, crcUpdate(0, f.Bytes())))
+	return f.Bytes()
+}
+
+// Encodes a listpack of short strings
+func listpack(elements ...string) string {
+	b := []byte{0, 0, 0, 0, byte(len(elements)), 0}
+	for _, e := range elements {
+		b = append(b, 0x80|byte(len(e)))
+		b = append(b, e...)
+		b = append(b, byte(len(e)+1))
+	}
+	b = append(b, 0xff)
+	binary.LittleEndian.PutUint32(b, uint32(len(b)))
+	return string(b)
+}
+
+func TestLoad_Empty(t *testing.T) {
+	b, _ := hex.DecodeString(emptyRDB)
+	dbs := storage.NewDatabases(16)
+	if err := Load(bytes.NewReader(b), dbs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if dbs[0].Len() != 0 {
+		t.Errorf("Unexpected keys: %d", dbs[0].Len())
+	}
+	b[len(b)-1] ^= 1
+	if err := Load(bytes.NewReader(b), dbs); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Expected a checksum error, Got: %v", err)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"signature", []byte("RESID0011\xff")},
+		{"version", []byte("REDIS0013\xff")},
+		{"truncated", []byte("REDIS0011\xfe")},
+		{"database", func() []byte {
+			f := newRDBFile("0011")
+			f.WriteByte(opSelectDB)
+			f.length(16)
+			return f.finish()
+		}()},
+		{"type", func() []byte {
+			f := newRDBFile("0011")
+			f.key(8, "k")
+			return f.finish()
+		}()},
+	}
+	for _, tt := range tests {
+		if err := Load(bytes.NewReader(tt.file), storage.NewDatabases(16)); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+	err := LoadFile(filepath.Join(t.TempDir(), "missing.rdb"), storage.NewDatabases(1))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist, Got: %v", err)
+	}
+}
+
+func TestLoad_Values(t *testing.T) {
+	now := time.Now().UnixMilli()
+	f := newRDBFile("0011")
+	f.WriteByte(opAux)
+	f.str("redis-ver")
+	f.str("7.2.0")
+	f.WriteByte(opSelectDB)
+	f.length(0)
+	f.WriteByte(opResizeDB)
+	f.length(9)
+	f.length(1)
+
+	f.key(typeString, "str")
+	f.str("plain")
+	f.key(typeString, "int")
+	f.Write([]byte{0xc1, 0x39, 0x30}) // 16 bit integer
+	f.key(typeString, "lzf")
+	f.Write([]byte{0xc3, 5, 20, 0x00, 'a', 0xe0, 0x0a, 0x00})
+	f.WriteByte(opExpireTimeMs)
+	f.ms(now + 100000)
+	f.WriteByte(opFreq)
+	f.WriteByte(5)
+	f.key(typeListQuicklist2, "list")
+	f.length(2)
+	f.length(quicklistNodePacked)
+	f.str(listpack("a", "b"))
+	f.length(quicklistNodePlain)
+	f.str("c")
+	f.key(typeSetIntset, "intset")
+	f.str("\x02\x00\x00\x00\x02\x00\x00\x00\x01\x00\x02\x00")
+	f.key(typeSetListpack, "set")
+	f.str(listpack("x", "y"))
+	f.key(typeZSet2, "zset")
+	f.length(2)
+	f.str("m")
+	f.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(1.5)))
+	f.str("n")
+	f.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(math.Inf(1))))
+	f.key(typeZSetListpack, "zlp")
+	f.str(listpack("p", "2", "q", "-0.5"))
+	f.key(typeHashListpack, "hash")
+	f.str(listpack("f", "v"))
+	// Expired keys are skipped
+	f.WriteByte(opExpireTimeMs)
+	f.ms(now - 1000)
+	f.key(typeString, "expired")
+	f.str("x")
+
+	f.WriteByte(opSelectDB)
+	f.length(3)
+	f.WriteByte(opExpireTime)
+	f.Write(binary.LittleEndian.AppendUint32(nil, uint32(now/1000+1000)))
+	f.key(typeList, "old-list")
+	f.length(2)
+	f.str("1")
+	f.str("2")
+	f.key(typeZSet, "old-zset")
+	f.length(1)
+	f.str("m")
+	f.WriteByte(3)
+	f.WriteString("2.5")
+	f.key(typeHash, "old-hash")
+	f.length(1)
+	f.str("f")
+	f.str("v")
+	f.key(typeHashZiplist, "zl-hash")
+	f.str("\x11\x00\x00\x00\x0d\x00\x00\x00\x02\x00\x00\x01f\x03\x01v\xff")
+	f.key(typeSet, "empty")
+	f.length(0)
+
+	dbs := storage.NewDatabases(16)
+	if err := Load(bytes.NewReader(f.finish()), dbs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if dbs[0].Len() != 9 || dbs[3].Len() != 4 {
+		t.Fatalf("Unexpected number of keys: %d and %d", dbs[0].Len(), dbs[3].Len())
+	}
+	dbs[0].View(nil, func(tx *storage.Tx) error {
+		for key, expected := range map[string]string{"str": "plain", "int": "12345", "lzf": strings.Repeat("a", 20)} {
+			if v, _, _ := tx.Get(key); v != expected {
+				t.Errorf("%s: Expected: %q, Got: %q", key, expected, v)
+			}
+		}
+		if at, _ := tx.ExpireAt("list"); at != now+100000 {
+			t.Errorf("Unexpected expiry of list: %d", at)
+		}
+		if l, _ := tx.List("list", false); l == nil || !reflect.DeepEqual(l.Range(0, -1), []string{"a", "b", "c"}) {
+			t.Errorf("Unexpected list: %v", l)
+		}
+		if s, _ := tx.LookupSet("intset", false); s == nil || s.Encoding() != storage.EncodingIntset || !s.Contains("2") {
+			t.Errorf("Unexpected intset: %v", s)
+		}
+		if s, _ := tx.LookupSet("set", false); s == nil || s.Len() != 2 || !s.Contains("y") {
+			t.Errorf("Unexpected set: %v", s)
+		}
+		if z, _ := tx.ZSet("zset", false); z == nil || !reflect.DeepEqual(z.RangeByRank(0, z.Len()-1, false), []storage.ZMember{{Member: "m", Score: 1.5}, {Member: "n", Score: math.Inf(1)}}) {
+			t.Errorf("Unexpected sorted set: %v", z)
+		}
+		if z, _ := tx.ZSet("zlp", false); z == nil || !reflect.DeepEqual(z.RangeByRank(0, z.Len()-1, false), []storage.ZMember{{Member: "q", Score: -0.5}, {Member: "p", Score: 2}}) {
+			t.Errorf("Unexpected listpack sorted set: %v", z)
+		}
+		if h, _ := tx.Hash("hash", false); h == nil || h.Len() != 1 {
+			t.Errorf("Unexpected hash: %v", h)
+		}
+		return nil
+	})
+	dbs[3].View(nil, func(tx *storage.Tx) error {
+		if l, _ := tx.List("old-list", false); l == nil || !reflect.DeepEqual(l.Range(0, -1), []string{"1", "2"}) {
+			t.Errorf("Unexpected list: %v", l)
+		}
+		if at, _ := tx.ExpireAt("old-list"); at != (now/1000+1000)*1000 {
+			t.Errorf("Unexpected expiry of old-list: %d", at)
+		}
+		if z, _ := tx.ZSet("old-zset", false); z == nil {
+			t.Error("Missing old-zset")
+		} else if score, _ := z.Score("m"); score != 2.5 {
+			t.Errorf("Unexpected score: %v", score)
+		}
+		for _, key := range []string{"old-hash", "zl-hash"} {
+			if h, _ := tx.Hash(key, false); h == nil {
+				t.Errorf("Missing %s", key)
+			} else if v, _ := h.Get("f"); v != "v" {
+				t.Errorf("%s: unexpected value %q", key, v)
+			}
+		}
+		return nil
+	})
+}
+
+func TestLoad_HashFieldTTLs(t *testing.T) {
+	now := time.Now().UnixMilli()
+	f := newRDBFile("0012")
+	f.key(typeHashMetadata, "h")
+	f.ms(now + 1000)
+	f.length(3)
+	// No TTL, the earliest TTL and one a second later
+	for _, field := range []struct {
+		ttl         int
+		name, value string
+	}{{0, "a", "1"}, {1, "b", "2"}, {1001, "c", "3"}} {
+		f.length(field.ttl)
+		f.str(field.name)
+		f.str(field.value)
+	}
+	// A field whose TTL is over is left out
+	f.key(typeHashMetadata, "old")
+	f.ms(now - 10)
+	f.length(2)
+	f.length(1)
+	f.str("x")
+	f.str("1")
+	f.length(0)
+	f.str("y")
+	f.str("2")
+	f.key(typeHashListpackEx, "lp")
+	f.ms(now + 5000)
+	f.str(listpack("x", "1", "0", "y", "2", strconv.FormatInt(now+5000, 10), "z", "3", strconv.FormatInt(now-1, 10)))
+	f.key(typeHashListpackEx, "gone")
+	f.ms(now - 1)
+	f.str(listpack("x", "1", strconv.FormatInt(now-1, 10)))
+
+	dbs := storage.NewDatabases(1)
+	if err := Load(bytes.NewReader(f.finish()), dbs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if dbs[0].Len() != 3 {
+		t.Errorf("Unexpected number of keys: %d", dbs[0].Len())
+	}
+	dbs[0].View(nil, func(tx *storage.Tx) error {
+		tests := []struct {
+			key, field string
+			at         int64
+		}{
+			{"h", "a", 0}, {"h", "b", now + 1000}, {"h", "c", now + 2000},
+			{"lp", "x", 0}, {"lp", "y", now + 5000},
+		}
+		for _, tt := range tests {
+			h, _ := tx.Hash(tt.key, false)
+			if h == nil {
+				t.Fatalf("Missing %s", tt.key)
+			}
+			if at, ok := h.FieldExpireAt(tt.field); !ok || at != tt.at {
+				t.Errorf("%s %s: Expected TTL at %d, Got: %d, %v", tt.key, tt.field, tt.at, at, ok)
+			}
+		}
+		for key, n := range map[string]int{"h": 3, "old": 1, "lp": 2} {
+			if h, _ := tx.Hash(key, false); h.Len() != n {
+				t.Errorf("%s: unexpected number of fields %d", key, h.Len())
+			}
+		}
+		return nil
+	})
+}
+
+func TestLoad_KeepsExpired(t *testing.T) {
+	now := time.Now().UnixMilli()
+	f := newRDBFile("0012")
+	f.WriteByte(opExpireTimeMs)
+	f.ms(now - 1000)
+	f.key(typeString, "expired")
+	f.str("x")
+	f.key(typeHashListpackEx, "h")
+	f.ms(now - 1)
+	f.str(listpack("x", "1", strconv.FormatInt(now-1, 10), "y", "2", "0"))
+
+	// A replica loads what expired too, master deletes it later
+	dbs := storage.NewDatabases(1)
+	dbs[0].KeepExpired()
+	if err := Load(bytes.NewReader(f.finish()), dbs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	dbs[0].Update([]string{"expired", "h"}, func(tx *storage.Tx) error {
+		if !tx.Exists("expired") {
+			t.Error("Expired key was left out")
+		}
+		if h, _ := tx.Hash("h", false); h == nil || h.Len() != 2 {
+			t.Errorf("Unexpected hash: %v", h)
+		}
+		return nil
+	})
+}
+
+func TestLoad_Stream(t *testing.T) {
+	f := newRDBFile("0011")
+	f.key(typeStreamListpacks3, "s")
+	f.length(1)
+	f.length(16)
+	f.rawID(1, 0)
+	f.str(listpack(
+		// Master entry: 3 valid, 1 deleted, fields f and g
+		"3", "1", "2", "f", "g", "0",
+		// 1-0 and 1-1 with the master fields
+		"2", "0", "0", "v", "w", "5",
+		"2", "0", "1", "x", "y", "5",
+		// 2-0 with its own fields
+		"0", "1", "0", "1", "h", "z", "6",
+		// 2-1 deleted
+		"3", "1", "1", "q", "r", "5",
+	))
+	f.length(3)
+	// Last ID, first ID, max deleted ID, entries added
+	f.length(2)
+	f.length(1)
+	f.length(1)
+	f.length(0)
+	f.length(2)
+	f.length(1)
+	f.length(4)
+	// A group with two pending entries
+	f.length(1)
+	f.str("g1")
+	f.length(1)
+	f.length(1)
+	f.length(2)
+	f.length(2)
+	f.rawID(1, 0)
+	f.ms(1000)
+	f.length(3)
+	f.rawID(1, 1)
+	f.ms(2000)
+	f.length(1)
+	f.length(2)
+	f.str("alice")
+	f.ms(500)
+	f.ms(400)
+	f.length(1)
+	f.rawID(1, 0)
+	f.str("bob")
+	f.ms(600)
+	f.ms(-1)
+	f.length(1)
+	f.rawID(1, 1)
+
+	dbs := storage.NewDatabases(1)
+	if err := Load(bytes.NewReader(f.finish()), dbs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	dbs[0].View(nil, func(tx *storage.Tx) error {
+		s, _ := tx.Stream("s", false)
+		if s == nil {
+			t.Fatal("Missing stream")
+		}
+		expected := []storage.StreamEntry{
+			{ID: storage.StreamID{Ms: 1, Seq: 0}, Fields: []string{"f", "v", "g", "w"}},
+			{ID: storage.StreamID{Ms: 1, Seq: 1}, Fields: []string{"f", "x", "g", "y"}},
+			{ID: storage.StreamID{Ms: 2, Seq: 0}, Fields: []string{"h", "z"}},
+		}
+		if got := s.Range(storage.MinStreamID, storage.MaxStreamID, false, 0); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Unexpected entries. Expected: %v, Got: %v", expected, got)
+		}
+		last := storage.StreamID{Ms: 2, Seq: 1}
+		if s.LastID != last || s.MaxDeletedID != last || s.EntriesAdded != 4 {
+			t.Errorf("Unexpected metadata: %v %v %d", s.LastID, s.MaxDeletedID, s.EntriesAdded)
+		}
+		g := s.Group("g1")
+		if g == nil || g.LastID != (storage.StreamID{Ms: 1, Seq: 1}) || g.EntriesRead != 2 || g.PendingLen() != 2 {
+			t.Fatalf("Unexpected group: %+v", g)
+		}
+		p := g.Pending(storage.StreamID{Ms: 1, Seq: 0})
+		if p == nil || p.Consumer.Name != "alice" || p.DeliveryTime != 1000 || p.DeliveryCount != 3 {
+			t.Errorf("Unexpected pending entry: %+v", p)
+		}
+		alice, bob := g.Consumer("alice"), g.Consumer("bob")
+		if alice.SeenTime != 500 || alice.ActiveTime != 400 || bob.ActiveTime != -1 || bob.PendingCount() != 1 {
+			t.Errorf("Unexpected consumers: %+v %+v", alice, bob)
+		}
+		return nil
+	})
+}
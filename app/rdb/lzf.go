@@ -0,0 +1,55 @@
+package rdb
+
+// Most bytes LZF produces from one input byte: a back reference of three
+// bytes copies up to 264
+const lzfMaxRatio = 88
+
+// Decompresses LZF data into a buffer of the uncompressed length n. Every
+// control byte either starts a literal run of up to 32 bytes or a back
+// reference to data already produced.
+func lzfDecompress(in []byte, n int) ([]byte, error) {
+	// n comes from the file, so one that in can't decompress to must not
+	// allocate the buffer
+	if n > len(in)*lzfMaxRatio {
+		return nil, ErrCorrupt
+	}
+	out := make([]byte, 0, n)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			run := ctrl + 1
+			if i+run > len(in) || len(out)+run > n {
+				return nil, ErrCorrupt
+			}
+			out = append(out, in[i:i+run]...)
+			i += run
+			continue
+		}
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, ErrCorrupt
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, ErrCorrupt
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		length += 2
+		if ref < 0 || len(out)+length > n {
+			return nil, ErrCorrupt
+		}
+		// The reference may overlap the bytes it produces
+		for j := range length {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != n {
+		return nil, ErrCorrupt
+	}
+	return out, nil
+}
@@ -0,0 +1,87 @@
+// Package rdb reads and writes Redis' RDB snapshot format.
+//
+// A file starts with "REDIS" and a four digit version, followed by
+// opcodes: auxiliary fields, the database selector, hints about its size,
+// and the keys with their expiry. It ends with the EOF opcode and, from
+// version 5 on, a CRC64 checksum of everything before it.
+package rdb
+
+import "errors"
+
+// Greatest RDB version this package reads, the one it writes
+const Version = 12
+
+// Opcodes that precede anything that isn't a key
+const (
+	opFunction2    = 0xf5
+	opModuleAux    = 0xf7
+	opIdle         = 0xf8
+	opFreq         = 0xf9
+	opAux          = 0xfa
+	opResizeDB     = 0xfb
+	opExpireTimeMs = 0xfc
+	opExpireTime   = 0xfd
+	opSelectDB     = 0xfe
+	opEOF          = 0xff
+)
+
+// Types of the values that follow a key
+const (
+	typeString           = 0
+	typeList             = 1
+	typeSet              = 2
+	typeZSet             = 3
+	typeHash             = 4
+	typeZSet2            = 5
+	typeModule           = 6
+	typeModule2          = 7
+	typeHashZipmap       = 9
+	typeListZiplist      = 10
+	typeSetIntset        = 11
+	typeZSetZiplist      = 12
+	typeHashZiplist      = 13
+	typeListQuicklist    = 14
+	typeStreamListpacks  = 15
+	typeHashListpack     = 16
+	typeZSetListpack     = 17
+	typeListQuicklist2   = 18
+	typeStreamListpacks2 = 19
+	typeSetListpack      = 20
+	typeStreamListpacks3 = 21
+	// Hashes with field TTLs, from version 12 on
+	typeHashMetadata   = 24
+	typeHashListpackEx = 25
+)
+
+// Encodings of lengths: the two most significant bits of the first byte
+// tell how many bytes follow
+const (
+	len6Bit  = 0
+	len14Bit = 1
+	len32Bit = 0x80
+	len64Bit = 0x81
+	// The other six bits tell how the string is encoded
+	lenEncoded = 3
+)
+
+// Special encodings of strings
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// Containers of quicklist nodes
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+// Flags of stream entries in a listpack
+const (
+	streamItemDeleted    = 1
+	streamItemSameFields = 2
+)
+
+var ErrCorrupt = errors.New("rdb: corrupt file")
@@ -0,0 +1,195 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// reader decodes the primitives of the format and checksums every byte it
+// consumes.
+type reader struct {
+	r   *bufio.Reader
+	crc uint64
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{r: bufio.NewReader(r)}
+}
+
+// Unexpected ends of the file are reported as corruption
+func (r *reader) wrap(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrCorrupt
+	}
+	return err
+}
+
+func (r *reader) readFull(n int) ([]byte, error) {
+	// Lengths come from the file, so a huge one must not allocate
+	// upfront before the data turns out to be missing
+	buf := make([]byte, 0, min(n, 1<<16))
+	for len(buf) < n {
+		chunk := min(n-len(buf), 1<<16)
+		buf = slices.Grow(buf, chunk)[:len(buf)+chunk]
+		if _, err := io.ReadFull(r.r, buf[len(buf)-chunk:]); err != nil {
+			return nil, r.wrap(err)
+		}
+	}
+	r.crc = crcUpdate(r.crc, buf)
+	return buf, nil
+}
+
+func (r *reader) readByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err != nil {
+		return 0, r.wrap(err)
+	}
+	r.crc = crcUpdate(r.crc, []byte{b})
+	return b, nil
+}
+
+func (r *reader) readUint32() (uint32, error) {
+	b, err := r.readFull(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (r *reader) readUint64() (uint64, error) {
+	b, err := r.readFull(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// Reads a length, or the special encoding of a string when encoded is
+// true
+func (r *reader) readLengthOrEncoding() (n uint64, encoded bool, err error) {
+	b, err := r.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch {
+	case b>>6 == len6Bit:
+		return uint64(b & 0x3f), false, nil
+	case b>>6 == len14Bit:
+		next, err := r.readByte()
+		return uint64(b&0x3f)<<8 | uint64(next), false, err
+	case b == len32Bit:
+		buf, err := r.readFull(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(buf)), false, nil
+	case b == len64Bit:
+		buf, err := r.readFull(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(buf), false, nil
+	case b>>6 == lenEncoded:
+		return uint64(b & 0x3f), true, nil
+	}
+	return 0, false, ErrCorrupt
+}
+
+func (r *reader) readLength() (uint64, error) {
+	n, encoded, err := r.readLengthOrEncoding()
+	if err == nil && encoded {
+		err = ErrCorrupt
+	}
+	return n, err
+}
+
+// Reads a length that counts elements or bytes to read, which must fit an
+// int
+func (r *reader) readCount() (int, error) {
+	n, err := r.readLength()
+	if err == nil && n > math.MaxInt32 {
+		err = ErrCorrupt
+	}
+	return int(n), err
+}
+
+func (r *reader) readString() (string, error) {
+	n, encoded, err := r.readLengthOrEncoding()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		if n > math.MaxInt32 {
+			return "", ErrCorrupt
+		}
+		b, err := r.readFull(int(n))
+		return string(b), err
+	}
+	switch n {
+	case encInt8:
+		b, err := r.readByte()
+		return strconv.Itoa(int(int8(b))), err
+	case encInt16:
+		b, err := r.readFull(2)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b)))), nil
+	case encInt32:
+		v, err := r.readUint32()
+		return strconv.Itoa(int(int32(v))), err
+	case encLZF:
+		clen, err := r.readCount()
+		if err != nil {
+			return "", err
+		}
+		ulen, err := r.readCount()
+		if err != nil {
+			return "", err
+		}
+		in, err := r.readFull(clen)
+		if err != nil {
+			return "", err
+		}
+		out, err := lzfDecompress(in, ulen)
+		return string(out), err
+	}
+	return "", ErrCorrupt
+}
+
+// Reads a sorted set score as saved by RDB versions before 8: a length
+// byte followed by the number in text, with three lengths reserved for
+// NaN and the infinities
+func (r *reader) readDoubleString() (float64, error) {
+	n, err := r.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	b, err := r.readFull(int(n))
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return 0, ErrCorrupt
+	}
+	return f, nil
+}
+
+func (r *reader) readDouble() (float64, error) {
+	v, err := r.readUint64()
+	return math.Float64frombits(v), err
+}
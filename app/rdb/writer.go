@@ -0,0 +1,329 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// Entries per listpack of a saved stream, like Redis' stream-node-max-entries
+const streamNodeMaxEntries = 100
+
+// writer encodes the primitives of the format and checksums every byte it
+// produces.
+type writer struct {
+	w   *bufio.Writer
+	crc uint64
+	err error
+}
+
+func (w *writer) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	w.crc = crcUpdate(w.crc, b)
+	_, w.err = w.w.Write(b)
+}
+
+func (w *writer) writeByte(b byte) {
+	w.write([]byte{b})
+}
+
+func (w *writer) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		w.writeByte(byte(n))
+	case n < 1<<14:
+		w.write([]byte{len14Bit<<6 | byte(n>>8), byte(n)})
+	case n <= math.MaxUint32:
+		w.write(binary.BigEndian.AppendUint32([]byte{len32Bit}, uint32(n)))
+	default:
+		w.write(binary.BigEndian.AppendUint64([]byte{len64Bit}, n))
+	}
+}
+
+// Writes a string, in the integer encoding when it is the decimal form of
+// a 32 bit integer
+func (w *writer) writeString(s string) {
+	if len(s) <= 11 {
+		if v, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(v, 10) == s {
+			enc := byte(lenEncoded << 6)
+			switch {
+			case v >= math.MinInt8 && v <= math.MaxInt8:
+				w.write([]byte{enc | encInt8, byte(v)})
+			case v >= math.MinInt16 && v <= math.MaxInt16:
+				w.write(binary.LittleEndian.AppendUint16([]byte{enc | encInt16}, uint16(v)))
+			default:
+				w.write(binary.LittleEndian.AppendUint32([]byte{enc | encInt32}, uint32(v)))
+			}
+			return
+		}
+	}
+	w.writeLength(uint64(len(s)))
+	w.write([]byte(s))
+}
+
+func (w *writer) writeUint64(v uint64) {
+	w.write(binary.LittleEndian.AppendUint64(nil, v))
+}
+
+func (w *writer) writeStreamID(id storage.StreamID) {
+	w.writeLength(id.Ms)
+	w.writeLength(id.Seq)
+}
+
+func (w *writer) writeRawStreamID(id storage.StreamID) {
+	w.write(rawStreamIDBytes(id))
+}
+
+func rawStreamIDBytes(id storage.StreamID) []byte {
+	return binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, id.Ms), id.Seq)
+}
+
+// SaveFile saves the snapshot to path. The file is written under a
+// temporary name in the same directory and renamed once complete, so path
+// always holds a whole snapshot.
+func SaveFile(path string, snap *storage.Snapshot) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = Save(f, snap)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Save writes the snapshot in the RDB format of Version.
+func Save(w io.Writer, snap *storage.Snapshot) error {
+	wr := &writer{w: bufio.NewWriter(w)}
+	wr.write([]byte(fmt.Sprintf("REDIS%04d", Version)))
+	for _, aux := range [][2]string{
+		{"redis-ver", "7.4.0"},
+		{"redis-bits", "64"},
+		{"ctime", strconv.FormatInt(snap.Time()/1000, 10)},
+		{"aof-base", "0"},
+	} {
+		wr.writeByte(opAux)
+		wr.writeString(aux[0])
+		wr.writeString(aux[1])
+	}
+	for db := range snap.Databases() {
+		keys, expires := snap.Size(db)
+		if keys == 0 {
+			continue
+		}
+		wr.writeByte(opSelectDB)
+		wr.writeLength(uint64(db))
+		wr.writeByte(opResizeDB)
+		wr.writeLength(uint64(keys))
+		wr.writeLength(uint64(expires))
+		err := snap.Each(db, func(key string, e *storage.Entry) error {
+			if at := e.ExpireAt(); at != 0 {
+				wr.writeByte(opExpireTimeMs)
+				wr.writeUint64(uint64(at))
+			}
+			wr.writeKey(key, e, snap.Time())
+			return wr.err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	wr.writeByte(opEOF)
+	wr.write(binary.LittleEndian.AppendUint64(nil, wr.crc))
+	if wr.err != nil {
+		return wr.err
+	}
+	return wr.w.Flush()
+}
+
+func (w *writer) writeKey(key string, e *storage.Entry, now int64) {
+	switch v := e.Value.(type) {
+	case string:
+		w.writeByte(typeString)
+		w.writeString(key)
+		w.writeString(v)
+	case *storage.List:
+		w.writeByte(typeList)
+		w.writeString(key)
+		w.writeLength(uint64(v.Len()))
+		v.Each(func(e string) bool {
+			w.writeString(e)
+			return true
+		})
+	case *storage.Set:
+		w.writeByte(typeSet)
+		w.writeString(key)
+		w.writeLength(uint64(v.Len()))
+		for _, m := range v.Members() {
+			w.writeString(m)
+		}
+	case *storage.ZSet:
+		w.writeByte(typeZSet2)
+		w.writeString(key)
+		w.writeLength(uint64(v.Len()))
+		v.Each(func(m string, score float64) bool {
+			w.writeString(m)
+			w.writeUint64(math.Float64bits(score))
+			return true
+		})
+	case *storage.Hash:
+		w.writeHash(key, v, now)
+	case *storage.Stream:
+		w.writeByte(typeStreamListpacks3)
+		w.writeString(key)
+		w.writeStream(v)
+	default:
+		w.err = fmt.Errorf("key %q: can't save values of type %T", key, e.Value)
+	}
+}
+
+// Writes a hash, with the TTLs of its fields when some of them have one.
+// Fields whose TTL was over at now are left out.
+func (w *writer) writeHash(key string, h *storage.Hash, now int64) {
+	type field struct {
+		name, value string
+		at          int64
+	}
+	var fields []field
+	var minExpire int64
+	h.Each(func(name, value string) bool {
+		at, _ := h.FieldExpireAt(name)
+		if at > 0 && at <= now {
+			return true
+		}
+		if at > 0 && (minExpire == 0 || at < minExpire) {
+			minExpire = at
+		}
+		fields = append(fields, field{name, value, at})
+		return true
+	})
+	if minExpire == 0 {
+		w.writeByte(typeHash)
+		w.writeString(key)
+		w.writeLength(uint64(len(fields)))
+		for _, f := range fields {
+			w.writeString(f.name)
+			w.writeString(f.value)
+		}
+		return
+	}
+	// TTLs are saved relative to the earliest one, plus one since 0 means
+	// no TTL
+	w.writeByte(typeHashMetadata)
+	w.writeString(key)
+	w.writeUint64(uint64(minExpire))
+	w.writeLength(uint64(len(fields)))
+	for _, f := range fields {
+		var ttl uint64
+		if f.at > 0 {
+			ttl = uint64(f.at-minExpire) + 1
+		}
+		w.writeLength(ttl)
+		w.writeString(f.name)
+		w.writeString(f.value)
+	}
+}
+
+// Writes the entries of a stream in listpacks, then its metadata and
+// consumer groups, in the layout readStream reads
+func (w *writer) writeStream(s *storage.Stream) {
+	entries := s.Range(storage.MinStreamID, storage.MaxStreamID, false, 0)
+	w.writeLength(uint64((len(entries) + streamNodeMaxEntries - 1) / streamNodeMaxEntries))
+	for i := 0; i < len(entries); i += streamNodeMaxEntries {
+		node := entries[i:min(i+streamNodeMaxEntries, len(entries))]
+		w.writeString(string(rawStreamIDBytes(node[0].ID)))
+		w.writeString(string(encodeListpack(streamListpack(node))))
+	}
+	w.writeLength(uint64(s.Len()))
+	w.writeStreamID(s.LastID)
+	var first storage.StreamID
+	if len(entries) > 0 {
+		first = entries[0].ID
+	}
+	w.writeStreamID(first)
+	w.writeStreamID(s.MaxDeletedID)
+	w.writeLength(s.EntriesAdded)
+	groups := s.Groups()
+	w.writeLength(uint64(len(groups)))
+	for _, g := range groups {
+		w.writeString(g.Name)
+		w.writeStreamID(g.LastID)
+		// Saved as unsigned, InvalidEntriesRead wraps around
+		w.writeLength(uint64(g.EntriesRead))
+		pending := g.PendingRange(storage.MinStreamID, storage.MaxStreamID, 0, nil)
+		w.writeLength(uint64(len(pending)))
+		for _, p := range pending {
+			w.writeRawStreamID(p.ID)
+			w.writeUint64(uint64(p.DeliveryTime))
+			w.writeLength(p.DeliveryCount)
+		}
+		consumers := g.Consumers()
+		w.writeLength(uint64(len(consumers)))
+		for _, c := range consumers {
+			w.writeString(c.Name)
+			w.writeUint64(uint64(c.SeenTime))
+			w.writeUint64(uint64(c.ActiveTime))
+			owned := g.PendingRange(storage.MinStreamID, storage.MaxStreamID, 0, c)
+			w.writeLength(uint64(len(owned)))
+			for _, p := range owned {
+				w.writeRawStreamID(p.ID)
+			}
+		}
+	}
+}
+
+// Lays out the entries of a stream node as the elements of its listpack:
+// the master entry with the fields of the first entry, then every entry
+// relative to it, see addStreamEntries
+func streamListpack(node []storage.StreamEntry) []string {
+	master := node[0].ID
+	var masterFields []string
+	for i := 0; i < len(node[0].Fields); i += 2 {
+		masterFields = append(masterFields, node[0].Fields[i])
+	}
+	// IDs are stored as differences, which can be negative for the
+	// sequence and wrap around
+	diff := func(a, b uint64) string { return strconv.FormatInt(int64(a-b), 10) }
+	lp := []string{strconv.Itoa(len(node)), "0", strconv.Itoa(len(masterFields))}
+	lp = append(lp, masterFields...)
+	lp = append(lp, "0")
+	for _, e := range node {
+		same := len(e.Fields) == 2*len(masterFields)
+		for i := 0; same && i < len(masterFields); i++ {
+			same = e.Fields[2*i] == masterFields[i]
+		}
+		flags := 0
+		if same {
+			flags = streamItemSameFields
+		}
+		lp = append(lp, strconv.Itoa(flags), diff(e.ID.Ms, master.Ms), diff(e.ID.Seq, master.Seq))
+		if same {
+			for i := 1; i < len(e.Fields); i += 2 {
+				lp = append(lp, e.Fields[i])
+			}
+			lp = append(lp, strconv.Itoa(len(masterFields)+3))
+		} else {
+			lp = append(lp, strconv.Itoa(len(e.Fields)/2))
+			lp = append(lp, e.Fields...)
+			lp = append(lp, strconv.Itoa(len(e.Fields)+4))
+		}
+	}
+	return lp
+}
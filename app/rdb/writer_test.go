@@ -0,0 +1,207 @@
+package rdb
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// Saves a snapshot of dbs and loads it into new databases
+func roundTrip(t *testing.T, dbs []*storage.KeyValue) []*storage.KeyValue {
+	t.Helper()
+	snap := storage.TakeSnapshot(dbs)
+	defer snap.Release()
+	var buf bytes.Buffer
+	if err := Save(&buf, snap); err != nil {
+		t.Fatalf("Unexpected error saving: %v", err)
+	}
+	loaded := storage.NewDatabases(len(dbs))
+	if err := Load(&buf, loaded); err != nil {
+		t.Fatalf("Unexpected error loading: %v", err)
+	}
+	return loaded
+}
+
+func TestEncodeListpack(t *testing.T) {
+	elements := []string{"a", "12", "-1", "hello", "1000000", "-70000", "123456789012", "007", strings.Repeat("x", 200), strings.Repeat("y", 5000)}
+	got, err := decodeListpack(encodeListpack(elements))
+	if err != nil || !reflect.DeepEqual(got, elements) {
+		t.Errorf("Expected: %q, Got: %q, %v", elements, got, err)
+	}
+}
+
+func TestSave_Values(t *testing.T) {
+	now := time.Now().UnixMilli()
+	dbs := storage.NewDatabases(4)
+	dbs[0].Update(nil, func(tx *storage.Tx) error {
+		tx.Set("str", "plain")
+		tx.Set("int", "-12345")
+		tx.Set("big", strings.Repeat("b", 20000))
+		tx.Set("ttl", "v")
+		tx.SetExpireAt("ttl", now+100000)
+		l, _ := tx.List("list", true)
+		for _, v := range []string{"a", "1", "c"} {
+			l.PushBack(v)
+		}
+		s, _ := tx.LookupSet("set", true)
+		s.Add("x")
+		s.Add("y")
+		z, _ := tx.ZSet("zset", true)
+		z.Add("m", 1.5)
+		z.Add("n", math.Inf(-1))
+		h, _ := tx.Hash("hash", true)
+		h.Set("f", "v")
+		h.Set("gone", "x")
+		h.SetFieldExpireAt("gone", now-1000)
+		h.Set("kept", "y")
+		h.SetFieldExpireAt("kept", now+100000)
+		return nil
+	})
+	dbs[2].Update(nil, func(tx *storage.Tx) error {
+		tx.Set("other", "db")
+		return nil
+	})
+	loaded := roundTrip(t, dbs)
+	if loaded[0].Len() != 8 || loaded[1].Len() != 0 || loaded[2].Len() != 1 {
+		t.Fatalf("Unexpected number of keys: %d, %d and %d", loaded[0].Len(), loaded[1].Len(), loaded[2].Len())
+	}
+	loaded[0].View(nil, func(tx *storage.Tx) error {
+		for key, expected := range map[string]string{"str": "plain", "int": "-12345", "big": strings.Repeat("b", 20000)} {
+			if v, _, _ := tx.Get(key); v != expected {
+				t.Errorf("%s: Expected: %q, Got: %q", key, expected, v)
+			}
+		}
+		if at, _ := tx.ExpireAt("ttl"); at != now+100000 {
+			t.Errorf("Unexpected expiry of ttl: %d", at)
+		}
+		if l, _ := tx.List("list", false); l == nil || !reflect.DeepEqual(l.Range(0, -1), []string{"a", "1", "c"}) {
+			t.Errorf("Unexpected list: %v", l)
+		}
+		if s, _ := tx.LookupSet("set", false); s == nil || s.Len() != 2 || !s.Contains("x") || !s.Contains("y") {
+			t.Errorf("Unexpected set: %v", s)
+		}
+		if z, _ := tx.ZSet("zset", false); z == nil || !reflect.DeepEqual(z.RangeByRank(0, z.Len()-1, false), []storage.ZMember{{Member: "n", Score: math.Inf(-1)}, {Member: "m", Score: 1.5}}) {
+			t.Errorf("Unexpected sorted set: %v", z)
+		}
+		h, _ := tx.Hash("hash", false)
+		if h == nil || h.Len() != 2 || h.Exists("gone") {
+			t.Fatalf("Unexpected hash: %v", h)
+		}
+		if at, _ := h.FieldExpireAt("kept"); at != now+100000 {
+			t.Errorf("Unexpected field expiry: %d", at)
+		}
+		if at, _ := h.FieldExpireAt("f"); at != 0 {
+			t.Errorf("Unexpected field expiry: %d", at)
+		}
+		return nil
+	})
+}
+
+func TestSave_SkipsExpiredKeys(t *testing.T) {
+	dbs := storage.NewDatabases(1)
+	dbs[0].Update(nil, func(tx *storage.Tx) error {
+		tx.Set("a", "1")
+		tx.Set("b", "2")
+		tx.SetExpireAt("b", tx.Now()+20)
+		return nil
+	})
+	time.Sleep(30 * time.Millisecond)
+	if loaded := roundTrip(t, dbs); loaded[0].Len() != 1 {
+		t.Errorf("Unexpected number of keys: %d", loaded[0].Len())
+	}
+}
+
+func TestSave_Stream(t *testing.T) {
+	dbs := storage.NewDatabases(1)
+	var expected []storage.StreamEntry
+	var entriesRead int64
+	dbs[0].Update(nil, func(tx *storage.Tx) error {
+		s, _ := tx.Stream("s", true)
+		// Enough entries for several nodes, with the sequence going down
+		// between some of them and fields differing from the master entry
+		for i := range 250 {
+			id := storage.StreamID{Ms: uint64(1000 + i/3), Seq: uint64(i % 3)}
+			fields := []string{"f", strconv.Itoa(i), "g", "x"}
+			if i%7 == 0 {
+				fields = []string{"h", strconv.Itoa(i)}
+			}
+			s.Add(id, fields)
+			expected = append(expected, storage.StreamEntry{ID: id, Fields: fields})
+		}
+		s.Delete(expected[5].ID)
+		expected = append(expected[:5], expected[6:]...)
+		g, _ := s.CreateGroup("g1", storage.StreamID{}, 0)
+		alice, _ := g.CreateConsumer("alice", 500)
+		g.CreateConsumer("bob", 600)
+		s.ReadGroup(g, alice, 2, false, 1000)
+		entriesRead = g.EntriesRead
+		s.CreateGroup("g2", s.LastID, storage.InvalidEntriesRead)
+		return nil
+	})
+	loaded := roundTrip(t, dbs)
+	loaded[0].View(nil, func(tx *storage.Tx) error {
+		s, _ := tx.Stream("s", false)
+		if s == nil {
+			t.Fatal("Missing stream")
+		}
+		if got := s.Range(storage.MinStreamID, storage.MaxStreamID, false, 0); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Unexpected entries. Expected: %v, Got: %v", expected, got)
+		}
+		if s.LastID != (storage.StreamID{Ms: 1083, Seq: 0}) || s.MaxDeletedID != (storage.StreamID{Ms: 1001, Seq: 2}) || s.EntriesAdded != 250 {
+			t.Errorf("Unexpected metadata: %v %v %d", s.LastID, s.MaxDeletedID, s.EntriesAdded)
+		}
+		g := s.Group("g1")
+		if g == nil || g.LastID != (storage.StreamID{Ms: 1000, Seq: 1}) || g.EntriesRead != entriesRead || g.PendingLen() != 2 {
+			t.Fatalf("Unexpected group: %+v", g)
+		}
+		p := g.Pending(storage.StreamID{Ms: 1000, Seq: 0})
+		if p == nil || p.Consumer.Name != "alice" || p.DeliveryTime != 1000 || p.DeliveryCount != 1 {
+			t.Errorf("Unexpected pending entry: %+v", p)
+		}
+		alice, bob := g.Consumer("alice"), g.Consumer("bob")
+		if alice == nil || bob == nil || alice.PendingCount() != 2 || bob.SeenTime != 600 || bob.PendingCount() != 0 {
+			t.Errorf("Unexpected consumers: %+v %+v", alice, bob)
+		}
+		if g2 := s.Group("g2"); g2 == nil || g2.EntriesRead != storage.InvalidEntriesRead {
+			t.Errorf("Unexpected group: %+v", g2)
+		}
+		return nil
+	})
+}
+
+func TestSaveFile(t *testing.T) {
+	dbs := storage.NewDatabases(1)
+	dbs[0].Update(nil, func(tx *storage.Tx) error {
+		tx.Set("k", "v")
+		return nil
+	})
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dump.rdb")
+	snap := storage.TakeSnapshot(dbs)
+	err := SaveFile(path, snap)
+	snap.Release()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	loaded := storage.NewDatabases(1)
+	if err := LoadFile(path, loaded); err != nil || loaded[0].Len() != 1 {
+		t.Errorf("Unexpected load: %d keys, %v", loaded[0].Len(), err)
+	}
+	// No temporary file is left behind
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Unexpected files: %v", entries)
+	}
+	snap = storage.TakeSnapshot(dbs)
+	defer snap.Release()
+	if err := SaveFile(filepath.Join(dir, "missing", "dump.rdb"), snap); err == nil {
+		t.Error("Expected an error for a missing directory")
+	}
+}
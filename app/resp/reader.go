@@ -0,0 +1,350 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Largest bulk string a client may send, same as Redis' proto-max-bulk-len.
+	maxBulkLen = 512 * 1024 * 1024
+	// Largest number of elements in a single multibulk request.
+	maxMultiBulkLen = 1024 * 1024
+	// Largest inline command line.
+	maxInlineLen = 64 * 1024
+)
+
+// ProtocolError is returned when the peer sends bytes that are not valid RESP.
+// The connection can not be resynchronized after it, so callers should reply
+// with the error and close the connection.
+type ProtocolError struct {
+	s string
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.s
+}
+
+// Reader reads RESP frames from a stream. It keeps whatever was read past the
+// current frame in its buffer, so frames split across several TCP reads and
+// several frames pipelined into a single read are both handled.
+type Reader struct {
+	rd *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		rd: bufio.NewReader(r),
+	}
+}
+
+// Buffered returns the number of bytes that were already received but not
+// consumed yet. Zero means there is no pipelined command waiting.
+func (r *Reader) Buffered() int {
+	return r.rd.Buffered()
+}
+
+// ReadCommand returns the next complete command, either sent as a multibulk
+// array of bulk strings or as an inline, space separated, line.
+// Empty lines and empty arrays are skipped.
+func (r *Reader) ReadCommand() ([]string, error) {
+	for {
+		typ, err := r.rd.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		var cmd []string
+		if typ[0] == Array {
+			cmd, err = r.readMultiBulk()
+		} else {
+			cmd, err = r.readInline()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(cmd) > 0 {
+			return cmd, nil
+		}
+	}
+}
+
+// ReadLine returns a single CRLF terminated line without the terminator.
+// It is used for simple replies such as "+PONG" during the replication handshake.
+func (r *Reader) ReadLine() (string, error) {
+	line, err := r.readLine(maxInlineLen)
+	if err != nil {
+		return "", err
+	}
+	return string(line), nil
+}
+
+// ReadRawBulk reads a "$<len>\r\n<payload>" frame that has no trailing CRLF.
+// A master uses this framing for the RDB file it sends on full resync.
+func (r *Reader) ReadRawBulk() ([]byte, error) {
+	line, err := r.readLine(maxInlineLen)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != BulkString {
+		return nil, &ProtocolError{fmt.Sprintf("expected '$', got '%s'", line)}
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > maxBulkLen {
+		return nil, &ProtocolError{"invalid bulk length"}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r.rd, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func (r *Reader) readMultiBulk() ([]string, error) {
+	line, err := r.readLine(maxInlineLen)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxMultiBulkLen {
+		return nil, &ProtocolError{"invalid multibulk length"}
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	result := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		s, err := r.readBulk()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+func (r *Reader) readBulk() (string, error) {
+	line, err := r.readLine(maxInlineLen)
+	if err != nil {
+		return "", err
+	}
+	if len(line) == 0 || line[0] != BulkString {
+		return "", &ProtocolError{fmt.Sprintf("expected '$', got '%s'", line)}
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > maxBulkLen {
+		return "", &ProtocolError{"invalid bulk length"}
+	}
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r.rd, buf); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return "", &ProtocolError{"bulk string is not terminated by CRLF"}
+	}
+	return string(buf[:n]), nil
+}
+
+func (r *Reader) readInline() ([]string, error) {
+	line, err := r.readLine(maxInlineLen)
+	if err != nil {
+		return nil, err
+	}
+	return splitInline(string(line))
+}
+
+// readLine returns the next line without its terminator. A bare "\n" is
+// accepted as terminator as well, so inline commands typed in telnet work.
+func (r *Reader) readLine(limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.rd.ReadSlice('\n')
+		line = append(line, chunk...)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			if len(line) > 0 {
+				return nil, unexpectedEOF(err)
+			}
+			return nil, err
+		}
+		if len(line) > limit {
+			return nil, &ProtocolError{"too big inline request"}
+		}
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// splitInline splits an inline command into arguments. Arguments may be
+// wrapped in double quotes (with \n, \r, \t, \xHH style escapes) or in
+// single quotes, like redis-cli does.
+func splitInline(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+		var sb strings.Builder
+		switch line[i] {
+		case '"':
+			i++
+			closed := false
+			for i < len(line) {
+				c := line[i]
+				if c == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 'r':
+						sb.WriteByte('\r')
+					case 't':
+						sb.WriteByte('\t')
+					case 'b':
+						sb.WriteByte('\b')
+					case 'a':
+						sb.WriteByte('\a')
+					case 'x':
+						if i+2 < len(line) {
+							if v, err := strconv.ParseUint(line[i+1:i+3], 16, 8); err == nil {
+								sb.WriteByte(byte(v))
+								i += 2
+								break
+							}
+						}
+						sb.WriteByte('x')
+					default:
+						sb.WriteByte(line[i])
+					}
+					i++
+					continue
+				}
+				if c == '"' {
+					closed = true
+					i++
+					break
+				}
+				sb.WriteByte(c)
+				i++
+			}
+			if !closed || (i < len(line) && line[i] != ' ' && line[i] != '\t') {
+				return nil, &ProtocolError{"unbalanced quotes in request"}
+			}
+		case '\'':
+			i++
+			closed := false
+			for i < len(line) {
+				if line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					sb.WriteByte('\'')
+					i += 2
+					continue
+				}
+				if line[i] == '\'' {
+					closed = true
+					i++
+					break
+				}
+				sb.WriteByte(line[i])
+				i++
+			}
+			if !closed || (i < len(line) && line[i] != ' ' && line[i] != '\t') {
+				return nil, &ProtocolError{"unbalanced quotes in request"}
+			}
+		default:
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				sb.WriteByte(line[i])
+				i++
+			}
+		}
+		args = append(args, sb.String())
+	}
+}
+
+// ReadValue reads any RESP frame, e.g. a reply sent by another server.
+func (r *Reader) ReadValue() (Value, error) {
+	line, err := r.readLine(maxInlineLen)
+	if err != nil {
+		return Value{}, err
+	}
+	if len(line) == 0 {
+		return Value{}, &ProtocolError{"empty frame"}
+	}
+	typ, rest := Type(line[0]), string(line[1:])
+	switch typ {
+	case TypeSimpleString, TypeError:
+		return Value{Type: typ, Str: rest}, nil
+	case TypeInteger:
+		n, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			return Value{}, &ProtocolError{"invalid integer"}
+		}
+		return IntegerValue(n), nil
+	case TypeBulkString, TypeVerbatim:
+		n, err := strconv.Atoi(rest)
+		if err != nil || n > maxBulkLen {
+			return Value{}, &ProtocolError{"invalid bulk length"}
+		}
+		if n < 0 {
+			return NullBulkValue(), nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r.rd, buf); err != nil {
+			return Value{}, unexpectedEOF(err)
+		}
+		return Value{Type: typ, Str: string(buf[:n])}, nil
+	case TypeArray, TypeSet, TypePush, TypeMap:
+		n, err := strconv.Atoi(rest)
+		if err != nil || n > maxMultiBulkLen {
+			return Value{}, &ProtocolError{"invalid multibulk length"}
+		}
+		if n < 0 {
+			return NullArrayValue(), nil
+		}
+		if typ == TypeMap {
+			n *= 2
+		}
+		values := make([]Value, n)
+		for i := range values {
+			values[i], err = r.ReadValue()
+			if err != nil {
+				return Value{}, unexpectedEOF(err)
+			}
+		}
+		return Value{Type: typ, Array: values}, nil
+	case TypeNull:
+		return NullValue(), nil
+	case TypeDouble:
+		f, err := strconv.ParseFloat(rest, 64)
+		if err != nil {
+			return Value{}, &ProtocolError{"invalid double"}
+		}
+		return DoubleValue(f), nil
+	case TypeBoolean:
+		if rest != "t" && rest != "f" {
+			return Value{}, &ProtocolError{"invalid boolean"}
+		}
+		return BooleanValue(rest == "t"), nil
+	case TypeBigNumber:
+		return BigNumberValue(rest), nil
+	}
+	return Value{}, &ProtocolError{fmt.Sprintf("unknown type '%c'", typ)}
+}
@@ -0,0 +1,102 @@
+package resp
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+// chunkReader returns at most n bytes per Read call, simulating a command
+// that arrives split over several TCP segments.
+type chunkReader struct {
+	data []byte
+	n    int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := r.n
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > len(r.data) {
+		n = len(r.data)
+	}
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestReader_SplitAndPipelined(t *testing.T) {
+	input := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n" +
+		"*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n" +
+		"PING\r\n"
+	expected := [][]string{
+		{"SET", "key", "value"},
+		{"GET", "key"},
+		{"PING"},
+	}
+	for _, chunk := range []int{1, 3, 7, len(input)} {
+		r := NewReader(&chunkReader{data: []byte(input), n: chunk})
+		for _, want := range expected {
+			got, err := r.ReadCommand()
+			if err != nil {
+				t.Fatalf("chunk %d: unexpected error: %v", chunk, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("chunk %d: unexpected command. Expected: %q, Got: %q", chunk, want, got)
+			}
+		}
+		if _, err := r.ReadCommand(); err != io.EOF {
+			t.Errorf("chunk %d: expected io.EOF, got %v", chunk, err)
+		}
+	}
+}
+
+func TestReader_BinarySafeBulk(t *testing.T) {
+	r := NewReader(&chunkReader{data: []byte("*2\r\n$4\r\nECHO\r\n$4\r\na\r\nb\r\n"), n: 2})
+	got, err := r.ReadCommand()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got[1] != "a\r\nb" {
+		t.Errorf("Unexpected argument. Expected: %q, Got: %q", "a\r\nb", got[1])
+	}
+}
+
+func TestReader_Inline(t *testing.T) {
+	r := NewReader(&chunkReader{data: []byte("set  \"a b\" 'c'\n\r\n"), n: 100})
+	got, err := r.ReadCommand()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []string{"set", "a b", "c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected command. Expected: %q, Got: %q", want, got)
+	}
+}
+
+func TestReader_TruncatedFrame(t *testing.T) {
+	r := NewReader(&chunkReader{data: []byte("*2\r\n$3\r\nGET\r\n$3\r\nke"), n: 4})
+	if _, err := r.ReadCommand(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestReader_RawBulk(t *testing.T) {
+	r := NewReader(&chunkReader{data: []byte("+FULLRESYNC abc 0\r\n$5\r\nREDIS*1\r\n$4\r\nPING\r\n"), n: 3})
+	line, err := r.ReadLine()
+	if err != nil || line != "+FULLRESYNC abc 0" {
+		t.Fatalf("Unexpected line %q, error %v", line, err)
+	}
+	payload, err := r.ReadRawBulk()
+	if err != nil || string(payload) != "REDIS" {
+		t.Fatalf("Unexpected payload %q, error %v", payload, err)
+	}
+	cmd, err := r.ReadCommand()
+	if err != nil || !reflect.DeepEqual(cmd, []string{"PING"}) {
+		t.Errorf("Unexpected command %q, error %v", cmd, err)
+	}
+}
@@ -2,11 +2,7 @@
 // parse them by RESP (Redis serialization protocol specification) protocol.
 package resp
 
-import (
-	"fmt"
-	"strconv"
-	"strings"
-)
+import "strconv"
 
 const (
 	Array      = '*'
@@ -14,65 +10,6 @@ const (
 	Quote      = '"'
 )
 
-// Parse string
-func ParseRequestString(received string) ([]string, error) {
-	typ := received[0]
-	fmt.Printf("typ: %v\n", string(typ))
-	switch typ {
-	case Array:
-		return parseArray(received[1:])
-	case BulkString:
-		return parseBulkString(received)
-	case Quote:
-		return ParseRequestString(received[1:])
-	default:
-		res := strings.Split(received, " ")
-		return res, nil
-
-	}
-}
-
-// If given request is an array, this function parses it
-func parseArray(input string) ([]string, error) {
-	var result []string
-	parts := strings.Split(input, "\r\n")
-	for i := 0; i < len(parts); i++ {
-		if strings.HasPrefix(parts[i], "$") {
-			length, err := strconv.Atoi(parts[i][1:])
-			if err != nil {
-				return nil, err
-			}
-			if i+1 < len(parts) {
-				result = append(result, parts[i+1][:length])
-				i++
-			} else {
-				break
-			}
-		}
-	}
-	return result, nil
-}
-
-func parseBulkString(input string) ([]string, error) {
-	var result []string
-	parts := strings.Split(input, "\r\n")
-	for i := 0; i < len(parts); i++ {
-		if strings.HasPrefix(parts[i], "$") {
-			length, err := strconv.Atoi(parts[i][1:])
-			if err != nil {
-				return nil, err
-			}
-			if i+1 < len(parts) {
-				result = append(result, parts[i+1][:length])
-				i++
-			} else {
-				break
-			}
-		}
-	}
-	return result, nil
-}
-
 func CreateArray(input []string) string {
 	result := "*" + strconv.Itoa(len(input)) + "\r\n"
 	for _, v := range input {
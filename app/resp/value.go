@@ -0,0 +1,324 @@
+package resp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	SimpleString = '+'
+	Error        = '-'
+	Integer      = ':'
+
+	// RESP3 only types
+	Null      = '_'
+	Double    = ','
+	Boolean   = '#'
+	BigNumber = '('
+	Verbatim  = '='
+	Map       = '%'
+	Set       = '~'
+	Push      = '>'
+)
+
+// Protocol versions a connection can speak, see the HELLO command.
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+// Type is the RESP type of a Value, identified by its leading byte.
+type Type byte
+
+const (
+	TypeSimpleString Type = SimpleString
+	TypeError        Type = Error
+	TypeInteger      Type = Integer
+	TypeBulkString   Type = BulkString
+	TypeArray        Type = Array
+	TypeNull         Type = Null
+	TypeDouble       Type = Double
+	TypeBoolean      Type = Boolean
+	TypeBigNumber    Type = BigNumber
+	TypeVerbatim     Type = Verbatim
+	TypeMap          Type = Map
+	TypeSet          Type = Set
+	TypePush         Type = Push
+)
+
+// Value is a single RESP frame. Only the fields that make sense for Type are
+// used: Str for strings, errors, big numbers and verbatim strings, Int for
+// integers and booleans, Float for doubles, Array for aggregates.
+// Maps keep their keys and values interleaved in Array, in reply order.
+// Null is set for the null bulk string and the null array.
+type Value struct {
+	Type  Type
+	Str   string
+	Int   int64
+	Float float64
+	Array []Value
+	Null  bool
+}
+
+var (
+	OK   = SimpleStringValue("OK")
+	Pong = SimpleStringValue("PONG")
+)
+
+func SimpleStringValue(s string) Value {
+	return Value{Type: TypeSimpleString, Str: s}
+}
+
+// ErrorValue creates an error reply. The message should start with an error
+// code such as "ERR" or "WRONGTYPE".
+func ErrorValue(msg string) Value {
+	return Value{Type: TypeError, Str: msg}
+}
+
+// Errorf creates an "ERR" error reply with a formatted message.
+func Errorf(format string, args ...any) Value {
+	return ErrorValue("ERR " + fmt.Sprintf(format, args...))
+}
+
+func IntegerValue(n int64) Value {
+	return Value{Type: TypeInteger, Int: n}
+}
+
+func BulkValue(s string) Value {
+	return Value{Type: TypeBulkString, Str: s}
+}
+
+func NullBulkValue() Value {
+	return Value{Type: TypeBulkString, Null: true}
+}
+
+func ArrayValue(values ...Value) Value {
+	if values == nil {
+		values = []Value{}
+	}
+	return Value{Type: TypeArray, Array: values}
+}
+
+func NullArrayValue() Value {
+	return Value{Type: TypeArray, Null: true}
+}
+
+// StringsValue creates an array of bulk strings, which is how commands are sent.
+func StringsValue(items []string) Value {
+	values := make([]Value, len(items))
+	for i, s := range items {
+		values[i] = BulkValue(s)
+	}
+	return ArrayValue(values...)
+}
+
+// DoubleValue is sent as a bulk string to RESP2 clients.
+func DoubleValue(f float64) Value {
+	return Value{Type: TypeDouble, Float: f}
+}
+
+// BooleanValue is sent as integer 1 or 0 to RESP2 clients.
+func BooleanValue(b bool) Value {
+	v := Value{Type: TypeBoolean}
+	if b {
+		v.Int = 1
+	}
+	return v
+}
+
+// BigNumberValue holds an integer that may not fit in 64 bits, in decimal.
+func BigNumberValue(n string) Value {
+	return Value{Type: TypeBigNumber, Str: n}
+}
+
+// VerbatimValue is a string with a three letter format hint, "txt" or "mkd".
+func VerbatimValue(format, s string) Value {
+	return Value{Type: TypeVerbatim, Str: format + ":" + s}
+}
+
+// MapValue creates a map from interleaved keys and values. RESP2 clients
+// get it as a flat array.
+func MapValue(pairs ...Value) Value {
+	if pairs == nil {
+		pairs = []Value{}
+	}
+	return Value{Type: TypeMap, Array: pairs}
+}
+
+// SetValue is sent as a plain array to RESP2 clients.
+func SetValue(values ...Value) Value {
+	if values == nil {
+		values = []Value{}
+	}
+	return Value{Type: TypeSet, Array: values}
+}
+
+// PushValue is an out of band message, such as a pub/sub message.
+func PushValue(values ...Value) Value {
+	if values == nil {
+		values = []Value{}
+	}
+	return Value{Type: TypePush, Array: values}
+}
+
+// NullValue is the RESP3 null. RESP2 clients get a null bulk string.
+func NullValue() Value {
+	return Value{Type: TypeNull, Null: true}
+}
+
+// IsError reports whether the value is an error reply.
+func (v Value) IsError() bool {
+	return v.Type == TypeError
+}
+
+// Bytes returns the RESP2 encoding of the value.
+func (v Value) Bytes() []byte {
+	return appendValue(nil, v, RESP2)
+}
+
+func (v Value) String() string {
+	return string(v.Bytes())
+}
+
+func appendValue(b []byte, v Value, proto int) []byte {
+	switch v.Type {
+	case TypeSimpleString, TypeError:
+		b = append(b, byte(v.Type))
+		b = append(b, sanitizeLine(v.Str)...)
+		return append(b, '\r', '\n')
+	case TypeInteger:
+		b = append(b, Integer)
+		b = strconv.AppendInt(b, v.Int, 10)
+		return append(b, '\r', '\n')
+	case TypeBulkString:
+		if v.Null {
+			return appendNull(b, BulkString, proto)
+		}
+		return appendBulk(b, BulkString, v.Str)
+	case TypeArray, TypeSet, TypePush:
+		if v.Null {
+			return appendNull(b, Array, proto)
+		}
+		typ := byte(v.Type)
+		if proto < RESP3 {
+			typ = Array
+		}
+		return appendAggregate(b, typ, v.Array, len(v.Array), proto)
+	case TypeMap:
+		if proto < RESP3 {
+			return appendAggregate(b, Array, v.Array, len(v.Array), proto)
+		}
+		return appendAggregate(b, Map, v.Array, len(v.Array)/2, proto)
+	case TypeNull:
+		return appendNull(b, BulkString, proto)
+	case TypeDouble:
+		f := FormatDouble(v.Float)
+		if proto < RESP3 {
+			return appendBulk(b, BulkString, f)
+		}
+		b = append(b, Double)
+		b = append(b, f...)
+		return append(b, '\r', '\n')
+	case TypeBoolean:
+		if proto < RESP3 {
+			return appendValue(b, IntegerValue(v.Int), proto)
+		}
+		if v.Int != 0 {
+			return append(b, "#t\r\n"...)
+		}
+		return append(b, "#f\r\n"...)
+	case TypeBigNumber:
+		if proto < RESP3 {
+			return appendBulk(b, BulkString, v.Str)
+		}
+		b = append(b, BigNumber)
+		b = append(b, v.Str...)
+		return append(b, '\r', '\n')
+	case TypeVerbatim:
+		if proto < RESP3 {
+			// Strip the "txt:" format prefix
+			return appendBulk(b, BulkString, v.Str[min(4, len(v.Str)):])
+		}
+		return appendBulk(b, Verbatim, v.Str)
+	}
+	return b
+}
+
+func appendBulk(b []byte, typ byte, s string) []byte {
+	b = append(b, typ)
+	b = strconv.AppendInt(b, int64(len(s)), 10)
+	b = append(b, '\r', '\n')
+	b = append(b, s...)
+	return append(b, '\r', '\n')
+}
+
+func appendAggregate(b []byte, typ byte, items []Value, n int, proto int) []byte {
+	b = append(b, typ)
+	b = strconv.AppendInt(b, int64(n), 10)
+	b = append(b, '\r', '\n')
+	for _, item := range items {
+		b = appendValue(b, item, proto)
+	}
+	return b
+}
+
+func appendNull(b []byte, typ byte, proto int) []byte {
+	if proto >= RESP3 {
+		return append(b, "_\r\n"...)
+	}
+	b = append(b, typ)
+	return append(b, "-1\r\n"...)
+}
+
+// FormatDouble formats a float the way Redis replies with it: the shortest
+// representation that round trips, and "inf", "-inf" or "nan". Like Redis'
+// fpconv_dtoa, integers are written out unless that takes 7 or more
+// trailing zeros, and other values when their digits end within 6 places
+// after the point or their magnitude is between 1e-3 and 1e4. Everything
+// else uses scientific notation.
+func FormatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	sign := ""
+	if math.Signbit(f) {
+		sign, f = "-", -f
+	}
+	// Shortest digits d.ddd and the decimal exponent of the first one
+	mantissa, e, _ := strings.Cut(strconv.FormatFloat(f, 'e', -1, 64), "e")
+	digits := strings.Replace(mantissa, ".", "", 1)
+	exp, _ := strconv.Atoi(e)
+	// The value is digits * 10^k
+	k := exp - len(digits) + 1
+	switch {
+	case k >= 0 && exp < len(digits)+7:
+		return sign + digits + strings.Repeat("0", k)
+	case k < 0 && (k > -7 || (exp > -4 && exp < 4)):
+		if exp < 0 {
+			return sign + "0." + strings.Repeat("0", -exp-1) + digits
+		}
+		return sign + digits[:exp+1] + "." + digits[exp+1:]
+	}
+	if len(digits) > 1 {
+		mantissa = digits[:1] + "." + digits[1:]
+	}
+	if exp < 0 {
+		return sign + mantissa + "e-" + strconv.Itoa(-exp)
+	}
+	return sign + mantissa + "e+" + strconv.Itoa(exp)
+}
+
+// Simple strings and errors can not contain CR or LF.
+func sanitizeLine(s string) string {
+	if strings.ContainsAny(s, "\r\n") {
+		return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+	}
+	return s
+}
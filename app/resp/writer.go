@@ -0,0 +1,50 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+)
+
+// Writer serializes values into a buffered stream. Nothing reaches the
+// underlying connection until Flush is called, which lets the server answer
+// a whole pipeline of commands with a single write.
+type Writer struct {
+	wr    *bufio.Writer
+	buf   []byte
+	proto int
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		wr:    bufio.NewWriter(w),
+		proto: RESP2,
+	}
+}
+
+// SetProtocol switches the encoding used for values written afterwards.
+// RESP3 only types are downgraded to their RESP2 equivalent in RESP2 mode.
+func (w *Writer) SetProtocol(proto int) {
+	w.proto = proto
+}
+
+func (w *Writer) Protocol() int {
+	return w.proto
+}
+
+// WriteValue encodes v into the write buffer.
+func (w *Writer) WriteValue(v Value) error {
+	w.buf = appendValue(w.buf[:0], v, w.proto)
+	_, err := w.wr.Write(w.buf)
+	return err
+}
+
+// WriteRaw writes already encoded bytes, e.g. an RDB payload.
+func (w *Writer) WriteRaw(b []byte) error {
+	_, err := w.wr.Write(b)
+	return err
+}
+
+// Flush sends everything buffered so far.
+func (w *Writer) Flush() error {
+	return w.wr.Flush()
+}
@@ -0,0 +1,110 @@
+package resp
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestWriter_Values(t *testing.T) {
+	cases := []struct {
+		v        Value
+		expected string
+	}{
+		{OK, "+OK\r\n"},
+		{Errorf("unknown command '%s'", "foo"), "-ERR unknown command 'foo'\r\n"},
+		{IntegerValue(-42), ":-42\r\n"},
+		{BulkValue(""), "$0\r\n\r\n"},
+		{NullBulkValue(), "$-1\r\n"},
+		{NullArrayValue(), "*-1\r\n"},
+		{ArrayValue(), "*0\r\n"},
+		{
+			ArrayValue(IntegerValue(1), ArrayValue(BulkValue("a"), NullBulkValue())),
+			"*2\r\n:1\r\n*2\r\n$1\r\na\r\n$-1\r\n",
+		},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		if err := w.WriteValue(c.v); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("Writer must not write before Flush, got %q", buf.String())
+		}
+		w.Flush()
+		if buf.String() != c.expected {
+			t.Errorf("Unexpected encoding. Expected: %q, Got: %q", c.expected, buf.String())
+		}
+	}
+}
+
+func TestReader_ReadValueRoundTrip(t *testing.T) {
+	v := ArrayValue(SimpleStringValue("OK"), IntegerValue(7), BulkValue("x\r\ny"), NullBulkValue(), ArrayValue())
+	r := NewReader(bytes.NewReader(v.Bytes()))
+	got, err := r.ReadValue()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("Unexpected value. Expected: %#v, Got: %#v", v, got)
+	}
+}
+
+func TestWriter_RESP3(t *testing.T) {
+	m := MapValue(BulkValue("a"), DoubleValue(1.5), BulkValue("b"), SetValue(BooleanValue(true)))
+	cases := []struct {
+		v     Value
+		resp2 string
+		resp3 string
+	}{
+		{m, "*4\r\n$1\r\na\r\n$3\r\n1.5\r\n$1\r\nb\r\n*1\r\n:1\r\n", "%2\r\n$1\r\na\r\n,1.5\r\n$1\r\nb\r\n~1\r\n#t\r\n"},
+		{NullValue(), "$-1\r\n", "_\r\n"},
+		{NullArrayValue(), "*-1\r\n", "_\r\n"},
+		{DoubleValue(math.Inf(-1)), "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{BigNumberValue("12345678901234567890"), "$20\r\n12345678901234567890\r\n", "(12345678901234567890\r\n"},
+		{VerbatimValue("txt", "hi"), "$2\r\nhi\r\n", "=6\r\ntxt:hi\r\n"},
+		{PushValue(BulkValue("message")), "*1\r\n$7\r\nmessage\r\n", ">1\r\n$7\r\nmessage\r\n"},
+	}
+	for _, c := range cases {
+		for proto, expected := range map[int]string{RESP2: c.resp2, RESP3: c.resp3} {
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			w.SetProtocol(proto)
+			w.WriteValue(c.v)
+			w.Flush()
+			if buf.String() != expected {
+				t.Errorf("RESP%d: unexpected encoding. Expected: %q, Got: %q", proto, expected, buf.String())
+			}
+		}
+	}
+}
+
+func TestFormatDouble(t *testing.T) {
+	cases := []struct {
+		f        float64
+		expected string
+	}{
+		{0, "0"},
+		{math.Copysign(0, -1), "-0"},
+		{1.5, "1.5"},
+		{-2, "-2"},
+		{1234567, "1234567"},
+		{3479099956230698, "3479099956230698"},
+		{1e7, "10000000"},
+		{1e8, "1e+8"},
+		{1.5e20, "1.5e+20"},
+		{56.4412578701582, "56.4412578701582"},
+		{0.0001, "0.0001"},
+		{1e-7, "1e-7"},
+		{0.0012345678901234, "0.0012345678901234"},
+		{1.23456789e-5, "1.23456789e-5"},
+		{math.Inf(-1), "-inf"},
+	}
+	for _, c := range cases {
+		if got := FormatDouble(c.f); got != c.expected {
+			t.Errorf("Unexpected format of %v. Expected: %q, Got: %q", c.f, c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+func (c *core) registerBitmapCommands() {
+	c.commands.Register(Command{Name: "setbit", Arity: 4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.setbitCommand})
+	c.commands.Register(Command{Name: "getbit", Arity: 3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.getbitCommand})
+	c.commands.Register(Command{Name: "bitcount", Arity: -2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.bitcountCommand})
+	c.commands.Register(Command{Name: "bitpos", Arity: -3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.bitposCommand})
+	c.commands.Register(Command{Name: "bitop", Arity: -4, Flags: FlagWrite, FirstKey: 2, LastKey: -1, Handler: c.bitopCommand})
+	c.commands.Register(Command{Name: "bitfield", Arity: -2, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.bitfieldCommand})
+	c.commands.Register(Command{Name: "bitfield_ro", Arity: -2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.bitfieldCommand})
+}
+
+// Bits in the largest string value
+const maxBitOffset = maxStringLen * 8
+
+func invalidBitOffset() resp.Value {
+	return resp.Errorf("bit offset is not an integer or out of range")
+}
+
+// Parses a bit offset of SETBIT and GETBIT
+func parseBitOffset(s string) (int64, bool) {
+	offset, ok := parseInt(s)
+	if !ok || offset < 0 || offset >= maxBitOffset {
+		return 0, false
+	}
+	return offset, true
+}
+
+// Handles "SETBIT key offset value" and replies with the previous bit
+func (c *core) setbitCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	offset, ok := parseBitOffset(args[2])
+	if !ok {
+		return invalidBitOffset()
+	}
+	if args[3] != "0" && args[3] != "1" {
+		return resp.Errorf("bit is not an integer or out of range")
+	}
+	var old int
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		v, _, err := tx.Get(args[1])
+		if err != nil {
+			return err
+		}
+		var b []byte
+		b, old = storage.SetBit([]byte(v), offset, int(args[3][0]-'0'))
+		tx.SetKeepTTL(args[1], string(b))
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.IntegerValue(int64(old))
+}
+
+func (c *core) getbitCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	offset, ok := parseBitOffset(args[2])
+	if !ok {
+		return invalidBitOffset()
+	}
+	var v string
+	err := c.db(cl).View([]string{args[1]}, func(tx *storage.Tx) (err error) {
+		v, _, err = tx.Get(args[1])
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.IntegerValue(int64(storage.GetBit(v, offset)))
+}
+
+// Parses "start [end [BYTE | BIT]]" of BITCOUNT and BITPOS into a range of
+// bits of a string of n bytes, with negative indexes counting from its end
+// like GETRANGE. empty is set when the range holds no bits. toEnd is set
+// when no end was given.
+func parseBitRange(args []string, n int64) (start, end int64, empty, toEnd bool, errReply resp.Value, ok bool) {
+	if len(args) > 3 {
+		return 0, 0, false, false, syntaxError(), false
+	}
+	start, end, toEnd = 0, -1, len(args) < 2
+	var ok1, ok2 bool
+	if len(args) > 0 {
+		start, ok1 = parseInt(args[0])
+		end, ok2 = -1, true
+		if !toEnd {
+			end, ok2 = parseInt(args[1])
+		}
+		if !ok1 || !ok2 {
+			return 0, 0, false, false, notInteger(), false
+		}
+	}
+	bits := false
+	if len(args) == 3 {
+		switch strings.ToLower(args[2]) {
+		case "bit":
+			bits = true
+		case "byte":
+		default:
+			return 0, 0, false, false, syntaxError(), false
+		}
+	}
+	total := n
+	if bits {
+		total = n * 8
+	}
+	if start < 0 {
+		start += total
+	}
+	if end < 0 {
+		end += total
+	}
+	start = max(start, 0)
+	end = min(max(end, 0), total-1)
+	if start > end {
+		return 0, 0, true, toEnd, resp.Value{}, true
+	}
+	if !bits {
+		start, end = start*8, end*8+7
+	}
+	return start, end, false, toEnd, resp.Value{}, true
+}
+
+// Handles "BITCOUNT key [start end [BYTE | BIT]]". The range is in bytes
+// unless BIT is given.
+func (c *core) bitcountCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	if len(args) == 3 {
+		return syntaxError()
+	}
+	var v string
+	err := c.db(cl).View([]string{args[1]}, func(tx *storage.Tx) (err error) {
+		v, _, err = tx.Get(args[1])
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	start, end, empty, _, errReply, ok := parseBitRange(args[2:], int64(len(v)))
+	if !ok {
+		return errReply
+	}
+	if empty {
+		return resp.IntegerValue(0)
+	}
+	return resp.IntegerValue(storage.BitCount(v, start, end))
+}
+
+// Handles "BITPOS key bit [start [end [BYTE | BIT]]]". Looking for a 0 with
+// no end given finds the first bit past the string when all bits in the
+// range are set.
+func (c *core) bitposCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	if args[2] != "0" && args[2] != "1" {
+		return resp.Errorf("The bit argument must be 1 or 0.")
+	}
+	bit := int(args[2][0] - '0')
+	var v string
+	var exists bool
+	err := c.db(cl).View([]string{args[1]}, func(tx *storage.Tx) (err error) {
+		v, exists, err = tx.Get(args[1])
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	start, end, empty, toEnd, errReply, ok := parseBitRange(args[3:], int64(len(v)))
+	if !ok {
+		return errReply
+	}
+	if !exists {
+		// A missing key is an endless string of zeros
+		if bit == 1 {
+			return resp.IntegerValue(-1)
+		}
+		return resp.IntegerValue(0)
+	}
+	if empty {
+		return resp.IntegerValue(-1)
+	}
+	return resp.IntegerValue(storage.BitPos(v, bit, start, end, toEnd))
+}
+
+// Handles "BITOP AND | OR | XOR | NOT destkey key [key ...]". An empty
+// result deletes destkey. Replies with the length of the result.
+func (c *core) bitopCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	op := strings.ToLower(args[1])
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if len(args) != 4 {
+			return resp.Errorf("BITOP NOT must be called with a single source key.")
+		}
+	default:
+		return syntaxError()
+	}
+	dst, keys := args[2], args[3:]
+	var n int
+	changed := true
+	err := c.db(cl).Update(args[2:], func(tx *storage.Tx) error {
+		srcs := make([]string, len(keys))
+		for i, k := range keys {
+			v, _, err := tx.Get(k)
+			if err != nil {
+				return err
+			}
+			srcs[i] = v
+		}
+		res := storage.BitOp(op, srcs)
+		n = len(res)
+		if n == 0 {
+			changed = tx.Delete(dst)
+			return nil
+		}
+		tx.Set(dst, res)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !changed {
+		cl.propagateAs = []string{}
+	}
+	return resp.IntegerValue(int64(n))
+}
+
+// An operation of BITFIELD
+type bitfieldOp struct {
+	// get, set or incrby
+	name     string
+	typ      storage.BitfieldType
+	offset   int64
+	value    int64
+	overflow storage.BitfieldOverflow
+}
+
+// Parses a type like i16 or u8. Unsigned types have up to 63 bits so their
+// values fit in an int64.
+func parseBitfieldType(s string) (storage.BitfieldType, bool) {
+	if len(s) < 2 || !strings.ContainsRune("iIuU", rune(s[0])) {
+		return storage.BitfieldType{}, false
+	}
+	signed := s[0] == 'i' || s[0] == 'I'
+	n, ok := parseInt(s[1:])
+	if !ok || n < 1 || (signed && n > 64) || (!signed && n > 63) {
+		return storage.BitfieldType{}, false
+	}
+	return storage.BitfieldType{Signed: signed, Bits: int(n)}, true
+}
+
+// Parses the offset of a BITFIELD operation. "#N" is the offset of the N-th
+// integer of type t.
+func parseBitfieldOffset(s string, t storage.BitfieldType) (int64, bool) {
+	mul := int64(1)
+	if strings.HasPrefix(s, "#") {
+		s, mul = s[1:], int64(t.Bits)
+	}
+	offset, ok := parseInt(s)
+	if !ok || offset < 0 || offset > maxBitOffset/mul {
+		return 0, false
+	}
+	offset *= mul
+	if offset+int64(t.Bits) > maxBitOffset {
+		return 0, false
+	}
+	return offset, true
+}
+
+// Parses the operations of BITFIELD and BITFIELD_RO, which only allows GET
+func parseBitfieldOps(args []string, readonly bool) ([]bitfieldOp, resp.Value, bool) {
+	var ops []bitfieldOp
+	overflow := storage.OverflowWrap
+	for i := 0; i < len(args); i++ {
+		name := strings.ToLower(args[i])
+		if readonly && name != "get" {
+			return nil, resp.Errorf("BITFIELD_RO only supports the GET subcommand"), false
+		}
+		switch name {
+		case "overflow":
+			if i+1 >= len(args) {
+				return nil, syntaxError(), false
+			}
+			i++
+			switch strings.ToLower(args[i]) {
+			case "wrap":
+				overflow = storage.OverflowWrap
+			case "sat":
+				overflow = storage.OverflowSat
+			case "fail":
+				overflow = storage.OverflowFail
+			default:
+				return nil, resp.Errorf("Invalid OVERFLOW type specified"), false
+			}
+			continue
+		case "get", "set", "incrby":
+		default:
+			return nil, syntaxError(), false
+		}
+		n := 3
+		if name == "get" {
+			n = 2
+		}
+		if i+n >= len(args) {
+			return nil, syntaxError(), false
+		}
+		op := bitfieldOp{name: name, overflow: overflow}
+		var ok bool
+		if op.typ, ok = parseBitfieldType(args[i+1]); !ok {
+			return nil, resp.Errorf("Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is."), false
+		}
+		if op.offset, ok = parseBitfieldOffset(args[i+2], op.typ); !ok {
+			return nil, invalidBitOffset(), false
+		}
+		if n == 3 {
+			if op.value, ok = parseInt(args[i+3]); !ok {
+				return nil, notInteger(), false
+			}
+		}
+		ops = append(ops, op)
+		i += n
+	}
+	return ops, resp.Value{}, true
+}
+
+// Handles "BITFIELD key [GET type offset | [OVERFLOW WRAP | SAT | FAIL]
+// SET type offset value | INCRBY type offset increment ...]" and
+// BITFIELD_RO. SET replies with the previous value and INCRBY with the new
+// one, or nil when the value would overflow with OVERFLOW FAIL.
+func (c *core) bitfieldCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	ops, errReply, ok := parseBitfieldOps(args[2:], strings.ToLower(args[0]) == "bitfield_ro")
+	if !ok {
+		return errReply
+	}
+	write := false
+	for _, op := range ops {
+		write = write || op.name != "get"
+	}
+	replies := make([]resp.Value, len(ops))
+	run := func(tx *storage.Tx) error {
+		v, _, err := tx.Get(args[1])
+		if err != nil {
+			return err
+		}
+		b := []byte(v)
+		for i, op := range ops {
+			old := storage.GetBitfield(string(b), op.offset, op.typ)
+			if op.name == "get" {
+				replies[i] = resp.IntegerValue(old)
+				continue
+			}
+			var res int64
+			var ok bool
+			if op.name == "set" {
+				res, ok = op.typ.Fit(op.value, op.overflow)
+			} else {
+				res, ok = op.typ.Add(old, op.value, op.overflow)
+			}
+			if !ok {
+				replies[i] = resp.NullBulkValue()
+				continue
+			}
+			b = storage.SetBitfield(b, op.offset, op.typ, res)
+			if op.name == "set" {
+				replies[i] = resp.IntegerValue(old)
+			} else {
+				replies[i] = resp.IntegerValue(res)
+			}
+		}
+		if string(b) != v {
+			tx.SetKeepTTL(args[1], string(b))
+		}
+		return nil
+	}
+	var err error
+	if write {
+		err = c.db(cl).Update([]string{args[1]}, run)
+	} else {
+		err = c.db(cl).View([]string{args[1]}, run)
+		cl.propagateAs = []string{}
+	}
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.ArrayValue(replies...)
+}
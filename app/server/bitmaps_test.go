@@ -0,0 +1,87 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestBitmapCommands(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"setbit", "b", "9", "1"}, ":0\r\n"},
+		{[]string{"setbit", "b", "9", "1"}, ":1\r\n"},
+		{[]string{"get", "b"}, "$2\r\n\x00\x40\r\n"},
+		{[]string{"getbit", "b", "9"}, ":1\r\n"},
+		{[]string{"getbit", "b", "1000"}, ":0\r\n"},
+		{[]string{"setbit", "b", "-1", "1"}, "-ERR bit offset is not an integer or out of range\r\n"},
+		{[]string{"setbit", "b", "4294967296", "1"}, "-ERR bit offset is not an integer or out of range\r\n"},
+		{[]string{"setbit", "b", "1", "2"}, "-ERR bit is not an integer or out of range\r\n"},
+		{[]string{"set", "s", "foobar"}, "+OK\r\n"},
+		{[]string{"bitcount", "s"}, ":26\r\n"},
+		{[]string{"bitcount", "s", "1", "1"}, ":6\r\n"},
+		{[]string{"bitcount", "s", "-2", "-1"}, ":7\r\n"},
+		{[]string{"bitcount", "s", "5", "30", "bit"}, ":17\r\n"},
+		{[]string{"bitcount", "s", "1"}, "-ERR syntax error\r\n"},
+		{[]string{"bitcount", "missing"}, ":0\r\n"},
+		{[]string{"set", "p", "\xff\xf0\x00"}, "+OK\r\n"},
+		{[]string{"bitpos", "p", "0"}, ":12\r\n"},
+		{[]string{"bitpos", "p", "1", "2"}, ":-1\r\n"},
+		{[]string{"bitpos", "p", "1", "7", "15", "bit"}, ":7\r\n"},
+		{[]string{"bitpos", "p", "0", "0", "0"}, ":-1\r\n"},
+		{[]string{"bitpos", "missing", "0"}, ":0\r\n"},
+		{[]string{"bitpos", "missing", "1"}, ":-1\r\n"},
+		{[]string{"bitpos", "p", "2"}, "-ERR The bit argument must be 1 or 0.\r\n"},
+		{[]string{"set", "x", "\xf0\x0f"}, "+OK\r\n"},
+		{[]string{"set", "y", "\x3c"}, "+OK\r\n"},
+		{[]string{"bitop", "and", "d", "x", "y"}, ":2\r\n"},
+		{[]string{"get", "d"}, "$2\r\n\x30\x00\r\n"},
+		{[]string{"bitop", "not", "d", "y"}, ":1\r\n"},
+		{[]string{"get", "d"}, "$1\r\n\xc3\r\n"},
+		{[]string{"bitop", "not", "d", "x", "y"}, "-ERR BITOP NOT must be called with a single source key.\r\n"},
+		{[]string{"bitop", "or", "d", "missing"}, ":0\r\n"},
+		{[]string{"get", "d"}, "$-1\r\n"},
+		{[]string{"lpush", "l", "a"}, ":1\r\n"},
+		{[]string{"bitop", "or", "d", "x", "l"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"getbit", "l", "0"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+}
+
+func TestBitfieldCommand(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"bitfield", "f", "get", "u8", "0"}, "*1\r\n:0\r\n"},
+		{[]string{"get", "f"}, "$-1\r\n"},
+		{[]string{"bitfield", "f", "set", "i8", "#1", "-2", "get", "u4", "8"}, "*2\r\n:0\r\n:15\r\n"},
+		{[]string{"get", "f"}, "$2\r\n\x00\xfe\r\n"},
+		{[]string{"bitfield", "f", "incrby", "u2", "100", "1", "incrby", "u2", "100", "3"}, "*2\r\n:1\r\n:0\r\n"},
+		{[]string{"bitfield", "f", "overflow", "sat", "incrby", "i8", "8", "-200", "overflow", "fail", "incrby", "i8", "8", "-1"}, "*2\r\n:-128\r\n$-1\r\n"},
+		{[]string{"bitfield", "f", "overflow", "sat", "set", "u8", "0", "300"}, "*1\r\n:0\r\n"},
+		{[]string{"bitfield_ro", "f", "get", "u8", "0", "get", "i8", "8"}, "*2\r\n:255\r\n:-128\r\n"},
+		{[]string{"bitfield", "f", "incrby", "i64", "0", "1"}, "*1\r\n:-36028797018963967\r\n"},
+		{[]string{"bitfield_ro", "f", "set", "u8", "0", "1"}, "-ERR BITFIELD_RO only supports the GET subcommand\r\n"},
+		{[]string{"bitfield", "f", "get", "u64", "0"}, "-ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.\r\n"},
+		{[]string{"bitfield", "f", "get", "i8", "-1"}, "-ERR bit offset is not an integer or out of range\r\n"},
+		{[]string{"bitfield", "f", "overflow", "bogus"}, "-ERR Invalid OVERFLOW type specified\r\n"},
+		{[]string{"bitfield", "f", "set", "i8", "0"}, "-ERR syntax error\r\n"},
+		{[]string{"bitfield", "f", "set", "i8", "0", "x"}, "-ERR value is not an integer or out of range\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+}
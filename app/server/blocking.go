@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+)
+
+// Outcome of a blocking command that could be served
+type blockedResult struct {
+	reply resp.Value
+	// Non blocking command replicas execute instead, e.g. LPOP for BLPOP
+	propagate []string
+	// Keys the command pushed to, they may serve other blocked clients
+	pushed []string
+}
+
+// Tries to execute a blocking command against the keyspace. ok is false
+// while none of its keys can serve it.
+type serveFunc func() (res blockedResult, ok bool)
+
+// A key of one of the logical databases
+type dbKey struct {
+	db  int
+	key string
+}
+
+// A client parked by a blocking command until one of its keys can serve it
+type blockedClient struct {
+	// Database the command runs in
+	db    int
+	keys  []dbKey
+	serve serveFunc
+	// Receives the reply once the client was served
+	done chan resp.Value
+}
+
+// blockingState keeps the clients parked by blocking commands, in the order
+// they blocked in, for every key they wait on.
+type blockingState struct {
+	mu      sync.Mutex
+	waiters map[dbKey][]*blockedClient
+	// Number of blocking commands in progress, lets writers skip the
+	// lock when nobody waits
+	waiting atomic.Int64
+}
+
+func newBlockingState() *blockingState {
+	return &blockingState{waiters: make(map[dbKey][]*blockedClient)}
+}
+
+// Must be called with mu held
+func (b *blockingState) remove(w *blockedClient) {
+	for _, key := range w.keys {
+		b.waiters[key] = slices.DeleteFunc(b.waiters[key], func(o *blockedClient) bool { return o == w })
+		if len(b.waiters[key]) == 0 {
+			delete(b.waiters, key)
+		}
+	}
+}
+
+// Remembers that the command of cl added elements to key, so clients
+// blocked on it are served once the command completed.
+func (c *core) signalKeyAsReady(cl *Client, key string) {
+	c.signalKeyAsReadyIn(cl, cl.db, key)
+}
+
+// signalKeyAsReadyIn is signalKeyAsReady for a key of database db, which
+// need not be the one cl selected.
+func (c *core) signalKeyAsReadyIn(cl *Client, db int, key string) {
+	if c.blocked.waiting.Load() > 0 {
+		cl.readyKeys = append(cl.readyKeys, dbKey{db, key})
+	}
+}
+
+// Serves clients blocked on keys that got new elements. Clients are served
+// in the order they blocked in; a served command may push to other keys,
+// which are then served too.
+func (c *core) serveBlocked(keys []dbKey) {
+	b := c.blocked
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c.serveBlockedLocked(keys)
+}
+
+func (c *core) serveBlockedLocked(keys []dbKey) {
+	b := c.blocked
+	for len(keys) > 0 {
+		key := keys[0]
+		keys = keys[1:]
+		for _, w := range slices.Clone(b.waiters[key]) {
+			res, ok := w.serve()
+			if !ok {
+				continue
+			}
+			b.remove(w)
+			if res.propagate != nil {
+				c.propagateWrite(w.db, res.propagate)
+			}
+			for _, k := range res.pushed {
+				keys = append(keys, dbKey{w.db, k})
+			}
+			w.done <- res.reply
+		}
+	}
+}
+
+// Runs a blocking command: serve is tried right away and, while it can't
+// be served, the client is parked on keys until another client writes to
+// one of them, the timeout passes or the client disconnects. A zero
+// timeout waits forever.
+func (c *core) block(ctx context.Context, cl *Client, keys []string, timeout time.Duration, serve serveFunc) resp.Value {
+	b := c.blocked
+	b.waiting.Add(1)
+	defer b.waiting.Add(-1)
+	if res, ok := serve(); ok {
+		cl.propagateAs = res.propagate
+		for _, key := range res.pushed {
+			c.signalKeyAsReady(cl, key)
+		}
+		return res.reply
+	}
+	// Whatever happens from now on is propagated by serveBlocked
+	cl.propagateAs = []string{}
+
+	w := &blockedClient{db: cl.db, serve: serve, done: make(chan resp.Value, 1)}
+	for _, key := range keys {
+		w.keys = append(w.keys, dbKey{cl.db, key})
+	}
+	b.mu.Lock()
+	// A writer may have pushed since the first attempt but before it could
+	// see this client, so try once more before parking.
+	if res, ok := serve(); ok {
+		if res.propagate != nil {
+			c.propagateWrite(cl.db, res.propagate)
+		}
+		pushed := make([]dbKey, len(res.pushed))
+		for i, k := range res.pushed {
+			pushed[i] = dbKey{cl.db, k}
+		}
+		c.serveBlockedLocked(pushed)
+		b.mu.Unlock()
+		return res.reply
+	}
+	for _, key := range w.keys {
+		b.waiters[key] = append(b.waiters[key], w)
+	}
+	b.mu.Unlock()
+	// Parked clients don't hold up snapshots, whoever serves them
+	// propagates on their behalf
+	if cl.writing {
+		c.writeMu.RUnlock()
+		defer c.writeMu.RLock()
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case reply := <-w.done:
+		return reply
+	case <-expired:
+	case <-ctx.Done():
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case reply := <-w.done:
+		// Served while timing out
+		return reply
+	default:
+	}
+	b.remove(w)
+	return resp.NullArrayValue()
+}
+
+// Parses the timeout of a blocking command, given in seconds with an
+// optional fraction.
+func parseTimeout(s string) (time.Duration, resp.Value, bool) {
+	f, ok := parseFloat(s)
+	if !ok || math.IsInf(f, 0) {
+		return 0, resp.Errorf("timeout is not a float or out of range"), false
+	}
+	if f < 0 {
+		return 0, resp.Errorf("timeout is negative"), false
+	}
+	if f*1000 > float64(math.MaxInt64/int64(time.Millisecond)) {
+		return 0, resp.Errorf("timeout is out of range"), false
+	}
+	// Rounded up to milliseconds like Redis, so a tiny timeout doesn't
+	// turn into waiting forever
+	return time.Duration(math.Ceil(f*1000)) * time.Millisecond, resp.Value{}, true
+}
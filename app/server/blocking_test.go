@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Waits until n clients are blocked on key
+func waitBlocked(t *testing.T, ms *MasterServer, key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		ms.blocked.mu.Lock()
+		got := len(ms.blocked.waiters[dbKey{0, key}])
+		ms.blocked.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d clients blocked on %q", n, key)
+}
+
+// Runs a command in the background and returns a channel with its reply
+func doAsync(ctx context.Context, ms *MasterServer, args ...string) <-chan string {
+	ch := make(chan string, 1)
+	go func() {
+		ch <- ms.dispatch(ctx, newTestClient(), args).String()
+	}()
+	return ch
+}
+
+func TestBlockingPop_FIFO(t *testing.T) {
+	ms := newTestMaster()
+	var mu sync.Mutex
+	var propagated [][]string
+	ms.propagate = func(args []string) {
+		mu.Lock()
+		propagated = append(propagated, args)
+		mu.Unlock()
+	}
+	first := doAsync(context.Background(), ms, "blpop", "q", "other", "0")
+	waitBlocked(t, ms, "q", 1)
+	second := doAsync(context.Background(), ms, "brpop", "q", "0")
+	waitBlocked(t, ms, "q", 2)
+
+	if got := do(ms, newTestClient(), "rpush", "q", "a", "b", "c"); got != ":3\r\n" {
+		t.Fatalf("Unexpected reply to RPUSH: %q", got)
+	}
+	if got := <-first; got != "*2\r\n$1\r\nq\r\n$1\r\na\r\n" {
+		t.Errorf("Unexpected reply to the first client. Got: %q", got)
+	}
+	if got := <-second; got != "*2\r\n$1\r\nq\r\n$1\r\nc\r\n" {
+		t.Errorf("Unexpected reply to the second client. Got: %q", got)
+	}
+	if got := do(ms, newTestClient(), "lrange", "q", "0", "-1"); got != "*1\r\n$1\r\nb\r\n" {
+		t.Errorf("Unexpected list after the pops. Got: %q", got)
+	}
+	expected := [][]string{
+		{"rpush", "q", "a", "b", "c"},
+		{"lpop", "q", "1"},
+		{"rpop", "q", "1"},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(propagated, expected) {
+		t.Errorf("Unexpected propagated commands. Expected: %q, Got: %q", expected, propagated)
+	}
+}
+
+func TestBlockingPop_Timeout(t *testing.T) {
+	ms := newTestMaster()
+	start := time.Now()
+	if got := do(ms, newTestClient(), "blpop", "q", "0.05"); got != "*-1\r\n" {
+		t.Errorf("Unexpected reply after timeout. Got: %q", got)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Unexpected early timeout after %s", elapsed)
+	}
+	if got := do(ms, newTestClient(), "blpop", "q", "-1"); got != "-ERR timeout is negative\r\n" {
+		t.Errorf("Unexpected reply to a negative timeout. Got: %q", got)
+	}
+	if got := do(ms, newTestClient(), "blpop", "q", "soon"); got != "-ERR timeout is not a float or out of range\r\n" {
+		t.Errorf("Unexpected reply to an invalid timeout. Got: %q", got)
+	}
+	ms.blocked.mu.Lock()
+	defer ms.blocked.mu.Unlock()
+	if len(ms.blocked.waiters) != 0 {
+		t.Errorf("Unexpected clients left blocked: %v", ms.blocked.waiters)
+	}
+}
+
+func TestBlockingPop_Disconnect(t *testing.T) {
+	ms := newTestMaster()
+	ctx, cancel := context.WithCancel(context.Background())
+	reply := doAsync(ctx, ms, "blpop", "q", "0")
+	waitBlocked(t, ms, "q", 1)
+	cancel()
+	<-reply
+	waitBlocked(t, ms, "q", 0)
+	do(ms, newTestClient(), "rpush", "q", "a")
+	if got := do(ms, newTestClient(), "llen", "q"); got != ":1\r\n" {
+		t.Errorf("Unexpected element popped by a disconnected client. Got: %q", got)
+	}
+}
+
+func TestBlockingMove_Chain(t *testing.T) {
+	ms := newTestMaster()
+	moved := doAsync(context.Background(), ms, "blmove", "src", "dst", "LEFT", "RIGHT", "0")
+	waitBlocked(t, ms, "src", 1)
+	popped := doAsync(context.Background(), ms, "blmpop", "0", "2", "nothing", "dst", "LEFT", "COUNT", "5")
+	waitBlocked(t, ms, "dst", 1)
+	do(ms, newTestClient(), "lpush", "src", "x")
+	if got := <-moved; got != "$1\r\nx\r\n" {
+		t.Errorf("Unexpected reply to BLMOVE. Got: %q", got)
+	}
+	if got := <-popped; got != "*2\r\n$3\r\ndst\r\n*1\r\n$1\r\nx\r\n" {
+		t.Errorf("Unexpected reply to BLMPOP. Got: %q", got)
+	}
+	if got := do(ms, newTestClient(), "lmpop", "1", "dst", "LEFT"); got != "*-1\r\n" {
+		t.Errorf("Unexpected reply to LMPOP. Got: %q", got)
+	}
+}
+
+func TestBlockingPop_ClientConnectionClosed(t *testing.T) {
+	ms := newTestMaster()
+	server, client := net.Pipe()
+	go ms.serveClient(NewClient(server))
+	client.Write([]byte("*3\r\n$5\r\nBLPOP\r\n$1\r\nq\r\n$1\r\n0\r\n"))
+	waitBlocked(t, ms, "q", 1)
+	client.Close()
+	waitBlocked(t, ms, "q", 0)
+}
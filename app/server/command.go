@@ -0,0 +1,293 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+type CommandFlag uint
+
+const (
+	FlagWrite CommandFlag = 1 << iota
+	FlagReadonly
+	FlagAdmin
+	FlagPubSub
+)
+
+var commandFlagNames = []struct {
+	flag CommandFlag
+	name string
+}{
+	{FlagWrite, "write"},
+	{FlagReadonly, "readonly"},
+	{FlagAdmin, "admin"},
+	{FlagPubSub, "pubsub"},
+}
+
+// Handles a single command. args holds the whole command line, args[0] is the
+// command name. A handler that already wrote its reply itself returns noReply.
+type CommandFunc func(ctx context.Context, cl *Client, args []string) resp.Value
+
+// Returned by handlers that wrote their reply directly to the client
+var noReply = resp.Value{}
+
+type Command struct {
+	Name string
+	// Number of arguments including the command name. A negative arity
+	// means "at least -Arity arguments".
+	Arity int
+	Flags CommandFlag
+	// Positions of the key arguments, as reported by COMMAND INFO.
+	// LastKey -1 means the keys run until the last argument.
+	FirstKey int
+	LastKey  int
+	Step     int
+	Handler  CommandFunc
+}
+
+func (cmd *Command) checkArity(n int) bool {
+	if cmd.Arity >= 0 {
+		return n == cmd.Arity
+	}
+	return n >= -cmd.Arity
+}
+
+// Keys returns the key arguments of the command line, following the key
+// positions of the command.
+func (cmd *Command) Keys(args []string) []string {
+	if cmd.FirstKey <= 0 {
+		return nil
+	}
+	last := cmd.LastKey
+	if last < 0 {
+		last = len(args) + last
+	}
+	var keys []string
+	for i := cmd.FirstKey; i <= last && i < len(args); i += cmd.Step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+func (cmd *Command) flagNames() []string {
+	var names []string
+	for _, f := range commandFlagNames {
+		if cmd.Flags&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// CommandTable maps lowercase command names to their implementation.
+type CommandTable struct {
+	commands map[string]*Command
+}
+
+func NewCommandTable() *CommandTable {
+	return &CommandTable{
+		commands: make(map[string]*Command),
+	}
+}
+
+// Register adds a command, replacing one with the same name.
+func (t *CommandTable) Register(cmd Command) {
+	cmd.Name = strings.ToLower(cmd.Name)
+	if cmd.FirstKey > 0 && cmd.Step == 0 {
+		cmd.Step = 1
+	}
+	t.commands[cmd.Name] = &cmd
+}
+
+func (t *CommandTable) Lookup(name string) (*Command, bool) {
+	cmd, ok := t.commands[strings.ToLower(name)]
+	return cmd, ok
+}
+
+func (t *CommandTable) Len() int {
+	return len(t.commands)
+}
+
+// Names returns all command names in alphabetical order.
+func (t *CommandTable) Names() []string {
+	names := make([]string, 0, len(t.commands))
+	for name := range t.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Reply for an unknown command, with the same wording as Redis
+func unknownCommand(args []string) resp.Value {
+	var sb strings.Builder
+	for _, arg := range args[1:] {
+		if sb.Len() >= 128 {
+			break
+		}
+		sb.WriteString("'" + truncate(arg, 128-sb.Len()) + "' ")
+	}
+	return resp.Errorf("unknown command '%s', with args beginning with: %s", truncate(args[0], 128), sb.String())
+}
+
+func wrongArity(name string) resp.Value {
+	return resp.Errorf("wrong number of arguments for '%s' command", strings.ToLower(name))
+}
+
+func syntaxError() resp.Value {
+	return resp.Errorf("syntax error")
+}
+
+func wrongType() resp.Value {
+	return resp.ErrorValue(storage.ErrWrongType.Error())
+}
+
+// Wraps an error reply so it can be returned from a storage transaction
+type replyError struct {
+	reply resp.Value
+}
+
+func (e *replyError) Error() string {
+	return e.reply.Str
+}
+
+func asError(reply resp.Value) error {
+	return &replyError{reply}
+}
+
+// Converts an error returned by a storage transaction into an error reply.
+// Storage errors already carry their Redis error code.
+func errorReply(err error) resp.Value {
+	var re *replyError
+	if errors.As(err, &re) {
+		return re.reply
+	}
+	var se *storage.StorageError
+	if errors.As(err, &se) {
+		return resp.ErrorValue(se.Error())
+	}
+	return resp.Errorf("%s", err.Error())
+}
+
+func notInteger() resp.Value {
+	return resp.Errorf("value is not an integer or out of range")
+}
+
+// Parses a decimal integer as strictly as Redis does: no sign other than a
+// leading '-', no leading zeros and no spaces.
+func parseInt(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	digits := s
+	if s[0] == '-' {
+		digits = s[1:]
+	}
+	if len(digits) == 0 || digits[0] < '0' || digits[0] > '9' || (digits[0] == '0' && len(s) > 1) {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// Parses a float argument. Like Redis it rejects spaces and NaN.
+func parseFloat(s string) (float64, bool) {
+	if len(s) == 0 || strings.ContainsAny(s, " \t\r\n") {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if (err != nil && !errors.Is(err, strconv.ErrRange)) || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// Describes a command the way COMMAND and COMMAND INFO reply with it
+func commandInfo(cmd *Command) resp.Value {
+	flags := make([]resp.Value, 0)
+	for _, name := range cmd.flagNames() {
+		flags = append(flags, resp.SimpleStringValue(name))
+	}
+	return resp.ArrayValue(
+		resp.BulkValue(cmd.Name),
+		resp.IntegerValue(int64(cmd.Arity)),
+		resp.SetValue(flags...),
+		resp.IntegerValue(int64(cmd.FirstKey)),
+		resp.IntegerValue(int64(cmd.LastKey)),
+		resp.IntegerValue(int64(cmd.Step)),
+		resp.SetValue(),   // ACL categories
+		resp.SetValue(),   // tips
+		resp.ArrayValue(), // key specifications
+		resp.ArrayValue(), // subcommands
+	)
+}
+
+// Handles "COMMAND", "COMMAND COUNT", "COMMAND INFO [name ...]",
+// "COMMAND LIST" and "COMMAND DOCS".
+func (c *core) commandCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	if len(args) == 1 {
+		var infos []resp.Value
+		for _, name := range c.commands.Names() {
+			cmd, _ := c.commands.Lookup(name)
+			infos = append(infos, commandInfo(cmd))
+		}
+		return resp.ArrayValue(infos...)
+	}
+	switch sub := strings.ToLower(args[1]); sub {
+	case "count":
+		if len(args) != 2 {
+			return wrongSubcommandArity("command", sub)
+		}
+		return resp.IntegerValue(int64(c.commands.Len()))
+	case "info":
+		names := args[2:]
+		if len(names) == 0 {
+			names = c.commands.Names()
+		}
+		infos := make([]resp.Value, 0, len(names))
+		for _, name := range names {
+			cmd, ok := c.commands.Lookup(name)
+			if !ok {
+				infos = append(infos, resp.NullArrayValue())
+				continue
+			}
+			infos = append(infos, commandInfo(cmd))
+		}
+		return resp.ArrayValue(infos...)
+	case "list":
+		if len(args) != 2 {
+			return wrongSubcommandArity("command", sub)
+		}
+		var names []resp.Value
+		for _, name := range c.commands.Names() {
+			names = append(names, resp.BulkValue(name))
+		}
+		return resp.ArrayValue(names...)
+	case "docs":
+		// There is no documentation to return, clients treat an empty
+		// reply as "no docs available".
+		return resp.MapValue()
+	}
+	return resp.Errorf("unknown subcommand '%s'. Try COMMAND HELP.", truncate(args[1], 128))
+}
+
+func wrongSubcommandArity(cmd, sub string) resp.Value {
+	return resp.Errorf("wrong number of arguments for '%s|%s' command", cmd, sub)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+func newTestMaster() *MasterServer {
+	cfg := NewConfig(6379, slog.Default(), storage.NewDatabases(16), Replica{}, Persistence{})
+	return NewMasterServer(cfg)
+}
+
+func newTestClient() *Client {
+	conn, _ := net.Pipe()
+	return NewClient(conn)
+}
+
+// Runs a command and returns its RESP2 encoded reply
+func do(ms *MasterServer, cl *Client, args ...string) string {
+	return ms.dispatch(context.Background(), cl, args).String()
+}
+
+func TestDispatch_Errors(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	cases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"foo", "a", "b"}, "-ERR unknown command 'foo', with args beginning with: 'a' 'b' \r\n"},
+		{[]string{"get"}, "-ERR wrong number of arguments for 'get' command\r\n"},
+		{[]string{"GET", "a", "b"}, "-ERR wrong number of arguments for 'get' command\r\n"},
+		{[]string{"set", "a", "b", "px"}, "-ERR syntax error\r\n"},
+		{[]string{"Echo", "hi"}, "$2\r\nhi\r\n"},
+		{[]string{"command", "info", "nosuch"}, "*1\r\n*-1\r\n"},
+	}
+	for _, c := range cases {
+		got := ms.dispatch(context.Background(), cl, c.args).String()
+		if got != c.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", c.args, c.expected, got)
+		}
+	}
+}
+
+func TestDispatch_ReadonlyReplica(t *testing.T) {
+	cfg := NewConfig(6380, slog.Default(), storage.NewDatabases(16), Replica{MasterHost: "localhost", MasterPort: "6379"}, Persistence{})
+	s := NewSlaveServer(cfg)
+	cl := newTestClient()
+	got := s.dispatch(context.Background(), cl, []string{"set", "a", "b"}).String()
+	if got != "-READONLY You can't write against a read only replica.\r\n" {
+		t.Errorf("Unexpected reply from replica: %q", got)
+	}
+	cl.fromMaster = true
+	got = s.dispatch(context.Background(), cl, []string{"set", "a", "b"}).String()
+	if got != "+OK\r\n" {
+		t.Errorf("Replica must apply writes from master, got %q", got)
+	}
+}
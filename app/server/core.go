@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// core is the part shared by master and replica servers: the keyspace and
+// the command table with the commands both roles understand. Role specific
+// behaviour is plugged in through the hooks below.
+type core struct {
+	Logger *slog.Logger
+	// The logical databases clients SELECT from
+	dbs      []*storage.KeyValue
+	commands *CommandTable
+	port     int
+	role     string
+	// Replicas refuse write commands from regular clients
+	readonly bool
+	// Called after a write command succeeded, so master can feed replicas
+	propagate func(args []string)
+	// Database of the last write propagated, guarded by propagateMu. -1
+	// makes the next write select its database explicitly.
+	propagatedDB int
+	propagateMu  *sync.Mutex
+	// Held for reading by write commands until they were propagated, so
+	// snapshots for replicas are taken between two writes
+	writeMu *sync.RWMutex
+	// Role specific INFO sections, appended to the common ones
+	infoSections func() []infoSection
+	// Clients parked by blocking commands
+	blocked *blockingState
+	// RDB snapshots and the writes not saved yet
+	persistence *persistence
+}
+
+func newCore(cfg *Config, role string) core {
+	c := core{
+		Logger:      cfg.logger,
+		dbs:         cfg.dbs,
+		commands:    NewCommandTable(),
+		propagateMu: &sync.Mutex{},
+		writeMu:     &sync.RWMutex{},
+		port:        cfg.port,
+		role:        role,
+		blocked:     newBlockingState(),
+		persistence: newPersistence(cfg.persistence),
+	}
+	return c
+}
+
+// Returns the database selected by the client
+func (c *core) db(cl *Client) *storage.KeyValue {
+	return c.dbs[cl.db]
+}
+
+// Feeds the commands of a write executed in database db to replicas,
+// preceded by a SELECT when the write propagated before was executed in
+// another database. Empty commands are skipped.
+func (c *core) propagateWrite(db int, commands ...[]string) {
+	if c.propagate == nil {
+		return
+	}
+	c.propagateMu.Lock()
+	defer c.propagateMu.Unlock()
+	for _, args := range commands {
+		if len(args) == 0 {
+			continue
+		}
+		if db != c.propagatedDB {
+			c.propagate([]string{"select", strconv.Itoa(db)})
+			c.propagatedDB = db
+		}
+		c.propagate(args)
+	}
+}
+
+func (c *core) registerCommands() {
+	c.commands.Register(Command{Name: "command", Arity: -1, Handler: c.commandCommand})
+	c.commands.Register(Command{Name: "ping", Arity: -1, Handler: c.pingCommand})
+	c.commands.Register(Command{Name: "echo", Arity: 2, Handler: c.echoCommand})
+	c.commands.Register(Command{Name: "hello", Arity: -1, Handler: c.helloCommand})
+	c.commands.Register(Command{Name: "info", Arity: -1, Handler: c.infoCommand})
+	c.registerStringCommands()
+	c.registerKeyCommands()
+	c.registerDBCommands()
+	c.registerExpireCommands()
+	c.registerListCommands()
+	c.registerHashCommands()
+	c.registerSetCommands()
+	c.registerZSetCommands()
+	c.registerStreamCommands()
+	c.registerStreamGroupCommands()
+	c.registerBitmapCommands()
+	c.registerHyperLogLogCommands()
+	c.registerGeoCommands()
+	c.registerPersistenceCommands()
+}
+
+// Executes a single command line and returns its reply
+func (c *core) dispatch(ctx context.Context, cl *Client, args []string) resp.Value {
+	cmd, ok := c.commands.Lookup(args[0])
+	if !ok {
+		return unknownCommand(args)
+	}
+	if !cmd.checkArity(len(args)) {
+		return wrongArity(cmd.Name)
+	}
+	write := cmd.Flags&FlagWrite != 0
+	if write && c.readonly && !cl.fromMaster {
+		return resp.ErrorValue("READONLY You can't write against a read only replica.")
+	}
+	if write {
+		c.writeMu.RLock()
+		cl.writing = true
+		defer func() {
+			cl.writing = false
+			c.writeMu.RUnlock()
+		}()
+	}
+	reply := cmd.Handler(ctx, cl, args)
+	if cl.propagateAs != nil {
+		args = cl.propagateAs
+		cl.propagateAs = nil
+	}
+	also := cl.alsoPropagate
+	cl.alsoPropagate = nil
+	if write && !reply.IsError() && (len(args) > 0 || len(also) > 0) {
+		c.persistence.dirty.Add(1)
+		c.propagateWrite(cl.db, append(also, args)...)
+	}
+	if cl.readyKeys != nil {
+		keys := cl.readyKeys
+		cl.readyKeys = nil
+		c.serveBlocked(keys)
+	}
+	return reply
+}
+
+// A command read from a client, or the error that ended the connection
+type request struct {
+	args []string
+	err  error
+	// More commands of the same pipeline are already buffered
+	pipelined bool
+}
+
+// Reads commands from the client until it disconnects, and answers them
+func (c *core) serveClient(cl *Client) {
+	defer cl.conn.Close()
+	c.Logger.Info("New connection accepted", "address", cl.conn.RemoteAddr())
+	// Commands are read by a separate goroutine, so a disconnect is noticed
+	// while a blocking command waits and cancels it through ctx.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+	requests := make(chan request)
+	go cl.readRequests(cancel, requests, done)
+	for req := range requests {
+		if req.err != nil {
+			if req.err == io.EOF {
+				c.Logger.Info("connection closed by client")
+				return
+			}
+			var protoErr *resp.ProtocolError
+			if errors.As(req.err, &protoErr) {
+				cl.WriteValue(resp.Errorf("%s", protoErr.Error()))
+				cl.writer.Flush()
+			}
+			c.Logger.Error("error reading from connection", "error", req.err.Error())
+			return
+		}
+		reply := c.dispatch(ctx, cl, req.args)
+		if reply.Type != noReply.Type {
+			cl.WriteValue(reply)
+		}
+		err := cl.flushIfIdle(req.pipelined)
+		if err != nil {
+			c.Logger.Error("error writing to connection", "error", err.Error())
+			return
+		}
+	}
+}
+
+func (c *core) pingCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	switch len(args) {
+	case 1:
+		return resp.Pong
+	case 2:
+		return resp.BulkValue(args[1])
+	}
+	return wrongArity(args[0])
+}
+
+func (c *core) echoCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	return resp.BulkValue(args[1])
+}
+
+func (c *core) helloCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	return hello(cl, c.role, args[1:])
+}
+
+func (c *core) infoCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	sections := []infoSection{
+		{"Server", [][2]string{
+			{"redis_version", redisVersion},
+			{"tcp_port", strconv.Itoa(c.port)},
+		}},
+		c.persistenceInfo(),
+	}
+	if c.infoSections != nil {
+		sections = append(sections, c.infoSections()...)
+	}
+	sections = append(sections, c.keyspaceInfo())
+	return infoReply(cl, selectInfoSections(sections, args[1:]))
+}
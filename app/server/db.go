@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+func (c *core) registerDBCommands() {
+	c.commands.Register(Command{Name: "select", Arity: 2, Handler: c.selectCommand})
+	c.commands.Register(Command{Name: "swapdb", Arity: 3, Flags: FlagWrite, Handler: c.swapdbCommand})
+}
+
+// Reports whether db is the index of an existing logical database
+func (c *core) validDB(db int64) bool {
+	return db >= 0 && db < int64(len(c.dbs))
+}
+
+// Runs fn with keysA of database a and keysB of database b locked for
+// writing. Databases are locked in index order, so commands touching two of
+// them can't deadlock each other.
+func (c *core) updateDBs(a int, keysA []string, b int, keysB []string, fn func(txA, txB *storage.Tx) error) error {
+	if a == b {
+		return c.dbs[a].Update(append(append([]string{}, keysA...), keysB...), func(tx *storage.Tx) error {
+			return fn(tx, tx)
+		})
+	}
+	if a > b {
+		return c.updateDBs(b, keysB, a, keysA, func(txB, txA *storage.Tx) error {
+			return fn(txA, txB)
+		})
+	}
+	return c.dbs[a].Update(keysA, func(txA *storage.Tx) error {
+		return c.dbs[b].Update(keysB, func(txB *storage.Tx) error {
+			return fn(txA, txB)
+		})
+	})
+}
+
+// Handles "SELECT index"
+func (c *core) selectCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	db, ok := parseInt(args[1])
+	if !ok {
+		return notInteger()
+	}
+	if !c.validDB(db) {
+		return resp.Errorf("DB index is out of range")
+	}
+	cl.db = int(db)
+	return resp.OK
+}
+
+// Handles "SWAPDB index1 index2". Clients stay connected to the same
+// index and see the data of the other database from then on.
+func (c *core) swapdbCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	a, ok := parseInt(args[1])
+	if !ok {
+		return resp.Errorf("invalid first DB index")
+	}
+	b, ok := parseInt(args[2])
+	if !ok {
+		return resp.Errorf("invalid second DB index")
+	}
+	if !c.validDB(a) || !c.validDB(b) {
+		return resp.Errorf("DB index is out of range")
+	}
+	if a == b {
+		return resp.OK
+	}
+	c.dbs[min(a, b)].Swap(c.dbs[max(a, b)])
+	// Clients blocked in either database may find their keys filled now
+	if c.blocked.waiting.Load() > 0 {
+		c.blocked.mu.Lock()
+		for k := range c.blocked.waiters {
+			if k.db == int(a) || k.db == int(b) {
+				cl.readyKeys = append(cl.readyKeys, k)
+			}
+		}
+		c.blocked.mu.Unlock()
+	}
+	return resp.OK
+}
+
+// Lists the databases holding keys, as the Keyspace section of INFO
+func (c *core) keyspaceInfo() infoSection {
+	s := infoSection{name: "Keyspace"}
+	for i, db := range c.dbs {
+		keys := db.Len()
+		if keys == 0 {
+			continue
+		}
+		s.fields = append(s.fields, [2]string{
+			"db" + strconv.Itoa(i),
+			fmt.Sprintf("keys=%d,expires=%d,avg_ttl=%d", keys, db.Expires(), db.AvgTTL()),
+		})
+	}
+	return s
+}
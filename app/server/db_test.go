@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelectAndMove(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"set", "a", "0"}, "+OK\r\n"},
+		{[]string{"select", "1"}, "+OK\r\n"},
+		{[]string{"get", "a"}, "$-1\r\n"},
+		{[]string{"set", "a", "1", "ex", "100"}, "+OK\r\n"},
+		{[]string{"dbsize"}, ":1\r\n"},
+		{[]string{"select", "16"}, "-ERR DB index is out of range\r\n"},
+		{[]string{"select", "-1"}, "-ERR DB index is out of range\r\n"},
+		{[]string{"select", "x"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"move", "a", "0"}, ":0\r\n"},
+		{[]string{"move", "a", "2"}, ":1\r\n"},
+		{[]string{"move", "a", "2"}, ":0\r\n"},
+		{[]string{"exists", "a"}, ":0\r\n"},
+		{[]string{"select", "2"}, "+OK\r\n"},
+		{[]string{"get", "a"}, "$1\r\n1\r\n"},
+		{[]string{"ttl", "a"}, ":100\r\n"},
+		{[]string{"copy", "a", "a", "db", "0", "replace"}, ":1\r\n"},
+		{[]string{"copy", "a", "b", "db", "3"}, ":1\r\n"},
+		{[]string{"select", "0"}, "+OK\r\n"},
+		{[]string{"get", "a"}, "$1\r\n1\r\n"},
+		{[]string{"ttl", "a"}, ":100\r\n"},
+		{[]string{"flushdb"}, "+OK\r\n"},
+		{[]string{"select", "3"}, "+OK\r\n"},
+		{[]string{"get", "b"}, "$1\r\n1\r\n"},
+		{[]string{"flushall"}, "+OK\r\n"},
+		{[]string{"dbsize"}, ":0\r\n"},
+		{[]string{"select", "2"}, "+OK\r\n"},
+		{[]string{"dbsize"}, ":0\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+}
+
+func TestSwapDB(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	other := newTestClient()
+	steps := []struct {
+		cl       *Client
+		args     []string
+		expected string
+	}{
+		{cl, []string{"set", "a", "0"}, "+OK\r\n"},
+		{other, []string{"select", "1"}, "+OK\r\n"},
+		{other, []string{"set", "b", "1"}, "+OK\r\n"},
+		{cl, []string{"swapdb", "0", "1"}, "+OK\r\n"},
+		{cl, []string{"get", "b"}, "$1\r\n1\r\n"},
+		{cl, []string{"get", "a"}, "$-1\r\n"},
+		{other, []string{"get", "a"}, "$1\r\n0\r\n"},
+		{cl, []string{"swapdb", "1", "1"}, "+OK\r\n"},
+		{cl, []string{"swapdb", "x", "1"}, "-ERR invalid first DB index\r\n"},
+		{cl, []string{"swapdb", "0", "x"}, "-ERR invalid second DB index\r\n"},
+		{cl, []string{"swapdb", "0", "16"}, "-ERR DB index is out of range\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, s.cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+}
+
+func TestSwapDBServesBlockedClients(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	reply := doAsync(context.Background(), ms, "blpop", "q", "0")
+	waitBlocked(t, ms, "q", 1)
+	do(ms, cl, "select", "5")
+	do(ms, cl, "rpush", "q", "v")
+	do(ms, cl, "swapdb", "5", "0")
+	select {
+	case got := <-reply:
+		if got != "*2\r\n$1\r\nq\r\n$1\r\nv\r\n" {
+			t.Errorf("Unexpected reply: %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("BLPOP was not served")
+	}
+}
+
+func TestPropagateSelect(t *testing.T) {
+	ms := newTestMaster()
+	var propagated [][]string
+	ms.propagate = func(args []string) { propagated = append(propagated, args) }
+	cl := newTestClient()
+	do(ms, cl, "set", "a", "0")
+	do(ms, cl, "select", "2")
+	do(ms, cl, "set", "a", "2")
+	do(ms, cl, "set", "b", "2")
+	do(ms, newTestClient(), "set", "a", "0")
+	expected := [][]string{
+		{"set", "a", "0"},
+		{"select", "2"},
+		{"set", "a", "2"},
+		{"set", "b", "2"},
+		{"select", "0"},
+		{"set", "a", "0"},
+	}
+	if !reflect.DeepEqual(propagated, expected) {
+		t.Errorf("Unexpected propagated commands. Expected: %q, Got: %q", expected, propagated)
+	}
+}
+
+func TestInfoKeyspace(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	do(ms, cl, "set", "a", "0")
+	do(ms, cl, "set", "b", "0", "ex", "100")
+	do(ms, cl, "select", "3")
+	do(ms, cl, "set", "a", "0")
+	got := do(ms, cl, "info", "keyspace")
+	if !strings.Contains(got, "# Keyspace\r\n") {
+		t.Fatalf("Missing Keyspace section: %q", got)
+	}
+	if !strings.Contains(got, "db0:keys=2,expires=1,avg_ttl=") || strings.Contains(got, "avg_ttl=0\r\ndb3") {
+		t.Errorf("Unexpected db0 line: %q", got)
+	}
+	if !strings.Contains(got, "db3:keys=1,expires=0,avg_ttl=0\r\n") {
+		t.Errorf("Unexpected db3 line: %q", got)
+	}
+	if strings.Contains(got, "db1:") {
+		t.Errorf("Empty database listed: %q", got)
+	}
+}
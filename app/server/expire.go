@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// Redis runs its active expire cycle with hz 10
+const activeExpireInterval = 100 * time.Millisecond
+
+// Runs the active expire cycle of every database in the background. A
+// cycle counts as a write command, so the deletions it propagates are not
+// made between a snapshot for a replica and the registration of the replica.
+func (c *core) startActiveExpire() {
+	for _, db := range c.dbs {
+		go func() {
+			ticker := time.NewTicker(activeExpireInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				c.writeMu.RLock()
+				db.ActiveExpireCycle()
+				c.writeMu.RUnlock()
+			}
+		}()
+	}
+}
+
+// Makes the deletion of keys and hash fields whose TTL is over a write that
+// is propagated as DEL or HDEL. Replicas never expire keys themselves, they
+// wait for these, so they don't diverge from master when their clock does.
+func (c *core) propagateExpired() {
+	for i, db := range c.dbs {
+		db.OnExpire(func(key string, fields []string) {
+			c.persistence.dirty.Add(1)
+			if fields == nil {
+				c.propagateWrite(i, []string{"del", key})
+			} else {
+				c.propagateWrite(i, append([]string{"hdel", key}, fields...))
+			}
+		})
+	}
+}
+
+func (c *core) registerExpireCommands() {
+	for _, name := range []string{"expire", "pexpire", "expireat", "pexpireat"} {
+		c.commands.Register(Command{Name: name, Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.expireCommand})
+	}
+	for _, name := range []string{"ttl", "pttl", "expiretime", "pexpiretime"} {
+		c.commands.Register(Command{Name: name, Arity: 2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.ttlCommand})
+	}
+	c.commands.Register(Command{Name: "persist", Arity: 2, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.persistCommand})
+}
+
+// Handles "EXPIRE key seconds [NX | XX | GT | LT]" and its PEXPIRE, EXPIREAT
+// and PEXPIREAT variants.
+func (c *core) expireCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	name := strings.ToLower(args[0])
+	key := args[1]
+	n, ok := parseInt(args[2])
+	if !ok {
+		return notInteger()
+	}
+	var nx, xx, gt, lt bool
+	for _, opt := range args[3:] {
+		switch strings.ToLower(opt) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "gt":
+			gt = true
+		case "lt":
+			lt = true
+		default:
+			return resp.Errorf("Unsupported option %s", opt)
+		}
+	}
+	if nx && (xx || gt || lt) {
+		return resp.Errorf("NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if gt && lt {
+		return resp.Errorf("GT and LT options at the same time are not compatible")
+	}
+
+	at, ok := expireTime(name, n, time.Now().UnixMilli())
+	if !ok {
+		return resp.Errorf("invalid expire time in '%s' command", name)
+	}
+	var set bool
+	c.db(cl).Update([]string{key}, func(tx *storage.Tx) error {
+		current, exists := tx.ExpireAt(key)
+		if !exists {
+			return nil
+		}
+		// A key without TTL behaves as if its TTL was infinite
+		switch {
+		case nx && current != -1,
+			xx && current == -1,
+			gt && (current == -1 || at <= current),
+			lt && current != -1 && at >= current:
+			return nil
+		}
+		set = tx.SetExpireAt(key, at)
+		return nil
+	})
+	if set {
+		// Replicas get the absolute time, so their TTL doesn't depend on
+		// when the write reaches them
+		cl.propagateAs = []string{"pexpireat", key, strconv.FormatInt(at, 10)}
+		return resp.IntegerValue(1)
+	}
+	cl.propagateAs = []string{}
+	return resp.IntegerValue(0)
+}
+
+// Converts the argument of an EXPIRE family command into unix milliseconds.
+// ok is false when the result does not fit into 64 bits.
+func expireTime(cmd string, n int64, now int64) (int64, bool) {
+	if cmd == "expire" || cmd == "expireat" {
+		if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+			return 0, false
+		}
+		n *= 1000
+	}
+	if cmd == "expire" || cmd == "pexpire" {
+		if (n > 0 && now > math.MaxInt64-n) || (n < 0 && now < math.MinInt64-n) {
+			return 0, false
+		}
+		n += now
+	}
+	return n, true
+}
+
+// Handles TTL, PTTL, EXPIRETIME and PEXPIRETIME. They reply -2 when the key
+// does not exist and -1 when it has no TTL.
+func (c *core) ttlCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	name := strings.ToLower(args[0])
+	var at, now int64
+	var exists bool
+	c.db(cl).View([]string{args[1]}, func(tx *storage.Tx) error {
+		at, exists = tx.ExpireAt(args[1])
+		now = tx.Now()
+		return nil
+	})
+	if !exists {
+		return resp.IntegerValue(-2)
+	}
+	if at == -1 {
+		return resp.IntegerValue(-1)
+	}
+	return resp.IntegerValue(ttlValue(name, at, now))
+}
+
+// Converts an expiry time in unix milliseconds into the reply of TTL, PTTL,
+// EXPIRETIME or PEXPIRETIME.
+func ttlValue(cmd string, at, now int64) int64 {
+	switch cmd {
+	case "ttl":
+		return (at - now + 500) / 1000
+	case "pttl":
+		return at - now
+	case "expiretime":
+		return at / 1000
+	}
+	return at
+}
+
+func (c *core) persistCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var removed bool
+	c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		removed = tx.Persist(args[1])
+		return nil
+	})
+	if removed {
+		return resp.IntegerValue(1)
+	}
+	cl.propagateAs = []string{}
+	return resp.IntegerValue(0)
+}
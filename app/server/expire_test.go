@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// Records the commands master propagates to replicas
+func recordPropagated(ms *MasterServer) *[][]string {
+	var propagated [][]string
+	ms.propagate = func(args []string) { propagated = append(propagated, args) }
+	return &propagated
+}
+
+// Checks that a propagated command is expected with its absolute time at
+// position i, which must be d milliseconds after a time in [before, after]
+func checkAbsoluteTime(t *testing.T, got, expected []string, i int, before, after, d int64) {
+	t.Helper()
+	at, err := strconv.ParseInt(got[i], 10, 64)
+	if err != nil || at < before+d || at > after+d {
+		t.Errorf("Unexpected time in %q, expected %d ms after [%d, %d]", got, d, before, after)
+	}
+	got = append([]string{}, got...)
+	got[i] = expected[i]
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("Unexpected propagated command. Expected: %q, Got: %q", expected, got)
+	}
+}
+
+func TestExpire_PropagatesAbsoluteTime(t *testing.T) {
+	ms := newTestMaster()
+	propagated := recordPropagated(ms)
+	cl := newTestClient()
+	do := func(args ...string) {
+		ms.dispatch(context.Background(), cl, args)
+	}
+	do("set", "a", "1")
+	before := time.Now().UnixMilli()
+	do("pexpire", "a", "5000")
+	do("expire", "a", "100", "gt")
+	after := time.Now().UnixMilli()
+	do("expireat", "a", "4000000000")
+	// Nothing changes, nothing is propagated
+	do("expire", "a", "100", "nx")
+	do("expire", "missing", "100")
+	if len(*propagated) != 4 {
+		t.Fatalf("Unexpected propagated commands: %q", *propagated)
+	}
+	checkAbsoluteTime(t, (*propagated)[1], []string{"pexpireat", "a", ""}, 2, before, after, 5000)
+	checkAbsoluteTime(t, (*propagated)[2], []string{"pexpireat", "a", ""}, 2, before, after, 100000)
+	if got := strings.Join((*propagated)[3], " "); got != "pexpireat a 4000000000000" {
+		t.Errorf("Unexpected propagated command: %q", got)
+	}
+}
+
+func TestExpire_ReplicaWaitsForMaster(t *testing.T) {
+	ms := newTestMaster()
+	propagated := recordPropagated(ms)
+	cl := newTestClient()
+	cfg := NewConfig(6380, slog.Default(), storage.NewDatabases(16), Replica{MasterHost: "localhost", MasterPort: "6379"}, Persistence{})
+	s := NewSlaveServer(cfg)
+	master := newTestClient()
+	master.fromMaster = true
+	replay := func() {
+		for _, args := range *propagated {
+			if got := s.dispatch(context.Background(), master, args).String(); strings.HasPrefix(got, "-") {
+				t.Errorf("%q: unexpected error on the replica: %q", args, got)
+			}
+		}
+		*propagated = nil
+	}
+	do(ms, cl, "set", "k", "old", "px", "20")
+	do(ms, cl, "set", "gone", "x", "px", "20")
+	do(ms, cl, "hset", "h", "a", "1", "b", "2")
+	do(ms, cl, "hpexpire", "h", "20", "fields", "1", "a")
+	replay()
+	time.Sleep(30 * time.Millisecond)
+
+	// The replica hides what expired, but keeps it for master to delete
+	reader := newTestClient()
+	if got := s.dispatch(context.Background(), reader, []string{"get", "k"}).String(); got != "$-1\r\n" {
+		t.Errorf("Unexpected reply from the replica: %q", got)
+	}
+	if n := s.dbs[0].Len(); n != 3 {
+		t.Errorf("The replica deleted expired keys itself, %d left", n)
+	}
+	do(ms, cl, "set", "k", "new", "nx")
+	do(ms, cl, "hset", "h", "b", "3")
+	ms.dbs[0].ActiveExpireCycle()
+	expected := [][]string{{"del", "k"}, {"set", "k", "new", "nx"}, {"hdel", "h", "a"}, {"hset", "h", "b", "3"}, {"del", "gone"}}
+	if !reflect.DeepEqual(*propagated, expected) {
+		t.Errorf("Unexpected propagated commands: %q", *propagated)
+	}
+	replay()
+	for _, args := range [][]string{{"get", "k"}, {"hgetall", "h"}, {"dbsize"}} {
+		if got, want := s.dispatch(context.Background(), reader, args).String(), do(ms, cl, args...); got != want {
+			t.Errorf("%q: the replica diverged. Master: %q, Replica: %q", args, want, got)
+		}
+	}
+	if n := s.dbs[0].Len(); n != 2 {
+		t.Errorf("Unexpected number of keys on the replica: %d", n)
+	}
+}
@@ -0,0 +1,444 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/geohash"
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// Geo commands keep points in sorted sets, scored by their 52 bit geohash.
+
+func (c *core) registerGeoCommands() {
+	c.commands.Register(Command{Name: "geoadd", Arity: -5, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.geoaddCommand})
+	c.commands.Register(Command{Name: "geopos", Arity: -2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.geoposCommand})
+	c.commands.Register(Command{Name: "geodist", Arity: -4, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.geodistCommand})
+	c.commands.Register(Command{Name: "geohash", Arity: -2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.geohashCommand})
+	c.commands.Register(Command{Name: "geosearch", Arity: -7, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.geosearchCommand})
+	c.commands.Register(Command{Name: "geosearchstore", Arity: -8, Flags: FlagWrite, FirstKey: 1, LastKey: 2, Handler: c.geosearchCommand})
+}
+
+// Parses a longitude latitude pair
+func parseLonLat(lonArg, latArg string) (lon, lat float64, errReply resp.Value, ok bool) {
+	lon, ok1 := parseFloat(lonArg)
+	lat, ok2 := parseFloat(latArg)
+	if !ok1 || !ok2 {
+		return 0, 0, resp.Errorf("value is not a valid float"), false
+	}
+	if !geohash.Valid(lon, lat) {
+		return 0, 0, resp.Errorf("invalid longitude,latitude pair %f,%f", lon, lat), false
+	}
+	return lon, lat, resp.Value{}, true
+}
+
+// Returns the meters per unit of distance
+func parseUnit(s string) (float64, resp.Value, bool) {
+	switch strings.ToLower(s) {
+	case "m":
+		return 1, resp.Value{}, true
+	case "km":
+		return 1000, resp.Value{}, true
+	case "ft":
+		return 0.3048, resp.Value{}, true
+	case "mi":
+		return 1609.34, resp.Value{}, true
+	}
+	return 0, resp.Errorf("unsupported unit provided. please use M, KM, FT, MI"), false
+}
+
+// Formats a distance with four decimals
+func distanceReply(d float64) resp.Value {
+	return resp.BulkValue(strconv.FormatFloat(d, 'f', 4, 64))
+}
+
+// Formats a coordinate with up to 17 decimals and no trailing zeros, like
+// Redis' human readable long doubles
+func coordValue(cl *Client, f float64) resp.Value {
+	if cl.Protocol() == resp.RESP3 {
+		return resp.DoubleValue(f)
+	}
+	s := strconv.FormatFloat(f, 'f', 17, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" {
+		s = "0"
+	}
+	return resp.BulkValue(s)
+}
+
+func coordsReply(cl *Client, lon, lat float64) resp.Value {
+	return resp.ArrayValue(coordValue(cl, lon), coordValue(cl, lat))
+}
+
+// Handles "GEOADD key [NX | XX] [CH] longitude latitude member [longitude
+// latitude member ...]" as a ZADD with the geohashes as scores, which is
+// also what gets propagated.
+func (c *core) geoaddCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	zadd := []string{"zadd", args[1]}
+	var nx, xx bool
+	i := 2
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ch":
+		default:
+			break options
+		}
+		zadd = append(zadd, args[i])
+	}
+	triples := args[i:]
+	if len(triples) == 0 || len(triples)%3 != 0 || (nx && xx) {
+		return syntaxError()
+	}
+	for j := 0; j < len(triples); j += 3 {
+		lon, lat, errReply, ok := parseLonLat(triples[j], triples[j+1])
+		if !ok {
+			return errReply
+		}
+		score, _ := geohash.Score(lon, lat)
+		zadd = append(zadd, strconv.FormatUint(uint64(score), 10), triples[j+2])
+	}
+	reply := c.zaddCommand(ctx, cl, zadd)
+	// ZADD already suppressed the propagation when nothing changed
+	if cl.propagateAs == nil {
+		cl.propagateAs = zadd
+	}
+	return reply
+}
+
+// Handles "GEOPOS key [member ...]". Missing members get a nil reply.
+func (c *core) geoposCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	items := make([]resp.Value, len(args)-2)
+	for i := range items {
+		items[i] = resp.NullArrayValue()
+	}
+	err := c.viewZSet(cl, args[1], func(z *storage.ZSet) {
+		if z == nil {
+			return
+		}
+		for i, m := range args[2:] {
+			if score, ok := z.Score(m); ok {
+				lon, lat := geohash.FromScore(score)
+				items[i] = coordsReply(cl, lon, lat)
+			}
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.ArrayValue(items...)
+}
+
+// Handles "GEODIST key member1 member2 [M | KM | FT | MI]"
+func (c *core) geodistCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	if len(args) > 5 {
+		return syntaxError()
+	}
+	unit := 1.0
+	if len(args) == 5 {
+		var errReply resp.Value
+		var ok bool
+		if unit, errReply, ok = parseUnit(args[4]); !ok {
+			return errReply
+		}
+	}
+	var d float64
+	var found bool
+	err := c.viewZSet(cl, args[1], func(z *storage.ZSet) {
+		if z == nil {
+			return
+		}
+		s1, ok1 := z.Score(args[2])
+		s2, ok2 := z.Score(args[3])
+		if !ok1 || !ok2 {
+			return
+		}
+		lon1, lat1 := geohash.FromScore(s1)
+		lon2, lat2 := geohash.FromScore(s2)
+		d, found = geohash.Distance(lon1, lat1, lon2, lat2), true
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !found {
+		return resp.NullBulkValue()
+	}
+	return distanceReply(d / unit)
+}
+
+// Handles "GEOHASH key [member ...]" with the standard 11 character
+// geohashes of the members.
+func (c *core) geohashCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	items := make([]resp.Value, len(args)-2)
+	for i := range items {
+		items[i] = resp.NullBulkValue()
+	}
+	err := c.viewZSet(cl, args[1], func(z *storage.ZSet) {
+		if z == nil {
+			return
+		}
+		for i, m := range args[2:] {
+			if score, ok := z.Score(m); ok {
+				items[i] = resp.BulkValue(geohash.String(score))
+			}
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.ArrayValue(items...)
+}
+
+// Options of GEOSEARCH and GEOSEARCHSTORE
+type geoSearch struct {
+	// FROMMEMBER member, the center is looked up when searching
+	fromMember bool
+	member     string
+	fromSet    bool
+	bySet      bool
+	shape      geohash.Shape
+	// Meters per unit of the distances
+	unit float64
+	// 1 for ASC, -1 for DESC
+	sort      int
+	count     int
+	any       bool
+	withCoord bool
+	withDist  bool
+	withHash  bool
+	storeDist bool
+}
+
+// Parses the options of GEOSEARCH, or GEOSEARCHSTORE with store set
+func parseGeoSearch(cmd string, args []string, store bool) (*geoSearch, resp.Value, bool) {
+	s := &geoSearch{}
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "withcoord":
+			s.withCoord = true
+		case opt == "withdist":
+			s.withDist = true
+		case opt == "withhash":
+			s.withHash = true
+		case opt == "any":
+			s.any = true
+		case opt == "asc":
+			s.sort = 1
+		case opt == "desc":
+			s.sort = -1
+		case opt == "storedist" && store:
+			s.storeDist = true
+		case opt == "count" && remaining > 0:
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				return nil, notInteger(), false
+			}
+			if n <= 0 {
+				return nil, resp.Errorf("COUNT must be > 0"), false
+			}
+			s.count = int(n)
+			i++
+		case opt == "frommember" && remaining > 0 && !s.fromSet:
+			s.fromMember, s.member, s.fromSet = true, args[i+1], true
+			i++
+		case opt == "fromlonlat" && remaining > 1 && !s.fromSet:
+			lon, lat, errReply, ok := parseLonLat(args[i+1], args[i+2])
+			if !ok {
+				return nil, errReply, false
+			}
+			s.shape.Lon, s.shape.Lat, s.fromSet = lon, lat, true
+			i += 2
+		case opt == "byradius" && remaining > 1 && !s.bySet:
+			r, ok := parseFloat(args[i+1])
+			if !ok {
+				return nil, resp.Errorf("need numeric radius"), false
+			}
+			if r < 0 {
+				return nil, resp.Errorf("radius cannot be negative"), false
+			}
+			unit, errReply, ok := parseUnit(args[i+2])
+			if !ok {
+				return nil, errReply, false
+			}
+			s.shape.Radius, s.unit, s.bySet = r*unit, unit, true
+			i += 2
+		case opt == "bybox" && remaining > 2 && !s.bySet:
+			w, ok1 := parseFloat(args[i+1])
+			h, ok2 := parseFloat(args[i+2])
+			if !ok1 {
+				return nil, resp.Errorf("need numeric width"), false
+			}
+			if !ok2 {
+				return nil, resp.Errorf("need numeric height"), false
+			}
+			if w < 0 || h < 0 {
+				return nil, resp.Errorf("height or width cannot be negative"), false
+			}
+			unit, errReply, ok := parseUnit(args[i+3])
+			if !ok {
+				return nil, errReply, false
+			}
+			s.shape.Box, s.shape.Width, s.shape.Height, s.unit, s.bySet = true, w*unit, h*unit, unit, true
+			i += 3
+		default:
+			return nil, syntaxError(), false
+		}
+	}
+	switch {
+	case !s.fromSet:
+		return nil, resp.Errorf("exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", cmd), false
+	case !s.bySet:
+		return nil, resp.Errorf("exactly one of BYRADIUS and BYBOX can be specified for %s", cmd), false
+	case s.any && s.count == 0:
+		return nil, resp.Errorf("the ANY argument requires COUNT argument"), false
+	case store && (s.withCoord || s.withDist || s.withHash):
+		return nil, resp.Errorf("%s is not compatible with WITHDIST, WITHHASH and WITHCOORD options", cmd), false
+	}
+	// COUNT without ANY returns the closest points
+	if s.count > 0 && s.sort == 0 && !s.any {
+		s.sort = 1
+	}
+	return s, resp.Value{}, true
+}
+
+// A point found by a geo search
+type geoPoint struct {
+	member   string
+	score    float64
+	lon, lat float64
+	// Distance from the center in meters
+	dist float64
+}
+
+// Returns the points of z within the shape, scanning the geohash areas that
+// cover it. With ANY the scan stops after count points.
+func (s *geoSearch) run(z *storage.ZSet) []geoPoint {
+	var points []geoPoint
+	limit := 0
+	if s.any {
+		limit = s.count
+	}
+	for _, area := range s.shape.Areas() {
+		if limit > 0 && len(points) >= limit {
+			break
+		}
+		min, max := area.ScoreRange()
+		for _, m := range z.RangeByScore(storage.ScoreRange{Min: min, Max: max, MaxEx: true}, false, 0, -1) {
+			lon, lat := geohash.FromScore(m.Score)
+			dist, ok := s.shape.Contains(lon, lat)
+			if !ok {
+				continue
+			}
+			points = append(points, geoPoint{m.Member, m.Score, lon, lat, dist})
+			if limit > 0 && len(points) >= limit {
+				break
+			}
+		}
+	}
+	if s.sort != 0 {
+		sort.SliceStable(points, func(i, j int) bool {
+			if s.sort > 0 {
+				return points[i].dist < points[j].dist
+			}
+			return points[i].dist > points[j].dist
+		})
+	}
+	if s.count > 0 && len(points) > s.count {
+		points = points[:s.count]
+	}
+	return points
+}
+
+func (s *geoSearch) reply(cl *Client, points []geoPoint) resp.Value {
+	items := make([]resp.Value, len(points))
+	for i, p := range points {
+		if !s.withDist && !s.withHash && !s.withCoord {
+			items[i] = resp.BulkValue(p.member)
+			continue
+		}
+		item := []resp.Value{resp.BulkValue(p.member)}
+		if s.withDist {
+			item = append(item, distanceReply(p.dist/s.unit))
+		}
+		if s.withHash {
+			item = append(item, resp.IntegerValue(int64(p.score)))
+		}
+		if s.withCoord {
+			item = append(item, coordsReply(cl, p.lon, p.lat))
+		}
+		items[i] = resp.ArrayValue(item...)
+	}
+	return resp.ArrayValue(items...)
+}
+
+// Handles "GEOSEARCH key FROMMEMBER member | FROMLONLAT longitude latitude
+// BYRADIUS radius unit | BYBOX width height unit [ASC | DESC] [COUNT count
+// [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]" and "GEOSEARCHSTORE destination
+// source ... [STOREDIST]", which stores the points with their geohashes, or
+// their distances with STOREDIST, as scores.
+func (c *core) geosearchCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	cmd := strings.ToLower(args[0])
+	store := cmd == "geosearchstore"
+	key, dst, opts := args[1], "", args[2:]
+	keys := []string{key}
+	if store {
+		key, dst, opts = args[2], args[1], args[3:]
+		keys = []string{dst, key}
+	}
+	s, errReply, ok := parseGeoSearch(strings.ToUpper(cmd), opts, store)
+	if !ok {
+		return errReply
+	}
+	var points []geoPoint
+	search := func(tx *storage.Tx) error {
+		z, err := tx.ZSet(key, false)
+		if err != nil || z == nil {
+			return err
+		}
+		if s.fromMember {
+			score, ok := z.Score(s.member)
+			if !ok {
+				return asError(resp.Errorf("could not decode requested zset member"))
+			}
+			s.shape.Lon, s.shape.Lat = geohash.FromScore(score)
+		}
+		points = s.run(z)
+		return nil
+	}
+	if !store {
+		if err := c.db(cl).View(keys, search); err != nil {
+			return errorReply(err)
+		}
+		return s.reply(cl, points)
+	}
+	var changed bool
+	err := c.db(cl).Update(keys, func(tx *storage.Tx) error {
+		if err := search(tx); err != nil {
+			return err
+		}
+		members := make([]storage.ZMember, len(points))
+		for i, p := range points {
+			members[i] = storage.ZMember{Member: p.member, Score: p.score}
+			if s.storeDist {
+				members[i].Score = p.dist / s.unit
+			}
+		}
+		_, changed = storeZSet(tx, dst, members)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !changed {
+		cl.propagateAs = []string{}
+	}
+	return resp.IntegerValue(int64(len(points)))
+}
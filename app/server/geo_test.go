@@ -0,0 +1,71 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestGeoCommands(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"geoadd", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"}, ":2\r\n"},
+		{[]string{"geoadd", "Sicily", "xx", "ch", "13.361389", "38.115556", "Palermo"}, ":0\r\n"},
+		{[]string{"geoadd", "Sicily", "nx", "xx", "13.361389", "38.115556", "Palermo"}, "-ERR syntax error\r\n"},
+		{[]string{"geoadd", "Sicily", "200", "100", "x"}, "-ERR invalid longitude,latitude pair 200.000000,100.000000\r\n"},
+		{[]string{"geoadd", "Sicily", "a", "1", "x"}, "-ERR value is not a valid float\r\n"},
+		{[]string{"zscore", "Sicily", "Palermo"}, "$16\r\n3479099956230698\r\n"},
+		{[]string{"geodist", "Sicily", "Palermo", "Catania"}, "$11\r\n166274.1516\r\n"},
+		{[]string{"geodist", "Sicily", "Palermo", "Catania", "km"}, "$8\r\n166.2742\r\n"},
+		{[]string{"geodist", "Sicily", "Palermo", "Catania", "mi"}, "$8\r\n103.3182\r\n"},
+		{[]string{"geodist", "Sicily", "Palermo", "Catania", "yd"}, "-ERR unsupported unit provided. please use M, KM, FT, MI\r\n"},
+		{[]string{"geodist", "Sicily", "Foo", "Bar"}, "$-1\r\n"},
+		{[]string{"geohash", "Sicily", "Palermo", "Catania", "Foo"}, "*3\r\n$11\r\nsqc8b49rny0\r\n$11\r\nsqdtr74hyu0\r\n$-1\r\n"},
+		{[]string{"geopos", "Sicily", "Palermo", "Catania", "NonExisting"}, "*3\r\n*2\r\n$20\r\n13.36138933897018433\r\n$20\r\n38.11555639549629859\r\n*2\r\n$20\r\n15.08726745843887329\r\n$20\r\n37.50266842333162032\r\n*-1\r\n"},
+		{[]string{"geopos", "missing", "a"}, "*1\r\n*-1\r\n"},
+		{[]string{"geoadd", "Sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2"}, ":2\r\n"},
+		{[]string{"geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "asc"}, "*2\r\n$7\r\nCatania\r\n$7\r\nPalermo\r\n"},
+		{[]string{"geosearch", "Sicily", "fromlonlat", "15", "37", "bybox", "400", "400", "km", "asc", "withcoord", "withdist"},
+			"*4\r\n" +
+				"*3\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n*2\r\n$20\r\n15.08726745843887329\r\n$20\r\n37.50266842333162032\r\n" +
+				"*3\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n*2\r\n$20\r\n13.36138933897018433\r\n$20\r\n38.11555639549629859\r\n" +
+				"*3\r\n$5\r\nedge2\r\n$8\r\n279.7403\r\n*2\r\n$20\r\n17.24151045083999634\r\n$20\r\n38.78813451624225195\r\n" +
+				"*3\r\n$5\r\nedge1\r\n$8\r\n279.7405\r\n*2\r\n$19\r\n12.7584877610206604\r\n$20\r\n38.78813451624225195\r\n"},
+		{[]string{"geosearch", "Sicily", "frommember", "Palermo", "byradius", "200", "km", "desc", "withhash"}, "*3\r\n*2\r\n$7\r\nCatania\r\n:3479447370796909\r\n*2\r\n$5\r\nedge1\r\n:3479273021651468\r\n*2\r\n$7\r\nPalermo\r\n:3479099956230698\r\n"},
+		{[]string{"geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "1000", "km", "count", "1"}, "*1\r\n$7\r\nCatania\r\n"},
+		{[]string{"geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "1000", "km", "count", "2", "any"}, "*2\r\n$7\r\nPalermo\r\n$5\r\nedge1\r\n"},
+		{[]string{"geosearch", "Sicily", "frommember", "Foo", "byradius", "200", "km"}, "-ERR could not decode requested zset member\r\n"},
+		{[]string{"geosearch", "missing", "frommember", "Foo", "byradius", "200", "km"}, "*0\r\n"},
+		{[]string{"geosearch", "Sicily", "byradius", "200", "km", "asc", "withdist"}, "-ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH\r\n"},
+		{[]string{"geosearch", "Sicily", "fromlonlat", "15", "37", "asc", "withdist"}, "-ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH\r\n"},
+		{[]string{"geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "any"}, "-ERR the ANY argument requires COUNT argument\r\n"},
+		{[]string{"geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "-1", "km"}, "-ERR radius cannot be negative\r\n"},
+		{[]string{"geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "count", "0"}, "-ERR COUNT must be > 0\r\n"},
+		{[]string{"geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "storedist"}, "-ERR syntax error\r\n"},
+		{[]string{"geosearchstore", "key1", "Sicily", "fromlonlat", "15", "37", "bybox", "400", "400", "km", "asc", "count", "3"}, ":3\r\n"},
+		{[]string{"zrange", "key1", "0", "-1"}, "*3\r\n$7\r\nPalermo\r\n$7\r\nCatania\r\n$5\r\nedge2\r\n"},
+		{[]string{"geosearchstore", "key2", "Sicily", "fromlonlat", "15", "37", "bybox", "400", "400", "km", "asc", "count", "3", "storedist"}, ":3\r\n"},
+		{[]string{"zscore", "key2", "Catania"}, "$16\r\n56.4412578701582\r\n"},
+		{[]string{"geosearchstore", "key2", "Sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "withdist"}, "-ERR GEOSEARCHSTORE is not compatible with WITHDIST, WITHHASH and WITHCOORD options\r\n"},
+		{[]string{"geosearchstore", "key2", "missing", "fromlonlat", "15", "37", "byradius", "200", "km"}, ":0\r\n"},
+		{[]string{"type", "key2"}, "+none\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+}
+
+func TestGeoaddPropagatesZAdd(t *testing.T) {
+	ms := newTestMaster()
+	var propagated [][]string
+	ms.propagate = func(args []string) { propagated = append(propagated, args) }
+	do(ms, newTestClient(), "geoadd", "Sicily", "ch", "13.361389", "38.115556", "Palermo")
+	if len(propagated) != 1 || len(propagated[0]) != 5 || propagated[0][0] != "zadd" || propagated[0][3] != "3479099956230698" {
+		t.Errorf("Unexpected propagated commands: %q", propagated)
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import "unicode"
+
+// Reports whether s matches the glob-style pattern, with the same rules
+// as Redis' KEYS and SCAN MATCH: '*' matches any sequence, '?' any single
+// byte, "[...]" a set with ranges and '^' negation, and '\' escapes the
+// next character.
+func globMatch(pattern, s string, nocase bool) bool {
+	skipLonger := false
+	return globMatchNested(pattern, s, nocase, &skipLonger, 0)
+}
+
+// Patterns nesting '*' deeper than this never match, like in Redis
+const maxGlobNesting = 1000
+
+// Once the rest of a pattern after a '*' matched no suffix of the string,
+// longer matches of an earlier '*' can't help either, they would only
+// leave shorter suffixes. skipLonger cuts those attempts off, as Redis
+// does, so patterns with many '*' don't take exponential time.
+func globMatchNested(pattern, s string, nocase bool, skipLonger *bool, nesting int) bool {
+	if nesting > maxGlobNesting {
+		return false
+	}
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatchNested(pattern[1:], s[i:], nocase, skipLonger, nesting+1) {
+					return true
+				}
+				if *skipLonger {
+					return false
+				}
+			}
+			*skipLonger = true
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			var matched bool
+			pattern, matched = matchSet(pattern[1:], s[0], nocase)
+			if !matched {
+				return false
+			}
+			s = s[1:]
+			// pattern points at the closing ']', skipped below
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || !equalByte(pattern[0], s[0], nocase) {
+				return false
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(s) == 0
+}
+
+// Matches c against the set at the start of pattern, just after the '['.
+// It returns the pattern from the closing ']' on, or the last byte of the
+// pattern when the set is not terminated.
+func matchSet(pattern string, c byte, nocase bool) (string, bool) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+	matched := false
+	for {
+		switch {
+		case len(pattern) == 0:
+			// Unterminated set, Redis treats the end of pattern as ']'
+			return "]", matched != not
+		case pattern[0] == '\\' && len(pattern) >= 2:
+			pattern = pattern[1:]
+			if pattern[0] == c {
+				matched = true
+			}
+		case pattern[0] == ']':
+			return pattern, matched != not
+		case len(pattern) >= 3 && pattern[1] == '-':
+			start, end := pattern[0], pattern[2]
+			if start > end {
+				start, end = end, start
+			}
+			cc := c
+			if nocase {
+				start, end, cc = lowerByte(start), lowerByte(end), lowerByte(c)
+			}
+			if cc >= start && cc <= end {
+				matched = true
+			}
+			pattern = pattern[2:]
+		default:
+			if equalByte(pattern[0], c, nocase) {
+				matched = true
+			}
+		}
+		pattern = pattern[1:]
+	}
+}
+
+func equalByte(a, b byte, nocase bool) bool {
+	if nocase {
+		return lowerByte(a) == lowerByte(b)
+	}
+	return a == b
+}
+
+func lowerByte(b byte) byte {
+	if b < 0x80 {
+		return byte(unicode.ToLower(rune(b)))
+	}
+	return b
+}
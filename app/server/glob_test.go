@@ -0,0 +1,42 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		expected   bool
+	}{
+		{"*", "", true},
+		{"user:*", "user:42", true},
+		{"user:*", "session:42", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"*a*b", "xxaxxb", true},
+		{"*a*b", "xxaxxbc", false},
+		{"[abc", "b", true},
+	}
+	for _, c := range cases {
+		if got := globMatch(c.pattern, c.s, false); got != c.expected {
+			t.Errorf("%q against %q: unexpected result. Expected: %v, Got: %v", c.pattern, c.s, c.expected, got)
+		}
+	}
+	// Would take exponential time without cutting off longer matches
+	if globMatch(strings.Repeat("*a", 30)+"b", strings.Repeat("a", 60), false) {
+		t.Errorf("Unexpected match of a pathological pattern")
+	}
+	if globMatch(strings.Repeat("*", 2000)+"a", "a", false) != true {
+		t.Errorf("Unexpected result with repeated stars")
+	}
+	if !globMatch("HELLO*", "hello world", true) {
+		t.Errorf("Unexpected case sensitive match")
+	}
+}
@@ -0,0 +1,607 @@
+package server
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+func (c *core) registerHashCommands() {
+	c.commands.Register(Command{Name: "hset", Arity: -4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.hsetCommand})
+	c.commands.Register(Command{Name: "hmset", Arity: -4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.hsetCommand})
+	c.commands.Register(Command{Name: "hsetnx", Arity: 4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.hsetnxCommand})
+	c.commands.Register(Command{Name: "hget", Arity: 3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.hgetCommand})
+	c.commands.Register(Command{Name: "hmget", Arity: -3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.hmgetCommand})
+	c.commands.Register(Command{Name: "hgetall", Arity: 2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.hgetallCommand})
+	c.commands.Register(Command{Name: "hkeys", Arity: 2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.hgetallCommand})
+	c.commands.Register(Command{Name: "hvals", Arity: 2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.hgetallCommand})
+	c.commands.Register(Command{Name: "hdel", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.hdelCommand})
+	c.commands.Register(Command{Name: "hexists", Arity: 3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.hexistsCommand})
+	c.commands.Register(Command{Name: "hlen", Arity: 2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.hlenCommand})
+	c.commands.Register(Command{Name: "hstrlen", Arity: 3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.hstrlenCommand})
+	c.commands.Register(Command{Name: "hincrby", Arity: 4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.hincrbyCommand})
+	c.commands.Register(Command{Name: "hincrbyfloat", Arity: 4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.hincrbyfloatCommand})
+	c.commands.Register(Command{Name: "hscan", Arity: -3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.hscanCommand})
+	c.commands.Register(Command{Name: "hrandfield", Arity: -2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.hrandfieldCommand})
+	for _, name := range []string{"hexpire", "hpexpire", "hexpireat", "hpexpireat"} {
+		c.commands.Register(Command{Name: name, Arity: -6, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.hexpireCommand})
+	}
+	for _, name := range []string{"httl", "hpttl", "hexpiretime", "hpexpiretime"} {
+		c.commands.Register(Command{Name: name, Arity: -5, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.httlCommand})
+	}
+	c.commands.Register(Command{Name: "hpersist", Arity: -5, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.hpersistCommand})
+}
+
+// Runs fn on the hash at key in a read only transaction. h is nil when
+// the key does not exist.
+func (c *core) viewHash(cl *Client, key string, fn func(h *storage.Hash)) error {
+	return c.db(cl).View([]string{key}, func(tx *storage.Tx) error {
+		h, err := tx.Hash(key, false)
+		if err != nil {
+			return err
+		}
+		fn(h)
+		return nil
+	})
+}
+
+// Handles "HSET key field value [field value ...]" and HMSET
+func (c *core) hsetCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	if len(args)%2 != 0 {
+		return wrongArity(args[0])
+	}
+	var added int
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		h, err := tx.Hash(args[1], true)
+		if err != nil {
+			return err
+		}
+		for i := 2; i < len(args); i += 2 {
+			if h.Set(args[i], args[i+1]) {
+				added++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if strings.ToLower(args[0]) == "hmset" {
+		return resp.OK
+	}
+	return resp.IntegerValue(int64(added))
+}
+
+func (c *core) hsetnxCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var added bool
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		h, err := tx.Hash(args[1], true)
+		if err != nil {
+			return err
+		}
+		if !h.Exists(args[2]) {
+			added = h.Set(args[2], args[3])
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !added {
+		cl.propagateAs = []string{}
+	}
+	return boolReply(added)
+}
+
+func boolReply(b bool) resp.Value {
+	if b {
+		return resp.IntegerValue(1)
+	}
+	return resp.IntegerValue(0)
+}
+
+func (c *core) hgetCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var v string
+	var ok bool
+	err := c.viewHash(cl, args[1], func(h *storage.Hash) {
+		if h != nil {
+			v, ok = h.Get(args[2])
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !ok {
+		return resp.NullBulkValue()
+	}
+	return resp.BulkValue(v)
+}
+
+func (c *core) hmgetCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	fields := args[2:]
+	values := make([]resp.Value, len(fields))
+	err := c.viewHash(cl, args[1], func(h *storage.Hash) {
+		for i, f := range fields {
+			values[i] = resp.NullBulkValue()
+			if h == nil {
+				continue
+			}
+			if v, ok := h.Get(f); ok {
+				values[i] = resp.BulkValue(v)
+			}
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.ArrayValue(values...)
+}
+
+// Handles HGETALL, HKEYS and HVALS. HGETALL replies with a map, which
+// RESP2 clients get as a flat array of fields and values.
+func (c *core) hgetallCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	name := strings.ToLower(args[0])
+	var items []resp.Value
+	err := c.viewHash(cl, args[1], func(h *storage.Hash) {
+		if h == nil {
+			return
+		}
+		items = make([]resp.Value, 0, 2*h.Len())
+		h.Each(func(field, value string) bool {
+			if name != "hvals" {
+				items = append(items, resp.BulkValue(field))
+			}
+			if name != "hkeys" {
+				items = append(items, resp.BulkValue(value))
+			}
+			return true
+		})
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if name == "hgetall" {
+		return resp.MapValue(items...)
+	}
+	return resp.ArrayValue(items...)
+}
+
+func (c *core) hdelCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var deleted int
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		h, err := tx.Hash(args[1], false)
+		if err != nil || h == nil {
+			return err
+		}
+		for _, f := range args[2:] {
+			if h.Delete(f) {
+				deleted++
+			}
+		}
+		tx.DeleteIfEmpty(args[1])
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if deleted == 0 {
+		cl.propagateAs = []string{}
+	}
+	return resp.IntegerValue(int64(deleted))
+}
+
+func (c *core) hexistsCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var ok bool
+	err := c.viewHash(cl, args[1], func(h *storage.Hash) {
+		ok = h != nil && h.Exists(args[2])
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return boolReply(ok)
+}
+
+func (c *core) hlenCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var n int
+	err := c.viewHash(cl, args[1], func(h *storage.Hash) {
+		if h != nil {
+			n = h.Len()
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.IntegerValue(int64(n))
+}
+
+func (c *core) hstrlenCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var v string
+	err := c.viewHash(cl, args[1], func(h *storage.Hash) {
+		if h != nil {
+			v, _ = h.Get(args[2])
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.IntegerValue(int64(len(v)))
+}
+
+// Handles "HINCRBY key field increment"
+func (c *core) hincrbyCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	delta, ok := parseInt(args[3])
+	if !ok {
+		return notInteger()
+	}
+	var result int64
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		h, err := tx.Hash(args[1], true)
+		if err != nil {
+			return err
+		}
+		var current int64
+		if v, exists := h.Get(args[2]); exists {
+			var ok bool
+			current, ok = parseInt(v)
+			if !ok {
+				return asError(resp.Errorf("hash value is not an integer"))
+			}
+		}
+		if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+			return asError(resp.Errorf("increment or decrement would overflow"))
+		}
+		result = current + delta
+		setKeepFieldTTL(h, args[2], strconv.FormatInt(result, 10))
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.IntegerValue(result)
+}
+
+// Updates a field without touching its TTL, unlike HSET
+func setKeepFieldTTL(h *storage.Hash, field, value string) {
+	at, _ := h.FieldExpireAt(field)
+	h.Set(field, value)
+	h.SetFieldExpireAt(field, at)
+}
+
+// Handles "HINCRBYFLOAT key field increment". Like INCRBYFLOAT, replicas
+// get the result instead of the increment.
+func (c *core) hincrbyfloatCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	delta, ok := parseFloat(args[3])
+	if !ok {
+		return resp.Errorf("value is not a valid float")
+	}
+	var result string
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		h, err := tx.Hash(args[1], true)
+		if err != nil {
+			return err
+		}
+		var current float64
+		if v, exists := h.Get(args[2]); exists {
+			var ok bool
+			current, ok = parseFloat(v)
+			if !ok {
+				return asError(resp.Errorf("hash value is not a float"))
+			}
+		}
+		f := current + delta
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return asError(resp.Errorf("increment would produce NaN or Infinity"))
+		}
+		result = strconv.FormatFloat(f, 'f', -1, 64)
+		setKeepFieldTTL(h, args[2], result)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	cl.propagateAs = []string{"hset", args[1], args[2], result}
+	return resp.BulkValue(result)
+}
+
+// Handles "HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]"
+func (c *core) hscanCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	cursor, ok := parseScanCursor(args[2])
+	if !ok {
+		return resp.Errorf("invalid cursor")
+	}
+	opts, errReply, ok := parseScanOptions(args[3:], true, false)
+	if !ok {
+		return errReply
+	}
+	var items []resp.Value
+	var next uint64
+	err := c.viewHash(cl, args[1], func(h *storage.Hash) {
+		if h == nil {
+			return
+		}
+		next = scanElements(cursor, opts.count, h.Scan, func(f string) {
+			if !opts.matches(f) {
+				return
+			}
+			items = append(items, resp.BulkValue(f))
+			if !opts.novalues {
+				v, _ := h.Get(f)
+				items = append(items, resp.BulkValue(v))
+			}
+		})
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return scanReply(next, items)
+}
+
+// Handles "HRANDFIELD key [count [WITHVALUES]]". A positive count returns
+// distinct fields, a negative one may return the same field several times.
+func (c *core) hrandfieldCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	if len(args) > 4 {
+		return syntaxError()
+	}
+	withCount := len(args) >= 3
+	var count int64 = 1
+	if withCount {
+		var ok bool
+		count, ok = parseInt(args[2])
+		if !ok {
+			return notInteger()
+		}
+	}
+	withValues := false
+	if len(args) == 4 {
+		if strings.ToLower(args[3]) != "withvalues" {
+			return syntaxError()
+		}
+		withValues = true
+		if count < -math.MaxInt64/2 {
+			return resp.Errorf("value is out of range")
+		}
+	}
+	var fields, values []string
+	err := c.viewHash(cl, args[1], func(h *storage.Hash) {
+		if h == nil || count == 0 {
+			return
+		}
+		var all, allValues []string
+		h.Each(func(field, value string) bool {
+			all = append(all, field)
+			allValues = append(allValues, value)
+			return true
+		})
+		for _, i := range randomIndexes(len(all), count) {
+			fields = append(fields, all[i])
+			values = append(values, allValues[i])
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !withCount {
+		if len(fields) == 0 {
+			return resp.NullBulkValue()
+		}
+		return resp.BulkValue(fields[0])
+	}
+	if !withValues {
+		return resp.StringsValue(fields)
+	}
+	if cl.Protocol() == resp.RESP2 {
+		// RESP2 clients get fields and values in one flat array
+		flat := make([]string, 0, 2*len(fields))
+		for i := range fields {
+			flat = append(flat, fields[i], values[i])
+		}
+		return resp.StringsValue(flat)
+	}
+	pairs := make([]resp.Value, len(fields))
+	for i := range fields {
+		pairs[i] = resp.StringsValue([]string{fields[i], values[i]})
+	}
+	return resp.ArrayValue(pairs...)
+}
+
+// Picks count random positions out of n, with the semantics of the count
+// argument of HRANDFIELD, SRANDMEMBER and ZRANDMEMBER: a positive count
+// returns distinct positions, at most n of them, a negative one exactly
+// -count positions that may repeat.
+func randomIndexes(n int, count int64) []int {
+	if n == 0 || count == 0 {
+		return nil
+	}
+	if count < 0 {
+		res := make([]int, -count)
+		for i := range res {
+			res[i] = rand.Intn(n)
+		}
+		return res
+	}
+	perm := rand.Perm(n)
+	if count < int64(n) {
+		perm = perm[:count]
+	}
+	return perm
+}
+
+// Largest field expiry time in milliseconds, like Redis' HFE_MAX_ABS_TIME_MSEC
+const maxFieldExpireAt = 1<<48 - 1
+
+// Parses "FIELDS numfields field [field ...]" at the end of a field TTL
+// command.
+func parseFields(args []string) ([]string, resp.Value, bool) {
+	if len(args) < 2 || strings.ToLower(args[0]) != "fields" {
+		return nil, resp.Errorf("Mandatory argument FIELDS is missing or not at the right position"), false
+	}
+	n, ok := parseInt(args[1])
+	if !ok || n <= 0 {
+		return nil, resp.Errorf("Parameter `numFields` should be greater than 0"), false
+	}
+	if n != int64(len(args)-2) {
+		return nil, resp.Errorf("The `numfields` parameter must match the number of arguments"), false
+	}
+	return args[2:], resp.Value{}, true
+}
+
+// Handles "HEXPIRE key seconds [NX | XX | GT | LT] FIELDS numfields field
+// [field ...]" and its HPEXPIRE, HEXPIREAT and HPEXPIREAT variants. The
+// reply has one code per field: -2 when the field does not exist, 0 when
+// the condition was not met, 1 when the TTL was set and 2 when the field
+// was deleted because the time is already over.
+func (c *core) hexpireCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	name := strings.ToLower(args[0])
+	key := args[1]
+	n, ok := parseInt(args[2])
+	if !ok {
+		return notInteger()
+	}
+	rest := args[3:]
+	var cond string
+	switch opt := strings.ToLower(rest[0]); opt {
+	case "nx", "xx", "gt", "lt":
+		cond = opt
+		rest = rest[1:]
+	}
+	fields, errReply, ok := parseFields(rest)
+	if !ok {
+		return errReply
+	}
+	at, ok := expireTime(strings.TrimPrefix(name, "h"), n, time.Now().UnixMilli())
+	if !ok || n < 0 || at > maxFieldExpireAt {
+		return resp.Errorf("invalid expire time, must be >= 0 and <= %d", int64(maxFieldExpireAt))
+	}
+	codes := make([]resp.Value, len(fields))
+	var changed []string
+	err := c.db(cl).Update([]string{key}, func(tx *storage.Tx) error {
+		h, err := tx.Hash(key, false)
+		if err != nil {
+			return err
+		}
+		for i, f := range fields {
+			codes[i] = resp.IntegerValue(-2)
+			if h == nil {
+				continue
+			}
+			current, exists := h.FieldExpireAt(f)
+			if !exists {
+				continue
+			}
+			// A field without TTL behaves as if its TTL was infinite
+			switch {
+			case cond == "nx" && current != 0,
+				cond == "xx" && current == 0,
+				cond == "gt" && (current == 0 || at <= current),
+				cond == "lt" && current != 0 && at >= current:
+				codes[i] = resp.IntegerValue(0)
+				continue
+			}
+			changed = append(changed, f)
+			if at <= tx.Now() {
+				h.Delete(f)
+				codes[i] = resp.IntegerValue(2)
+				continue
+			}
+			h.SetFieldExpireAt(f, at)
+			codes[i] = resp.IntegerValue(1)
+		}
+		tx.DeleteIfEmpty(key)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	// Replicas get the absolute time of the fields that changed, a time
+	// that is already over deletes them there too
+	cl.propagateAs = []string{}
+	if len(changed) > 0 {
+		cl.propagateAs = append([]string{"hpexpireat", key, strconv.FormatInt(at, 10), "fields", strconv.Itoa(len(changed))}, changed...)
+	}
+	return resp.ArrayValue(codes...)
+}
+
+// Handles "HTTL key FIELDS numfields field [field ...]" and its HPTTL,
+// HEXPIRETIME and HPEXPIRETIME variants. Fields that don't exist reply -2,
+// fields without TTL -1.
+func (c *core) httlCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	name := strings.ToLower(args[0])
+	fields, errReply, ok := parseFields(args[2:])
+	if !ok {
+		return errReply
+	}
+	codes := make([]resp.Value, len(fields))
+	err := c.db(cl).View([]string{args[1]}, func(tx *storage.Tx) error {
+		h, err := tx.Hash(args[1], false)
+		if err != nil {
+			return err
+		}
+		for i, f := range fields {
+			codes[i] = resp.IntegerValue(-2)
+			if h == nil {
+				continue
+			}
+			at, exists := h.FieldExpireAt(f)
+			switch {
+			case !exists:
+			case at == 0:
+				codes[i] = resp.IntegerValue(-1)
+			default:
+				codes[i] = resp.IntegerValue(ttlValue(name[1:], at, tx.Now()))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.ArrayValue(codes...)
+}
+
+// Handles "HPERSIST key FIELDS numfields field [field ...]". Fields that
+// don't exist reply -2, fields without TTL -1 and those whose TTL was
+// removed 1.
+func (c *core) hpersistCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	fields, errReply, ok := parseFields(args[2:])
+	if !ok {
+		return errReply
+	}
+	codes := make([]resp.Value, len(fields))
+	persisted := false
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		h, err := tx.Hash(args[1], false)
+		if err != nil {
+			return err
+		}
+		for i, f := range fields {
+			codes[i] = resp.IntegerValue(-2)
+			if h == nil {
+				continue
+			}
+			at, exists := h.FieldExpireAt(f)
+			switch {
+			case !exists:
+			case at == 0:
+				codes[i] = resp.IntegerValue(-1)
+			default:
+				h.SetFieldExpireAt(f, 0)
+				codes[i] = resp.IntegerValue(1)
+				persisted = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !persisted {
+		cl.propagateAs = []string{}
+	}
+	return resp.ArrayValue(codes...)
+}
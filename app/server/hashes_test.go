@@ -0,0 +1,96 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashCommands(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"hset", "h", "name", "ann", "age", "30"}, ":2\r\n"},
+		{[]string{"hset", "h", "name", "bob"}, ":0\r\n"},
+		{[]string{"hset", "h", "odd"}, "-ERR wrong number of arguments for 'hset' command\r\n"},
+		{[]string{"hget", "h", "name"}, "$3\r\nbob\r\n"},
+		{[]string{"hget", "h", "nope"}, "$-1\r\n"},
+		{[]string{"hmget", "h", "age", "nope"}, "*2\r\n$2\r\n30\r\n$-1\r\n"},
+		{[]string{"hgetall", "h"}, "*4\r\n$4\r\nname\r\n$3\r\nbob\r\n$3\r\nage\r\n$2\r\n30\r\n"},
+		{[]string{"hkeys", "h"}, "*2\r\n$4\r\nname\r\n$3\r\nage\r\n"},
+		{[]string{"hvals", "h"}, "*2\r\n$3\r\nbob\r\n$2\r\n30\r\n"},
+		{[]string{"hincrby", "h", "age", "5"}, ":35\r\n"},
+		{[]string{"hincrby", "h", "name", "5"}, "-ERR hash value is not an integer\r\n"},
+		{[]string{"hincrbyfloat", "h", "score", "1.5"}, "$3\r\n1.5\r\n"},
+		{[]string{"hincrbyfloat", "h", "name", "1"}, "-ERR hash value is not a float\r\n"},
+		{[]string{"hsetnx", "h", "name", "eve"}, ":0\r\n"},
+		{[]string{"hstrlen", "h", "name"}, ":3\r\n"},
+		{[]string{"hexists", "h", "score"}, ":1\r\n"},
+		{[]string{"hdel", "h", "score", "nope"}, ":1\r\n"},
+		{[]string{"hlen", "h"}, ":2\r\n"},
+		{[]string{"hscan", "h", "0", "MATCH", "n*"}, "*2\r\n$1\r\n0\r\n*2\r\n$4\r\nname\r\n$3\r\nbob\r\n"},
+		{[]string{"hscan", "h", "0", "NOVALUES"}, "*2\r\n$1\r\n0\r\n*2\r\n$4\r\nname\r\n$3\r\nage\r\n"},
+		{[]string{"hrandfield", "nope"}, "$-1\r\n"},
+		{[]string{"hrandfield", "nope", "3"}, "*0\r\n"},
+		{[]string{"hexpire", "h", "100", "FIELDS", "2", "name", "nope"}, "*2\r\n:1\r\n:-2\r\n"},
+		{[]string{"hexpire", "h", "200", "NX", "FIELDS", "1", "name"}, "*1\r\n:0\r\n"},
+		{[]string{"httl", "h", "FIELDS", "2", "name", "age"}, "*2\r\n:100\r\n:-1\r\n"},
+		{[]string{"hpersist", "h", "FIELDS", "2", "name", "age"}, "*2\r\n:1\r\n:-1\r\n"},
+		{[]string{"hexpire", "h", "100", "FIELDS", "2", "name"}, "-ERR The `numfields` parameter must match the number of arguments\r\n"},
+		{[]string{"hpexpire", "h", "0", "FIELDS", "2", "name", "age"}, "*2\r\n:2\r\n:2\r\n"},
+		{[]string{"type", "h"}, "+none\r\n"},
+		{[]string{"set", "s", "v"}, "+OK\r\n"},
+		{[]string{"hget", "s", "f"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+}
+
+func TestHashRandField(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	do(ms, cl, "hset", "h", "a", "1", "b", "2")
+	cases := []struct {
+		args   []string
+		prefix string
+	}{
+		{[]string{"hrandfield", "h", "5"}, "*2\r\n"},
+		{[]string{"hrandfield", "h", "-5"}, "*5\r\n"},
+		{[]string{"hrandfield", "h", "1", "WITHVALUES"}, "*2\r\n"},
+		{[]string{"hrandfield", "h", "0"}, "*0\r\n"},
+	}
+	for _, c := range cases {
+		if got := do(ms, cl, c.args...); !strings.HasPrefix(got, c.prefix) {
+			t.Errorf("%q: unexpected reply. Expected prefix: %q, Got: %q", c.args, c.prefix, got)
+		}
+	}
+}
+
+func TestHashExpire_PropagatesAbsoluteTime(t *testing.T) {
+	ms := newTestMaster()
+	propagated := recordPropagated(ms)
+	cl := newTestClient()
+	do(ms, cl, "hset", "h", "a", "1", "b", "2", "c", "3")
+	do(ms, cl, "hexpire", "h", "100", "fields", "1", "c")
+	before := time.Now().UnixMilli()
+	// Only the fields that changed are propagated
+	do(ms, cl, "hpexpire", "h", "5000", "nx", "fields", "3", "a", "missing", "c")
+	after := time.Now().UnixMilli()
+	do(ms, cl, "hexpire", "h", "100", "xx", "fields", "1", "b")
+	// A time that is already over deletes the field
+	do(ms, cl, "hpexpireat", "h", "1", "fields", "1", "b")
+	if len(*propagated) != 4 {
+		t.Fatalf("Unexpected propagated commands: %q", *propagated)
+	}
+	checkAbsoluteTime(t, (*propagated)[2], []string{"hpexpireat", "h", "", "fields", "1", "a"}, 2, before, after, 5000)
+	if got := strings.Join((*propagated)[3], " "); got != "hpexpireat h 1 fields 1 b" {
+		t.Errorf("Unexpected propagated command: %q", got)
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+
+	"github.com/codecrafters-io/redis-starter-go/app/hyperloglog"
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+func (c *core) registerHyperLogLogCommands() {
+	c.commands.Register(Command{Name: "pfadd", Arity: -2, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.pfaddCommand})
+	c.commands.Register(Command{Name: "pfcount", Arity: -2, Flags: FlagReadonly, FirstKey: 1, LastKey: -1, Handler: c.pfcountCommand})
+	c.commands.Register(Command{Name: "pfmerge", Arity: -2, Flags: FlagWrite, FirstKey: 1, LastKey: -1, Handler: c.pfmergeCommand})
+}
+
+// HyperLogLog errors carry their own error code
+func hllError(err error) error {
+	return asError(resp.ErrorValue(err.Error()))
+}
+
+// Returns the HyperLogLog stored at key, or nil when the key is missing
+func lookupHLL(tx *storage.Tx, key string) (*hyperloglog.HLL, error) {
+	v, exists, err := tx.Get(key)
+	if err != nil || !exists {
+		return nil, err
+	}
+	h, err := hyperloglog.Parse(v)
+	if err != nil {
+		return nil, hllError(err)
+	}
+	return h, nil
+}
+
+// Handles "PFADD key [element ...]". Replies 1 when the key was created or
+// a register changed.
+func (c *core) pfaddCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var changed bool
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		h, err := lookupHLL(tx, args[1])
+		if err != nil {
+			return err
+		}
+		if h == nil {
+			h, changed = hyperloglog.New(), true
+		}
+		for _, e := range args[2:] {
+			ok, err := h.Add(e)
+			if err != nil {
+				return hllError(err)
+			}
+			changed = changed || ok
+		}
+		if changed {
+			tx.SetKeepTTL(args[1], h.String())
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !changed {
+		cl.propagateAs = []string{}
+	}
+	return boolReply(changed)
+}
+
+// Handles "PFCOUNT key [key ...]". The estimate of a single key is cached in
+// its value; several keys are counted by merging their registers.
+func (c *core) pfcountCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	keys := args[1:]
+	var n uint64
+	if len(keys) == 1 {
+		err := c.db(cl).Update(keys, func(tx *storage.Tx) error {
+			h, err := lookupHLL(tx, keys[0])
+			if err != nil || h == nil {
+				return err
+			}
+			old := h.String()
+			if n, err = h.Count(); err != nil {
+				return hllError(err)
+			}
+			if v := h.String(); v != old {
+				tx.SetKeepTTL(keys[0], v)
+			}
+			return nil
+		})
+		if err != nil {
+			return errorReply(err)
+		}
+		return resp.IntegerValue(int64(n))
+	}
+	var regs hyperloglog.RegisterSet
+	err := c.db(cl).View(keys, func(tx *storage.Tx) error {
+		for _, k := range keys {
+			h, err := lookupHLL(tx, k)
+			if err != nil {
+				return err
+			}
+			if h == nil {
+				continue
+			}
+			if err := h.Merge(&regs); err != nil {
+				return hllError(err)
+			}
+		}
+		n = regs.Count()
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.IntegerValue(int64(n))
+}
+
+// Handles "PFMERGE destkey [sourcekey ...]". The union includes destkey
+// itself, and is stored dense when any input is dense.
+func (c *core) pfmergeCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	keys := args[1:]
+	err := c.db(cl).Update(keys, func(tx *storage.Tx) error {
+		var regs hyperloglog.RegisterSet
+		dense := false
+		var dst *hyperloglog.HLL
+		for i, k := range keys {
+			h, err := lookupHLL(tx, k)
+			if err != nil {
+				return err
+			}
+			if h == nil {
+				continue
+			}
+			if i == 0 {
+				dst = h
+			}
+			dense = dense || h.Dense()
+			if err := h.Merge(&regs); err != nil {
+				return hllError(err)
+			}
+		}
+		if dst == nil {
+			dst = hyperloglog.New()
+		}
+		if err := dst.Store(&regs, dense); err != nil {
+			return hllError(err)
+		}
+		tx.SetKeepTTL(keys[0], dst.String())
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.OK
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestHyperLogLogCommands(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"pfadd", "h", "a", "b", "c", "d", "e", "f", "g"}, ":1\r\n"},
+		{[]string{"pfadd", "h", "a"}, ":0\r\n"},
+		{[]string{"pfcount", "h"}, ":7\r\n"},
+		{[]string{"pfadd", "empty"}, ":1\r\n"},
+		{[]string{"pfadd", "empty"}, ":0\r\n"},
+		{[]string{"get", "empty"}, "$18\r\nHYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff\r\n"},
+		{[]string{"pfadd", "other", "f", "g", "h", "i"}, ":1\r\n"},
+		{[]string{"pfcount", "h", "other", "missing"}, ":9\r\n"},
+		{[]string{"pfmerge", "u", "h", "other"}, "+OK\r\n"},
+		{[]string{"pfcount", "u"}, ":9\r\n"},
+		{[]string{"pfmerge", "u"}, "+OK\r\n"},
+		{[]string{"pfcount", "u"}, ":9\r\n"},
+		{[]string{"pfcount", "missing"}, ":0\r\n"},
+		{[]string{"set", "s", "not a hll"}, "+OK\r\n"},
+		{[]string{"pfadd", "s", "a"}, "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n"},
+		{[]string{"pfcount", "h", "s"}, "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n"},
+		{[]string{"set", "bad", "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x7f\xfe"}, "+OK\r\n"},
+		{[]string{"pfcount", "bad"}, "-INVALIDOBJ Corrupted HLL object detected\r\n"},
+		{[]string{"lpush", "l", "a"}, ":1\r\n"},
+		{[]string{"pfmerge", "l", "h"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+}
+
+func TestPFCountCachesEstimate(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	do(ms, cl, "pfadd", "h", "a", "b")
+	before := do(ms, cl, "get", "h")
+	do(ms, cl, "pfcount", "h")
+	after := do(ms, cl, "get", "h")
+	// The estimate is cached little endian in bytes 8 to 15 of the header,
+	// after the 5 bytes of the bulk string length
+	if before[5+8:5+16] != "\x00\x00\x00\x00\x00\x00\x00\x80" || after[5+8:5+16] != "\x02\x00\x00\x00\x00\x00\x00\x00" {
+		t.Errorf("Unexpected cached estimate. Before: %q, After: %q", before, after)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+)
+
+// One "# Name" block of the INFO reply
+type infoSection struct {
+	name   string
+	fields [][2]string
+}
+
+// Picks the sections asked for in "INFO [section ...]". No arguments, "all",
+// "default" and "everything" select every section.
+func selectInfoSections(all []infoSection, args []string) []infoSection {
+	if len(args) == 0 {
+		return all
+	}
+	var res []infoSection
+	for _, sec := range all {
+		for _, arg := range args {
+			arg = strings.ToLower(arg)
+			if arg == "all" || arg == "default" || arg == "everything" || arg == strings.ToLower(sec.name) {
+				res = append(res, sec)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// Encodes INFO sections. RESP2 clients get the classic text format,
+// RESP3 clients a map from field name to value.
+func infoReply(cl *Client, sections []infoSection) resp.Value {
+	if cl.Protocol() >= resp.RESP3 {
+		var pairs []resp.Value
+		for _, sec := range sections {
+			for _, f := range sec.fields {
+				pairs = append(pairs, resp.BulkValue(f[0]), resp.BulkValue(f[1]))
+			}
+		}
+		return resp.MapValue(pairs...)
+	}
+	var sb strings.Builder
+	for i, sec := range sections {
+		if i > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString("# " + sec.name + "\r\n")
+		for _, f := range sec.fields {
+			sb.WriteString(f[0] + ":" + f[1] + "\r\n")
+		}
+	}
+	return resp.BulkValue(sb.String())
+}
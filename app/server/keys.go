@@ -0,0 +1,325 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+func (c *core) registerKeyCommands() {
+	c.commands.Register(Command{Name: "type", Arity: 2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.typeCommand})
+	c.commands.Register(Command{Name: "object", Arity: -2, Flags: FlagReadonly, FirstKey: 2, LastKey: 2, Handler: c.objectCommand})
+	c.commands.Register(Command{Name: "del", Arity: -2, Flags: FlagWrite, FirstKey: 1, LastKey: -1, Handler: c.delCommand})
+	c.commands.Register(Command{Name: "unlink", Arity: -2, Flags: FlagWrite, FirstKey: 1, LastKey: -1, Handler: c.delCommand})
+	c.commands.Register(Command{Name: "exists", Arity: -2, Flags: FlagReadonly, FirstKey: 1, LastKey: -1, Handler: c.existsCommand})
+	c.commands.Register(Command{Name: "touch", Arity: -2, Flags: FlagReadonly, FirstKey: 1, LastKey: -1, Handler: c.existsCommand})
+	c.commands.Register(Command{Name: "rename", Arity: 3, Flags: FlagWrite, FirstKey: 1, LastKey: 2, Handler: c.renameCommand})
+	c.commands.Register(Command{Name: "renamenx", Arity: 3, Flags: FlagWrite, FirstKey: 1, LastKey: 2, Handler: c.renameCommand})
+	c.commands.Register(Command{Name: "copy", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: 2, Handler: c.copyCommand})
+	c.commands.Register(Command{Name: "move", Arity: 3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.moveCommand})
+	c.commands.Register(Command{Name: "randomkey", Arity: 1, Flags: FlagReadonly, Handler: c.randomkeyCommand})
+	c.commands.Register(Command{Name: "dbsize", Arity: 1, Flags: FlagReadonly, Handler: c.dbsizeCommand})
+	c.commands.Register(Command{Name: "flushdb", Arity: -1, Flags: FlagWrite, Handler: c.flushCommand})
+	c.commands.Register(Command{Name: "flushall", Arity: -1, Flags: FlagWrite, Handler: c.flushCommand})
+	c.commands.Register(Command{Name: "scan", Arity: -2, Flags: FlagReadonly, Handler: c.scanCommand})
+	c.commands.Register(Command{Name: "keys", Arity: 2, Flags: FlagReadonly, Handler: c.keysCommand})
+}
+
+// Handles "DEL key [key ...]" and "UNLINK key [key ...]". UNLINK leaves
+// freeing large values to the background.
+func (c *core) delCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	unlink := strings.EqualFold(args[0], "unlink")
+	var n int64
+	c.db(cl).Update(args[1:], func(tx *storage.Tx) error {
+		for _, key := range args[1:] {
+			var ok bool
+			if unlink {
+				ok = tx.Unlink(key)
+			} else {
+				ok = tx.Delete(key)
+			}
+			if ok {
+				n++
+			}
+		}
+		return nil
+	})
+	if n == 0 {
+		cl.propagateAs = []string{}
+	}
+	return resp.IntegerValue(n)
+}
+
+// Handles "EXISTS key [key ...]" and "TOUCH key [key ...]". Keys given
+// several times are counted every time. TOUCH also counts as an access.
+func (c *core) existsCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	touch := strings.EqualFold(args[0], "touch")
+	var n int64
+	c.db(cl).View(args[1:], func(tx *storage.Tx) error {
+		for _, key := range args[1:] {
+			var ok bool
+			if touch {
+				ok = tx.Lookup(key) != nil
+			} else {
+				ok = tx.Exists(key)
+			}
+			if ok {
+				n++
+			}
+		}
+		return nil
+	})
+	return resp.IntegerValue(n)
+}
+
+// Handles "RENAME key newkey" and "RENAMENX key newkey". The value keeps
+// its type and TTL.
+func (c *core) renameCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	nx := strings.EqualFold(args[0], "renamenx")
+	src, dst := args[1], args[2]
+	var renamed bool
+	err := c.db(cl).Update(args[1:], func(tx *storage.Tx) error {
+		if !tx.Exists(src) {
+			return asError(resp.Errorf("no such key"))
+		}
+		if src == dst || (nx && tx.Exists(dst)) {
+			return nil
+		}
+		renamed = tx.Rename(src, dst)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if renamed {
+		c.signalKeyAsReady(cl, dst)
+	} else {
+		cl.propagateAs = []string{}
+	}
+	if nx {
+		return boolReply(renamed)
+	}
+	return resp.OK
+}
+
+// Handles "COPY source destination [DB destination-db] [REPLACE]". The copy
+// keeps the type and TTL of the source.
+func (c *core) copyCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	src, dst := args[1], args[2]
+	var replace bool
+	db := int64(cl.db)
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "replace":
+			replace = true
+		case opt == "db" && i+1 < len(args):
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				return notInteger()
+			}
+			db = n
+			i++
+		default:
+			return syntaxError()
+		}
+	}
+	if !c.validDB(db) {
+		return resp.Errorf("DB index is out of range")
+	}
+	if src == dst && int(db) == cl.db {
+		return resp.Errorf("source and destination objects are the same")
+	}
+	var copied bool
+	c.updateDBs(cl.db, []string{src}, int(db), []string{dst}, func(srcTx, dstTx *storage.Tx) error {
+		e := srcTx.Peek(src)
+		if e == nil || (!replace && dstTx.Exists(dst)) {
+			return nil
+		}
+		dstTx.Delete(dst)
+		dstTx.PutEntry(dst, e.Clone(dstTx.Now()))
+		copied = true
+		return nil
+	})
+	if copied {
+		c.signalKeyAsReadyIn(cl, int(db), dst)
+	} else {
+		cl.propagateAs = []string{}
+	}
+	return boolReply(copied)
+}
+
+// Handles "MOVE key db". Nothing is moved when the key exists in db
+// already.
+func (c *core) moveCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	key := args[1]
+	db, ok := parseInt(args[2])
+	if !ok {
+		return notInteger()
+	}
+	if !c.validDB(db) {
+		return resp.Errorf("DB index is out of range")
+	}
+	if int(db) == cl.db {
+		return resp.Errorf("source and destination objects are the same")
+	}
+	var moved bool
+	c.updateDBs(cl.db, []string{key}, int(db), []string{key}, func(srcTx, dstTx *storage.Tx) error {
+		e := srcTx.Peek(key)
+		if e == nil || dstTx.Exists(key) {
+			return nil
+		}
+		srcTx.Delete(key)
+		dstTx.PutEntry(key, e)
+		moved = true
+		return nil
+	})
+	if moved {
+		c.signalKeyAsReadyIn(cl, int(db), key)
+	} else {
+		cl.propagateAs = []string{}
+	}
+	return boolReply(moved)
+}
+
+func (c *core) randomkeyCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	key, ok := c.db(cl).RandomKey()
+	if !ok {
+		return resp.NullBulkValue()
+	}
+	return resp.BulkValue(key)
+}
+
+func (c *core) dbsizeCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	return resp.IntegerValue(int64(c.db(cl).Len()))
+}
+
+// Handles "FLUSHDB [ASYNC | SYNC]", which empties the selected database,
+// and "FLUSHALL [ASYNC | SYNC]", which empties all of them. ASYNC frees the
+// values in the background.
+func (c *core) flushCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	async := false
+	if len(args) > 2 {
+		return syntaxError()
+	}
+	if len(args) == 2 {
+		switch strings.ToLower(args[1]) {
+		case "async":
+			async = true
+		case "sync":
+		default:
+			return syntaxError()
+		}
+	}
+	if strings.EqualFold(args[0], "flushdb") {
+		c.db(cl).Flush(async)
+		return resp.OK
+	}
+	for _, db := range c.dbs {
+		db.Flush(async)
+	}
+	return resp.OK
+}
+
+func (c *core) typeCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	typ := "none"
+	c.db(cl).View([]string{args[1]}, func(tx *storage.Tx) error {
+		if e := tx.Peek(args[1]); e != nil {
+			typ = e.Type.String()
+		}
+		return nil
+	})
+	return resp.SimpleStringValue(typ)
+}
+
+// Handles "OBJECT ENCODING|IDLETIME|FREQ|REFCOUNT key" and "OBJECT HELP".
+// Inspecting a key does not count as an access to it.
+func (c *core) objectCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	sub := strings.ToLower(args[1])
+	if sub == "help" {
+		return resp.ArrayValue(
+			resp.SimpleStringValue("OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"),
+			resp.SimpleStringValue("ENCODING <key>"),
+			resp.SimpleStringValue("    Return the kind of internal representation used in order to store the value"),
+			resp.SimpleStringValue("    associated with a <key>."),
+			resp.SimpleStringValue("FREQ <key>"),
+			resp.SimpleStringValue("    Return the access frequency index of the <key>. The returned integer is"),
+			resp.SimpleStringValue("    proportional to the logarithm of the recent access frequency of the key."),
+			resp.SimpleStringValue("IDLETIME <key>"),
+			resp.SimpleStringValue("    Return the idle time of the <key>, that is the approximated number of"),
+			resp.SimpleStringValue("    seconds elapsed since the last access to the key."),
+			resp.SimpleStringValue("REFCOUNT <key>"),
+			resp.SimpleStringValue("    Return the number of references of the value associated with the specified"),
+			resp.SimpleStringValue("    <key>."),
+		)
+	}
+	switch sub {
+	case "encoding", "idletime", "freq", "refcount":
+	default:
+		return resp.Errorf("unknown subcommand '%s'. Try OBJECT HELP.", truncate(args[1], 128))
+	}
+	if len(args) != 3 {
+		return wrongSubcommandArity("object", sub)
+	}
+	var reply resp.Value
+	c.db(cl).View([]string{args[2]}, func(tx *storage.Tx) error {
+		e := tx.Peek(args[2])
+		if e == nil {
+			reply = resp.NullBulkValue()
+			return nil
+		}
+		switch sub {
+		case "encoding":
+			reply = resp.BulkValue(e.Encoding())
+		case "idletime":
+			reply = resp.IntegerValue(e.IdleTime(tx.Now()) / 1000)
+		case "freq":
+			reply = resp.IntegerValue(int64(e.Freq(tx.Now())))
+		case "refcount":
+			reply = resp.IntegerValue(1)
+		}
+		return nil
+	})
+	return reply
+}
+
+// Handles "SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]". Like
+// Redis, COUNT is the number of keys to look at rather than to return, and
+// a call gives up after visiting ten times as many buckets.
+func (c *core) scanCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	cursor, ok := parseScanCursor(args[1])
+	if !ok {
+		return resp.Errorf("invalid cursor")
+	}
+	opts, errReply, ok := parseScanOptions(args[2:], false, true)
+	if !ok {
+		return errReply
+	}
+	var keys []resp.Value
+	sampled := 0
+	for buckets := opts.count * 10; ; buckets-- {
+		cursor = c.db(cl).Scan(cursor, func(key string, e *storage.Entry) {
+			sampled++
+			if opts.matches(key) && (!opts.hasTyp || e.Type == opts.typ) {
+				keys = append(keys, resp.BulkValue(key))
+			}
+		})
+		if cursor == 0 || sampled >= opts.count || buckets == 0 {
+			break
+		}
+	}
+	return scanReply(cursor, keys)
+}
+
+// Handles "KEYS pattern"
+func (c *core) keysCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	pattern := args[1]
+	keys := []resp.Value{}
+	c.db(cl).Each(func(key string, e *storage.Entry) {
+		if pattern == "*" || globMatch(pattern, key, false) {
+			keys = append(keys, resp.BulkValue(key))
+		}
+	})
+	return resp.ArrayValue(keys...)
+}
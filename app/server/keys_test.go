@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyCommands(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"set", "a", "1"}, "+OK\r\n"},
+		{[]string{"rpush", "l", "x", "y"}, ":2\r\n"},
+		{[]string{"exists", "a", "a", "l", "missing"}, ":3\r\n"},
+		{[]string{"touch", "a", "missing"}, ":1\r\n"},
+		{[]string{"dbsize"}, ":2\r\n"},
+		{[]string{"del", "a", "missing", "a"}, ":1\r\n"},
+		{[]string{"unlink", "l"}, ":1\r\n"},
+		{[]string{"exists", "a", "l"}, ":0\r\n"},
+		{[]string{"randomkey"}, "$-1\r\n"},
+		{[]string{"set", "a", "1", "px", "100000"}, "+OK\r\n"},
+		{[]string{"rename", "a", "b"}, "+OK\r\n"},
+		{[]string{"get", "a"}, "$-1\r\n"},
+		{[]string{"get", "b"}, "$1\r\n1\r\n"},
+		{[]string{"rename", "a", "b"}, "-ERR no such key\r\n"},
+		{[]string{"rename", "b", "b"}, "+OK\r\n"},
+		{[]string{"randomkey"}, "$1\r\nb\r\n"},
+		{[]string{"sadd", "s", "m"}, ":1\r\n"},
+		{[]string{"renamenx", "b", "s"}, ":0\r\n"},
+		{[]string{"renamenx", "b", "b"}, ":0\r\n"},
+		{[]string{"renamenx", "s", "t"}, ":1\r\n"},
+		{[]string{"type", "t"}, "+set\r\n"},
+		{[]string{"rename", "t", "b"}, "+OK\r\n"},
+		{[]string{"type", "b"}, "+set\r\n"},
+		{[]string{"ttl", "b"}, ":-1\r\n"},
+		{[]string{"copy", "b", "c"}, ":1\r\n"},
+		{[]string{"copy", "b", "c"}, ":0\r\n"},
+		{[]string{"sadd", "c", "n"}, ":1\r\n"},
+		{[]string{"scard", "b"}, ":1\r\n"},
+		{[]string{"copy", "c", "b", "replace"}, ":1\r\n"},
+		{[]string{"scard", "b"}, ":2\r\n"},
+		{[]string{"copy", "missing", "d"}, ":0\r\n"},
+		{[]string{"copy", "b", "b"}, "-ERR source and destination objects are the same\r\n"},
+		{[]string{"copy", "b", "d", "db", "0"}, ":1\r\n"},
+		{[]string{"copy", "b", "d", "db", "16"}, "-ERR DB index is out of range\r\n"},
+		{[]string{"copy", "b", "d", "db", "x"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"copy", "b", "d", "db"}, "-ERR syntax error\r\n"},
+		{[]string{"move", "b", "0"}, "-ERR source and destination objects are the same\r\n"},
+		{[]string{"move", "b", "16"}, "-ERR DB index is out of range\r\n"},
+		{[]string{"flushdb", "now"}, "-ERR syntax error\r\n"},
+		{[]string{"flushall", "async"}, "+OK\r\n"},
+		{[]string{"dbsize"}, ":0\r\n"},
+		{[]string{"set", "a", "1"}, "+OK\r\n"},
+		{[]string{"flushdb"}, "+OK\r\n"},
+		{[]string{"dbsize"}, ":0\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+}
+
+func TestRenameAndCopyKeepTTL(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	do(ms, cl, "zadd", "z", "1", "a", "2", "b")
+	do(ms, cl, "pexpire", "z", "100000")
+	do(ms, cl, "rename", "z", "y")
+	do(ms, cl, "copy", "y", "x")
+	for _, key := range []string{"y", "x"} {
+		ttl, _ := strconv.Atoi(strings.Trim(do(ms, cl, "pttl", key), ":\r\n"))
+		if ttl <= 0 || ttl > 100000 {
+			t.Errorf("%s: unexpected TTL %d", key, ttl)
+		}
+		if got := do(ms, cl, "zrange", key, "0", "-1", "withscores"); got != "*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n" {
+			t.Errorf("%s: unexpected members %q", key, got)
+		}
+	}
+	// The copy is independent of the source
+	do(ms, cl, "zadd", "x", "3", "c")
+	if got := do(ms, cl, "zcard", "y"); got != ":2\r\n" {
+		t.Errorf("Source changed with its copy: %q", got)
+	}
+}
+
+func TestRenameServesBlockedClients(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	reply := doAsync(context.Background(), ms, "blpop", "dst", "0")
+	waitBlocked(t, ms, "dst", 1)
+	do(ms, cl, "rpush", "src", "v")
+	do(ms, cl, "rename", "src", "dst")
+	select {
+	case got := <-reply:
+		if got != "*2\r\n$3\r\ndst\r\n$1\r\nv\r\n" {
+			t.Errorf("Unexpected reply: %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("BLPOP was not served")
+	}
+}
@@ -0,0 +1,575 @@
+package server
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+func (c *core) registerListCommands() {
+	c.commands.Register(Command{Name: "lpush", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.pushCommand})
+	c.commands.Register(Command{Name: "rpush", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.pushCommand})
+	c.commands.Register(Command{Name: "lpushx", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.pushCommand})
+	c.commands.Register(Command{Name: "rpushx", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.pushCommand})
+	c.commands.Register(Command{Name: "lpop", Arity: -2, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.popCommand})
+	c.commands.Register(Command{Name: "rpop", Arity: -2, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.popCommand})
+	c.commands.Register(Command{Name: "llen", Arity: 2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.llenCommand})
+	c.commands.Register(Command{Name: "lrange", Arity: 4, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.lrangeCommand})
+	c.commands.Register(Command{Name: "lindex", Arity: 3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.lindexCommand})
+	c.commands.Register(Command{Name: "lset", Arity: 4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.lsetCommand})
+	c.commands.Register(Command{Name: "lrem", Arity: 4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.lremCommand})
+	c.commands.Register(Command{Name: "ltrim", Arity: 4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.ltrimCommand})
+	c.commands.Register(Command{Name: "linsert", Arity: 5, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.linsertCommand})
+	c.commands.Register(Command{Name: "lpos", Arity: -3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.lposCommand})
+	c.commands.Register(Command{Name: "lmove", Arity: 5, Flags: FlagWrite, FirstKey: 1, LastKey: 2, Handler: c.lmoveCommand})
+	c.commands.Register(Command{Name: "rpoplpush", Arity: 3, Flags: FlagWrite, FirstKey: 1, LastKey: 2, Handler: c.lmoveCommand})
+	c.commands.Register(Command{Name: "lmpop", Arity: -4, Flags: FlagWrite, Handler: c.lmpopCommand})
+	c.commands.Register(Command{Name: "blpop", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: -2, Handler: c.bpopCommand})
+	c.commands.Register(Command{Name: "brpop", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: -2, Handler: c.bpopCommand})
+	c.commands.Register(Command{Name: "blmove", Arity: 6, Flags: FlagWrite, FirstKey: 1, LastKey: 2, Handler: c.blmoveCommand})
+	c.commands.Register(Command{Name: "brpoplpush", Arity: 4, Flags: FlagWrite, FirstKey: 1, LastKey: 2, Handler: c.blmoveCommand})
+	c.commands.Register(Command{Name: "blmpop", Arity: -5, Flags: FlagWrite, Handler: c.lmpopCommand})
+}
+
+// Parses an index argument of a list command
+func parseIndex(s string) (int, bool) {
+	n, ok := parseInt(s)
+	return int(n), ok
+}
+
+// Handles LPUSH, RPUSH, LPUSHX and RPUSHX
+func (c *core) pushCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	name := strings.ToLower(args[0])
+	front := name[0] == 'l'
+	onlyExisting := strings.HasSuffix(name, "x")
+	key := args[1]
+	var n int
+	err := c.db(cl).Update([]string{key}, func(tx *storage.Tx) error {
+		l, err := tx.List(key, !onlyExisting)
+		if err != nil || l == nil {
+			return err
+		}
+		for _, v := range args[2:] {
+			if front {
+				l.PushFront(v)
+			} else {
+				l.PushBack(v)
+			}
+		}
+		n = l.Len()
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if n > 0 {
+		c.signalKeyAsReady(cl, key)
+	} else {
+		cl.propagateAs = []string{}
+	}
+	return resp.IntegerValue(int64(n))
+}
+
+// Pops up to count elements from one end of the list
+func popList(l *storage.List, front bool, count int) []string {
+	res := make([]string, 0, min(count, l.Len()))
+	for len(res) < count {
+		var v string
+		var ok bool
+		if front {
+			v, ok = l.PopFront()
+		} else {
+			v, ok = l.PopBack()
+		}
+		if !ok {
+			break
+		}
+		res = append(res, v)
+	}
+	return res
+}
+
+// Handles "LPOP key [count]" and "RPOP key [count]"
+func (c *core) popCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	if len(args) > 3 {
+		return wrongArity(args[0])
+	}
+	front := strings.ToLower(args[0]) == "lpop"
+	count := 1
+	if len(args) == 3 {
+		n, ok := parseInt(args[2])
+		if !ok || n < 0 {
+			return resp.Errorf("value is out of range, must be positive")
+		}
+		count = int(n)
+	}
+	key := args[1]
+	var popped []string
+	var found bool
+	err := c.db(cl).Update([]string{key}, func(tx *storage.Tx) error {
+		l, err := tx.List(key, false)
+		if err != nil || l == nil {
+			return err
+		}
+		found = true
+		popped = popList(l, front, count)
+		tx.DeleteIfEmpty(key)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if len(popped) == 0 {
+		cl.propagateAs = []string{}
+	}
+	if len(args) == 2 {
+		if !found {
+			return resp.NullBulkValue()
+		}
+		return resp.BulkValue(popped[0])
+	}
+	if !found {
+		return resp.NullArrayValue()
+	}
+	return resp.StringsValue(popped)
+}
+
+func (c *core) llenCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var n int
+	err := c.db(cl).View([]string{args[1]}, func(tx *storage.Tx) error {
+		l, err := tx.List(args[1], false)
+		if l != nil {
+			n = l.Len()
+		}
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.IntegerValue(int64(n))
+}
+
+func (c *core) lrangeCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	start, ok1 := parseIndex(args[2])
+	stop, ok2 := parseIndex(args[3])
+	if !ok1 || !ok2 {
+		return notInteger()
+	}
+	res := []string{}
+	err := c.db(cl).View([]string{args[1]}, func(tx *storage.Tx) error {
+		l, err := tx.List(args[1], false)
+		if l != nil {
+			res = l.Range(start, stop)
+		}
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.StringsValue(res)
+}
+
+func (c *core) lindexCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	i, ok := parseIndex(args[2])
+	if !ok {
+		return notInteger()
+	}
+	var v string
+	var found bool
+	err := c.db(cl).View([]string{args[1]}, func(tx *storage.Tx) error {
+		l, err := tx.List(args[1], false)
+		if l != nil {
+			v, found = l.Index(i)
+		}
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !found {
+		return resp.NullBulkValue()
+	}
+	return resp.BulkValue(v)
+}
+
+func (c *core) lsetCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	i, ok := parseIndex(args[2])
+	if !ok {
+		return notInteger()
+	}
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		l, err := tx.List(args[1], false)
+		if err != nil {
+			return err
+		}
+		if l == nil {
+			return asError(resp.Errorf("no such key"))
+		}
+		if !l.Set(i, args[3]) {
+			return asError(resp.Errorf("index out of range"))
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.OK
+}
+
+// Handles "LREM key count element"
+func (c *core) lremCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	count, ok := parseIndex(args[2])
+	if !ok {
+		return notInteger()
+	}
+	var removed int
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		l, err := tx.List(args[1], false)
+		if err != nil || l == nil {
+			return err
+		}
+		removed = l.Remove(count, args[3])
+		tx.DeleteIfEmpty(args[1])
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if removed == 0 {
+		cl.propagateAs = []string{}
+	}
+	return resp.IntegerValue(int64(removed))
+}
+
+func (c *core) ltrimCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	start, ok1 := parseIndex(args[2])
+	stop, ok2 := parseIndex(args[3])
+	if !ok1 || !ok2 {
+		return notInteger()
+	}
+	trimmed := false
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		l, err := tx.List(args[1], false)
+		if err != nil || l == nil {
+			return err
+		}
+		n := l.Len()
+		l.Trim(start, stop)
+		trimmed = l.Len() < n
+		tx.DeleteIfEmpty(args[1])
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !trimmed {
+		cl.propagateAs = []string{}
+	}
+	return resp.OK
+}
+
+// Handles "LINSERT key BEFORE|AFTER pivot element"
+func (c *core) linsertCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var before bool
+	switch strings.ToLower(args[2]) {
+	case "before":
+		before = true
+	case "after":
+	default:
+		return syntaxError()
+	}
+	var n int
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		l, err := tx.List(args[1], false)
+		if err != nil || l == nil {
+			return err
+		}
+		n = l.Insert(before, args[3], args[4])
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if n <= 0 {
+		cl.propagateAs = []string{}
+	}
+	return resp.IntegerValue(int64(n))
+}
+
+// Handles "LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]"
+func (c *core) lposCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	rank, count, maxlen := 1, -1, 0
+	for i := 3; i < len(args); i += 2 {
+		opt := strings.ToLower(args[i])
+		if i+1 >= len(args) || (opt != "rank" && opt != "count" && opt != "maxlen") {
+			return syntaxError()
+		}
+		n, ok := parseInt(args[i+1])
+		if !ok {
+			return notInteger()
+		}
+		switch opt {
+		case "rank":
+			if n == 0 || n == math.MinInt64 {
+				return resp.Errorf("RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = int(n)
+		case "count":
+			if n < 0 {
+				return resp.Errorf("COUNT can't be negative")
+			}
+			count = int(n)
+		case "maxlen":
+			if n < 0 {
+				return resp.Errorf("MAXLEN can't be negative")
+			}
+			maxlen = int(n)
+		}
+	}
+	limit := count
+	if count < 0 {
+		// Without COUNT only the first match is reported
+		limit = 1
+	}
+	var positions []int
+	err := c.db(cl).View([]string{args[1]}, func(tx *storage.Tx) error {
+		l, err := tx.List(args[1], false)
+		if l != nil {
+			positions = l.Positions(args[2], rank, limit, maxlen)
+		}
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if count < 0 {
+		if len(positions) == 0 {
+			return resp.NullBulkValue()
+		}
+		return resp.IntegerValue(int64(positions[0]))
+	}
+	res := make([]resp.Value, len(positions))
+	for i, p := range positions {
+		res[i] = resp.IntegerValue(int64(p))
+	}
+	return resp.ArrayValue(res...)
+}
+
+func parseListSide(s string) (front bool, ok bool) {
+	switch strings.ToLower(s) {
+	case "left":
+		return true, true
+	case "right":
+		return false, true
+	}
+	return false, false
+}
+
+// Atomically pops an element from one end of src and pushes it to one end
+// of dst. ok is false when src does not exist. dst is type checked before
+// anything is popped.
+func lmove(tx *storage.Tx, src, dst string, fromFront, toFront bool) (v string, ok bool, err error) {
+	from, err := tx.List(src, false)
+	if err != nil || from == nil {
+		return "", false, err
+	}
+	if e := tx.Peek(dst); e != nil && e.Type != storage.TypeList {
+		return "", false, storage.ErrWrongType
+	}
+	if fromFront {
+		v, _ = from.PopFront()
+	} else {
+		v, _ = from.PopBack()
+	}
+	to, _ := tx.List(dst, true)
+	if toFront {
+		to.PushFront(v)
+	} else {
+		to.PushBack(v)
+	}
+	tx.DeleteIfEmpty(src)
+	return v, true, nil
+}
+
+// Handles "LMOVE source destination LEFT|RIGHT LEFT|RIGHT" and
+// "RPOPLPUSH source destination"
+func (c *core) lmoveCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	fromFront, toFront := false, true
+	if len(args) == 5 {
+		var ok1, ok2 bool
+		fromFront, ok1 = parseListSide(args[3])
+		toFront, ok2 = parseListSide(args[4])
+		if !ok1 || !ok2 {
+			return syntaxError()
+		}
+	}
+	src, dst := args[1], args[2]
+	var v string
+	var ok bool
+	err := c.db(cl).Update([]string{src, dst}, func(tx *storage.Tx) (err error) {
+		v, ok, err = lmove(tx, src, dst, fromFront, toFront)
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !ok {
+		cl.propagateAs = []string{}
+		return resp.NullBulkValue()
+	}
+	c.signalKeyAsReady(cl, dst)
+	return resp.BulkValue(v)
+}
+
+// Pops up to count elements from the first non empty list among keys
+func popFirstList(tx *storage.Tx, keys []string, front bool, count int) (key string, popped []string, err error) {
+	for _, key := range keys {
+		l, err := tx.List(key, false)
+		if err != nil {
+			return "", nil, err
+		}
+		if l == nil {
+			continue
+		}
+		popped = popList(l, front, count)
+		tx.DeleteIfEmpty(key)
+		return key, popped, nil
+	}
+	return "", nil, nil
+}
+
+// Returns a serveFunc popping from the first non empty list among keys
+func (c *core) listPopper(cl *Client, keys []string, front bool, count int, reply func(key string, popped []string) resp.Value) serveFunc {
+	return func() (blockedResult, bool) {
+		var key string
+		var popped []string
+		err := c.db(cl).Update(keys, func(tx *storage.Tx) (err error) {
+			key, popped, err = popFirstList(tx, keys, front, count)
+			return err
+		})
+		if err != nil {
+			return blockedResult{reply: errorReply(err)}, true
+		}
+		if popped == nil {
+			return blockedResult{}, false
+		}
+		name := "rpop"
+		if front {
+			name = "lpop"
+		}
+		return blockedResult{
+			reply:     reply(key, popped),
+			propagate: []string{name, key, strconv.Itoa(len(popped))},
+		}, true
+	}
+}
+
+// Handles "BLPOP key [key ...] timeout" and "BRPOP key [key ...] timeout"
+func (c *core) bpopCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	timeout, errReply, ok := parseTimeout(args[len(args)-1])
+	if !ok {
+		return errReply
+	}
+	front := strings.ToLower(args[0]) == "blpop"
+	keys := args[1 : len(args)-1]
+	return c.block(ctx, cl, keys, timeout, c.listPopper(cl, keys, front, 1, func(key string, popped []string) resp.Value {
+		return resp.StringsValue([]string{key, popped[0]})
+	}))
+}
+
+// Handles "LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]" and
+// "BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]"
+func (c *core) lmpopCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	blocking := strings.ToLower(args[0]) == "blmpop"
+	rest := args[1:]
+	var timeout time.Duration
+	if blocking {
+		var errReply resp.Value
+		var ok bool
+		timeout, errReply, ok = parseTimeout(rest[0])
+		if !ok {
+			return errReply
+		}
+		rest = rest[1:]
+	}
+	numkeys, ok := parseInt(rest[0])
+	if !ok || numkeys <= 0 {
+		return resp.Errorf("numkeys should be greater than 0")
+	}
+	if numkeys > int64(len(rest)-2) {
+		return syntaxError()
+	}
+	keys := rest[1 : 1+numkeys]
+	opts := rest[1+numkeys:]
+	front, ok := parseListSide(opts[0])
+	if !ok {
+		return syntaxError()
+	}
+	count := 1
+	switch {
+	case len(opts) == 3 && strings.ToLower(opts[1]) == "count":
+		n, ok := parseInt(opts[2])
+		if !ok || n <= 0 {
+			return resp.Errorf("count should be greater than 0")
+		}
+		count = int(n)
+	case len(opts) != 1:
+		return syntaxError()
+	}
+	serve := c.listPopper(cl, keys, front, count, func(key string, popped []string) resp.Value {
+		return resp.ArrayValue(resp.BulkValue(key), resp.StringsValue(popped))
+	})
+	if blocking {
+		return c.block(ctx, cl, keys, timeout, serve)
+	}
+	res, ok := serve()
+	if !ok {
+		cl.propagateAs = []string{}
+		return resp.NullArrayValue()
+	}
+	cl.propagateAs = res.propagate
+	return res.reply
+}
+
+// Handles "BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout" and
+// "BRPOPLPUSH source destination timeout"
+func (c *core) blmoveCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	sides := []string{"RIGHT", "LEFT"}
+	if len(args) == 6 {
+		sides = args[3:5]
+	}
+	fromFront, ok1 := parseListSide(sides[0])
+	toFront, ok2 := parseListSide(sides[1])
+	if !ok1 || !ok2 {
+		return syntaxError()
+	}
+	timeout, errReply, ok := parseTimeout(args[len(args)-1])
+	if !ok {
+		return errReply
+	}
+	src, dst := args[1], args[2]
+	return c.block(ctx, cl, []string{src}, timeout, func() (blockedResult, bool) {
+		var v string
+		var ok bool
+		err := c.db(cl).Update([]string{src, dst}, func(tx *storage.Tx) (err error) {
+			v, ok, err = lmove(tx, src, dst, fromFront, toFront)
+			return err
+		})
+		if err != nil {
+			return blockedResult{reply: errorReply(err)}, true
+		}
+		if !ok {
+			return blockedResult{}, false
+		}
+		return blockedResult{
+			reply:     resp.BulkValue(v),
+			propagate: []string{"lmove", src, dst, sides[0], sides[1]},
+			pushed:    []string{dst},
+		}, true
+	})
+}
@@ -0,0 +1,49 @@
+package server
+
+import "testing"
+
+func TestListCommands(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"rpush", "l", "a", "b", "c"}, ":3\r\n"},
+		{[]string{"lpush", "l", "z"}, ":4\r\n"},
+		{[]string{"lrange", "l", "0", "-1"}, "*4\r\n$1\r\nz\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{[]string{"lrange", "l", "-2", "100"}, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{[]string{"lindex", "l", "-1"}, "$1\r\nc\r\n"},
+		{[]string{"lindex", "l", "10"}, "$-1\r\n"},
+		{[]string{"lset", "l", "-4", "y"}, "+OK\r\n"},
+		{[]string{"lset", "l", "4", "x"}, "-ERR index out of range\r\n"},
+		{[]string{"lset", "nolist", "0", "x"}, "-ERR no such key\r\n"},
+		{[]string{"linsert", "l", "BEFORE", "b", "a"}, ":5\r\n"},
+		{[]string{"linsert", "l", "AFTER", "nope", "a"}, ":-1\r\n"},
+		{[]string{"lpos", "l", "a", "RANK", "-1"}, ":2\r\n"},
+		{[]string{"lpos", "l", "a", "COUNT", "0"}, "*2\r\n:1\r\n:2\r\n"},
+		{[]string{"lpos", "l", "a", "RANK", "0"}, "-ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list\r\n"},
+		{[]string{"lrem", "l", "0", "a"}, ":2\r\n"},
+		{[]string{"ltrim", "l", "1", "-1"}, "+OK\r\n"},
+		{[]string{"lmove", "l", "dst", "LEFT", "RIGHT"}, "$1\r\nb\r\n"},
+		{[]string{"rpoplpush", "l", "dst"}, "$1\r\nc\r\n"},
+		{[]string{"type", "l"}, "+none\r\n"},
+		{[]string{"lpop", "dst", "5"}, "*2\r\n$1\r\nc\r\n$1\r\nb\r\n"},
+		{[]string{"rpop", "dst"}, "$-1\r\n"},
+		{[]string{"rpop", "dst", "1"}, "*-1\r\n"},
+		{[]string{"lpushx", "dst", "a"}, ":0\r\n"},
+		{[]string{"set", "s", "v"}, "+OK\r\n"},
+		{[]string{"lpush", "s", "a"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"rpush", "src", "a"}, ":1\r\n"},
+		{[]string{"lmove", "src", "s", "LEFT", "LEFT"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"llen", "src"}, ":1\r\n"},
+		{[]string{"type", "src"}, "+list\r\n"},
+		{[]string{"object", "encoding", "src"}, "$8\r\nlistpack\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+}
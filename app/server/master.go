@@ -3,19 +3,14 @@ package server
 import (
 	"bytes"
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
-	"log/slog"
 	"net"
 	"strconv"
-	"strings"
-	"time"
+	"sync"
 
 	"github.com/codecrafters-io/redis-starter-go/app/rdb"
 	"github.com/codecrafters-io/redis-starter-go/app/resp"
-	storage "github.com/codecrafters-io/redis-starter-go/app/storage"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
 )
 
 func generateMasterID() string {
@@ -23,29 +18,46 @@ func generateMasterID() string {
 	return masterID
 }
 
+// Redis' hard limit of the output buffer of a replica, past which it is
+// disconnected
+const replicaOutputLimit = 256 << 20
+
 type MasterServer struct {
-	Port             int
-	MasterReplid     string
-	MasterReplOffset string
-	Replicas         map[*Client]bool
-	Logger           *slog.Logger
-	KeyValue         storage.KeyValue
+	core
+	Port         int
+	MasterReplid string
+	// Bytes of writes propagated so far, guarded by propagateMu
+	replOffset int64
+	// Replicas that are online, false while they receive their snapshot
+	Replicas map[*Client]bool
+	// Writes not sent to each replica yet. While it receives its snapshot
+	// they pile up, then feedReplica sends them as they come.
+	outputs map[*Client]*replicaOutput
+	// A replica whose output grows past this many bytes is dropped
+	outputLimit int
+	replicasMu  sync.Mutex
 }
 
 func NewMasterServer(cfg *Config) *MasterServer {
 	masterID := generateMasterID()
-	offset := "0"
-	return &MasterServer{
-		Port:             cfg.port,
-		MasterReplid:     masterID,
-		MasterReplOffset: offset,
-		Replicas:         make(map[*Client]bool),
-		Logger:           cfg.logger,
-		KeyValue:         cfg.kv,
+	ms := &MasterServer{
+		core:         newCore(cfg, "master"),
+		Port:         cfg.port,
+		MasterReplid: masterID,
+		Replicas:     make(map[*Client]bool),
+		outputs:      make(map[*Client]*replicaOutput),
+		outputLimit:  replicaOutputLimit,
 	}
+	ms.propagate = ms.propagateToReplicas
+	ms.propagateExpired()
+	ms.infoSections = ms.replicationInfo
+	ms.registerCommands()
+	ms.commands.Register(Command{Name: "replconf", Arity: -1, Flags: FlagAdmin, Handler: ms.HandleReplconfCommand})
+	ms.commands.Register(Command{Name: "psync", Arity: -3, Flags: FlagAdmin, Handler: ms.HandlePsyncCommand})
+	return ms
 }
 
-func (ms MasterServer) Start() error {
+func (ms *MasterServer) Start() error {
 	host := "0.0.0.0:"
 	address := host + fmt.Sprintf("%d", ms.Port)
 	listener, err := net.Listen("tcp", address)
@@ -54,224 +66,215 @@ func (ms MasterServer) Start() error {
 	}
 	ms.Logger.Info("Server started successfully", "port", ms.Port)
 	defer listener.Close()
+	ms.startActiveExpire()
+	ms.startSaveCron()
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
 			ms.Logger.Error("error during handle connection", "error", err.Error())
+			continue
 		}
 
 		client := NewClient(conn)
-		go ms.handleConnection(*client)
+		go ms.handleConnection(client)
 	}
 }
 
-func (ms MasterServer) sendTaskToReplica(task []string, conn net.Conn) error {
-	arr := resp.CreateArray(task)
-	bytes, err := json.Marshal(arr)
-	if err != nil {
-		return err
+// Writes waiting to be sent to a replica
+type replicaOutput struct {
+	mu     sync.Mutex
+	buf    []byte
+	closed bool
+	// Signals that buf got data or that the output was closed
+	ready chan struct{}
+}
+
+func newReplicaOutput() *replicaOutput {
+	return &replicaOutput{ready: make(chan struct{}, 1)}
+}
+
+// Appends data without waiting for the replica. It reports false when the
+// output would grow past limit bytes.
+func (o *replicaOutput) push(data []byte, limit int) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return true
 	}
-	_, err = conn.Write(bytes)
-	if err != nil {
-		return err
+	if len(o.buf)+len(data) > limit {
+		return false
 	}
-	return nil
+	o.buf = append(o.buf, data...)
+	o.signal()
+	return true
 }
 
-func (ms MasterServer) Ping(ctx context.Context, cl Client) (bool, error) {
-	_, err := cl.conn.Write([]byte(PongCommand))
-	if err != nil {
-		ms.Logger.Error(err.Error())
-		return false, err
+func (o *replicaOutput) signal() {
+	select {
+	case o.ready <- struct{}{}:
+	default:
 	}
-	return true, nil
 }
 
-func (ms MasterServer) responseLoop(ctx context.Context, conn net.Conn, response, expectedResponse []byte) error {
+// Waits for data and takes all of it, false once the output is closed
+func (o *replicaOutput) take() ([]byte, bool) {
 	for {
-		select {
-		case <-ctx.Done():
-			return errors.New("timeout")
-		default:
-			_, err := conn.Read(response)
-			if err != nil {
-				return err
-			}
-			if bytes.Equal(response, expectedResponse) {
-				return nil
-			}
+		o.mu.Lock()
+		buf, closed := o.buf, o.closed
+		o.buf = nil
+		o.mu.Unlock()
+		if closed {
+			return nil, false
+		}
+		if len(buf) > 0 {
+			return buf, true
 		}
+		<-o.ready
 	}
 }
 
-func (ms MasterServer) Set(ctx context.Context, input []string, cl Client) error {
-	withTimeout, _ := context.WithTimeout(ctx, time.Second*6)
-	if len(ms.Replicas) > 0 {
-		for k, v := range ms.Replicas {
-			if v {
-				err := ms.sendTaskToReplica(input, k.conn)
-				if err != nil {
-					return err
-				}
-				expectedResponse := []byte(StatusOK)
-				response := make([]byte, len(expectedResponse))
-				err = ms.responseLoop(withTimeout, cl.conn, response, expectedResponse)
-				if err != nil {
-					return err
-				}
-				return nil
-			}
-		}
-	} else {
-		key := input[1]
-		value := input[2]
-		args := make(map[string]string)
-		if len(input) > 3 {
-			if input[3] == "px" { // px allows setting a key with expiry
-				args["px"] = input[4]
-			}
-		}
-		ms.KeyValue.SetVariable(key, value, args)
+func (o *replicaOutput) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = true
+	o.buf = nil
+	o.signal()
+}
 
+// Replicas apply the same command; they never reply to propagated writes,
+// so there is nothing to wait for here. Writes are only queued, a slow
+// replica doesn't hold up the writers. It is called with propagateMu held.
+func (ms *MasterServer) propagateToReplicas(args []string) {
+	data := resp.StringsValue(args).Bytes()
+	ms.replOffset += int64(len(data))
+	ms.replicasMu.Lock()
+	defer ms.replicasMu.Unlock()
+	for cl, out := range ms.outputs {
+		if !out.push(data, ms.outputLimit) {
+			ms.Logger.Error("replica output buffer over the limit, closing it", "limit", ms.outputLimit)
+			ms.dropReplica(cl)
+		}
 	}
-	_, err := cl.conn.Write([]byte(StatusOK))
-	if err != nil {
-		return err
-	}
-	return nil
 }
 
-func (ms MasterServer) Get(ctx context.Context, key string, cl Client) {
-	var arr []string
-	v, err := ms.KeyValue.GetVariable(key)
-	if err != nil {
-		ms.Logger.Error("error while getting value", "error", err.Error())
-		cl.conn.Write([]byte(nullBulkString))
-		return
-	}
-	arr = append(arr, v)
-	ecnodedV := resp.CreateBulkStringFromArray(arr)
-	_, err = cl.conn.Write([]byte(ecnodedV))
-	if err != nil {
-		ms.Logger.Error("error while writing response", "error", err.Error())
+// Forgets a replica and closes its connection. It is called with
+// replicasMu held.
+func (ms *MasterServer) dropReplica(cl *Client) {
+	out, ok := ms.outputs[cl]
+	if !ok {
 		return
 	}
+	out.close()
+	delete(ms.outputs, cl)
+	delete(ms.Replicas, cl)
+	cl.conn.Close()
 }
 
-func (ms MasterServer) Info(ctx context.Context, args []string, cl Client) {
-	cmd := args[0]
-	switch cmd {
-	case "replication":
-		{
-			role := "role:" + ms.Role()
-			id := "master_replid:" + ms.MasterReplid
-			offset := "master_repl_offset:" + ms.MasterReplOffset
-			b := resp.CreateBulkString(role + "\r\n" + id + "\r\n" + offset + "\r\n")
-			_, err := cl.conn.Write([]byte(b))
-			if err != nil {
-				ms.Logger.Error("error while handling info command", "error", err)
-			}
+// Sends the writes queued for an online replica until it is dropped
+func (ms *MasterServer) feedReplica(cl *Client, out *replicaOutput) {
+	for {
+		buf, ok := out.take()
+		if !ok {
+			return
+		}
+		if _, err := cl.conn.Write(buf); err != nil {
+			ms.Logger.Error("error propagating command to replica", "error", err.Error())
+			ms.replicasMu.Lock()
+			ms.dropReplica(cl)
+			ms.replicasMu.Unlock()
+			return
 		}
 	}
 }
 
-func (ms MasterServer) Echo(ctx context.Context, input []string, cl Client) error {
-	resString := resp.CreateBulkStringFromArray(input)
-	b := []byte(resString)
-	_, err := cl.conn.Write(b)
-	if err != nil {
-		return err
+func (ms *MasterServer) replicationInfo() []infoSection {
+	ms.replicasMu.Lock()
+	replicas := len(ms.Replicas)
+	ms.replicasMu.Unlock()
+	ms.propagateMu.Lock()
+	offset := ms.replOffset
+	ms.propagateMu.Unlock()
+	return []infoSection{
+		{"Replication", [][2]string{
+			{"role", ms.Role()},
+			{"connected_slaves", strconv.Itoa(replicas)},
+			{"master_replid", ms.MasterReplid},
+			{"master_repl_offset", strconv.FormatInt(offset, 10)},
+		}},
 	}
-	return nil
 }
 
-func (ms MasterServer) HandleReplconfCommand(ctx context.Context, args []string, cl Client) {
-	ms.WriteResponse(cl, "+OK\r\n")
+func (ms *MasterServer) HandleReplconfCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	return resp.OK
 }
 
-func (ms MasterServer) HandlePsyncCommand(ctx context.Context, args []string, cl Client) {
-	response := fmt.Sprintf("+FULLRESYNC %s 0\r\n", ms.MasterReplid)
-	err := ms.WriteResponse(cl, response)
-	if err != nil {
-		ms.Logger.Error("error handling psync command", "error", err)
+// Handles "PSYNC replid offset" with a full resynchronization: the replica
+// gets a snapshot of the keyspace, then the writes made since it was taken,
+// sent by their own goroutine once it is online.
+func (ms *MasterServer) HandlePsyncCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var offset int64
+	var out *replicaOutput
+	// The replica is registered when the snapshot is taken, so the writes
+	// that follow are queued for it
+	snap := ms.takeSnapshot(func() {
+		// The replica loads the snapshot into database 0 whatever the
+		// others selected last, so the next write names its database
+		ms.propagateMu.Lock()
+		ms.propagatedDB = -1
+		offset = ms.replOffset
+		out = newReplicaOutput()
+		ms.replicasMu.Lock()
+		ms.Replicas[cl] = false
+		ms.outputs[cl] = out
+		ms.replicasMu.Unlock()
+		ms.propagateMu.Unlock()
+	})
+	response := resp.SimpleStringValue(fmt.Sprintf("FULLRESYNC %s %d", ms.MasterReplid, offset))
+	err := cl.WriteValue(response)
+	if err == nil {
+		err = ms.SendRDBFile(cl, snap)
 	}
-	err = ms.SendRDBFile(cl)
+	snap.Release()
+	ms.replicasMu.Lock()
+	defer ms.replicasMu.Unlock()
 	if err != nil {
-		ms.Logger.Error("error sending rdb file", "error", err)
+		ms.Logger.Error("error sending rdb file", "error", err.Error())
 		ms.Logger.Info("closing connection...")
-		cl.conn.Close()
-		return
+		ms.dropReplica(cl)
+		return noReply
+	}
+	// It may have been dropped for falling behind meanwhile
+	if _, ok := ms.outputs[cl]; ok {
+		ms.Replicas[cl] = true
+		go ms.feedReplica(cl, out)
 	}
-	ms.Replicas[&cl] = true
+	return noReply
 }
 
-func (ms MasterServer) SendRDBFile(cl Client) error {
-	emptyRDBFile, err := rdb.DecodeRDBFile()
-	if err != nil {
+// Sends a snapshot of the keyspace as the RDB payload of a full resync
+func (ms *MasterServer) SendRDBFile(cl *Client, snap *storage.Snapshot) error {
+	var buf bytes.Buffer
+	if err := rdb.Save(&buf, snap); err != nil {
 		return err
 	}
-	response := "$" + strconv.Itoa(len(emptyRDBFile)) + "\r\n" + emptyRDBFile
-	err = ms.WriteResponse(cl, response)
-	if err != nil {
-		return err
+	// The payload is not terminated by CRLF, so it can't be sent as a bulk string
+	err := cl.writer.WriteRaw([]byte("$" + strconv.Itoa(buf.Len()) + "\r\n"))
+	if err == nil {
+		err = cl.writer.WriteRaw(buf.Bytes())
+	}
+	if err == nil {
+		err = cl.writer.Flush()
 	}
-	return nil
+	return err
 }
 
-func (ms MasterServer) Role() string {
+func (ms *MasterServer) Role() string {
 	return "master"
 }
 
-func (ms MasterServer) WriteResponse(cl Client, data string) error {
-	_, err := cl.conn.Write([]byte(data))
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (ms MasterServer) handleConnection(cl Client) {
-	defer cl.conn.Close()
-	ms.Logger.Info("New connection accepted", "address", cl.conn.RemoteAddr())
-	for {
-		n, err := cl.conn.Read(cl.data)
-		if err != nil {
-			if err == io.EOF {
-				ms.Logger.Error("connection closed by client")
-				break
-			}
-			ms.Logger.Error("error reading from connection: %v", err)
-			return
-		}
-		receivedData := string(cl.data[:n])
-		fmt.Printf("receivedData: %v\n", receivedData)
-		if len(receivedData) > 2 {
-			parsedString, err := resp.ParseRequestString(receivedData)
-			fmt.Printf("parsedString: %v\n", parsedString)
-			if err != nil {
-				ms.Logger.Error(err.Error())
-			}
-			command := strings.ToLower(parsedString[0])
-			ctx := context.Background()
-			fmt.Printf("command: %v\n", command)
-			switch command {
-			// Common commands
-			case "set":
-				ms.Set(ctx, parsedString, cl)
-			case "get":
-				ms.Get(ctx, parsedString[1], cl)
-			case "echo":
-				ms.Echo(ctx, parsedString[1:], cl)
-			case "info":
-				ms.Info(ctx, parsedString[1:], cl)
-			// Master server commands
-			case "replconf":
-				ms.HandleReplconfCommand(ctx, parsedString[1:], cl)
-			case "psync":
-				ms.HandlePsyncCommand(ctx, parsedString[1:], cl)
-			default:
-				ms.Ping(ctx, cl)
-			}
-		}
-	}
+func (ms *MasterServer) handleConnection(cl *Client) {
+	ms.serveClient(cl)
+	ms.replicasMu.Lock()
+	ms.dropReplica(cl)
+	ms.replicasMu.Unlock()
 }
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// How often the save policies are checked, like Redis' serverCron
+const saveCronInterval = time.Second
+
+// Redis waits this long before a policy retries a failed BGSAVE
+const saveRetryDelay = 5 * time.Second
+
+// Persistence configures where snapshots are saved and when they are taken
+// automatically.
+type Persistence struct {
+	Dir        string
+	DBFilename string
+	Save       []SavePolicy
+}
+
+// SavePolicy triggers a BGSAVE once at least Changes writes happened and
+// Seconds passed since the last successful save.
+type SavePolicy struct {
+	Seconds int64
+	Changes int64
+}
+
+// ParseSavePolicies parses the "seconds changes [seconds changes ...]"
+// format of the save directive. An empty string disables automatic saves.
+func ParseSavePolicies(s string) ([]SavePolicy, error) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save parameters %q", s)
+	}
+	var res []SavePolicy
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.ParseInt(fields[i], 10, 64)
+		changes, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds < 1 || changes < 0 {
+			return nil, fmt.Errorf("invalid save parameters %q", s)
+		}
+		res = append(res, SavePolicy{Seconds: seconds, Changes: changes})
+	}
+	return res, nil
+}
+
+// State of RDB snapshots, shared by the commands and the save cron
+type persistence struct {
+	Persistence
+	// Successful writes since the last successful save
+	dirty atomic.Int64
+	// Guards the fields below
+	mu sync.Mutex
+	// A SAVE or BGSAVE is running
+	saving bool
+	// BGSAVE SCHEDULE asked for a save while another one was running
+	scheduled bool
+	// Unix time in seconds of the last successful save, or of the start
+	lastSave int64
+	// Result and unix time in seconds of the last BGSAVE
+	lastBgsaveOK   bool
+	lastBgsaveTime int64
+}
+
+func newPersistence(cfg Persistence) *persistence {
+	return &persistence{Persistence: cfg, lastSave: time.Now().Unix(), lastBgsaveOK: true}
+}
+
+func (c *core) registerPersistenceCommands() {
+	c.commands.Register(Command{Name: "save", Arity: 1, Flags: FlagAdmin, Handler: c.saveCommand})
+	c.commands.Register(Command{Name: "bgsave", Arity: -1, Flags: FlagAdmin, Handler: c.bgsaveCommand})
+	c.commands.Register(Command{Name: "lastsave", Arity: 1, Handler: c.lastsaveCommand})
+}
+
+// Takes a snapshot of every database between two write commands. fn, when
+// not nil, runs before writes resume, so it sees the state the snapshot
+// holds. The caller releases the snapshot, other ones can be taken
+// meanwhile.
+func (c *core) takeSnapshot(fn func()) *storage.Snapshot {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	snap := storage.TakeSnapshot(c.dbs)
+	if fn != nil {
+		fn()
+	}
+	return snap
+}
+
+// Saves a snapshot to the RDB file and marks the writes made before it as
+// saved
+func (c *core) rdbSave(snap *storage.Snapshot, dirty int64) error {
+	p := c.persistence
+	path := filepath.Join(p.Dir, p.DBFilename)
+	if err := rdb.SaveFile(path, snap); err != nil {
+		c.Logger.Error("error saving the RDB file", "path", path, "error", err.Error())
+		return err
+	}
+	p.dirty.Add(-dirty)
+	p.mu.Lock()
+	p.lastSave = time.Now().Unix()
+	p.mu.Unlock()
+	c.Logger.Info("DB saved on disk", "path", path)
+	return nil
+}
+
+// Marks a save as running, false when one runs already
+func (p *persistence) startSave() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.saving {
+		return false
+	}
+	p.saving = true
+	return true
+}
+
+// Handles "SAVE", saving in the foreground of the calling client
+func (c *core) saveCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	p := c.persistence
+	if !p.startSave() {
+		return resp.Errorf("Background save already in progress")
+	}
+	defer func() {
+		p.mu.Lock()
+		p.saving = false
+		p.mu.Unlock()
+	}()
+	var dirty int64
+	snap := c.takeSnapshot(func() { dirty = p.dirty.Load() })
+	defer snap.Release()
+	if err := c.rdbSave(snap, dirty); err != nil {
+		return resp.Errorf("%s", err.Error())
+	}
+	return resp.OK
+}
+
+// Handles "BGSAVE [SCHEDULE]". The snapshot is taken before replying and
+// saved by a goroutine while clients keep writing.
+func (c *core) bgsaveCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	schedule := false
+	if len(args) > 2 {
+		return syntaxError()
+	}
+	if len(args) == 2 {
+		if !strings.EqualFold(args[1], "schedule") {
+			return syntaxError()
+		}
+		schedule = true
+	}
+	if c.bgsave() {
+		return resp.SimpleStringValue("Background saving started")
+	}
+	if !schedule {
+		return resp.Errorf("Background save already in progress")
+	}
+	p := c.persistence
+	p.mu.Lock()
+	p.scheduled = true
+	p.mu.Unlock()
+	return resp.SimpleStringValue("Background saving scheduled")
+}
+
+// Starts a background save, false when a save runs already
+func (c *core) bgsave() bool {
+	p := c.persistence
+	if !p.startSave() {
+		return false
+	}
+	p.mu.Lock()
+	p.scheduled = false
+	p.mu.Unlock()
+	var dirty int64
+	snap := c.takeSnapshot(func() { dirty = p.dirty.Load() })
+	go func() {
+		err := c.rdbSave(snap, dirty)
+		snap.Release()
+		p.mu.Lock()
+		p.saving = false
+		p.lastBgsaveOK = err == nil
+		p.lastBgsaveTime = time.Now().Unix()
+		p.mu.Unlock()
+	}()
+	return true
+}
+
+func (c *core) lastsaveCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	p := c.persistence
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return resp.IntegerValue(p.lastSave)
+}
+
+// Reports whether a scheduled BGSAVE or a save policy is due at now
+func (p *persistence) saveDue(now int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.saving {
+		return false
+	}
+	if p.scheduled {
+		return true
+	}
+	if !p.lastBgsaveOK && now-p.lastBgsaveTime < int64(saveRetryDelay/time.Second) {
+		return false
+	}
+	dirty := p.dirty.Load()
+	for _, policy := range p.Save {
+		if dirty >= policy.Changes && now-p.lastSave >= policy.Seconds {
+			return true
+		}
+	}
+	return false
+}
+
+// Starts the BGSAVEs triggered by the save policies and BGSAVE SCHEDULE in
+// the background
+func (c *core) startSaveCron() {
+	go func() {
+		for range time.Tick(saveCronInterval) {
+			if c.persistence.saveDue(time.Now().Unix()) {
+				c.bgsave()
+			}
+		}
+	}()
+}
+
+func (c *core) persistenceInfo() infoSection {
+	p := c.persistence
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status, inProgress := "ok", "0"
+	if !p.lastBgsaveOK {
+		status = "err"
+	}
+	if p.saving {
+		inProgress = "1"
+	}
+	return infoSection{"Persistence", [][2]string{
+		{"rdb_changes_since_last_save", strconv.FormatInt(p.dirty.Load(), 10)},
+		{"rdb_bgsave_in_progress", inProgress},
+		{"rdb_last_save_time", strconv.FormatInt(p.lastSave, 10)},
+		{"rdb_last_bgsave_status", status},
+	}}
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+func newTestMasterSavingTo(dir string) *MasterServer {
+	cfg := NewConfig(6379, slog.Default(), storage.NewDatabases(16), Replica{}, Persistence{Dir: dir, DBFilename: "dump.rdb"})
+	return NewMasterServer(cfg)
+}
+
+// Waits until no background save runs
+func waitSaved(t *testing.T, ms *MasterServer) {
+	t.Helper()
+	for range 200 {
+		ms.persistence.mu.Lock()
+		saving := ms.persistence.saving
+		ms.persistence.mu.Unlock()
+		if !saving {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("The background save didn't finish")
+}
+
+// Loads the RDB file saved in dir
+func loadSaved(t *testing.T, dir string) []*storage.KeyValue {
+	t.Helper()
+	dbs := storage.NewDatabases(16)
+	if err := rdb.LoadFile(filepath.Join(dir, "dump.rdb"), dbs); err != nil {
+		t.Fatalf("Unexpected error loading: %v", err)
+	}
+	return dbs
+}
+
+func TestDirty_OnlyCountsChanges(t *testing.T) {
+	ms := newTestMaster()
+	propagated := recordPropagated(ms)
+	cl := newTestClient()
+	do(ms, cl, "set", "k", "v")
+	do(ms, cl, "sadd", "s", "a")
+	do(ms, cl, "rpush", "l", "a")
+	// Writes that change nothing are neither counted nor propagated
+	noops := [][]string{
+		{"set", "k", "x", "NX", "PX", "1000"},
+		{"set", "missing", "x", "XX"},
+		{"setnx", "k", "x"},
+		{"msetnx", "k", "x", "other", "y"},
+		{"del", "missing"},
+		{"unlink", "missing"},
+		{"persist", "k"},
+		{"srem", "missing", "a"},
+		{"srem", "s", "b"},
+		{"sadd", "s", "a"},
+		{"smove", "s", "s", "a"},
+		{"hdel", "missing", "f"},
+		{"lpushx", "missing", "a"},
+		{"lpop", "missing"},
+		{"lrem", "l", "0", "b"},
+		{"ltrim", "l", "0", "-1"},
+		{"linsert", "l", "before", "b", "c"},
+		{"zrem", "missing", "a"},
+		{"zadd", "z", "xx", "1", "a"},
+		{"sinterstore", "dst", "missing"},
+		{"copy", "missing", "dst"},
+		{"getdel", "missing"},
+		{"pfadd", "hll"},
+		{"pfadd", "hll"},
+	}
+	for _, args := range noops {
+		do(ms, cl, args...)
+	}
+	// The first PFADD creates the key
+	if got := ms.persistence.dirty.Load(); got != 4 {
+		t.Errorf("Unexpected number of changes. Expected: 4, Got: %d", got)
+	}
+	if len(*propagated) != 4 {
+		t.Errorf("Unexpected propagated commands: %q", *propagated)
+	}
+}
+
+func TestSave(t *testing.T) {
+	dir := t.TempDir()
+	ms := newTestMasterSavingTo(dir)
+	cl := newTestClient()
+	do(ms, cl, "set", "a", "1")
+	do(ms, cl, "select", "2")
+	do(ms, cl, "rpush", "l", "x", "y")
+	do(ms, cl, "expire", "l", "100")
+	do(ms, cl, "hset", "h", "f", "v", "g", "w")
+	do(ms, cl, "hpexpire", "h", "1000000", "fields", "1", "f")
+	if got := do(ms, cl, "info", "persistence"); !strings.Contains(got, "rdb_changes_since_last_save:5\r\n") {
+		t.Errorf("Unexpected INFO: %q", got)
+	}
+	before := time.Now().Unix()
+	if got := do(ms, cl, "save"); got != "+OK\r\n" {
+		t.Fatalf("Unexpected reply to SAVE: %q", got)
+	}
+	if got := do(ms, cl, "lastsave"); got < ":"+strconv.FormatInt(before, 10) {
+		t.Errorf("Unexpected LASTSAVE: %q", got)
+	}
+	if got := do(ms, cl, "info", "persistence"); !strings.Contains(got, "rdb_changes_since_last_save:0\r\n") {
+		t.Errorf("Unexpected INFO: %q", got)
+	}
+	dbs := loadSaved(t, dir)
+	dbs[2].View(nil, func(tx *storage.Tx) error {
+		if l, _ := tx.List("l", false); l == nil || !reflect.DeepEqual(l.Range(0, -1), []string{"x", "y"}) {
+			t.Errorf("Unexpected list: %v", l)
+		}
+		if at, ok := tx.ExpireAt("l"); !ok || at == 0 {
+			t.Error("The TTL of the list wasn't saved")
+		}
+		return nil
+	})
+	if dbs[0].Len() != 1 {
+		t.Errorf("Unexpected number of keys in db 0: %d", dbs[0].Len())
+	}
+	// Field TTLs survive a restart
+	restarted := NewMasterServer(NewConfig(6379, slog.Default(), dbs, Replica{}, Persistence{}))
+	cl = newTestClient()
+	do(restarted, cl, "select", "2")
+	got := do(restarted, cl, "hpttl", "h", "fields", "2", "f", "g")
+	var ttl int64
+	if _, err := fmt.Sscanf(got, "*2\r\n:%d\r\n:-1\r\n", &ttl); err != nil || ttl <= 990000 || ttl > 1000000 {
+		t.Errorf("Unexpected reply to HPTTL: %q", got)
+	}
+}
+
+func TestBgsave(t *testing.T) {
+	dir := t.TempDir()
+	ms := newTestMasterSavingTo(dir)
+	cl := newTestClient()
+	do(ms, cl, "set", "a", "1")
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"bgsave", "now"}, "-ERR syntax error\r\n"},
+		{[]string{"bgsave", "schedule", "x"}, "-ERR syntax error\r\n"},
+		{[]string{"bgsave"}, "+Background saving started\r\n"},
+		// Writes after the snapshot was taken aren't saved
+		{[]string{"set", "a", "2"}, "+OK\r\n"},
+	}
+	for _, s := range steps {
+		if got := do(ms, cl, s.args...); got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+	waitSaved(t, ms)
+	loadSaved(t, dir)[0].View(nil, func(tx *storage.Tx) error {
+		if v, _, _ := tx.Get("a"); v != "1" {
+			t.Errorf("Unexpected saved value: %q", v)
+		}
+		return nil
+	})
+	if got := do(ms, cl, "info", "persistence"); !strings.Contains(got, "rdb_changes_since_last_save:1\r\n") || !strings.Contains(got, "rdb_last_bgsave_status:ok\r\n") {
+		t.Errorf("Unexpected INFO: %q", got)
+	}
+}
+
+func TestBgsave_InProgress(t *testing.T) {
+	ms := newTestMasterSavingTo(t.TempDir())
+	cl := newTestClient()
+	// Pretend a save runs
+	ms.persistence.saving = true
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"bgsave"}, "-ERR Background save already in progress\r\n"},
+		{[]string{"save"}, "-ERR Background save already in progress\r\n"},
+		{[]string{"bgsave", "SCHEDULE"}, "+Background saving scheduled\r\n"},
+	}
+	for _, s := range steps {
+		if got := do(ms, cl, s.args...); got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+	now := time.Now().Unix()
+	if ms.persistence.saveDue(now) {
+		t.Error("No save is due while one runs")
+	}
+	ms.persistence.saving = false
+	if !ms.persistence.saveDue(now) {
+		t.Error("The scheduled save is due")
+	}
+}
+
+func TestBgsave_Error(t *testing.T) {
+	ms := newTestMasterSavingTo(filepath.Join(t.TempDir(), "missing"))
+	cl := newTestClient()
+	if got := do(ms, cl, "save"); !strings.HasPrefix(got, "-ERR ") {
+		t.Errorf("Unexpected reply to SAVE: %q", got)
+	}
+	do(ms, cl, "bgsave")
+	waitSaved(t, ms)
+	if got := do(ms, cl, "info", "persistence"); !strings.Contains(got, "rdb_last_bgsave_status:err\r\n") {
+		t.Errorf("Unexpected INFO: %q", got)
+	}
+}
+
+func TestSaveDue(t *testing.T) {
+	p := newPersistence(Persistence{Save: []SavePolicy{{Seconds: 60, Changes: 10}, {Seconds: 300, Changes: 1}}})
+	start := p.lastSave
+	tests := []struct {
+		now      int64
+		dirty    int64
+		expected bool
+	}{
+		{start + 10, 100, false},
+		{start + 60, 9, false},
+		{start + 60, 10, true},
+		{start + 299, 1, false},
+		{start + 300, 1, true},
+		{start + 300, 0, false},
+	}
+	for _, tt := range tests {
+		p.dirty.Store(tt.dirty)
+		if got := p.saveDue(tt.now); got != tt.expected {
+			t.Errorf("%d changes after %ds: Expected: %v, Got: %v", tt.dirty, tt.now-start, tt.expected, got)
+		}
+	}
+	// A failed save is retried after a delay
+	p.lastBgsaveOK, p.lastBgsaveTime = false, start+300
+	p.dirty.Store(1)
+	if p.saveDue(start + 302) {
+		t.Error("Expected no retry before the delay")
+	}
+	if !p.saveDue(start + 305) {
+		t.Error("Expected a retry after the delay")
+	}
+}
+
+func TestParseSavePolicies(t *testing.T) {
+	got, err := ParseSavePolicies("3600 1 300 100")
+	expected := []SavePolicy{{Seconds: 3600, Changes: 1}, {Seconds: 300, Changes: 100}}
+	if err != nil || !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %v, Got: %v, %v", expected, got, err)
+	}
+	if got, err := ParseSavePolicies(""); err != nil || got != nil {
+		t.Errorf("Expected no policies, Got: %v, %v", got, err)
+	}
+	for _, s := range []string{"3600", "a 1", "0 1", "60 -1"} {
+		if _, err := ParseSavePolicies(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
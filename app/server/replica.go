@@ -6,47 +6,40 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log/slog"
 	"net"
-	"os"
+	"strconv"
 	"strings"
 
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
 	"github.com/codecrafters-io/redis-starter-go/app/resp"
-	"github.com/codecrafters-io/redis-starter-go/app/storage"
 )
 
 type SlaveServer struct {
+	core
 	Port       int
 	MasterHost string
 	MasterPort string
 	MasterID   string
-	Logger     *slog.Logger
-	KeyValue   storage.KeyValue
-}
-
-type MasterConn struct {
-	conn net.Conn
-	data []byte
-}
-
-func NewMasterConn(conn net.Conn) MasterConn {
-	return MasterConn{
-		conn: conn,
-		data: make([]byte, 256),
-	}
 }
 
 func NewSlaveServer(cfg *Config) *SlaveServer {
-	return &SlaveServer{
+	s := &SlaveServer{
+		core:       newCore(cfg, "slave"),
 		Port:       cfg.port,
 		MasterPort: cfg.replica.MasterPort,
 		MasterHost: cfg.replica.MasterHost,
-		Logger:     cfg.logger,
-		KeyValue:   cfg.kv,
 	}
+	s.readonly = true
+	// Keys expire when master says so, see propagateExpired
+	for _, db := range s.dbs {
+		db.KeepExpired()
+	}
+	s.infoSections = s.replicationInfo
+	s.registerCommands()
+	return s
 }
 
-func (s SlaveServer) Start() error {
+func (s *SlaveServer) Start() error {
 	masterAddr := s.MasterHost + ":" + s.MasterPort
 	masterConn, err := net.Dial("tcp", masterAddr)
 	host := "0.0.0.0:"
@@ -55,9 +48,10 @@ func (s SlaveServer) Start() error {
 		s.Logger.Error("error trying to set connection to master server...")
 		return err
 	}
-	err = s.createHandshake(context.Background(), masterConn)
+	masterClient := NewClient(masterConn)
+	err = s.createHandshake(context.Background(), masterClient)
 	if err != nil {
-		s.Logger.Error("error trying to create a handshake with master server...", "error", err)
+		s.Logger.Error("error trying to create a handshake with master server...", "error", err.Error())
 		return err
 	}
 	s.Logger.Info("Server started successfully", "port", s.Port)
@@ -65,274 +59,156 @@ func (s SlaveServer) Start() error {
 	if err != nil {
 		panic(err)
 	}
-	masterClient := NewClient(masterConn)
-	go s.handleMasterConnection(*masterClient)
+	go s.handleMasterConnection(masterClient)
+	s.startSaveCron()
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
 			s.Logger.Error("error during handle connection", "error", err.Error())
+			continue
 		}
 
 		client := NewClient(conn)
-		go s.handleConnections(*client)
+		go s.handleConnections(client)
 	}
 }
 
-func (s SlaveServer) handleConnections(cl Client) {
-	defer cl.conn.Close()
-	s.Logger.Info("New connection accepted", "address", cl.conn.RemoteAddr())
-	for {
-		n, err := cl.conn.Read(cl.data)
-		if err != nil {
-			if err == io.EOF {
-				s.Logger.Error("connection closed by client")
-				return
-			}
-			s.Logger.Error("error reading from connection: %v", err)
-			return
-		}
-		receivedData := string(cl.data[:n])
-		if len(receivedData) > 2 {
-			parsedString, err := resp.ParseRequestString(receivedData)
-			if err != nil {
-				s.Logger.Error(err.Error())
-			}
-			command := strings.ToLower(parsedString[0])
-			ctx := context.Background()
-			fmt.Printf("command: %v\n", command)
-			switch command {
-			// Common commands
-			case "echo":
-				s.Echo(ctx, parsedString[1:], cl)
-			case "info":
-				s.Info(ctx, parsedString[1:], cl)
-			default:
-				s.Ping(ctx, cl)
-			}
-		}
-	}
+func (s *SlaveServer) handleConnections(cl *Client) {
+	s.serveClient(cl)
 }
 
-func (s SlaveServer) handleMasterConnection(cl Client) {
+// Applies the write stream that master propagates after the full resync.
+// Master does not expect any reply for these commands.
+func (s *SlaveServer) handleMasterConnection(cl *Client) {
 	ctx := context.Background()
 	defer cl.conn.Close()
+	cl.fromMaster = true
 	for {
-		n, err := cl.conn.Read(cl.data)
+		args, err := cl.reader.ReadCommand()
 		if err != nil {
 			if err == io.EOF {
-				s.Logger.Error("connection closed by client")
+				s.Logger.Error("connection closed by master")
 				return
 			}
-			s.Logger.Error("error reading from connection: %v", err)
+			s.Logger.Error("error reading from master connection", "error", err.Error())
 			return
 		}
-		receivedData := string(cl.data[:n])
-		receivedData = strings.ReplaceAll(receivedData, `"`, "")
-		receivedData = strings.ReplaceAll(receivedData, `\r\n`, "\r\n")
-		if len(receivedData) > 2 {
-			parsedData, err := resp.ParseRequestString(receivedData)
-			if err != nil {
-				s.Logger.Error("error", "error", err)
-			}
-			command := strings.ToLower(parsedData[0])
-			if strings.HasPrefix(command, "REDIS") {
-				err := s.SaveRDBFile(command)
-				if err != nil {
-					s.Logger.Error("Cannot save rdb file")
-					cl.conn.Close()
-				}
-			}
-			switch command {
-			case "set":
-				s.Set(ctx, parsedData[1:], cl)
-			case "info":
-				s.Info(ctx, parsedData[1:], cl)
-			}
-		} else {
-			s.Logger.Error("Received zero length data")
+		reply := s.dispatch(ctx, cl, args)
+		if reply.IsError() {
+			s.Logger.Error("error applying command from master", "command", args[0], "error", reply.Str)
 		}
-		cl.data = make([]byte, 256)
 	}
 }
 
-func (s SlaveServer) SaveRDBFile(f string) error {
-	err := os.WriteFile("app/storage/replica/db.rdb", []byte(f), 0777)
-	if err != nil {
-		return err
+// LoadRDBFile replaces the keyspace with the snapshot master sent
+func (s *SlaveServer) LoadRDBFile(f []byte) error {
+	for _, db := range s.dbs {
+		db.Flush(false)
 	}
-	return nil
+	return rdb.Load(bytes.NewReader(f), s.dbs)
 }
 
 // Creates connection with master server
-func (s SlaveServer) createHandshake(ctx context.Context, conn net.Conn) error {
-	err := s.PingMasterServer(ctx, conn)
+func (s *SlaveServer) createHandshake(ctx context.Context, cl *Client) error {
+	err := s.PingMasterServer(ctx, cl)
 	if err != nil {
 		return err
 	}
-	err = s.ReplconfMasterServer(ctx, conn)
+	err = s.ReplconfMasterServer(ctx, cl)
 	if err != nil {
 		return err
 	}
-	err = s.PsyncMasterServer(ctx, conn)
+	err = s.PsyncMasterServer(ctx, cl)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s SlaveServer) PingMasterServer(ctx context.Context, conn net.Conn) error {
-	if conn == nil {
+func (s *SlaveServer) PingMasterServer(ctx context.Context, cl *Client) error {
+	if cl == nil || cl.conn == nil {
 		return errors.New("connection is nil")
 	}
 
-	_, err := conn.Write([]byte(PingCommand))
+	_, err := cl.conn.Write(resp.StringsValue([]string{"PING"}).Bytes())
 	if err != nil {
 		return err
 	}
-	expectedResponse := []byte("+PONG\r\n")
-	response := make([]byte, len(expectedResponse))
-	s.responseLoop(ctx, conn, response, expectedResponse)
-	return nil
+	return s.expectReply(cl, "+PONG")
 }
 
-func (s SlaveServer) responseLoop(ctx context.Context, conn net.Conn, response, expectedResponse []byte) error {
-	for {
-		select {
-		case <-ctx.Done():
-			return errors.New("timeout")
-		default:
-			_, err := conn.Read(response)
-			if err != nil {
-				return err
-			}
-			if bytes.Equal(response, expectedResponse) {
-				return nil
-			}
-		}
+// Reads a single line reply from master and checks that it is the expected one
+func (s *SlaveServer) expectReply(cl *Client, expected string) error {
+	line, err := cl.reader.ReadLine()
+	if err != nil {
+		return err
 	}
+	if line != expected {
+		return fmt.Errorf("unexpected reply from master: %q, expected %q", line, expected)
+	}
+	return nil
 }
 
-func (s SlaveServer) ReplconfMasterServer(ctx context.Context, conn net.Conn) error {
-	if conn == nil {
+func (s *SlaveServer) ReplconfMasterServer(ctx context.Context, cl *Client) error {
+	if cl == nil || cl.conn == nil {
 		return errors.New("connection is nil")
 	}
 
-	_, err := conn.Write([]byte("*3\r\n$8\r\nREPLCONF\r\n$14\r\nlistening-port\r\n$4\r\n6380\r\n"))
-	if err != nil {
-		return err
-	}
-	expectedResponse := []byte("+OK\r\n")
-	response := make([]byte, len(expectedResponse))
-	err = s.responseLoop(ctx, conn, response, expectedResponse)
+	port := strconv.Itoa(s.Port)
+	_, err := cl.conn.Write(resp.StringsValue([]string{"REPLCONF", "listening-port", port}).Bytes())
 	if err != nil {
 		return err
 	}
-	_, err = conn.Write([]byte("*3\r\n$8\r\nREPLCONF\r\n$4\r\ncapa\r\n$6\r\npsync2\r\n"))
+	err = s.expectReply(cl, "+OK")
 	if err != nil {
 		return err
 	}
-	expectedResponse = []byte("+OK\r\n")
-	response = make([]byte, len(expectedResponse))
-	err = s.responseLoop(ctx, conn, response, expectedResponse)
+	_, err = cl.conn.Write(resp.StringsValue([]string{"REPLCONF", "capa", "psync2"}).Bytes())
 	if err != nil {
 		return err
 	}
-	return nil
+	return s.expectReply(cl, "+OK")
 }
 
-func (s SlaveServer) PsyncMasterServer(ctx context.Context, conn net.Conn) error {
-	if conn == nil {
+func (s *SlaveServer) PsyncMasterServer(ctx context.Context, cl *Client) error {
+	if cl == nil || cl.conn == nil {
 		return errors.New("connection is nil")
 	}
 	// This command tells master server that it doesn't have any data yet,
 	// and needs to be fully resynchronized.
-	cmd := "*3\r\n$5\r\nPSYNC\r\n$1\r\n?\r\n$2\r\n-1\r\n"
-	_, err := conn.Write([]byte(cmd))
+	cmd := resp.StringsValue([]string{"PSYNC", "?", "-1"})
+	_, err := cl.conn.Write(cmd.Bytes())
 	if err != nil {
 		return err
 	}
-	expectedPrefix := "+FULLRESYNC "
-	expectedSuffix := " 0\r\n"
-	var response []byte
-	buffer := make([]byte, 1)
-	for {
-		_, err := conn.Read(buffer)
-		if err != nil {
-			return err
-		}
-		response = append(response, buffer[0])
-		if len(response) >= len(expectedPrefix) && string(response[len(response)-len(expectedSuffix):]) == expectedSuffix {
-			break
-		}
-	}
-
-	startIndex := len(expectedPrefix)
-	endIndex := len(response) - len(expectedSuffix)
-	masterID := string(response[startIndex:endIndex])
-
-	s.MasterID = masterID
-	fmt.Printf("s.MasterID: %v\n", s.MasterID)
-
-	return nil
-}
-
-func (s SlaveServer) Ping(context.Context, Client) (bool, error) {
-	return true, nil
-}
-
-func (s SlaveServer) Echo(ctx context.Context, input []string, cl Client) error {
-	resString := resp.CreateBulkStringFromArray(input)
-	b := []byte(resString)
-	_, err := cl.conn.Write(b)
+	// Master answers with "+FULLRESYNC <replid> <offset>" followed by the RDB payload
+	line, err := cl.reader.ReadLine()
 	if err != nil {
 		return err
 	}
-	return nil
-}
-
-func (s SlaveServer) HandleRDBFile(input []string) {
-	fmt.Printf("input: %v\n", input)
-}
-
-func (s SlaveServer) Set(ctx context.Context, input []string, cl Client) error {
-	key := input[0]
-	value := input[1]
-	args := make(map[string]string)
-	if len(input) > 3 {
-		if input[2] == "px" { // px allows setting a key with expiry
-			args["px"] = input[3]
-		}
+	fields := strings.Fields(line)
+	if len(fields) != 3 || fields[0] != "+FULLRESYNC" {
+		return fmt.Errorf("unexpected reply to psync: %q", line)
 	}
-	s.KeyValue.SetVariable(key, value, args)
-	_, err := cl.conn.Write([]byte(StatusOK))
+	s.MasterID = fields[1]
+
+	rdbFile, err := cl.reader.ReadRawBulk()
 	if err != nil {
 		return err
 	}
-	cl.conn.Write([]byte(StatusOK))
-	return nil
-}
-
-func (s SlaveServer) Get(context.Context, string, Client) {
+	return s.LoadRDBFile(rdbFile)
 }
 
-func (s SlaveServer) Info(ctx context.Context, args []string, cl Client) {
-	cmd := args[0]
-	switch cmd {
-	case "replication":
-		{
-			role := "role:" + s.Role()
-			b := resp.CreateBulkString(role)
-			_, err := cl.conn.Write([]byte(b))
-			if err != nil {
-				s.Logger.Error("error while handling info command", "error", err)
-			}
-		}
+func (s *SlaveServer) replicationInfo() []infoSection {
+	return []infoSection{
+		{"Replication", [][2]string{
+			{"role", s.Role()},
+			{"master_host", s.MasterHost},
+			{"master_port", s.MasterPort},
+		}},
 	}
 }
 
-func (s SlaveServer) CreateHandshake() {
-}
-
-func (s SlaveServer) Role() string {
+func (s *SlaveServer) Role() string {
 	return "slave"
 }
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// Waits until the replica connected through cl is registered, online or not
+func waitReplica(t *testing.T, ms *MasterServer, cl *Client, online bool) {
+	t.Helper()
+	for range 200 {
+		ms.replicasMu.Lock()
+		got, ok := ms.Replicas[cl]
+		ms.replicasMu.Unlock()
+		if ok && got == online {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for the replica, online: %v", online)
+}
+
+// Reads the full resync master sends to conn and loads its snapshot. It
+// returns the replication offset of the snapshot too.
+func readFullResync(t *testing.T, r *resp.Reader) ([]*storage.KeyValue, int64) {
+	t.Helper()
+	line, err := r.ReadLine()
+	var offset int64
+	if err == nil {
+		_, err = fmt.Sscanf(line, "+FULLRESYNC "+generateMasterID()+" %d", &offset)
+	}
+	if err != nil {
+		t.Fatalf("Unexpected reply to PSYNC: %q, %v", line, err)
+	}
+	payload, err := r.ReadRawBulk()
+	if err != nil {
+		t.Fatalf("Unexpected error reading the snapshot: %v", err)
+	}
+	dbs := storage.NewDatabases(16)
+	if err := rdb.Load(bytes.NewReader(payload), dbs); err != nil {
+		t.Fatalf("Unexpected error loading the snapshot: %v", err)
+	}
+	return dbs, offset
+}
+
+// Number of bytes the commands take when propagated
+func propagatedSize(commands ...[]string) int64 {
+	var n int64
+	for _, args := range commands {
+		n += int64(len(resp.StringsValue(args).Bytes()))
+	}
+	return n
+}
+
+func TestPsync_SendsLiveSnapshot(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	do(ms, cl, "set", "a", "1")
+	do(ms, cl, "select", "2")
+	do(ms, cl, "rpush", "l", "x")
+	do(ms, cl, "hset", "h", "f", "v")
+	do(ms, cl, "hpexpire", "h", "100000", "fields", "1", "f")
+
+	server, conn := net.Pipe()
+	defer conn.Close()
+	replica := NewClient(server)
+	done := doAsyncAs(ms, replica, "psync", "?", "-1")
+	// The snapshot was taken once the replica is registered. Master can't
+	// send it until the replica reads, so these writes are buffered.
+	waitReplica(t, ms, replica, false)
+	do(ms, cl, "rpush", "l", "y")
+	do(ms, cl, "incr", "n")
+
+	r := resp.NewReader(conn)
+	dbs, offset := readFullResync(t, r)
+	if dbs[0].Len() != 1 || dbs[2].Len() != 2 {
+		t.Fatalf("Unexpected snapshot: %d and %d keys", dbs[0].Len(), dbs[2].Len())
+	}
+	dbs[2].View(nil, func(tx *storage.Tx) error {
+		if l, _ := tx.List("l", false); l == nil || !reflect.DeepEqual(l.Range(0, -1), []string{"x"}) {
+			t.Errorf("Unexpected list in the snapshot: %v", l)
+		}
+		if h, _ := tx.Hash("h", false); h == nil {
+			t.Error("Missing hash in the snapshot")
+		} else if at, _ := h.FieldExpireAt("f"); at == 0 {
+			t.Error("The field TTL is missing in the snapshot")
+		}
+		return nil
+	})
+	// HPEXPIRE was propagated as HPEXPIREAT, of the same size here
+	before := propagatedSize(
+		[]string{"set", "a", "1"},
+		[]string{"select", "2"}, []string{"rpush", "l", "x"}, []string{"hset", "h", "f", "v"},
+		[]string{"hpexpireat", "h", strconv.FormatInt(time.Now().UnixMilli()+100000, 10), "fields", "1", "f"},
+	)
+	if offset != before {
+		t.Errorf("Unexpected offset of the snapshot. Expected: %d, Got: %d", before, offset)
+	}
+	expected := [][]string{
+		{"select", "2"},
+		{"rpush", "l", "y"},
+		{"incr", "n"},
+	}
+	for _, e := range expected {
+		if got, err := r.ReadCommand(); err != nil || !reflect.DeepEqual(got, e) {
+			t.Errorf("Unexpected buffered write. Expected: %q, Got: %q, %v", e, got, err)
+		}
+	}
+	<-done
+	waitReplica(t, ms, replica, true)
+	// Once online the replica gets writes as they happen
+	do(ms, cl, "set", "b", "2")
+	if got, err := r.ReadCommand(); err != nil || !reflect.DeepEqual(got, []string{"set", "b", "2"}) {
+		t.Errorf("Unexpected write: %q, %v", got, err)
+	}
+	expected = append(expected, []string{"set", "b", "2"})
+	if got, want := do(ms, cl, "info", "replication"), "master_repl_offset:"+strconv.FormatInt(offset+propagatedSize(expected...), 10)+"\r\n"; !strings.Contains(got, want) {
+		t.Errorf("Unexpected INFO, expected %q: %q", want, got)
+	}
+}
+
+func TestPsync_DropsSlowReplica(t *testing.T) {
+	ms := newTestMaster()
+	ms.outputLimit = 100
+	server, conn := net.Pipe()
+	defer conn.Close()
+	replica := NewClient(server)
+	done := doAsyncAs(ms, replica, "psync", "?", "-1")
+	readFullResync(t, resp.NewReader(conn))
+	<-done
+	waitReplica(t, ms, replica, true)
+	// The replica doesn't read, writes don't wait for it
+	cl := newTestClient()
+	for i := range 10 {
+		if got := do(ms, cl, "set", "k", strconv.Itoa(i)); got != "+OK\r\n" {
+			t.Fatalf("Unexpected reply to SET: %q", got)
+		}
+	}
+	ms.replicasMu.Lock()
+	_, ok := ms.Replicas[replica]
+	ms.replicasMu.Unlock()
+	if ok {
+		t.Error("The replica that fell behind wasn't dropped")
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the connection to the replica to be closed")
+	}
+}
+
+func TestPsync_BlockedClients(t *testing.T) {
+	ms := newTestMaster()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// A parked blocking command doesn't hold up the snapshot
+	blpop := doAsync(ctx, ms, "blpop", "q", "0")
+	waitBlocked(t, ms, "q", 1)
+
+	server, conn := net.Pipe()
+	defer conn.Close()
+	replica := NewClient(server)
+	done := doAsyncAs(ms, replica, "psync", "?", "-1")
+	r := resp.NewReader(conn)
+	readFullResync(t, r)
+	<-done
+	waitReplica(t, ms, replica, true)
+
+	go do(ms, newTestClient(), "rpush", "q", "v")
+	for _, e := range [][]string{{"select", "0"}, {"rpush", "q", "v"}, {"lpop", "q", "1"}} {
+		if got, err := r.ReadCommand(); err != nil || !reflect.DeepEqual(got, e) {
+			t.Errorf("Unexpected write. Expected: %q, Got: %q, %v", e, got, err)
+		}
+	}
+	if got := <-blpop; got != "*2\r\n$1\r\nq\r\n$1\r\nv\r\n" {
+		t.Errorf("Unexpected reply to BLPOP: %q", got)
+	}
+}
+
+func TestLoadRDBFile(t *testing.T) {
+	master := storage.NewDatabases(16)
+	master[1].Update(nil, func(tx *storage.Tx) error {
+		tx.Set("a", "1")
+		return nil
+	})
+	snap := storage.TakeSnapshot(master)
+	var buf bytes.Buffer
+	err := rdb.Save(&buf, snap)
+	snap.Release()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cfg := NewConfig(6380, slog.Default(), storage.NewDatabases(16), Replica{MasterHost: "localhost", MasterPort: "6379"}, Persistence{})
+	s := NewSlaveServer(cfg)
+	cl := newTestClient()
+	cl.fromMaster = true
+	s.dispatch(context.Background(), cl, []string{"set", "stale", "x"})
+	if err := s.LoadRDBFile(buf.Bytes()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.dbs[0].Len() != 0 || s.dbs[1].Len() != 1 {
+		t.Errorf("Unexpected keys: %d and %d", s.dbs[0].Len(), s.dbs[1].Len())
+	}
+}
+
+// Runs a command for cl in the background, the channel is closed once it
+// returned
+func doAsyncAs(ms *MasterServer, cl *Client, args ...string) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ms.dispatch(context.Background(), cl, args)
+	}()
+	return done
+}
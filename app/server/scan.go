@@ -0,0 +1,93 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// Options of the SCAN command family
+type scanOptions struct {
+	// Glob pattern elements must match, empty matches everything
+	match string
+	count int
+	// HSCAN NOVALUES
+	novalues bool
+	// SCAN TYPE, the type keys must have
+	typ    storage.ValueType
+	hasTyp bool
+}
+
+func parseScanCursor(s string) (uint64, bool) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	return n, err == nil
+}
+
+// Parses "[MATCH pattern] [COUNT count]", "[NOVALUES]" for HSCAN and
+// "[TYPE type]" for SCAN
+func parseScanOptions(args []string, allowNoValues, allowType bool) (scanOptions, resp.Value, bool) {
+	opts := scanOptions{count: 10}
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		switch {
+		case opt == "match" && i+1 < len(args):
+			opts.match = args[i+1]
+			if opts.match == "*" {
+				opts.match = ""
+			}
+			i++
+		case opt == "count" && i+1 < len(args):
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				return opts, notInteger(), false
+			}
+			if n < 1 {
+				return opts, syntaxError(), false
+			}
+			opts.count = int(min(n, int64(maxScanCount)))
+			i++
+		case opt == "novalues" && allowNoValues:
+			opts.novalues = true
+		case opt == "type" && allowType && i+1 < len(args):
+			typ, ok := storage.ParseValueType(args[i+1])
+			if !ok {
+				return opts, resp.Errorf("unknown type name '%s'", args[i+1]), false
+			}
+			opts.typ, opts.hasTyp = typ, true
+			i++
+		default:
+			return opts, syntaxError(), false
+		}
+	}
+	return opts, resp.Value{}, true
+}
+
+// Upper bound of COUNT, it only sizes buffers
+const maxScanCount = 1 << 20
+
+func (opts *scanOptions) matches(s string) bool {
+	return opts.match == "" || globMatch(opts.match, s, false)
+}
+
+// Walks a container with scan, the Scan method of a Hash, Set or ZSet,
+// from cursor until about count elements were visited, and returns the
+// cursor to continue with. Like SCAN, at most 10*count buckets are visited,
+// so a sparse table doesn't make a call slow.
+func scanElements(cursor uint64, count int, scan func(cursor uint64, fn func(string)) uint64, fn func(string)) uint64 {
+	sampled := 0
+	for buckets := count * 10; ; buckets-- {
+		cursor = scan(cursor, func(elem string) {
+			sampled++
+			fn(elem)
+		})
+		if cursor == 0 || sampled >= count || buckets == 0 {
+			return cursor
+		}
+	}
+}
+
+func scanReply(cursor uint64, elements []resp.Value) resp.Value {
+	return resp.ArrayValue(resp.BulkValue(strconv.FormatUint(cursor, 10)), resp.ArrayValue(elements...))
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+)
+
+// Runs a SCAN family command until the cursor is 0 and returns the
+// elements of all pages
+func scanAll(t *testing.T, ms *MasterServer, args ...string) []string {
+	t.Helper()
+	cl := newTestClient()
+	var res []string
+	cursor := "0"
+	for {
+		var cmd []string
+		if args[0] == "scan" {
+			cmd = append([]string{"scan", cursor}, args[1:]...)
+		} else {
+			cmd = append([]string{args[0], args[1], cursor}, args[2:]...)
+		}
+		reply := ms.dispatch(context.Background(), cl, cmd)
+		if reply.Type != resp.Array {
+			t.Fatalf("%q: unexpected reply %q", cmd, reply.String())
+		}
+		cursor = reply.Array[0].Str
+		for _, v := range reply.Array[1].Array {
+			res = append(res, v.Str)
+		}
+		if cursor == "0" {
+			return res
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	for i := 0; i < 200; i++ {
+		do(ms, cl, "set", "key:"+strconv.Itoa(i), "v")
+	}
+	do(ms, cl, "rpush", "list:1", "a")
+	do(ms, cl, "sadd", "set:1", "a")
+
+	keys := scanAll(t, ms, "scan", "count", "7")
+	if len(keys) != 202 {
+		t.Errorf("Unexpected number of keys. Expected: 202, Got: %d", len(keys))
+	}
+	keys = scanAll(t, ms, "scan", "match", "key:1?")
+	sort.Strings(keys)
+	if len(keys) != 10 || keys[0] != "key:10" || keys[9] != "key:19" {
+		t.Errorf("Unexpected keys matching key:1?: %q", keys)
+	}
+	keys = scanAll(t, ms, "scan", "type", "LIST")
+	if len(keys) != 1 || keys[0] != "list:1" {
+		t.Errorf("Unexpected keys of type list: %q", keys)
+	}
+
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"scan", "x"}, "-ERR invalid cursor\r\n"},
+		{[]string{"scan", "0", "type", "nosuch"}, "-ERR unknown type name 'nosuch'\r\n"},
+		{[]string{"scan", "0", "count", "0"}, "-ERR syntax error\r\n"},
+		{[]string{"scan", "0", "novalues"}, "-ERR syntax error\r\n"},
+		{[]string{"keys", "set:*"}, "*1\r\n$5\r\nset:1\r\n"},
+		{[]string{"keys", "key:2[0-9][0-9]"}, "*0\r\n"},
+		{[]string{"keys", "key:19[^0-8]"}, "*1\r\n$7\r\nkey:199\r\n"},
+		{[]string{"keys", "nosuch*"}, "*0\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+	if got := do(ms, cl, "keys", "*"); got[:5] != "*202\r" {
+		t.Errorf("Unexpected reply to KEYS *: %q", got[:10])
+	}
+}
+
+func TestZScan(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"zadd", "z", "1.5", "a", "2", "b"}, ":2\r\n"},
+		{[]string{"zscan", "z", "0"}, "*2\r\n$1\r\n0\r\n*4\r\n$1\r\na\r\n$3\r\n1.5\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{[]string{"zscan", "z", "0", "match", "b"}, "*2\r\n$1\r\n0\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{[]string{"zscan", "missing", "0"}, "*2\r\n$1\r\n0\r\n*0\r\n"},
+		{[]string{"zscan", "z", "0", "type", "zset"}, "-ERR syntax error\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		do(ms, cl, "zadd", "big", strconv.Itoa(i), "m"+strconv.Itoa(i))
+	}
+	if members := scanAll(t, ms, "zscan", "big", "count", "15"); len(members) != 400 {
+		t.Errorf("Unexpected number of members and scores. Expected: 400, Got: %d", len(members))
+	}
+}
+
+func TestSScanWhileDeleting(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	for i := 0; i < 300; i++ {
+		do(ms, cl, "sadd", "s", "e"+strconv.Itoa(i))
+	}
+	seen := make(map[string]bool)
+	cursor := "0"
+	for i := 299; ; i-- {
+		reply := ms.dispatch(context.Background(), cl, []string{"sscan", "s", cursor})
+		if n := len(reply.Array[1].Array); n > 50 {
+			t.Fatalf("SSCAN returned %d members for COUNT 10", n)
+		}
+		for _, v := range reply.Array[1].Array {
+			seen[v.Str] = true
+		}
+		cursor = reply.Array[0].Str
+		if cursor == "0" {
+			break
+		}
+		// Members removed between calls don't make others get skipped,
+		// although the table shrinks
+		do(ms, cl, "srem", "s", "e"+strconv.Itoa(i))
+	}
+	members := scanAll(t, ms, "sscan", "s")
+	if len(members) == 300 {
+		t.Fatal("No member was removed during the scan")
+	}
+	for _, m := range members {
+		if !seen[m] {
+			t.Fatalf("Member %q was not returned", m)
+		}
+	}
+}
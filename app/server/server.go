@@ -1,24 +1,19 @@
 package server
 
 import (
-	"bytes"
 	"context"
 	"log/slog"
 	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
 
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
 	"github.com/codecrafters-io/redis-starter-go/app/storage"
 )
 
-const (
-	nullBulkString = "$-1\r\n"
-)
-
 type Server interface {
 	Start() error
-	Ping(context.Context, Client) (bool, error)
-	Set(context.Context, []string, Client) error
-	Get(context.Context, string, Client)
-	Info(context.Context, []string, Client)
 	Role() string
 }
 
@@ -28,31 +23,149 @@ type Replica struct {
 }
 
 type Config struct {
-	port    int
-	logger  *slog.Logger
-	kv      storage.KeyValue
-	replica Replica
+	port        int
+	logger      *slog.Logger
+	dbs         []*storage.KeyValue
+	replica     Replica
+	persistence Persistence
 }
 
+// Version reported to clients in HELLO and INFO
+const redisVersion = "7.2.0"
+
+var lastClientID atomic.Int64
+
 // Client that wil connect to a server
 type Client struct {
-	conn net.Conn
-	data []byte
-	d    bytes.Buffer
+	id     int64
+	name   string
+	conn   net.Conn
+	reader *resp.Reader
+	writer *resp.Writer
+	// Set on a replica for the connection to its master. Writes coming
+	// from it are applied even though the replica is read only.
+	fromMaster bool
+	// Set by a handler that needs replicas to execute a different command
+	// than the one it got, e.g. INCRBYFLOAT is propagated as a SET of the
+	// result so float rounding can't make replicas diverge.
+	propagateAs []string
+	// Commands replicas execute before the one of the current command, set
+	// by a handler whose effect takes several commands to replay, e.g.
+	// XCLAIM sends one per claimed entry
+	alsoPropagate [][]string
+	// Keys the current command added elements to, see signalKeyAsReady
+	readyKeys []dbKey
+	// The current command is a write holding core.writeMu for reading
+	writing bool
+	// Index of the selected database
+	db int
 }
 
 func NewClient(conn net.Conn) *Client {
 	return &Client{
-		conn: conn,
-		data: make([]byte, 1024),
+		id:     lastClientID.Add(1),
+		conn:   conn,
+		reader: resp.NewReader(conn),
+		writer: resp.NewWriter(conn),
+	}
+}
+
+// Protocol version negotiated by the client with HELLO, RESP2 by default.
+func (cl *Client) Protocol() int {
+	return cl.writer.Protocol()
+}
+
+// Buffers a reply for the client. Replies are sent once the client has no
+// more pipelined commands waiting, see flushIfIdle.
+func (cl *Client) WriteValue(v resp.Value) error {
+	return cl.writer.WriteValue(v)
+}
+
+// Sends buffered replies unless more commands from the same pipeline are
+// already waiting in the read buffer.
+func (cl *Client) flushIfIdle(pipelined bool) error {
+	if pipelined {
+		return nil
+	}
+	return cl.writer.Flush()
+}
+
+// Reads commands and hands them to the serving goroutine until the
+// connection fails, which also cancels the context of the running command.
+// done is closed once the serving goroutine stopped.
+func (cl *Client) readRequests(cancel context.CancelFunc, requests chan<- request, done <-chan struct{}) {
+	defer close(requests)
+	for {
+		args, err := cl.reader.ReadCommand()
+		req := request{args: args, err: err, pipelined: cl.reader.Buffered() > 0}
+		if err != nil {
+			cancel()
+		}
+		select {
+		case requests <- req:
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
+		}
 	}
 }
 
-func NewConfig(port int, logger *slog.Logger, kv storage.KeyValue, replica Replica) *Config {
+// NewConfig configures a server with the logical databases dbs, which must
+// all have the same number of shards, saved as set by persistence.
+func NewConfig(port int, logger *slog.Logger, dbs []*storage.KeyValue, replica Replica, persistence Persistence) *Config {
 	return &Config{
-		port:    port,
-		logger:  logger,
-		kv:      kv,
-		replica: replica,
+		port:        port,
+		logger:      logger,
+		dbs:         dbs,
+		replica:     replica,
+		persistence: persistence,
+	}
+}
+
+// Handles "HELLO [protover [AUTH username password] [SETNAME clientname]]".
+// It switches the connection protocol and replies with a map describing the
+// server, encoded in the new protocol.
+func hello(cl *Client, role string, args []string) resp.Value {
+	proto := cl.Protocol()
+	if len(args) > 0 {
+		v, err := strconv.Atoi(args[0])
+		if err != nil {
+			return resp.Errorf("Protocol version is not an integer or out of range")
+		}
+		if v != resp.RESP2 && v != resp.RESP3 {
+			return resp.ErrorValue("NOPROTO unsupported protocol version")
+		}
+		proto = v
+	}
+	name := cl.name
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		switch {
+		case opt == "auth" && i+2 < len(args):
+			// There is no authentication, every user is the default
+			// one without a password.
+			i += 2
+		case opt == "setname" && i+1 < len(args):
+			if strings.ContainsAny(args[i+1], " \n") {
+				return resp.Errorf("Client names cannot contain spaces, newlines or special characters.")
+			}
+			name = args[i+1]
+			i++
+		default:
+			return resp.Errorf("Syntax error in HELLO option '%s'", args[i])
+		}
 	}
+	cl.name = name
+	cl.writer.SetProtocol(proto)
+	return resp.MapValue(
+		resp.BulkValue("server"), resp.BulkValue("redis"),
+		resp.BulkValue("version"), resp.BulkValue(redisVersion),
+		resp.BulkValue("proto"), resp.IntegerValue(int64(proto)),
+		resp.BulkValue("id"), resp.IntegerValue(cl.id),
+		resp.BulkValue("mode"), resp.BulkValue("standalone"),
+		resp.BulkValue("role"), resp.BulkValue(role),
+		resp.BulkValue("modules"), resp.ArrayValue(),
+	)
 }
@@ -0,0 +1,450 @@
+package server
+
+import (
+	"context"
+	"math"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+func (c *core) registerSetCommands() {
+	c.commands.Register(Command{Name: "sadd", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.saddCommand})
+	c.commands.Register(Command{Name: "srem", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.sremCommand})
+	c.commands.Register(Command{Name: "smembers", Arity: 2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.smembersCommand})
+	c.commands.Register(Command{Name: "sismember", Arity: 3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.sismemberCommand})
+	c.commands.Register(Command{Name: "smismember", Arity: -3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.smismemberCommand})
+	c.commands.Register(Command{Name: "scard", Arity: 2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.scardCommand})
+	c.commands.Register(Command{Name: "spop", Arity: -2, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.spopCommand})
+	c.commands.Register(Command{Name: "srandmember", Arity: -2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.srandmemberCommand})
+	c.commands.Register(Command{Name: "smove", Arity: 4, Flags: FlagWrite, FirstKey: 1, LastKey: 2, Handler: c.smoveCommand})
+	c.commands.Register(Command{Name: "sscan", Arity: -3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.sscanCommand})
+	for _, name := range []string{"sinter", "sunion", "sdiff"} {
+		c.commands.Register(Command{Name: name, Arity: -2, Flags: FlagReadonly, FirstKey: 1, LastKey: -1, Handler: c.setAlgebraCommand})
+		c.commands.Register(Command{Name: name + "store", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: -1, Handler: c.setAlgebraCommand})
+	}
+	c.commands.Register(Command{Name: "sintercard", Arity: -3, Flags: FlagReadonly, Handler: c.sintercardCommand})
+}
+
+// Runs fn on the set at key in a read only transaction. s is nil when the
+// key does not exist.
+func (c *core) viewSet(cl *Client, key string, fn func(s *storage.Set)) error {
+	return c.db(cl).View([]string{key}, func(tx *storage.Tx) error {
+		s, err := tx.LookupSet(key, false)
+		if err != nil {
+			return err
+		}
+		fn(s)
+		return nil
+	})
+}
+
+func (c *core) saddCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var added int
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		s, err := tx.LookupSet(args[1], true)
+		if err != nil {
+			return err
+		}
+		for _, m := range args[2:] {
+			if s.Add(m) {
+				added++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if added == 0 {
+		cl.propagateAs = []string{}
+	}
+	return resp.IntegerValue(int64(added))
+}
+
+func (c *core) sremCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var removed int
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		s, err := tx.LookupSet(args[1], false)
+		if err != nil || s == nil {
+			return err
+		}
+		for _, m := range args[2:] {
+			if s.Remove(m) {
+				removed++
+			}
+		}
+		tx.DeleteIfEmpty(args[1])
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if removed == 0 {
+		cl.propagateAs = []string{}
+	}
+	return resp.IntegerValue(int64(removed))
+}
+
+// Replies with members as a RESP3 set, which RESP2 clients get as an array
+func membersReply(members []string) resp.Value {
+	values := make([]resp.Value, len(members))
+	for i, m := range members {
+		values[i] = resp.BulkValue(m)
+	}
+	return resp.SetValue(values...)
+}
+
+func (c *core) smembersCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var members []string
+	err := c.viewSet(cl, args[1], func(s *storage.Set) {
+		if s != nil {
+			members = s.Members()
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return membersReply(members)
+}
+
+func (c *core) sismemberCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var ok bool
+	err := c.viewSet(cl, args[1], func(s *storage.Set) {
+		ok = s != nil && s.Contains(args[2])
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return boolReply(ok)
+}
+
+func (c *core) smismemberCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	res := make([]resp.Value, len(args)-2)
+	err := c.viewSet(cl, args[1], func(s *storage.Set) {
+		for i, m := range args[2:] {
+			res[i] = boolReply(s != nil && s.Contains(m))
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.ArrayValue(res...)
+}
+
+func (c *core) scardCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var n int
+	err := c.viewSet(cl, args[1], func(s *storage.Set) {
+		if s != nil {
+			n = s.Len()
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.IntegerValue(int64(n))
+}
+
+// Handles "SPOP key [count]". Since the popped members are random,
+// replicas get an SREM of them.
+func (c *core) spopCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	if len(args) > 3 {
+		return syntaxError()
+	}
+	var count int64 = 1
+	if len(args) == 3 {
+		var ok bool
+		count, ok = parseInt(args[2])
+		if !ok || count < 0 {
+			return resp.Errorf("value is out of range, must be positive")
+		}
+	}
+	var popped []string
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		s, err := tx.LookupSet(args[1], false)
+		if err != nil || s == nil {
+			return err
+		}
+		members := s.Members()
+		for _, i := range randomIndexes(len(members), count) {
+			popped = append(popped, members[i])
+			s.Remove(members[i])
+		}
+		tx.DeleteIfEmpty(args[1])
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if len(popped) == 0 {
+		cl.propagateAs = []string{}
+	} else {
+		cl.propagateAs = append([]string{"srem", args[1]}, popped...)
+	}
+	if len(args) == 2 {
+		if len(popped) == 0 {
+			return resp.NullBulkValue()
+		}
+		return resp.BulkValue(popped[0])
+	}
+	return membersReply(popped)
+}
+
+// Handles "SRANDMEMBER key [count]". A positive count returns distinct
+// members, a negative one may return the same member several times.
+func (c *core) srandmemberCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	if len(args) > 3 {
+		return syntaxError()
+	}
+	var count int64 = 1
+	if len(args) == 3 {
+		var ok bool
+		count, ok = parseInt(args[2])
+		if !ok {
+			return notInteger()
+		}
+		if count == math.MinInt64 {
+			return resp.Errorf("value is out of range")
+		}
+	}
+	var picked []string
+	err := c.viewSet(cl, args[1], func(s *storage.Set) {
+		if s == nil {
+			return
+		}
+		members := s.Members()
+		for _, i := range randomIndexes(len(members), count) {
+			picked = append(picked, members[i])
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if len(args) == 2 {
+		if len(picked) == 0 {
+			return resp.NullBulkValue()
+		}
+		return resp.BulkValue(picked[0])
+	}
+	return resp.StringsValue(picked)
+}
+
+// Handles "SMOVE source destination member"
+func (c *core) smoveCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	src, dst, m := args[1], args[2], args[3]
+	var moved bool
+	err := c.db(cl).Update([]string{src, dst}, func(tx *storage.Tx) error {
+		from, err := tx.LookupSet(src, false)
+		if err != nil {
+			return err
+		}
+		if e := tx.Peek(dst); e != nil && e.Type != storage.TypeSet {
+			return storage.ErrWrongType
+		}
+		if from == nil || !from.Contains(m) {
+			return nil
+		}
+		moved = true
+		if src == dst {
+			return nil
+		}
+		from.Remove(m)
+		tx.DeleteIfEmpty(src)
+		to, _ := tx.LookupSet(dst, true)
+		to.Add(m)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !moved || src == dst {
+		cl.propagateAs = []string{}
+	}
+	return boolReply(moved)
+}
+
+// Handles "SSCAN key cursor [MATCH pattern] [COUNT count]"
+func (c *core) sscanCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	cursor, ok := parseScanCursor(args[2])
+	if !ok {
+		return resp.Errorf("invalid cursor")
+	}
+	opts, errReply, ok := parseScanOptions(args[3:], false, false)
+	if !ok {
+		return errReply
+	}
+	var items []resp.Value
+	var next uint64
+	err := c.viewSet(cl, args[1], func(s *storage.Set) {
+		if s == nil {
+			return
+		}
+		next = scanElements(cursor, opts.count, s.Scan, func(m string) {
+			if opts.matches(m) {
+				items = append(items, resp.BulkValue(m))
+			}
+		})
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return scanReply(next, items)
+}
+
+// Computes the intersection, union or difference of the sets at keys.
+// Missing keys count as empty sets.
+func setAlgebra(tx *storage.Tx, op string, keys []string) (map[string]struct{}, error) {
+	sets := make([]*storage.Set, len(keys))
+	for i, key := range keys {
+		s, err := tx.LookupSet(key, false)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = s
+	}
+	res := make(map[string]struct{})
+	switch op {
+	case "sinter":
+		for _, s := range sets {
+			if s == nil {
+				return res, nil
+			}
+		}
+		// Start from the smallest set, like Redis
+		smallest := sets[0]
+		for _, s := range sets {
+			if s.Len() < smallest.Len() {
+				smallest = s
+			}
+		}
+		for _, m := range smallest.Members() {
+			in := true
+			for _, s := range sets {
+				if !s.Contains(m) {
+					in = false
+					break
+				}
+			}
+			if in {
+				res[m] = struct{}{}
+			}
+		}
+	case "sunion":
+		for _, s := range sets {
+			if s == nil {
+				continue
+			}
+			for _, m := range s.Members() {
+				res[m] = struct{}{}
+			}
+		}
+	case "sdiff":
+		if sets[0] == nil {
+			return res, nil
+		}
+		for _, m := range sets[0].Members() {
+			res[m] = struct{}{}
+		}
+		for _, s := range sets[1:] {
+			if s == nil {
+				continue
+			}
+			for m := range res {
+				if s.Contains(m) {
+					delete(res, m)
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// Handles SINTER, SUNION and SDIFF and their *STORE variants, which store
+// the result at their first argument and reply with its size.
+func (c *core) setAlgebraCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	name := strings.ToLower(args[0])
+	op := strings.TrimSuffix(name, "store")
+	if op != name {
+		dst := args[1]
+		keys := args[2:]
+		var n int
+		var changed bool
+		err := c.db(cl).Update(args[1:], func(tx *storage.Tx) error {
+			res, err := setAlgebra(tx, op, keys)
+			if err != nil {
+				return err
+			}
+			n = len(res)
+			changed = tx.Delete(dst) || n > 0
+			if n == 0 {
+				return nil
+			}
+			s := storage.NewSet()
+			for m := range res {
+				s.Add(m)
+			}
+			tx.Put(dst, storage.TypeSet, s)
+			return nil
+		})
+		if err != nil {
+			return errorReply(err)
+		}
+		if !changed {
+			cl.propagateAs = []string{}
+		}
+		return resp.IntegerValue(int64(n))
+	}
+	var members []string
+	err := c.db(cl).View(args[1:], func(tx *storage.Tx) error {
+		res, err := setAlgebra(tx, op, args[1:])
+		for m := range res {
+			members = append(members, m)
+		}
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return membersReply(members)
+}
+
+// Handles "SINTERCARD numkeys key [key ...] [LIMIT limit]"
+func (c *core) sintercardCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	numkeys, ok := parseInt(args[1])
+	if !ok || numkeys <= 0 {
+		return resp.Errorf("numkeys should be greater than 0")
+	}
+	if numkeys > int64(len(args)-2) {
+		return resp.Errorf("Number of keys can't be greater than number of args")
+	}
+	keys := args[2 : 2+numkeys]
+	opts := args[2+numkeys:]
+	var limit int64
+	switch {
+	case len(opts) == 0:
+	case len(opts) == 2 && strings.ToLower(opts[0]) == "limit":
+		limit, ok = parseInt(opts[1])
+		if !ok {
+			return notInteger()
+		}
+		if limit < 0 {
+			return resp.Errorf("LIMIT can't be negative")
+		}
+	default:
+		return syntaxError()
+	}
+	var n int
+	err := c.db(cl).View(keys, func(tx *storage.Tx) error {
+		res, err := setAlgebra(tx, "sinter", keys)
+		n = len(res)
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if limit > 0 && int64(n) > limit {
+		n = int(limit)
+	}
+	return resp.IntegerValue(int64(n))
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetCommands(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"sadd", "a", "3", "1", "2", "1"}, ":3\r\n"},
+		{[]string{"smembers", "a"}, "*3\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n"},
+		{[]string{"object", "encoding", "a"}, "$6\r\nintset\r\n"},
+		{[]string{"sadd", "b", "2", "3", "4"}, ":3\r\n"},
+		{[]string{"sismember", "a", "2"}, ":1\r\n"},
+		{[]string{"smismember", "a", "2", "9"}, "*2\r\n:1\r\n:0\r\n"},
+		{[]string{"sintercard", "2", "a", "b"}, ":2\r\n"},
+		{[]string{"sintercard", "2", "a", "b", "LIMIT", "1"}, ":1\r\n"},
+		{[]string{"sintercard", "3", "a", "b"}, "-ERR Number of keys can't be greater than number of args\r\n"},
+		{[]string{"sinterstore", "c", "a", "b"}, ":2\r\n"},
+		{[]string{"sunionstore", "c", "a", "b"}, ":4\r\n"},
+		{[]string{"sdiffstore", "c", "a", "b"}, ":1\r\n"},
+		{[]string{"smembers", "c"}, "*1\r\n$1\r\n1\r\n"},
+		{[]string{"sdiffstore", "c", "a", "a"}, ":0\r\n"},
+		{[]string{"type", "c"}, "+none\r\n"},
+		{[]string{"sinter", "a", "missing"}, "*0\r\n"},
+		{[]string{"smove", "a", "b", "1"}, ":1\r\n"},
+		{[]string{"smove", "a", "b", "1"}, ":0\r\n"},
+		{[]string{"srem", "a", "2", "3"}, ":2\r\n"},
+		{[]string{"scard", "a"}, ":0\r\n"},
+		{[]string{"spop", "a"}, "$-1\r\n"},
+		{[]string{"spop", "b", "-1"}, "-ERR value is out of range, must be positive\r\n"},
+		{[]string{"srandmember", "missing", "5"}, "*0\r\n"},
+		{[]string{"sscan", "b", "0", "MATCH", "[12]"}, "*2\r\n$1\r\n0\r\n*2\r\n$1\r\n1\r\n$1\r\n2\r\n"},
+		{[]string{"set", "s", "v"}, "+OK\r\n"},
+		{[]string{"sunion", "b", "s"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"smove", "b", "s", "1"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+}
+
+func TestSetRandomCounts(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	do(ms, cl, "sadd", "s", "a", "b", "c")
+	cases := []struct {
+		args   []string
+		prefix string
+	}{
+		{[]string{"srandmember", "s", "5"}, "*3\r\n"},
+		{[]string{"srandmember", "s", "-5"}, "*5\r\n"},
+		{[]string{"srandmember", "s", "0"}, "*0\r\n"},
+		{[]string{"spop", "s", "2"}, "*2\r\n"},
+		{[]string{"scard", "s"}, ":1\r\n"},
+		{[]string{"spop", "s", "5"}, "*1\r\n"},
+		{[]string{"spop", "s", "5"}, "*0\r\n"},
+	}
+	for _, c := range cases {
+		if got := do(ms, cl, c.args...); !strings.HasPrefix(got, c.prefix) {
+			t.Errorf("%q: unexpected reply. Expected prefix: %q, Got: %q", c.args, c.prefix, got)
+		}
+	}
+}
@@ -0,0 +1,874 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// Default COUNT of XAUTOCLAIM, and how many pending entries it looks at per
+// entry it may claim
+const (
+	xautoclaimDefaultCount   = 100
+	xautoclaimAttemptsFactor = 10
+)
+
+func (c *core) registerStreamGroupCommands() {
+	c.commands.Register(Command{Name: "xgroup", Arity: -2, Flags: FlagWrite, FirstKey: 2, LastKey: 2, Handler: c.xgroupCommand})
+	c.commands.Register(Command{Name: "xreadgroup", Arity: -7, Flags: FlagWrite, Handler: c.xreadgroupCommand})
+	c.commands.Register(Command{Name: "xack", Arity: -4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.xackCommand})
+	c.commands.Register(Command{Name: "xpending", Arity: -3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.xpendingCommand})
+	c.commands.Register(Command{Name: "xclaim", Arity: -6, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.xclaimCommand})
+	c.commands.Register(Command{Name: "xautoclaim", Arity: -6, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.xautoclaimCommand})
+	c.commands.Register(Command{Name: "xinfo", Arity: -2, Flags: FlagReadonly, FirstKey: 2, LastKey: 2, Handler: c.xinfoCommand})
+}
+
+func noGroup(key, group string) resp.Value {
+	return resp.ErrorValue("NOGROUP No such key '" + key + "' or consumer group '" + group + "'")
+}
+
+func noGroupForKey(key, group string) resp.Value {
+	return resp.ErrorValue("NOGROUP No such consumer group '" + group + "' for key name '" + key + "'")
+}
+
+// Looks up a consumer group of the stream at key. The stream and the group
+// are nil when they don't exist.
+func lookupGroup(tx *storage.Tx, key, group string) (*storage.Stream, *storage.ConsumerGroup, error) {
+	s, err := tx.Stream(key, false)
+	if err != nil || s == nil {
+		return nil, nil, err
+	}
+	return s, s.Group(group), nil
+}
+
+// Parses the ENTRIESREAD option of XGROUP CREATE and SETID
+func parseEntriesRead(s string) (int64, resp.Value, bool) {
+	n, ok := parseInt(s)
+	if !ok {
+		return 0, notInteger(), false
+	}
+	if n < storage.InvalidEntriesRead {
+		return 0, resp.Errorf("value for ENTRIESREAD must be positive or -1"), false
+	}
+	return n, resp.Value{}, true
+}
+
+// Handles the XGROUP subcommands:
+//
+//	XGROUP CREATE key group id | $ [MKSTREAM] [ENTRIESREAD entries-read]
+//	XGROUP SETID key group id | $ [ENTRIESREAD entries-read]
+//	XGROUP DESTROY key group
+//	XGROUP CREATECONSUMER key group consumer
+//	XGROUP DELCONSUMER key group consumer
+func (c *core) xgroupCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	sub := strings.ToLower(args[1])
+	if sub == "help" {
+		return resp.ArrayValue(
+			resp.SimpleStringValue("XGROUP <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"),
+			resp.SimpleStringValue("CREATE <key> <groupname> <id|$> [option]"),
+			resp.SimpleStringValue("    Create a new consumer group. Options are:"),
+			resp.SimpleStringValue("    * MKSTREAM"),
+			resp.SimpleStringValue("      Create the empty stream if it does not exist."),
+			resp.SimpleStringValue("    * ENTRIESREAD entries_read"),
+			resp.SimpleStringValue("      Set the group's entries_read counter (internal use)."),
+			resp.SimpleStringValue("CREATECONSUMER <key> <groupname> <consumer>"),
+			resp.SimpleStringValue("    Create a new consumer in the specified group."),
+			resp.SimpleStringValue("DELCONSUMER <key> <groupname> <consumer>"),
+			resp.SimpleStringValue("    Remove the specified consumer."),
+			resp.SimpleStringValue("DESTROY <key> <groupname>"),
+			resp.SimpleStringValue("    Remove the specified group."),
+			resp.SimpleStringValue("SETID <key> <groupname> <id|$> [ENTRIESREAD entries_read]"),
+			resp.SimpleStringValue("    Set the current group ID and entries_read counter."),
+		)
+	}
+	switch sub {
+	case "create", "setid":
+		if len(args) < 5 {
+			return wrongSubcommandArity("xgroup", sub)
+		}
+	case "destroy":
+		if len(args) != 4 {
+			return wrongSubcommandArity("xgroup", sub)
+		}
+	case "createconsumer", "delconsumer":
+		if len(args) != 5 {
+			return wrongSubcommandArity("xgroup", sub)
+		}
+	default:
+		return resp.Errorf("unknown subcommand '%s'. Try XGROUP HELP.", truncate(args[1], 128))
+	}
+	key, group := args[2], args[3]
+
+	var id storage.StreamID
+	// "$" stands for the last ID of the stream
+	toLast := false
+	entriesRead := int64(storage.InvalidEntriesRead)
+	mkstream := false
+	if sub == "create" || sub == "setid" {
+		toLast = args[4] == "$"
+		if !toLast {
+			var errReply resp.Value
+			var ok bool
+			if id, errReply, ok = parseStreamID(args[4], 0); !ok {
+				return errReply
+			}
+		}
+		for i := 5; i < len(args); i++ {
+			switch opt := strings.ToLower(args[i]); {
+			case opt == "mkstream" && sub == "create":
+				mkstream = true
+			case opt == "entriesread" && i+1 < len(args):
+				n, errReply, ok := parseEntriesRead(args[i+1])
+				if !ok {
+					return errReply
+				}
+				entriesRead = n
+				i++
+			default:
+				return syntaxError()
+			}
+		}
+	}
+
+	var reply resp.Value
+	// CREATE and SETID always change the group
+	changed := true
+	err := c.db(cl).Update([]string{key}, func(tx *storage.Tx) error {
+		s, err := tx.Stream(key, mkstream)
+		if err != nil {
+			return err
+		}
+		if s == nil {
+			return asError(resp.Errorf("The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."))
+		}
+		if toLast {
+			id = s.LastID
+		}
+		g := s.Group(group)
+		if g == nil && sub != "create" && sub != "destroy" {
+			return asError(noGroupForKey(key, group))
+		}
+		switch sub {
+		case "create":
+			if _, ok := s.CreateGroup(group, id, entriesRead); !ok {
+				return asError(resp.ErrorValue("BUSYGROUP Consumer Group name already exists"))
+			}
+			reply = resp.OK
+		case "setid":
+			s.SetGroupID(g, id, entriesRead)
+			reply = resp.OK
+		case "destroy":
+			changed = s.DestroyGroup(group)
+			reply = boolReply(changed)
+		case "createconsumer":
+			_, changed = g.CreateConsumer(args[4], tx.Now())
+			reply = boolReply(changed)
+		case "delconsumer":
+			var pending int
+			pending, changed = g.DeleteConsumer(args[4])
+			reply = resp.IntegerValue(int64(pending))
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !changed {
+		cl.propagateAs = []string{}
+	}
+	if sub == "destroy" && reply.Int == 1 {
+		// Clients blocked on the group get an error
+		c.signalKeyAsReady(cl, key)
+	}
+	return reply
+}
+
+// Handles "XREADGROUP GROUP group consumer [COUNT count] [BLOCK
+// milliseconds] [NOACK] STREAMS key [key ...] id [id ...]". The ID ">"
+// delivers entries the group didn't see yet, any other ID returns the
+// entries pending for the consumer after it.
+func (c *core) xreadgroupCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	if strings.ToLower(args[1]) != "group" {
+		return syntaxError()
+	}
+	group, consumer := args[2], args[3]
+	x, errReply, ok := parseXReadArgs(args, 4)
+	if !ok {
+		return errReply
+	}
+	after := make([]storage.StreamID, len(x.keys))
+	history := false
+	for i, arg := range x.ids {
+		if arg == ">" {
+			continue
+		}
+		id, errReply, ok := parseStreamID(arg, 0)
+		if !ok {
+			return errReply
+		}
+		after[i] = id
+		history = true
+	}
+
+	// Replicas read the same entries without blocking
+	propagate := []string{"xreadgroup", "group", group, consumer}
+	if x.count > 0 {
+		propagate = append(propagate, "count", strconv.Itoa(x.count))
+	}
+	if x.noack {
+		propagate = append(propagate, "noack")
+	}
+	propagate = append(append(append(propagate, "streams"), x.keys...), x.ids...)
+
+	serve := func() (blockedResult, bool) {
+		var items []resp.Value
+		err := c.db(cl).Update(x.keys, func(tx *storage.Tx) error {
+			now := tx.Now()
+			for i, key := range x.keys {
+				s, g, err := lookupGroup(tx, key, group)
+				if err != nil {
+					return err
+				}
+				if g == nil {
+					return asError(resp.ErrorValue("NOGROUP No such key '" + key + "' or consumer group '" + group + "' in XREADGROUP with GROUP option"))
+				}
+				cons, _ := g.CreateConsumer(consumer, now)
+				cons.SeenTime = now
+				if x.ids[i] == ">" {
+					if entries := s.ReadGroup(g, cons, x.count, x.noack, now); len(entries) > 0 {
+						items = append(items, resp.BulkValue(key), streamEntriesValue(entries))
+					}
+					continue
+				}
+				start, ok := after[i].Next()
+				var pending []*storage.PendingEntry
+				if ok {
+					pending = g.PendingRange(start, storage.MaxStreamID, x.count, cons)
+				}
+				// The history of the consumer, deleted entries come without
+				// fields
+				entries := make([]resp.Value, len(pending))
+				for j, p := range pending {
+					if e, ok := s.Get(p.ID); ok {
+						entries[j] = streamEntryValue(e)
+					} else {
+						entries[j] = resp.ArrayValue(resp.BulkValue(p.ID.String()), resp.NullArrayValue())
+					}
+				}
+				items = append(items, resp.BulkValue(key), resp.ArrayValue(entries...))
+			}
+			return nil
+		})
+		if err != nil {
+			return blockedResult{reply: errorReply(err)}, true
+		}
+		if items == nil {
+			return blockedResult{}, false
+		}
+		return blockedResult{reply: streamsReply(cl, items), propagate: propagate}, true
+	}
+	if x.blocking && !history {
+		return c.block(ctx, cl, x.keys, x.timeout, serve)
+	}
+	res, ok := serve()
+	if !ok {
+		cl.propagateAs = []string{}
+		return resp.NullArrayValue()
+	}
+	cl.propagateAs = propagate
+	return res.reply
+}
+
+// Handles "XACK key group id [id ...]"
+func (c *core) xackCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	ids := make([]storage.StreamID, len(args)-3)
+	for i, arg := range args[3:] {
+		id, errReply, ok := parseStreamID(arg, 0)
+		if !ok {
+			return errReply
+		}
+		ids[i] = id
+	}
+	var acked int
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		_, g, err := lookupGroup(tx, args[1], args[2])
+		if err != nil || g == nil {
+			return err
+		}
+		for _, id := range ids {
+			if g.Ack(id) {
+				acked++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if acked == 0 {
+		cl.propagateAs = []string{}
+	}
+	return resp.IntegerValue(int64(acked))
+}
+
+// Handles "XPENDING key group [[IDLE min-idle-time] start end count
+// [consumer]]". Without a range it replies with a summary of the pending
+// entries of the group.
+func (c *core) xpendingCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	key, group := args[1], args[2]
+	summary := len(args) == 3
+	var minIdle int64
+	var start, end storage.StreamID
+	count := 0
+	consumer := ""
+	if !summary {
+		i := 3
+		if strings.ToLower(args[i]) == "idle" && i+1 < len(args) {
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				return notInteger()
+			}
+			minIdle = n
+			i += 2
+		}
+		if len(args) < i+3 || len(args) > i+4 {
+			return syntaxError()
+		}
+		var errReply resp.Value
+		var ok bool
+		if start, errReply, ok = parseRangeID(args[i], true); !ok {
+			return errReply
+		}
+		if end, errReply, ok = parseRangeID(args[i+1], false); !ok {
+			return errReply
+		}
+		n, ok := parseInt(args[i+2])
+		if !ok {
+			return notInteger()
+		}
+		count = max(int(n), 0)
+		if len(args) == i+4 {
+			consumer = args[i+3]
+		}
+	}
+
+	var reply resp.Value
+	err := c.db(cl).View([]string{key}, func(tx *storage.Tx) error {
+		_, g, err := lookupGroup(tx, key, group)
+		if err != nil {
+			return err
+		}
+		if g == nil {
+			return asError(noGroup(key, group))
+		}
+		if summary {
+			reply = pendingSummary(g)
+			return nil
+		}
+		var cons *storage.Consumer
+		if consumer != "" {
+			if cons = g.Consumer(consumer); cons == nil {
+				reply = resp.ArrayValue()
+				return nil
+			}
+		}
+		now := tx.Now()
+		var items []resp.Value
+		for _, p := range g.PendingRange(start, end, 0, cons) {
+			if len(items) >= count {
+				break
+			}
+			idle := now - p.DeliveryTime
+			if idle < minIdle {
+				continue
+			}
+			items = append(items, resp.ArrayValue(
+				resp.BulkValue(p.ID.String()),
+				resp.BulkValue(p.Consumer.Name),
+				resp.IntegerValue(max(idle, 0)),
+				resp.IntegerValue(int64(p.DeliveryCount)),
+			))
+		}
+		reply = resp.ArrayValue(items...)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return reply
+}
+
+// The reply of XPENDING without a range: the number of pending entries,
+// the smallest and greatest pending IDs, and the number of entries pending
+// for each consumer.
+func pendingSummary(g *storage.ConsumerGroup) resp.Value {
+	pending := g.PendingRange(storage.MinStreamID, storage.MaxStreamID, 0, nil)
+	if len(pending) == 0 {
+		return resp.ArrayValue(resp.IntegerValue(0), resp.NullBulkValue(), resp.NullBulkValue(), resp.NullArrayValue())
+	}
+	var consumers []resp.Value
+	for _, cons := range g.Consumers() {
+		if n := cons.PendingCount(); n > 0 {
+			consumers = append(consumers, resp.ArrayValue(resp.BulkValue(cons.Name), resp.BulkValue(strconv.Itoa(n))))
+		}
+	}
+	return resp.ArrayValue(
+		resp.IntegerValue(int64(len(pending))),
+		resp.BulkValue(pending[0].ID.String()),
+		resp.BulkValue(pending[len(pending)-1].ID.String()),
+		resp.ArrayValue(consumers...),
+	)
+}
+
+// Handles "XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms]
+// [TIME unix-time-milliseconds] [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID
+// lastid]"
+func (c *core) xclaimCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	key, group, consumer := args[1], args[2], args[3]
+	minIdle, ok := parseInt(args[4])
+	if !ok {
+		return resp.Errorf("Invalid min-idle-time argument for XCLAIM")
+	}
+	minIdle = max(minIdle, 0)
+	var ids []storage.StreamID
+	i := 5
+	for ; i < len(args); i++ {
+		id, ok := storage.ParseStreamID(args[i], 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	// Delivery time relative to now or absolute, -1 for now
+	var idle, deliveryTime int64 = -1, -1
+	var retryCount int64 = -1
+	var force, justID bool
+	var lastID storage.StreamID
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		switch {
+		case opt == "force":
+			force = true
+		case opt == "justid":
+			justID = true
+		case (opt == "idle" || opt == "time" || opt == "retrycount") && i+1 < len(args):
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				return resp.Errorf("Invalid %s option argument for XCLAIM", strings.ToUpper(opt))
+			}
+			switch opt {
+			case "idle":
+				idle = n
+			case "time":
+				deliveryTime = n
+			default:
+				retryCount = n
+			}
+			i++
+		case opt == "lastid" && i+1 < len(args):
+			id, errReply, ok := parseStreamID(args[i+1], 0)
+			if !ok {
+				return errReply
+			}
+			lastID = id
+			i++
+		default:
+			return resp.Errorf("Unrecognized XCLAIM option '%s'", args[i])
+		}
+	}
+
+	var items []resp.Value
+	var propagated [][]string
+	err := c.db(cl).Update([]string{key}, func(tx *storage.Tx) error {
+		s, g, err := lookupGroup(tx, key, group)
+		if err != nil {
+			return err
+		}
+		if g == nil {
+			return asError(noGroup(key, group))
+		}
+		now := tx.Now()
+		delivered := now
+		switch {
+		case idle >= 0:
+			delivered = now - idle
+		case deliveryTime >= 0:
+			delivered = deliveryTime
+		}
+		if delivered < 0 || delivered > now {
+			delivered = now
+		}
+		movedLastID := g.LastID.Less(lastID)
+		if movedLastID {
+			g.LastID = lastID
+		}
+		cons, created := g.CreateConsumer(consumer, now)
+		cons.SeenTime = now
+		for _, id := range ids {
+			e, exists := s.Get(id)
+			p := g.Pending(id)
+			switch {
+			case p == nil && force && exists:
+				p = g.AddPending(id, cons, now)
+				p.DeliveryCount = 0
+			case p == nil:
+				continue
+			case !exists:
+				// Deleted from the stream, no one can process it anymore
+				g.Ack(id)
+				propagated = append(propagated, []string{"xack", key, group, id.String()})
+				continue
+			case minIdle > 0 && now-p.DeliveryTime < minIdle:
+				continue
+			}
+			g.Claim(p, cons)
+			p.DeliveryTime = delivered
+			if retryCount >= 0 {
+				p.DeliveryCount = uint64(retryCount)
+			} else if !justID {
+				p.DeliveryCount++
+			}
+			cons.ActiveTime = now
+			propagated = append(propagated, xclaimPropagation(key, group, consumer, p, g.LastID))
+			if justID {
+				items = append(items, resp.BulkValue(id.String()))
+			} else {
+				items = append(items, streamEntryValue(e))
+			}
+		}
+		propagated = append(propagated, claimSideEffects(key, g, consumer, len(items) > 0, created, movedLastID)...)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	cl.propagateAs = []string{}
+	cl.alsoPropagate = propagated
+	return resp.ArrayValue(items...)
+}
+
+// The XCLAIM replicas execute for an entry claimed by master. It names
+// the state master left the pending entry in, so the outcome doesn't
+// depend on the clock of the replica.
+func xclaimPropagation(key, group, consumer string, p *storage.PendingEntry, lastID storage.StreamID) []string {
+	return []string{
+		"xclaim", key, group, consumer, "0", p.ID.String(),
+		"time", strconv.FormatInt(p.DeliveryTime, 10),
+		"retrycount", strconv.FormatUint(p.DeliveryCount, 10),
+		"force", "justid", "lastid", lastID.String(),
+	}
+}
+
+// What replicas need when no XCLAIM carries it: the creation of the
+// consumer and a last delivered ID that was moved
+func claimSideEffects(key string, g *storage.ConsumerGroup, consumer string, claimed, created, movedLastID bool) [][]string {
+	if claimed {
+		return nil
+	}
+	var res [][]string
+	if created {
+		res = append(res, []string{"xgroup", "createconsumer", key, g.Name, consumer})
+	}
+	if movedLastID {
+		res = append(res, []string{"xgroup", "setid", key, g.Name, g.LastID.String(), "entriesread", strconv.FormatInt(g.EntriesRead, 10)})
+	}
+	return res
+}
+
+// Handles "XAUTOCLAIM key group consumer min-idle-time start [COUNT count]
+// [JUSTID]". It claims entries idle for long enough starting at start and
+// replies with the ID to continue at, 0-0 once done, the claimed entries
+// and the IDs of pending entries that were deleted from the stream.
+func (c *core) xautoclaimCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	key, group, consumer := args[1], args[2], args[3]
+	minIdle, ok := parseInt(args[4])
+	if !ok {
+		return resp.Errorf("Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	minIdle = max(minIdle, 0)
+	start, errReply, ok := parseRangeID(args[5], true)
+	if !ok {
+		return errReply
+	}
+	count := xautoclaimDefaultCount
+	justID := false
+	for i := 6; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "justid":
+			justID = true
+		case opt == "count" && i+1 < len(args):
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				return notInteger()
+			}
+			if n < 1 || n > 1<<40 {
+				return resp.Errorf("COUNT must be > 0")
+			}
+			count = int(n)
+			i++
+		default:
+			return syntaxError()
+		}
+	}
+
+	var reply resp.Value
+	var propagated [][]string
+	err := c.db(cl).Update([]string{key}, func(tx *storage.Tx) error {
+		s, g, err := lookupGroup(tx, key, group)
+		if err != nil {
+			return err
+		}
+		if g == nil {
+			return asError(noGroup(key, group))
+		}
+		now := tx.Now()
+		cons, created := g.CreateConsumer(consumer, now)
+		cons.SeenTime = now
+		attempts := count * xautoclaimAttemptsFactor
+		pending := g.PendingRange(start, storage.MaxStreamID, attempts+1, nil)
+		next := storage.MinStreamID
+		var claimed, deleted []resp.Value
+		for i, p := range pending {
+			if attempts == 0 || len(claimed) == count {
+				next = pending[i].ID
+				break
+			}
+			attempts--
+			e, exists := s.Get(p.ID)
+			if !exists {
+				g.Ack(p.ID)
+				propagated = append(propagated, []string{"xack", key, group, p.ID.String()})
+				deleted = append(deleted, resp.BulkValue(p.ID.String()))
+				continue
+			}
+			if minIdle > 0 && now-p.DeliveryTime < minIdle {
+				continue
+			}
+			g.Claim(p, cons)
+			p.DeliveryTime = now
+			if !justID {
+				p.DeliveryCount++
+			}
+			cons.ActiveTime = now
+			propagated = append(propagated, xclaimPropagation(key, group, consumer, p, g.LastID))
+			if justID {
+				claimed = append(claimed, resp.BulkValue(p.ID.String()))
+			} else {
+				claimed = append(claimed, streamEntryValue(e))
+			}
+		}
+		propagated = append(propagated, claimSideEffects(key, g, consumer, len(claimed) > 0, created, false)...)
+		reply = resp.ArrayValue(resp.BulkValue(next.String()), resp.ArrayValue(claimed...), resp.ArrayValue(deleted...))
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	cl.propagateAs = []string{}
+	cl.alsoPropagate = propagated
+	return reply
+}
+
+// An entry of XINFO STREAM, or nil
+func optionalEntryValue(e storage.StreamEntry, ok bool) resp.Value {
+	if !ok {
+		return resp.NullBulkValue()
+	}
+	return streamEntryValue(e)
+}
+
+// The entries read counter and lag of a group for XINFO, nil when unknown
+func groupCounters(s *storage.Stream, g *storage.ConsumerGroup) (entriesRead, lag resp.Value) {
+	entriesRead, lag = resp.NullBulkValue(), resp.NullBulkValue()
+	if g.EntriesRead != storage.InvalidEntriesRead {
+		entriesRead = resp.IntegerValue(g.EntriesRead)
+	}
+	if n, ok := s.Lag(g); ok {
+		lag = resp.IntegerValue(n)
+	}
+	return entriesRead, lag
+}
+
+// Handles the XINFO subcommands:
+//
+//	XINFO STREAM key [FULL [COUNT count]]
+//	XINFO GROUPS key
+//	XINFO CONSUMERS key group
+func (c *core) xinfoCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	sub := strings.ToLower(args[1])
+	if sub == "help" {
+		return resp.ArrayValue(
+			resp.SimpleStringValue("XINFO <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"),
+			resp.SimpleStringValue("CONSUMERS <key> <groupname>"),
+			resp.SimpleStringValue("    Show consumers of <groupname>."),
+			resp.SimpleStringValue("GROUPS <key>"),
+			resp.SimpleStringValue("    Show the stream consumer groups."),
+			resp.SimpleStringValue("STREAM <key> [FULL [COUNT <count>]"),
+			resp.SimpleStringValue("    Show information about the stream."),
+		)
+	}
+	full, count := false, 10
+	switch sub {
+	case "stream":
+		switch {
+		case len(args) == 3:
+		case len(args) == 4 && strings.ToLower(args[3]) == "full":
+			full = true
+		case len(args) == 6 && strings.ToLower(args[3]) == "full" && strings.ToLower(args[4]) == "count":
+			n, ok := parseInt(args[5])
+			if !ok {
+				return notInteger()
+			}
+			full, count = true, max(int(n), 0)
+		default:
+			return syntaxError()
+		}
+	case "groups":
+		if len(args) != 3 {
+			return wrongSubcommandArity("xinfo", sub)
+		}
+	case "consumers":
+		if len(args) != 4 {
+			return wrongSubcommandArity("xinfo", sub)
+		}
+	default:
+		return resp.Errorf("unknown subcommand '%s'. Try XINFO HELP.", truncate(args[1], 128))
+	}
+
+	key := args[2]
+	var reply resp.Value
+	err := c.db(cl).View([]string{key}, func(tx *storage.Tx) error {
+		s, err := tx.Stream(key, false)
+		if err != nil {
+			return err
+		}
+		if s == nil {
+			return asError(resp.Errorf("no such key"))
+		}
+		now := tx.Now()
+		switch sub {
+		case "stream":
+			reply = streamInfo(s, full, count)
+		case "groups":
+			var groups []resp.Value
+			for _, g := range s.Groups() {
+				entriesRead, lag := groupCounters(s, g)
+				groups = append(groups, resp.MapValue(
+					resp.BulkValue("name"), resp.BulkValue(g.Name),
+					resp.BulkValue("consumers"), resp.IntegerValue(int64(len(g.Consumers()))),
+					resp.BulkValue("pending"), resp.IntegerValue(int64(g.PendingLen())),
+					resp.BulkValue("last-delivered-id"), resp.BulkValue(g.LastID.String()),
+					resp.BulkValue("entries-read"), entriesRead,
+					resp.BulkValue("lag"), lag,
+				))
+			}
+			reply = resp.ArrayValue(groups...)
+		case "consumers":
+			g := s.Group(args[3])
+			if g == nil {
+				return asError(noGroupForKey(key, args[3]))
+			}
+			var consumers []resp.Value
+			for _, cons := range g.Consumers() {
+				inactive := int64(-1)
+				if cons.ActiveTime >= 0 {
+					inactive = max(now-cons.ActiveTime, 0)
+				}
+				consumers = append(consumers, resp.MapValue(
+					resp.BulkValue("name"), resp.BulkValue(cons.Name),
+					resp.BulkValue("pending"), resp.IntegerValue(int64(cons.PendingCount())),
+					resp.BulkValue("idle"), resp.IntegerValue(max(now-cons.SeenTime, 0)),
+					resp.BulkValue("inactive"), resp.IntegerValue(inactive),
+				))
+			}
+			reply = resp.ArrayValue(consumers...)
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return reply
+}
+
+// The reply of XINFO STREAM. The full form lists up to count entries and
+// pending entries, all of them when count is 0.
+func streamInfo(s *storage.Stream, full bool, count int) resp.Value {
+	recordedFirst := storage.MinStreamID
+	first, hasFirst := s.First()
+	if hasFirst {
+		recordedFirst = first.ID
+	}
+	// There is no radix tree: every node of entries counts as one key
+	// and one tree node
+	items := []resp.Value{
+		resp.BulkValue("length"), resp.IntegerValue(int64(s.Len())),
+		resp.BulkValue("radix-tree-keys"), resp.IntegerValue(int64(s.Nodes())),
+		resp.BulkValue("radix-tree-nodes"), resp.IntegerValue(int64(s.Nodes())),
+		resp.BulkValue("last-generated-id"), resp.BulkValue(s.LastID.String()),
+		resp.BulkValue("max-deleted-entry-id"), resp.BulkValue(s.MaxDeletedID.String()),
+		resp.BulkValue("entries-added"), resp.IntegerValue(int64(s.EntriesAdded)),
+		resp.BulkValue("recorded-first-entry-id"), resp.BulkValue(recordedFirst.String()),
+	}
+	if !full {
+		last, hasLast := s.Last()
+		items = append(items,
+			resp.BulkValue("groups"), resp.IntegerValue(int64(len(s.Groups()))),
+			resp.BulkValue("first-entry"), optionalEntryValue(first, hasFirst),
+			resp.BulkValue("last-entry"), optionalEntryValue(last, hasLast),
+		)
+		return resp.MapValue(items...)
+	}
+
+	entries := s.Range(storage.MinStreamID, storage.MaxStreamID, false, count)
+	var groups []resp.Value
+	for _, g := range s.Groups() {
+		entriesRead, lag := groupCounters(s, g)
+		pending := g.PendingRange(storage.MinStreamID, storage.MaxStreamID, count, nil)
+		pel := make([]resp.Value, len(pending))
+		for i, p := range pending {
+			pel[i] = resp.ArrayValue(
+				resp.BulkValue(p.ID.String()),
+				resp.BulkValue(p.Consumer.Name),
+				resp.IntegerValue(p.DeliveryTime),
+				resp.IntegerValue(int64(p.DeliveryCount)),
+			)
+		}
+		var consumers []resp.Value
+		for _, cons := range g.Consumers() {
+			pending := g.PendingRange(storage.MinStreamID, storage.MaxStreamID, count, cons)
+			pel := make([]resp.Value, len(pending))
+			for i, p := range pending {
+				pel[i] = resp.ArrayValue(
+					resp.BulkValue(p.ID.String()),
+					resp.IntegerValue(p.DeliveryTime),
+					resp.IntegerValue(int64(p.DeliveryCount)),
+				)
+			}
+			consumers = append(consumers, resp.MapValue(
+				resp.BulkValue("name"), resp.BulkValue(cons.Name),
+				resp.BulkValue("seen-time"), resp.IntegerValue(cons.SeenTime),
+				resp.BulkValue("active-time"), resp.IntegerValue(cons.ActiveTime),
+				resp.BulkValue("pel-count"), resp.IntegerValue(int64(cons.PendingCount())),
+				resp.BulkValue("pending"), resp.ArrayValue(pel...),
+			))
+		}
+		groups = append(groups, resp.MapValue(
+			resp.BulkValue("name"), resp.BulkValue(g.Name),
+			resp.BulkValue("last-delivered-id"), resp.BulkValue(g.LastID.String()),
+			resp.BulkValue("entries-read"), entriesRead,
+			resp.BulkValue("lag"), lag,
+			resp.BulkValue("pel-count"), resp.IntegerValue(int64(g.PendingLen())),
+			resp.BulkValue("pending"), resp.ArrayValue(pel...),
+			resp.BulkValue("consumers"), resp.ArrayValue(consumers...),
+		))
+	}
+	items = append(items,
+		resp.BulkValue("entries"), streamEntriesValue(entries),
+		resp.BulkValue("groups"), resp.ArrayValue(groups...),
+	)
+	return resp.MapValue(items...)
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+func TestStreamGroupCommands(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"xgroup", "create", "s", "g", "$"}, "-ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.\r\n"},
+		{[]string{"xgroup", "create", "s", "g", "$", "mkstream"}, "+OK\r\n"},
+		{[]string{"xgroup", "create", "s", "g", "0"}, "-BUSYGROUP Consumer Group name already exists\r\n"},
+		{[]string{"xgroup", "createconsumer", "s", "other", "c"}, "-NOGROUP No such consumer group 'other' for key name 's'\r\n"},
+		{[]string{"xadd", "s", "1-0", "a", "1"}, "$3\r\n1-0\r\n"},
+		{[]string{"xadd", "s", "2-0", "b", "2"}, "$3\r\n2-0\r\n"},
+		{[]string{"xadd", "s", "3-0", "c", "3"}, "$3\r\n3-0\r\n"},
+		{[]string{"xreadgroup", "group", "g", "alice", "count", "1", "streams", "s", ">"}, "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{[]string{"xreadgroup", "group", "g", "bob", "streams", "s", ">"}, "*1\r\n*2\r\n$1\r\ns\r\n*2\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n"},
+		{[]string{"xreadgroup", "group", "g", "bob", "streams", "s", ">"}, "*-1\r\n"},
+		{[]string{"xreadgroup", "group", "g", "alice", "streams", "s", "0"}, "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{[]string{"xreadgroup", "group", "g", "alice", "streams", "s", "$"}, "-ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.\r\n"},
+		{[]string{"xreadgroup", "group", "nope", "alice", "streams", "s", ">"}, "-NOGROUP No such key 's' or consumer group 'nope' in XREADGROUP with GROUP option\r\n"},
+		{[]string{"xread", "streams", "s", ">"}, "-ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.\r\n"},
+		{[]string{"xpending", "s", "g"}, "*4\r\n:3\r\n$3\r\n1-0\r\n$3\r\n3-0\r\n*2\r\n*2\r\n$5\r\nalice\r\n$1\r\n1\r\n*2\r\n$3\r\nbob\r\n$1\r\n2\r\n"},
+		{[]string{"xpending", "s", "g", "idle", "100000", "-", "+", "10"}, "*0\r\n"},
+		{[]string{"xpending", "s", "nope"}, "-NOGROUP No such key 's' or consumer group 'nope'\r\n"},
+		{[]string{"xack", "s", "g", "1-0", "9-0"}, ":1\r\n"},
+		{[]string{"xdel", "s", "3-0"}, ":1\r\n"},
+		{[]string{"xclaim", "s", "g", "alice", "0", "2-0", "3-0", "justid"}, "*1\r\n$3\r\n2-0\r\n"},
+		{[]string{"xpending", "s", "g", "-", "+", "10", "bob"}, "*0\r\n"},
+		{[]string{"xclaim", "s", "g", "alice", "0", "2-0", "retrycount", "7", "idle", "100000"}, "*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{[]string{"xclaim", "s", "g", "alice", "0", "2-0", "bogus"}, "-ERR Unrecognized XCLAIM option 'bogus'\r\n"},
+		{[]string{"xautoclaim", "s", "g", "carol", "50000", "0", "count", "1"}, "*3\r\n$3\r\n0-0\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n*0\r\n"},
+		{[]string{"xautoclaim", "s", "g", "carol", "0", "0", "count", "0"}, "-ERR COUNT must be > 0\r\n"},
+		{[]string{"xgroup", "delconsumer", "s", "g", "carol"}, ":1\r\n"},
+		{[]string{"xgroup", "createconsumer", "s", "g", "dave"}, ":1\r\n"},
+		{[]string{"xgroup", "createconsumer", "s", "g", "dave"}, ":0\r\n"},
+		{[]string{"xgroup", "setid", "s", "g", "0", "entriesread", "-2"}, "-ERR value for ENTRIESREAD must be positive or -1\r\n"},
+		{[]string{"xgroup", "setid", "s", "g", "1-0"}, "+OK\r\n"},
+		{[]string{"xreadgroup", "group", "g", "dave", "noack", "streams", "s", ">"}, "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{[]string{"xinfo", "groups", "missing"}, "-ERR no such key\r\n"},
+		{[]string{"xinfo", "consumers", "s", "nope"}, "-NOGROUP No such consumer group 'nope' for key name 's'\r\n"},
+		{[]string{"xgroup", "destroy", "s", "g"}, ":1\r\n"},
+		{[]string{"xgroup", "destroy", "s", "g"}, ":0\r\n"},
+		{[]string{"xgroup", "bogus", "s"}, "-ERR unknown subcommand 'bogus'. Try XGROUP HELP.\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+}
+
+func TestXClaimDeliveryTime(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	do(ms, cl, "xadd", "s", "1-0", "a", "1")
+	do(ms, cl, "xgroup", "create", "s", "g", "0")
+	do(ms, cl, "xreadgroup", "group", "g", "bob", "streams", "s", ">")
+	do(ms, cl, "xclaim", "s", "g", "alice", "0", "1-0", "retrycount", "7", "idle", "100000")
+	// The idle time grows while the test runs
+	got := do(ms, cl, "xpending", "s", "g", "idle", "100000", "-", "+", "10")
+	prefix, suffix := "*1\r\n*4\r\n$3\r\n1-0\r\n$5\r\nalice\r\n:100", ":7\r\n"
+	if !strings.HasPrefix(got, prefix) || !strings.HasSuffix(got, suffix) {
+		t.Errorf("Unexpected pending entry. Expected: %q...%q, Got: %q", prefix, suffix, got)
+	}
+	if got := do(ms, cl, "xpending", "s", "g", "idle", "200000", "-", "+", "10"); got != "*0\r\n" {
+		t.Errorf("Unexpected entries idle for too short. Got: %q", got)
+	}
+}
+
+func TestStreamInfo(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	do(ms, cl, "xadd", "s", "1-0", "a", "1")
+	do(ms, cl, "xadd", "s", "2-0", "b", "2")
+	do(ms, cl, "xgroup", "create", "s", "g", "0")
+	do(ms, cl, "xreadgroup", "group", "g", "c", "count", "1", "streams", "s", ">")
+	cases := []struct {
+		args     []string
+		contains []string
+	}{
+		{[]string{"xinfo", "stream", "s"}, []string{
+			"$6\r\nlength\r\n:2\r\n",
+			"$17\r\nlast-generated-id\r\n$3\r\n2-0\r\n",
+			"$13\r\nentries-added\r\n:2\r\n",
+			"$6\r\ngroups\r\n:1\r\n",
+			"$11\r\nfirst-entry\r\n*2\r\n$3\r\n1-0\r\n",
+		}},
+		{[]string{"xinfo", "groups", "s"}, []string{
+			"$4\r\nname\r\n$1\r\ng\r\n",
+			"$7\r\npending\r\n:1\r\n",
+			"$12\r\nentries-read\r\n:1\r\n",
+			"$3\r\nlag\r\n:1\r\n",
+		}},
+		{[]string{"xinfo", "consumers", "s", "g"}, []string{
+			"$4\r\nname\r\n$1\r\nc\r\n",
+			"$7\r\npending\r\n:1\r\n",
+		}},
+		{[]string{"xinfo", "stream", "s", "full"}, []string{
+			"$7\r\nentries\r\n*2\r\n",
+			"$9\r\npel-count\r\n:1\r\n",
+			"$7\r\npending\r\n*1\r\n*4\r\n$3\r\n1-0\r\n$1\r\nc\r\n",
+		}},
+	}
+	for _, c := range cases {
+		got := do(ms, cl, c.args...)
+		for _, s := range c.contains {
+			if !strings.Contains(got, s) {
+				t.Errorf("%q: reply misses %q. Got: %q", c.args, s, got)
+			}
+		}
+	}
+}
+
+func TestBlockingXReadGroup(t *testing.T) {
+	ms := newTestMaster()
+	do(ms, newTestClient(), "xgroup", "create", "s", "g", "$", "mkstream")
+	first := doAsync(context.Background(), ms, "xreadgroup", "group", "g", "a", "block", "0", "streams", "s", ">")
+	waitBlocked(t, ms, "s", 1)
+	second := doAsync(context.Background(), ms, "xreadgroup", "group", "g", "b", "block", "0", "streams", "s", ">")
+	waitBlocked(t, ms, "s", 2)
+
+	do(ms, newTestClient(), "xadd", "s", "1-0", "f", "v")
+	if got := <-first; got != "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n" {
+		t.Errorf("Unexpected reply to the first client. Got: %q", got)
+	}
+	// The entry went to the first consumer, the second one keeps waiting
+	// until the group is destroyed
+	waitBlocked(t, ms, "s", 1)
+	do(ms, newTestClient(), "xgroup", "destroy", "s", "g")
+	if got := <-second; got != "-NOGROUP No such key 's' or consumer group 'g' in XREADGROUP with GROUP option\r\n" {
+		t.Errorf("Unexpected reply to the second client. Got: %q", got)
+	}
+}
+
+// Describes the pending entries of group g of stream s in db, with the
+// delivery time of the ones owned by consumer timed
+func pendingState(t *testing.T, db *storage.KeyValue, timed string) []string {
+	t.Helper()
+	var res []string
+	db.View(nil, func(tx *storage.Tx) error {
+		s, _ := tx.Stream("s", false)
+		g := s.Group("g")
+		res = append(res, "last "+g.LastID.String())
+		for _, c := range g.Consumers() {
+			res = append(res, "consumer "+c.Name)
+		}
+		for _, p := range g.PendingRange(storage.MinStreamID, storage.MaxStreamID, 0, nil) {
+			desc := fmt.Sprintf("%s %s %d", p.ID, p.Consumer.Name, p.DeliveryCount)
+			if p.Consumer.Name == timed {
+				desc += fmt.Sprintf(" at %d", p.DeliveryTime)
+			}
+			res = append(res, desc)
+		}
+		return nil
+	})
+	return res
+}
+
+func TestXClaim_Replicates(t *testing.T) {
+	ms := newTestMaster()
+	propagated := recordPropagated(ms)
+	cl := newTestClient()
+	for _, id := range []string{"1-0", "2-0", "3-0", "4-0"} {
+		do(ms, cl, "xadd", "s", id, "a", "1")
+	}
+	do(ms, cl, "xgroup", "create", "s", "g", "0")
+	do(ms, cl, "xreadgroup", "group", "g", "bob", "streams", "s", ">")
+	do(ms, cl, "xdel", "s", "3-0")
+	time.Sleep(30 * time.Millisecond)
+	do(ms, cl, "xadd", "s", "5-0", "a", "1")
+	do(ms, cl, "xreadgroup", "group", "g", "carol", "streams", "s", ">")
+	// Only entries idle for long enough on master are claimed
+	if got := do(ms, cl, "xclaim", "s", "g", "alice", "20", "1-0", "5-0", "justid"); got != "*1\r\n$3\r\n1-0\r\n" {
+		t.Errorf("Unexpected reply to XCLAIM: %q", got)
+	}
+	do(ms, cl, "xclaim", "s", "g", "dave", "20", "3-0")
+	if got := do(ms, cl, "xautoclaim", "s", "g", "alice", "20", "0", "justid"); got != "*3\r\n$3\r\n0-0\r\n*2\r\n$3\r\n2-0\r\n$3\r\n4-0\r\n*0\r\n" {
+		t.Errorf("Unexpected reply to XAUTOCLAIM: %q", got)
+	}
+	do(ms, cl, "xclaim", "s", "g", "erin", "0", "9-0", "lastid", "9-0")
+
+	// The replica applies them long after, with its own clock
+	time.Sleep(30 * time.Millisecond)
+	cfg := NewConfig(6380, slog.Default(), storage.NewDatabases(16), Replica{MasterHost: "localhost", MasterPort: "6379"}, Persistence{})
+	s := NewSlaveServer(cfg)
+	master := newTestClient()
+	master.fromMaster = true
+	for _, args := range *propagated {
+		if got := s.dispatch(context.Background(), master, args).String(); strings.HasPrefix(got, "-") {
+			t.Errorf("%q: unexpected error on the replica: %q", args, got)
+		}
+	}
+	expected := pendingState(t, ms.dbs[0], "alice")
+	if got := pendingState(t, s.dbs[0], "alice"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("The replica diverged.\nMaster: %q\nReplica: %q", expected, got)
+	}
+}
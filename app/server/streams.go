@@ -0,0 +1,591 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// Default LIMIT of approximate trimming: 100 times stream-node-max-entries,
+// like Redis
+const streamTrimDefaultLimit = 100 * 100
+
+func (c *core) registerStreamCommands() {
+	c.commands.Register(Command{Name: "xadd", Arity: -5, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.xaddCommand})
+	c.commands.Register(Command{Name: "xrange", Arity: -4, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.xrangeCommand})
+	c.commands.Register(Command{Name: "xrevrange", Arity: -4, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.xrangeCommand})
+	c.commands.Register(Command{Name: "xlen", Arity: 2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.xlenCommand})
+	c.commands.Register(Command{Name: "xdel", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.xdelCommand})
+	c.commands.Register(Command{Name: "xtrim", Arity: -4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.xtrimCommand})
+	c.commands.Register(Command{Name: "xread", Arity: -4, Flags: FlagReadonly, Handler: c.xreadCommand})
+}
+
+func invalidStreamID() resp.Value {
+	return resp.Errorf("Invalid stream ID specified as stream command argument")
+}
+
+// Parses an ID given as "ms-seq", or "ms" alone which takes seq as the
+// sequence number.
+func parseStreamID(s string, seq uint64) (storage.StreamID, resp.Value, bool) {
+	id, ok := storage.ParseStreamID(s, seq)
+	if !ok {
+		return id, invalidStreamID(), false
+	}
+	return id, resp.Value{}, true
+}
+
+// Parses a bound of an XRANGE interval: "-", "+", an ID or an ID prefixed
+// with '(' to exclude it. A start ID given as "ms" alone starts at ms-0, an
+// end ID ends at the greatest sequence number of ms.
+func parseRangeID(s string, start bool) (storage.StreamID, resp.Value, bool) {
+	switch s {
+	case "-":
+		return storage.MinStreamID, resp.Value{}, true
+	case "+":
+		return storage.MaxStreamID, resp.Value{}, true
+	}
+	seq := storage.MaxStreamID.Seq
+	if start {
+		seq = 0
+	}
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	id, errReply, ok := parseStreamID(s, seq)
+	if !ok || !exclusive {
+		return id, errReply, ok
+	}
+	if start {
+		if id, ok = id.Next(); !ok {
+			return id, resp.Errorf("invalid start ID for the interval"), false
+		}
+	} else if id, ok = id.Prev(); !ok {
+		return id, resp.Errorf("invalid end ID for the interval"), false
+	}
+	return id, resp.Value{}, true
+}
+
+func streamEntryValue(e storage.StreamEntry) resp.Value {
+	return resp.ArrayValue(resp.BulkValue(e.ID.String()), resp.StringsValue(e.Fields))
+}
+
+func streamEntriesValue(entries []storage.StreamEntry) resp.Value {
+	items := make([]resp.Value, len(entries))
+	for i, e := range entries {
+		items[i] = streamEntryValue(e)
+	}
+	return resp.ArrayValue(items...)
+}
+
+// The trimming options of XADD and XTRIM:
+//
+//	MAXLEN | MINID [= | ~] threshold [LIMIT count]
+type streamTrim struct {
+	// "maxlen" or "minid", empty without trimming
+	strategy string
+	approx   bool
+	maxlen   int
+	minid    storage.StreamID
+	// -1 when not given
+	limit int
+}
+
+// Parses the trimming option at args[i], if there is one, and returns the
+// number of arguments it took.
+func (t *streamTrim) parse(args []string, i int) (int, resp.Value, bool) {
+	opt := strings.ToLower(args[i])
+	switch {
+	case opt == "limit" && i+1 < len(args):
+		n, ok := parseInt(args[i+1])
+		if !ok {
+			return 0, notInteger(), false
+		}
+		if n < 0 {
+			return 0, resp.Errorf("The LIMIT argument must be >= 0."), false
+		}
+		t.limit = int(n)
+		return 2, resp.Value{}, true
+	case opt != "maxlen" && opt != "minid":
+		return 0, resp.Value{}, true
+	case t.strategy != "" && t.strategy != opt:
+		return 0, resp.Errorf("syntax error, MAXLEN and MINID options at the same time are not compatible"), false
+	}
+	t.strategy = opt
+	n := 1
+	if i+1 < len(args) && (args[i+1] == "=" || args[i+1] == "~") {
+		t.approx = args[i+1] == "~"
+		n++
+	}
+	if i+n >= len(args) {
+		return 0, syntaxError(), false
+	}
+	threshold := args[i+n]
+	if opt == "minid" {
+		id, errReply, ok := parseStreamID(threshold, 0)
+		if !ok {
+			return 0, errReply, false
+		}
+		t.minid = id
+	} else {
+		maxlen, ok := parseInt(threshold)
+		if !ok {
+			return 0, notInteger(), false
+		}
+		if maxlen < 0 {
+			return 0, resp.Errorf("The MAXLEN argument must be >= 0."), false
+		}
+		t.maxlen = int(maxlen)
+	}
+	return n + 1, resp.Value{}, true
+}
+
+// Checks the options once all of them were parsed
+func (t *streamTrim) validate() (resp.Value, bool) {
+	if t.limit >= 0 && !t.approx {
+		return resp.Errorf("syntax error, LIMIT cannot be used without the special ~ option"), false
+	}
+	if t.approx && t.limit < 0 {
+		t.limit = streamTrimDefaultLimit
+	}
+	return resp.Value{}, true
+}
+
+// Trims s and returns the number of removed entries
+func (t *streamTrim) apply(s *storage.Stream) int {
+	switch t.strategy {
+	case "maxlen":
+		return s.TrimMaxLen(t.maxlen, t.approx, t.limit)
+	case "minid":
+		return s.TrimMinID(t.minid, t.approx, t.limit)
+	}
+	return 0
+}
+
+// Returns the options as replicas should apply them. Approximate trimming
+// depends on how entries are laid out in nodes, so replicas get the exact
+// trimming that results in the same stream.
+func (t *streamTrim) args(s *storage.Stream) []string {
+	switch {
+	case t.strategy == "":
+		return nil
+	case !t.approx && t.strategy == "maxlen":
+		return []string{"maxlen", strconv.Itoa(t.maxlen)}
+	case !t.approx:
+		return []string{"minid", t.minid.String()}
+	case t.strategy == "maxlen":
+		return []string{"maxlen", "=", strconv.Itoa(s.Len())}
+	}
+	minid := t.minid
+	if first, ok := s.First(); ok {
+		minid = first.ID
+	}
+	return []string{"minid", "=", minid.String()}
+}
+
+// Handles "XADD key [NOMKSTREAM] [MAXLEN | MINID [= | ~] threshold [LIMIT
+// count]] * | id field value [field value ...]"
+func (c *core) xaddCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	key := args[1]
+	trim := streamTrim{limit: -1}
+	nomkstream := false
+	i := 2
+	for ; i < len(args); i++ {
+		if strings.ToLower(args[i]) == "nomkstream" {
+			nomkstream = true
+			continue
+		}
+		n, errReply, ok := trim.parse(args, i)
+		if !ok {
+			return errReply
+		}
+		if n == 0 {
+			break
+		}
+		i += n - 1
+	}
+	if errReply, ok := trim.validate(); !ok {
+		return errReply
+	}
+	fields := args[min(i+1, len(args)):]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return wrongArity(args[0])
+	}
+
+	// The ID is "*", "ms-*" or an explicit ID
+	idArg := args[i]
+	var explicit storage.StreamID
+	var ms uint64
+	auto, autoSeq := idArg == "*", false
+	if !auto {
+		msPart, found := strings.CutSuffix(idArg, "-*")
+		var err error
+		if ms, err = strconv.ParseUint(msPart, 10, 64); found && err == nil {
+			autoSeq = true
+		} else {
+			var errReply resp.Value
+			var ok bool
+			if explicit, errReply, ok = parseStreamID(idArg, 0); !ok {
+				return errReply
+			}
+			if explicit == storage.MinStreamID {
+				return resp.Errorf("The ID specified in XADD must be greater than 0-0")
+			}
+		}
+	}
+
+	var id storage.StreamID
+	var trimArgs []string
+	added := false
+	err := c.db(cl).Update([]string{key}, func(tx *storage.Tx) error {
+		s, err := tx.Stream(key, !nomkstream)
+		if err != nil || s == nil {
+			return err
+		}
+		switch {
+		case auto:
+			if id, err = s.NextID(uint64(tx.Now())); err != nil {
+				return err
+			}
+		case autoSeq:
+			id = storage.StreamID{Ms: ms}
+			if ms == s.LastID.Ms {
+				// Wraps to ms-0 and fails below once ms is exhausted
+				id.Seq = s.LastID.Seq + 1
+			}
+		default:
+			id = explicit
+		}
+		if !s.LastID.Less(id) {
+			return asError(resp.Errorf("The ID specified in XADD is equal or smaller than the target stream top item"))
+		}
+		s.Add(id, fields)
+		trim.apply(s)
+		trimArgs = trim.args(s)
+		added = true
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !added {
+		cl.propagateAs = []string{}
+		return resp.NullBulkValue()
+	}
+	propagate := []string{"xadd", key}
+	if nomkstream {
+		propagate = append(propagate, "nomkstream")
+	}
+	propagate = append(propagate, trimArgs...)
+	cl.propagateAs = append(append(propagate, id.String()), fields...)
+	c.signalKeyAsReady(cl, key)
+	return resp.BulkValue(id.String())
+}
+
+// Handles "XRANGE key start end [COUNT count]" and "XREVRANGE key end start
+// [COUNT count]"
+func (c *core) xrangeCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	reverse := strings.ToLower(args[0]) == "xrevrange"
+	startArg, endArg := args[2], args[3]
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+	start, errReply, ok := parseRangeID(startArg, true)
+	if !ok {
+		return errReply
+	}
+	end, errReply, ok := parseRangeID(endArg, false)
+	if !ok {
+		return errReply
+	}
+	count := -1
+	switch {
+	case len(args) == 6 && strings.ToLower(args[4]) == "count":
+		n, ok := parseInt(args[5])
+		if !ok {
+			return notInteger()
+		}
+		count = max(int(n), 0)
+	case len(args) != 4:
+		return syntaxError()
+	}
+	if count == 0 {
+		return resp.ArrayValue()
+	}
+
+	var entries []storage.StreamEntry
+	err := c.db(cl).View([]string{args[1]}, func(tx *storage.Tx) error {
+		s, err := tx.Stream(args[1], false)
+		if err != nil || s == nil {
+			return err
+		}
+		entries = s.Range(start, end, reverse, count)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return streamEntriesValue(entries)
+}
+
+func (c *core) xlenCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var n int
+	err := c.db(cl).View([]string{args[1]}, func(tx *storage.Tx) error {
+		s, err := tx.Stream(args[1], false)
+		if s != nil {
+			n = s.Len()
+		}
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.IntegerValue(int64(n))
+}
+
+// Handles "XDEL key id [id ...]"
+func (c *core) xdelCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	ids := make([]storage.StreamID, len(args)-2)
+	for i, arg := range args[2:] {
+		id, errReply, ok := parseStreamID(arg, 0)
+		if !ok {
+			return errReply
+		}
+		ids[i] = id
+	}
+	var deleted int
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		s, err := tx.Stream(args[1], false)
+		if err != nil || s == nil {
+			return err
+		}
+		for _, id := range ids {
+			if s.Delete(id) {
+				deleted++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if deleted == 0 {
+		cl.propagateAs = []string{}
+	}
+	return resp.IntegerValue(int64(deleted))
+}
+
+// Handles "XTRIM key MAXLEN | MINID [= | ~] threshold [LIMIT count]"
+func (c *core) xtrimCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	key := args[1]
+	trim := streamTrim{limit: -1}
+	for i := 2; i < len(args); {
+		n, errReply, ok := trim.parse(args, i)
+		if !ok {
+			return errReply
+		}
+		if n == 0 {
+			return syntaxError()
+		}
+		i += n
+	}
+	if trim.strategy == "" {
+		return syntaxError()
+	}
+	if errReply, ok := trim.validate(); !ok {
+		return errReply
+	}
+	var removed int
+	var trimArgs []string
+	err := c.db(cl).Update([]string{key}, func(tx *storage.Tx) error {
+		s, err := tx.Stream(key, false)
+		if err != nil || s == nil {
+			return err
+		}
+		removed = trim.apply(s)
+		trimArgs = trim.args(s)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if removed == 0 {
+		cl.propagateAs = []string{}
+	} else {
+		cl.propagateAs = append([]string{"xtrim", key}, trimArgs...)
+	}
+	return resp.IntegerValue(int64(removed))
+}
+
+// Replies with the entries read from several streams, given as key and
+// entries interleaved. RESP3 clients get a map.
+func streamsReply(cl *Client, items []resp.Value) resp.Value {
+	if cl.Protocol() == resp.RESP3 {
+		return resp.MapValue(items...)
+	}
+	pairs := make([]resp.Value, 0, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		pairs = append(pairs, resp.ArrayValue(items[i], items[i+1]))
+	}
+	return resp.ArrayValue(pairs...)
+}
+
+// Reads the entries of streams after the given IDs. ok is false when none of
+// the streams has new entries.
+func (c *core) readStreams(cl *Client, keys []string, after []storage.StreamID, count int) (reply resp.Value, ok bool, err error) {
+	var items []resp.Value
+	err = c.db(cl).View(keys, func(tx *storage.Tx) error {
+		for i, key := range keys {
+			s, err := tx.Stream(key, false)
+			if err != nil {
+				return err
+			}
+			if s == nil {
+				continue
+			}
+			start, ok := after[i].Next()
+			if !ok {
+				continue
+			}
+			entries := s.Range(start, storage.MaxStreamID, false, count)
+			if len(entries) > 0 {
+				items = append(items, resp.BulkValue(key), streamEntriesValue(entries))
+			}
+		}
+		return nil
+	})
+	if err != nil || items == nil {
+		return resp.Value{}, false, err
+	}
+	return streamsReply(cl, items), true, nil
+}
+
+// The options of XREAD, and of XREADGROUP after its GROUP option:
+//
+//	[COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+type xreadArgs struct {
+	// 0 reads all entries
+	count    int
+	blocking bool
+	timeout  time.Duration
+	noack    bool
+	keys     []string
+	ids      []string
+}
+
+// Parses the options starting at args[i]. NOACK is only accepted for
+// XREADGROUP.
+func parseXReadArgs(args []string, i int) (xreadArgs, resp.Value, bool) {
+	var x xreadArgs
+	name := strings.ToLower(args[0])
+	group := name == "xreadgroup"
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		if opt == "streams" {
+			break
+		}
+		if opt == "noack" && group {
+			x.noack = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return x, syntaxError(), false
+		}
+		switch opt {
+		case "count":
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				return x, notInteger(), false
+			}
+			x.count = max(int(n), 0)
+		case "block":
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				return x, resp.Errorf("timeout is not an integer or out of range"), false
+			}
+			if n < 0 {
+				return x, resp.Errorf("timeout is negative"), false
+			}
+			x.timeout = time.Duration(n) * time.Millisecond
+			x.blocking = true
+		default:
+			return x, syntaxError(), false
+		}
+		i++
+	}
+	rest := args[min(i+1, len(args)):]
+	if len(rest) == 0 {
+		return x, syntaxError(), false
+	}
+	if len(rest)%2 != 0 {
+		return x, resp.Errorf("Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified.", name), false
+	}
+	x.keys, x.ids = rest[:len(rest)/2], rest[len(rest)/2:]
+	for _, id := range x.ids {
+		switch {
+		case id == ">" && !group:
+			return x, resp.Errorf("The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option."), false
+		case id == "$" && group:
+			return x, resp.Errorf("The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set."), false
+		}
+	}
+	return x, resp.Value{}, true
+}
+
+// Handles "XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id
+// [id ...]"
+func (c *core) xreadCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	x, errReply, ok := parseXReadArgs(args, 1)
+	if !ok {
+		return errReply
+	}
+	after := make([]storage.StreamID, len(x.keys))
+	for i, arg := range x.ids {
+		if arg == "$" {
+			continue
+		}
+		id, errReply, ok := parseStreamID(arg, 0)
+		if !ok {
+			return errReply
+		}
+		after[i] = id
+	}
+	// "$" reads only entries added from now on
+	err := c.db(cl).View(x.keys, func(tx *storage.Tx) error {
+		for i, key := range x.keys {
+			if x.ids[i] != "$" {
+				continue
+			}
+			s, err := tx.Stream(key, false)
+			if err != nil {
+				return err
+			}
+			if s != nil {
+				after[i] = s.LastID
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	serve := func() (blockedResult, bool) {
+		reply, ok, err := c.readStreams(cl, x.keys, after, x.count)
+		if err != nil {
+			return blockedResult{reply: errorReply(err)}, true
+		}
+		return blockedResult{reply: reply}, ok
+	}
+	if x.blocking {
+		return c.block(ctx, cl, x.keys, x.timeout, serve)
+	}
+	if res, ok := serve(); ok {
+		return res.reply
+	}
+	return resp.NullArrayValue()
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStreamCommands(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"xadd", "s", "1-1", "a", "1"}, "$3\r\n1-1\r\n"},
+		{[]string{"xadd", "s", "1-*", "b", "2"}, "$3\r\n1-2\r\n"},
+		{[]string{"xadd", "s", "2", "c", "3"}, "$3\r\n2-0\r\n"},
+		{[]string{"xadd", "s", "2-0", "d", "4"}, "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{[]string{"xadd", "s", "1-*", "d", "4"}, "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{[]string{"xadd", "s", "0-0", "d", "4"}, "-ERR The ID specified in XADD must be greater than 0-0\r\n"},
+		{[]string{"xadd", "s", "x-1", "d", "4"}, "-ERR Invalid stream ID specified as stream command argument\r\n"},
+		{[]string{"xadd", "s", "3-0", "d"}, "-ERR wrong number of arguments for 'xadd' command\r\n"},
+		{[]string{"xadd", "new", "0-*", "a", "1"}, "$3\r\n0-1\r\n"},
+		{[]string{"xadd", "missing", "nomkstream", "*", "a", "1"}, "$-1\r\n"},
+		{[]string{"type", "missing"}, "+none\r\n"},
+		{[]string{"type", "s"}, "+stream\r\n"},
+		{[]string{"xlen", "s"}, ":3\r\n"},
+		{[]string{"xrange", "s", "-", "+", "count", "1"}, "*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{[]string{"xrange", "s", "(1-1", "1"}, "*1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{[]string{"xrevrange", "s", "+", "(1-2"}, "*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n"},
+		{[]string{"xrange", "s", "(18446744073709551615-18446744073709551615", "+"}, "-ERR invalid start ID for the interval\r\n"},
+		{[]string{"xrange", "s", "-", "+", "count", "0"}, "*0\r\n"},
+		{[]string{"xrange", "missing", "-", "+"}, "*0\r\n"},
+		{[]string{"xdel", "s", "1-1", "5-0"}, ":1\r\n"},
+		{[]string{"xadd", "s", "maxlen", "2", "limit", "5", "*", "e", "5"}, "-ERR syntax error, LIMIT cannot be used without the special ~ option\r\n"},
+		{[]string{"xadd", "s", "maxlen", "-1", "*", "e", "5"}, "-ERR The MAXLEN argument must be >= 0.\r\n"},
+		{[]string{"xadd", "s", "maxlen", "=", "2", "3-0", "e", "5"}, "$3\r\n3-0\r\n"},
+		{[]string{"xrange", "s", "-", "+"}, "*2\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\ne\r\n$1\r\n5\r\n"},
+		{[]string{"xtrim", "s", "maxlen", "~", "1"}, ":0\r\n"},
+		{[]string{"xtrim", "s", "minid", "3"}, ":1\r\n"},
+		{[]string{"xtrim", "s", "maxlen", "1", "minid", "1"}, "-ERR syntax error, MAXLEN and MINID options at the same time are not compatible\r\n"},
+		{[]string{"xread", "streams", "s", "new", "0", "0"}, "*2\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\ne\r\n$1\r\n5\r\n*2\r\n$3\r\nnew\r\n*1\r\n*2\r\n$3\r\n0-1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{[]string{"xread", "count", "1", "streams", "s", "3-0"}, "*-1\r\n"},
+		{[]string{"xread", "streams", "s", "new", "0"}, "-ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.\r\n"},
+		{[]string{"xread", "block", "10", "streams", "s", "$"}, "*-1\r\n"},
+		{[]string{"xread", "block", "-1", "streams", "s", "$"}, "-ERR timeout is negative\r\n"},
+		{[]string{"set", "str", "v"}, "+OK\r\n"},
+		{[]string{"xread", "streams", "str", "0"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"xadd", "str", "*", "a", "1"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+}
+
+func TestStreamPropagation(t *testing.T) {
+	ms := newTestMaster()
+	var propagated [][]string
+	ms.propagate = func(args []string) {
+		propagated = append(propagated, args)
+	}
+	cl := newTestClient()
+	for i := 0; i < 150; i++ {
+		do(ms, cl, "xadd", "s", "*", "f", "v")
+	}
+	id := strings.Split(do(ms, cl, "xadd", "s", "maxlen", "~", "20", "*", "f", "v"), "\r\n")[1]
+	if got := do(ms, cl, "xlen", "s"); got != ":51\r\n" {
+		t.Errorf("Unexpected length after approximate trimming. Got: %q", got)
+	}
+	expected := []string{"xadd", "s", "maxlen", "=", "51", id, "f", "v"}
+	if got := propagated[len(propagated)-1]; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected propagated command. Expected: %q, Got: %q", expected, got)
+	}
+}
+
+func TestBlockingXRead(t *testing.T) {
+	ms := newTestMaster()
+	do(ms, newTestClient(), "xadd", "s", "1-0", "old", "1")
+	replies := make([]<-chan string, 2)
+	for i := range replies {
+		replies[i] = doAsync(context.Background(), ms, "xread", "block", "0", "streams", "other", "s", "0", "$")
+		waitBlocked(t, ms, "s", i+1)
+	}
+	if got := do(ms, newTestClient(), "xadd", "s", "2-0", "new", "2"); got != "$3\r\n2-0\r\n" {
+		t.Fatalf("Unexpected reply to XADD: %q", got)
+	}
+	expected := "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$3\r\nnew\r\n$1\r\n2\r\n"
+	for i, reply := range replies {
+		if got := <-reply; got != expected {
+			t.Errorf("Unexpected reply to client %d. Expected: %q, Got: %q", i, expected, got)
+		}
+	}
+}
@@ -0,0 +1,553 @@
+package server
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+func (c *core) registerStringCommands() {
+	c.commands.Register(Command{Name: "get", Arity: 2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.getCommand})
+	c.commands.Register(Command{Name: "set", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.setCommand})
+	c.commands.Register(Command{Name: "setnx", Arity: 3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.setnxCommand})
+	c.commands.Register(Command{Name: "setex", Arity: 4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.setexCommand})
+	c.commands.Register(Command{Name: "psetex", Arity: 4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.setexCommand})
+	c.commands.Register(Command{Name: "getset", Arity: 3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.getsetCommand})
+	c.commands.Register(Command{Name: "getex", Arity: -2, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.getexCommand})
+	c.commands.Register(Command{Name: "getdel", Arity: 2, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.getdelCommand})
+	c.commands.Register(Command{Name: "append", Arity: 3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.appendCommand})
+	c.commands.Register(Command{Name: "strlen", Arity: 2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.strlenCommand})
+	c.commands.Register(Command{Name: "getrange", Arity: 4, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.getrangeCommand})
+	c.commands.Register(Command{Name: "substr", Arity: 4, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.getrangeCommand})
+	c.commands.Register(Command{Name: "setrange", Arity: 4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.setrangeCommand})
+	c.commands.Register(Command{Name: "incr", Arity: 2, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.incrCommand})
+	c.commands.Register(Command{Name: "decr", Arity: 2, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.incrCommand})
+	c.commands.Register(Command{Name: "incrby", Arity: 3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.incrCommand})
+	c.commands.Register(Command{Name: "decrby", Arity: 3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.incrCommand})
+	c.commands.Register(Command{Name: "incrbyfloat", Arity: 3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.incrbyfloatCommand})
+	c.commands.Register(Command{Name: "mget", Arity: -2, Flags: FlagReadonly, FirstKey: 1, LastKey: -1, Handler: c.mgetCommand})
+	c.commands.Register(Command{Name: "mset", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: -1, Step: 2, Handler: c.msetCommand})
+	c.commands.Register(Command{Name: "msetnx", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: -1, Step: 2, Handler: c.msetCommand})
+}
+
+// Largest string value, same as Redis' proto-max-bulk-len
+const maxStringLen = 512 * 1024 * 1024
+
+// Options of SET and GETEX
+type setOptions struct {
+	nx, xx  bool
+	get     bool
+	keepTTL bool
+	persist bool
+	// Absolute expiry time in unix milliseconds, 0 when not given
+	expireAt int64
+}
+
+const (
+	allowSetOptions = iota
+	allowGetexOptions
+)
+
+// Parses "[NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-time-seconds
+// | PXAT unix-time-milliseconds | KEEPTTL]" for SET, or the expiry options
+// and PERSIST for GETEX. Option names are case insensitive and every option
+// may be given once; conflicting options are a syntax error.
+func parseSetOptions(cmd string, args []string, allow int) (setOptions, resp.Value, bool) {
+	var opts setOptions
+	expireGiven := false
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		switch {
+		case opt == "nx" && allow == allowSetOptions && !opts.xx:
+			opts.nx = true
+		case opt == "xx" && allow == allowSetOptions && !opts.nx:
+			opts.xx = true
+		case opt == "get" && allow == allowSetOptions:
+			opts.get = true
+		case opt == "keepttl" && allow == allowSetOptions && !expireGiven:
+			opts.keepTTL = true
+			expireGiven = true
+		case opt == "persist" && allow == allowGetexOptions && !expireGiven:
+			opts.persist = true
+			expireGiven = true
+		case (opt == "ex" || opt == "px" || opt == "exat" || opt == "pxat") && !expireGiven && i+1 < len(args):
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				return opts, notInteger(), false
+			}
+			at, ok := setExpireTime(opt, n, time.Now().UnixMilli())
+			if !ok {
+				return opts, resp.Errorf("invalid expire time in '%s' command", cmd), false
+			}
+			opts.expireAt = at
+			expireGiven = true
+			i++
+		default:
+			return opts, syntaxError(), false
+		}
+	}
+	return opts, resp.Value{}, true
+}
+
+// Returns the SET replicas execute for a SET with an expiry. The expiry is
+// sent as an absolute PXAT, so their TTL doesn't depend on when the write
+// reaches them.
+func setPropagation(key, value string, opts setOptions) []string {
+	args := []string{"set", key, value}
+	switch {
+	case opts.nx:
+		args = append(args, "nx")
+	case opts.xx:
+		args = append(args, "xx")
+	}
+	if opts.get {
+		args = append(args, "get")
+	}
+	return append(args, "pxat", strconv.FormatInt(opts.expireAt, 10))
+}
+
+// Converts an EX, PX, EXAT or PXAT argument into unix milliseconds.
+// Unlike EXPIRE, SET only accepts positive times.
+func setExpireTime(unit string, n int64, now int64) (int64, bool) {
+	if n <= 0 {
+		return 0, false
+	}
+	if unit == "ex" || unit == "exat" {
+		if n > math.MaxInt64/1000 {
+			return 0, false
+		}
+		n *= 1000
+	}
+	if unit == "ex" || unit == "px" {
+		if n > math.MaxInt64-now {
+			return 0, false
+		}
+		n += now
+	}
+	return n, true
+}
+
+// Get value from key value storage
+func (c *core) getCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var v string
+	var ok bool
+	err := c.db(cl).View([]string{args[1]}, func(tx *storage.Tx) (err error) {
+		v, ok, err = tx.Get(args[1])
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !ok {
+		return resp.NullBulkValue()
+	}
+	return resp.BulkValue(v)
+}
+
+// Set given key value pair into storage
+func (c *core) setCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	opts, errReply, ok := parseSetOptions("set", args[3:], allowSetOptions)
+	if !ok {
+		return errReply
+	}
+	old, existed, written, err := c.set(cl, args[1], args[2], opts)
+	if err != nil {
+		return errorReply(err)
+	}
+	switch {
+	case !written:
+		cl.propagateAs = []string{}
+	case opts.expireAt != 0:
+		cl.propagateAs = setPropagation(args[1], args[2], opts)
+	}
+	switch {
+	case opts.get && existed:
+		return resp.BulkValue(old)
+	case opts.get, !written:
+		return resp.NullBulkValue()
+	}
+	return resp.OK
+}
+
+// Stores the value following SET options. Returns the old value and whether
+// the write happened, it doesn't when NX or XX prevented it. SET replaces a
+// value of any type, but with GET the old value must be a string.
+func (c *core) set(cl *Client, key, value string, opts setOptions) (old string, existed, written bool, err error) {
+	err = c.db(cl).Update([]string{key}, func(tx *storage.Tx) error {
+		e := tx.Lookup(key)
+		existed = e != nil
+		if existed && opts.get {
+			if e.Type != storage.TypeString {
+				return storage.ErrWrongType
+			}
+			old = e.Value.(string)
+		}
+		if (opts.nx && existed) || (opts.xx && !existed) {
+			return nil
+		}
+		written = true
+		if opts.keepTTL {
+			tx.SetKeepTTL(key, value)
+			return nil
+		}
+		tx.Set(key, value)
+		if opts.expireAt != 0 {
+			tx.SetExpireAt(key, opts.expireAt)
+		}
+		return nil
+	})
+	return old, existed, written, err
+}
+
+func (c *core) setnxCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	_, _, written, _ := c.set(cl, args[1], args[2], setOptions{nx: true})
+	if !written {
+		cl.propagateAs = []string{}
+		return resp.IntegerValue(0)
+	}
+	return resp.IntegerValue(1)
+}
+
+// Handles "SETEX key seconds value" and "PSETEX key milliseconds value"
+func (c *core) setexCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	name := strings.ToLower(args[0])
+	n, ok := parseInt(args[2])
+	if !ok {
+		return notInteger()
+	}
+	unit := "ex"
+	if name == "psetex" {
+		unit = "px"
+	}
+	at, ok := setExpireTime(unit, n, time.Now().UnixMilli())
+	if !ok {
+		return resp.Errorf("invalid expire time in '%s' command", name)
+	}
+	opts := setOptions{expireAt: at}
+	c.set(cl, args[1], args[3], opts)
+	cl.propagateAs = setPropagation(args[1], args[3], opts)
+	return resp.OK
+}
+
+func (c *core) getsetCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	old, existed, _, err := c.set(cl, args[1], args[2], setOptions{get: true})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !existed {
+		return resp.NullBulkValue()
+	}
+	return resp.BulkValue(old)
+}
+
+// Handles "GETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds |
+// PXAT unix-time-milliseconds | PERSIST]"
+func (c *core) getexCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	opts, errReply, ok := parseSetOptions("getex", args[2:], allowGetexOptions)
+	if !ok {
+		return errReply
+	}
+	key := args[1]
+	var v string
+	var exists bool
+	err := c.db(cl).Update([]string{key}, func(tx *storage.Tx) (err error) {
+		v, exists, err = tx.Get(key)
+		if !exists {
+			return err
+		}
+		if opts.persist {
+			tx.Persist(key)
+		} else if opts.expireAt != 0 {
+			tx.SetExpireAt(key, opts.expireAt)
+		}
+		return nil
+	})
+	// Replicas only need the change of the TTL, with an absolute time
+	switch {
+	case !exists:
+		cl.propagateAs = []string{}
+	case opts.persist:
+		cl.propagateAs = []string{"persist", key}
+	case opts.expireAt != 0:
+		cl.propagateAs = []string{"pexpireat", key, strconv.FormatInt(opts.expireAt, 10)}
+	default:
+		cl.propagateAs = []string{}
+	}
+	if err != nil {
+		return errorReply(err)
+	}
+	if !exists {
+		return resp.NullBulkValue()
+	}
+	return resp.BulkValue(v)
+}
+
+func (c *core) getdelCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var v string
+	var exists bool
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) (err error) {
+		v, exists, err = tx.Get(args[1])
+		if exists {
+			tx.Delete(args[1])
+		}
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !exists {
+		cl.propagateAs = []string{}
+		return resp.NullBulkValue()
+	}
+	return resp.BulkValue(v)
+}
+
+func (c *core) appendCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var n int
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		v, _, err := tx.Get(args[1])
+		if err != nil {
+			return err
+		}
+		v += args[2]
+		n = len(v)
+		tx.SetKeepTTL(args[1], v)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.IntegerValue(int64(n))
+}
+
+func (c *core) strlenCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var v string
+	err := c.db(cl).View([]string{args[1]}, func(tx *storage.Tx) (err error) {
+		v, _, err = tx.Get(args[1])
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.IntegerValue(int64(len(v)))
+}
+
+// Handles "GETRANGE key start end". Negative offsets count from the end of
+// the string, and the range is clamped to the string.
+func (c *core) getrangeCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	start, ok1 := parseInt(args[2])
+	end, ok2 := parseInt(args[3])
+	if !ok1 || !ok2 {
+		return notInteger()
+	}
+	var v string
+	err := c.db(cl).View([]string{args[1]}, func(tx *storage.Tx) (err error) {
+		v, _, err = tx.Get(args[1])
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	n := int64(len(v))
+	if start < 0 && end < 0 && start > end {
+		return resp.BulkValue("")
+	}
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	start = max(start, 0)
+	end = max(end, 0)
+	end = min(end, n-1)
+	if n == 0 || start > end {
+		return resp.BulkValue("")
+	}
+	return resp.BulkValue(v[start : end+1])
+}
+
+// Handles "SETRANGE key offset value". The string is padded with zero bytes
+// when offset is past its end.
+func (c *core) setrangeCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	offset, ok := parseInt(args[2])
+	if !ok {
+		return notInteger()
+	}
+	if offset < 0 {
+		return resp.Errorf("offset is out of range")
+	}
+	value := args[3]
+	if len(value) > 0 && offset+int64(len(value)) > maxStringLen {
+		return resp.Errorf("string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+	var n int
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		v, exists, err := tx.Get(args[1])
+		if err != nil {
+			return err
+		}
+		n = len(v)
+		if len(value) == 0 {
+			// Nothing to write, and a missing key is not created
+			return nil
+		}
+		if !exists {
+			v = ""
+		}
+		b := []byte(v)
+		if end := int(offset) + len(value); end > len(b) {
+			b = append(b, make([]byte, end-len(b))...)
+		}
+		copy(b[offset:], value)
+		n = len(b)
+		tx.SetKeepTTL(args[1], string(b))
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if len(value) == 0 {
+		cl.propagateAs = []string{}
+	}
+	return resp.IntegerValue(int64(n))
+}
+
+// Handles INCR, DECR, INCRBY and DECRBY. The key is created with value 0
+// when missing, and its TTL is kept.
+func (c *core) incrCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	name := strings.ToLower(args[0])
+	delta := int64(1)
+	if len(args) == 3 {
+		var ok bool
+		delta, ok = parseInt(args[2])
+		if !ok {
+			return notInteger()
+		}
+	}
+	if name == "decr" || name == "decrby" {
+		if delta == math.MinInt64 {
+			return resp.Errorf("decrement would overflow")
+		}
+		delta = -delta
+	}
+	var result int64
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		v, exists, err := tx.Get(args[1])
+		if err != nil {
+			return err
+		}
+		var current int64
+		if exists {
+			var ok bool
+			current, ok = parseInt(v)
+			if !ok {
+				return asError(notInteger())
+			}
+		}
+		if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+			return asError(resp.Errorf("increment or decrement would overflow"))
+		}
+		result = current + delta
+		tx.SetKeepTTL(args[1], strconv.FormatInt(result, 10))
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.IntegerValue(result)
+}
+
+// Handles "INCRBYFLOAT key increment". Replicas get a SET of the result,
+// since repeating the float addition there could round differently.
+func (c *core) incrbyfloatCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	delta, ok := parseFloat(args[2])
+	if !ok {
+		return resp.Errorf("value is not a valid float")
+	}
+	var result string
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		v, exists, err := tx.Get(args[1])
+		if err != nil {
+			return err
+		}
+		var current float64
+		if exists {
+			var ok bool
+			current, ok = parseFloat(v)
+			if !ok {
+				return asError(resp.Errorf("value is not a valid float"))
+			}
+		}
+		f := current + delta
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return asError(resp.Errorf("increment would produce NaN or Infinity"))
+		}
+		result = strconv.FormatFloat(f, 'f', -1, 64)
+		tx.SetKeepTTL(args[1], result)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	cl.propagateAs = []string{"set", args[1], result, "keepttl"}
+	return resp.BulkValue(result)
+}
+
+func (c *core) mgetCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	keys := args[1:]
+	values := make([]resp.Value, len(keys))
+	c.db(cl).View(keys, func(tx *storage.Tx) error {
+		for i, key := range keys {
+			// Keys holding other types read as nil, they are not an error
+			if v, ok, err := tx.Get(key); ok && err == nil {
+				values[i] = resp.BulkValue(v)
+			} else {
+				values[i] = resp.NullBulkValue()
+			}
+		}
+		return nil
+	})
+	return resp.ArrayValue(values...)
+}
+
+// Handles "MSET key value [key value ...]" and MSETNX, which sets nothing
+// when any of the keys already exists. All keys are written atomically.
+func (c *core) msetCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	name := strings.ToLower(args[0])
+	if len(args)%2 == 0 {
+		return wrongArity(name)
+	}
+	keys := make([]string, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		keys = append(keys, args[i])
+	}
+	written := true
+	c.db(cl).Update(keys, func(tx *storage.Tx) error {
+		if name == "msetnx" {
+			for _, key := range keys {
+				if tx.Exists(key) {
+					written = false
+					return nil
+				}
+			}
+		}
+		for i := 1; i < len(args); i += 2 {
+			tx.Set(args[i], args[i+1])
+		}
+		return nil
+	})
+	if name == "mset" {
+		return resp.OK
+	}
+	if written {
+		return resp.IntegerValue(1)
+	}
+	cl.propagateAs = []string{}
+	return resp.IntegerValue(0)
+}
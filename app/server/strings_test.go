@@ -0,0 +1,156 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSet_Options(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"set", "lock", "a", "NX", "PX", "30000"}, "+OK\r\n"},
+		{[]string{"set", "lock", "b", "nx", "px", "30000"}, "$-1\r\n"},
+		{[]string{"ttl", "lock"}, ":30\r\n"},
+		{[]string{"set", "lock", "c", "XX", "GET", "KEEPTTL"}, "$1\r\na\r\n"},
+		{[]string{"ttl", "lock"}, ":30\r\n"},
+		{[]string{"set", "lock", "d"}, "+OK\r\n"},
+		{[]string{"ttl", "lock"}, ":-1\r\n"},
+		{[]string{"set", "missing", "x", "XX", "GET"}, "$-1\r\n"},
+		{[]string{"set", "k", "v", "NX", "XX"}, "-ERR syntax error\r\n"},
+		{[]string{"set", "k", "v", "EX", "10", "PX", "100"}, "-ERR syntax error\r\n"},
+		{[]string{"set", "k", "v", "EX", "10", "KEEPTTL"}, "-ERR syntax error\r\n"},
+		{[]string{"set", "k", "v", "EX", "0"}, "-ERR invalid expire time in 'set' command\r\n"},
+		{[]string{"set", "k", "v", "EX", "ten"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"getset", "lock", "e"}, "$1\r\nd\r\n"},
+		{[]string{"setnx", "lock", "f"}, ":0\r\n"},
+		{[]string{"setex", "tmp", "100", "v"}, "+OK\r\n"},
+		{[]string{"getex", "tmp", "PERSIST"}, "$1\r\nv\r\n"},
+		{[]string{"ttl", "tmp"}, ":-1\r\n"},
+		{[]string{"getdel", "tmp"}, "$1\r\nv\r\n"},
+		{[]string{"get", "tmp"}, "$-1\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+}
+
+func TestStringCommands(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"append", "s", "Hello"}, ":5\r\n"},
+		{[]string{"append", "s", " World"}, ":11\r\n"},
+		{[]string{"strlen", "s"}, ":11\r\n"},
+		{[]string{"getrange", "s", "-5", "-1"}, "$5\r\nWorld\r\n"},
+		{[]string{"getrange", "s", "0", "100"}, "$11\r\nHello World\r\n"},
+		{[]string{"getrange", "s", "5", "2"}, "$0\r\n\r\n"},
+		{[]string{"setrange", "s", "6", "Redis"}, ":11\r\n"},
+		{[]string{"setrange", "p", "3", "x"}, ":4\r\n"},
+		{[]string{"get", "p"}, "$4\r\n\x00\x00\x00x\r\n"},
+		{[]string{"setrange", "s", "-1", "x"}, "-ERR offset is out of range\r\n"},
+		{[]string{"incr", "n"}, ":1\r\n"},
+		{[]string{"incrby", "n", "41"}, ":42\r\n"},
+		{[]string{"decrby", "n", "50"}, ":-8\r\n"},
+		{[]string{"incr", "s"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"set", "big", "9223372036854775807"}, "+OK\r\n"},
+		{[]string{"incr", "big"}, "-ERR increment or decrement would overflow\r\n"},
+		{[]string{"incrby", "n", "1.5"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"incrbyfloat", "f", "10.5"}, "$4\r\n10.5\r\n"},
+		{[]string{"incrbyfloat", "f", "0.1"}, "$4\r\n10.6\r\n"},
+		{[]string{"incrbyfloat", "f", "5.0e3"}, "$6\r\n5010.6\r\n"},
+		{[]string{"incrbyfloat", "s", "1"}, "-ERR value is not a valid float\r\n"},
+		{[]string{"incrbyfloat", "f", "inf"}, "-ERR increment would produce NaN or Infinity\r\n"},
+		{[]string{"mset", "a", "1", "b", "2"}, "+OK\r\n"},
+		{[]string{"mset", "a", "1", "b"}, "-ERR wrong number of arguments for 'mset' command\r\n"},
+		{[]string{"mget", "a", "nokey", "b"}, "*3\r\n$1\r\n1\r\n$-1\r\n$1\r\n2\r\n"},
+		{[]string{"msetnx", "c", "3", "a", "x"}, ":0\r\n"},
+		{[]string{"get", "c"}, "$-1\r\n"},
+		{[]string{"msetnx", "c", "3", "d", "4"}, ":1\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+}
+
+func TestTypeAndObject(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"type", "nokey"}, "+none\r\n"},
+		{[]string{"set", "n", "12345"}, "+OK\r\n"},
+		{[]string{"type", "n"}, "+string\r\n"},
+		{[]string{"object", "encoding", "n"}, "$3\r\nint\r\n"},
+		{[]string{"set", "s", "short"}, "+OK\r\n"},
+		{[]string{"object", "encoding", "s"}, "$6\r\nembstr\r\n"},
+		{[]string{"object", "encoding", "nokey"}, "$-1\r\n"},
+		{[]string{"object", "idletime", "s"}, ":0\r\n"},
+		{[]string{"object", "freq", "s"}, ":5\r\n"},
+		{[]string{"object", "foo", "s"}, "-ERR unknown subcommand 'foo'. Try OBJECT HELP.\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+}
+
+func TestSet_PropagatesAbsoluteTime(t *testing.T) {
+	ms := newTestMaster()
+	propagated := recordPropagated(ms)
+	cl := newTestClient()
+	before := time.Now().UnixMilli()
+	do(ms, cl, "set", "a", "1", "NX", "GET", "EX", "100")
+	do(ms, cl, "setex", "b", "10", "v")
+	do(ms, cl, "psetex", "c", "500", "v")
+	do(ms, cl, "getex", "b", "px", "2000")
+	after := time.Now().UnixMilli()
+	do(ms, cl, "set", "d", "1", "pxat", "4000000000000")
+	do(ms, cl, "getex", "b", "persist")
+	// GETEX without changes or of a missing key isn't propagated, nor a
+	// SET that NX or XX prevented
+	do(ms, cl, "getex", "b")
+	do(ms, cl, "getex", "missing", "ex", "10")
+	do(ms, cl, "set", "a", "2", "NX", "PX", "1000")
+	do(ms, cl, "set", "missing", "2", "XX")
+	do(ms, cl, "setnx", "a", "3")
+	do(ms, cl, "set", "e", "1")
+	expected := [][]string{
+		{"set", "a", "1", "nx", "get", "pxat", ""},
+		{"set", "b", "v", "pxat", ""},
+		{"set", "c", "v", "pxat", ""},
+		{"pexpireat", "b", ""},
+		{"set", "d", "1", "pxat", "4000000000000"},
+		{"persist", "b"},
+		{"set", "e", "1"},
+	}
+	if len(*propagated) != len(expected) {
+		t.Fatalf("Unexpected propagated commands: %q", *propagated)
+	}
+	for i, d := range []int64{100000, 10000, 500, 2000} {
+		got := (*propagated)[i]
+		checkAbsoluteTime(t, got, expected[i], len(got)-1, before, after, d)
+	}
+	for i, e := range expected[4:] {
+		if got := (*propagated)[i+4]; strings.Join(got, " ") != strings.Join(e, " ") {
+			t.Errorf("Unexpected propagated command. Expected: %q, Got: %q", e, got)
+		}
+	}
+}
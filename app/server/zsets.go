@@ -0,0 +1,907 @@
+package server
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+func (c *core) registerZSetCommands() {
+	c.commands.Register(Command{Name: "zadd", Arity: -4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.zaddCommand})
+	c.commands.Register(Command{Name: "zincrby", Arity: 4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.zincrbyCommand})
+	c.commands.Register(Command{Name: "zrem", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.zremCommand})
+	c.commands.Register(Command{Name: "zcard", Arity: 2, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.zcardCommand})
+	c.commands.Register(Command{Name: "zscore", Arity: 3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.zscoreCommand})
+	c.commands.Register(Command{Name: "zmscore", Arity: -3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.zmscoreCommand})
+	c.commands.Register(Command{Name: "zrank", Arity: -3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.zrankCommand})
+	c.commands.Register(Command{Name: "zrevrank", Arity: -3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.zrankCommand})
+	c.commands.Register(Command{Name: "zcount", Arity: 4, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.zcountCommand})
+	c.commands.Register(Command{Name: "zlexcount", Arity: 4, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.zcountCommand})
+	for _, name := range []string{"zrange", "zrevrange", "zrangebyscore", "zrevrangebyscore", "zrangebylex", "zrevrangebylex"} {
+		c.commands.Register(Command{Name: name, Arity: -4, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.zrangeCommand})
+	}
+	c.commands.Register(Command{Name: "zrangestore", Arity: -5, Flags: FlagWrite, FirstKey: 1, LastKey: 2, Handler: c.zrangeCommand})
+	for _, name := range []string{"zremrangebyrank", "zremrangebyscore", "zremrangebylex"} {
+		c.commands.Register(Command{Name: name, Arity: 4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.zremrangeCommand})
+	}
+	c.commands.Register(Command{Name: "zpopmin", Arity: -2, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.zpopCommand})
+	c.commands.Register(Command{Name: "zpopmax", Arity: -2, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.zpopCommand})
+	c.commands.Register(Command{Name: "bzpopmin", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: -2, Handler: c.bzpopCommand})
+	c.commands.Register(Command{Name: "bzpopmax", Arity: -3, Flags: FlagWrite, FirstKey: 1, LastKey: -2, Handler: c.bzpopCommand})
+	c.commands.Register(Command{Name: "zscan", Arity: -3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Handler: c.zscanCommand})
+	for _, name := range []string{"zunion", "zinter", "zdiff"} {
+		c.commands.Register(Command{Name: name, Arity: -3, Flags: FlagReadonly, Handler: c.zsetAlgebraCommand})
+		c.commands.Register(Command{Name: name + "store", Arity: -4, Flags: FlagWrite, FirstKey: 1, LastKey: 1, Handler: c.zsetAlgebraCommand})
+	}
+}
+
+// Parses a score range bound: a float, "-inf" or "+inf", exclusive when
+// prefixed with '('.
+func parseScoreBound(s string) (float64, bool, bool) {
+	ex := strings.HasPrefix(s, "(")
+	if ex {
+		s = s[1:]
+	}
+	f, ok := parseFloat(s)
+	return f, ex, ok
+}
+
+func parseScoreRange(min, max string) (storage.ScoreRange, resp.Value, bool) {
+	var r storage.ScoreRange
+	var ok1, ok2 bool
+	r.Min, r.MinEx, ok1 = parseScoreBound(min)
+	r.Max, r.MaxEx, ok2 = parseScoreBound(max)
+	if !ok1 || !ok2 {
+		return r, resp.Errorf("min or max is not a float"), false
+	}
+	return r, resp.Value{}, true
+}
+
+// Parses a lexicographical range bound: "-", "+", or a member prefixed
+// with '[' for inclusive or '(' for exclusive.
+func parseLexBound(s string) (member string, ex, inf, ok bool) {
+	switch {
+	case s == "-" || s == "+":
+		return "", false, true, true
+	case strings.HasPrefix(s, "["):
+		return s[1:], false, false, true
+	case strings.HasPrefix(s, "("):
+		return s[1:], true, false, true
+	}
+	return "", false, false, false
+}
+
+func parseLexRange(min, max string) (storage.LexRange, resp.Value, bool) {
+	var r storage.LexRange
+	var ok1, ok2 bool
+	r.Min, r.MinEx, r.MinInf, ok1 = parseLexBound(min)
+	r.Max, r.MaxEx, r.MaxInf, ok2 = parseLexBound(max)
+	if !ok1 || !ok2 {
+		return r, resp.Errorf("min or max not valid string range item"), false
+	}
+	// "+" as min or "-" as max make the range empty
+	if (r.MinInf && min == "+") || (r.MaxInf && max == "-") {
+		r = storage.LexRange{Min: "b", Max: "a"}
+	}
+	return r, resp.Value{}, true
+}
+
+// Replies with members of a sorted set. With scores, RESP2 clients get one
+// flat array of members and scores, RESP3 clients an array of pairs.
+func zmembersReply(cl *Client, members []storage.ZMember, withScores bool) resp.Value {
+	items := make([]resp.Value, 0, len(members))
+	for _, m := range members {
+		switch {
+		case !withScores:
+			items = append(items, resp.BulkValue(m.Member))
+		case cl.Protocol() == resp.RESP3:
+			items = append(items, resp.ArrayValue(resp.BulkValue(m.Member), resp.DoubleValue(m.Score)))
+		default:
+			items = append(items, resp.BulkValue(m.Member), resp.DoubleValue(m.Score))
+		}
+	}
+	return resp.ArrayValue(items...)
+}
+
+// Handles "ZADD key [NX | XX] [GT | LT] [CH] [INCR] score member [score
+// member ...]"
+func (c *core) zaddCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var nx, xx, gt, lt, ch, incr bool
+	i := 2
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "gt":
+			gt = true
+		case "lt":
+			lt = true
+		case "ch":
+			ch = true
+		case "incr":
+			incr = true
+		default:
+			break options
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return syntaxError()
+	}
+	if nx && xx {
+		return resp.Errorf("XX and NX options at the same time are not compatible")
+	}
+	if (gt && nx) || (lt && nx) || (gt && lt) {
+		return resp.Errorf("GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if incr && len(pairs) > 2 {
+		return resp.Errorf("INCR option supports a single increment-element pair")
+	}
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		var ok bool
+		scores[j], ok = parseFloat(pairs[2*j])
+		if !ok {
+			return resp.Errorf("value is not a valid float")
+		}
+	}
+
+	key := args[1]
+	var added, changed int
+	var result float64
+	var updated bool
+	err := c.db(cl).Update([]string{key}, func(tx *storage.Tx) error {
+		z, err := tx.ZSet(key, !xx)
+		if err != nil || z == nil {
+			return err
+		}
+		for j, score := range scores {
+			member := pairs[2*j+1]
+			current, exists := z.Score(member)
+			if (exists && nx) || (!exists && xx) {
+				continue
+			}
+			if incr && exists {
+				score += current
+				if math.IsNaN(score) {
+					tx.DeleteIfEmpty(key)
+					return asError(resp.Errorf("resulting score is not a number (NaN)"))
+				}
+			}
+			if exists && ((lt && score >= current) || (gt && score <= current)) {
+				continue
+			}
+			result, updated = score, true
+			if !exists {
+				added++
+			} else if score != current {
+				changed++
+			}
+			z.Add(member, score)
+		}
+		tx.DeleteIfEmpty(key)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if added > 0 {
+		c.signalKeyAsReady(cl, key)
+	}
+	if added+changed == 0 {
+		cl.propagateAs = []string{}
+	}
+	if incr {
+		if !updated {
+			return resp.NullBulkValue()
+		}
+		return resp.DoubleValue(result)
+	}
+	if ch {
+		return resp.IntegerValue(int64(added + changed))
+	}
+	return resp.IntegerValue(int64(added))
+}
+
+// Handles "ZINCRBY key increment member"
+func (c *core) zincrbyCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	return c.zaddCommand(ctx, cl, []string{"zadd", args[1], "incr", args[2], args[3]})
+}
+
+func (c *core) zremCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var removed int
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		z, err := tx.ZSet(args[1], false)
+		if err != nil || z == nil {
+			return err
+		}
+		for _, m := range args[2:] {
+			if z.Remove(m) {
+				removed++
+			}
+		}
+		tx.DeleteIfEmpty(args[1])
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if removed == 0 {
+		cl.propagateAs = []string{}
+	}
+	return resp.IntegerValue(int64(removed))
+}
+
+// Runs fn on the sorted set at key in a read only transaction. z is nil
+// when the key does not exist.
+func (c *core) viewZSet(cl *Client, key string, fn func(z *storage.ZSet)) error {
+	return c.db(cl).View([]string{key}, func(tx *storage.Tx) error {
+		z, err := tx.ZSet(key, false)
+		if err != nil {
+			return err
+		}
+		fn(z)
+		return nil
+	})
+}
+
+func (c *core) zcardCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	var n int
+	err := c.viewZSet(cl, args[1], func(z *storage.ZSet) {
+		if z != nil {
+			n = z.Len()
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.IntegerValue(int64(n))
+}
+
+func (c *core) zscoreCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	reply := resp.NullBulkValue()
+	err := c.viewZSet(cl, args[1], func(z *storage.ZSet) {
+		if z == nil {
+			return
+		}
+		if score, ok := z.Score(args[2]); ok {
+			reply = resp.DoubleValue(score)
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return reply
+}
+
+func (c *core) zmscoreCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	res := make([]resp.Value, len(args)-2)
+	err := c.viewZSet(cl, args[1], func(z *storage.ZSet) {
+		for i, m := range args[2:] {
+			res[i] = resp.NullBulkValue()
+			if z == nil {
+				continue
+			}
+			if score, ok := z.Score(m); ok {
+				res[i] = resp.DoubleValue(score)
+			}
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.ArrayValue(res...)
+}
+
+// Handles "ZRANK key member [WITHSCORE]" and ZREVRANK
+func (c *core) zrankCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	withScore := false
+	switch {
+	case len(args) == 4 && strings.ToLower(args[3]) == "withscore":
+		withScore = true
+	case len(args) != 3:
+		return syntaxError()
+	}
+	reverse := strings.ToLower(args[0]) == "zrevrank"
+	var rank int
+	var score float64
+	var found bool
+	err := c.viewZSet(cl, args[1], func(z *storage.ZSet) {
+		if z == nil {
+			return
+		}
+		rank, found = z.Rank(args[2], reverse)
+		score, _ = z.Score(args[2])
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	switch {
+	case !found && withScore:
+		return resp.NullArrayValue()
+	case !found:
+		return resp.NullBulkValue()
+	case withScore:
+		return resp.ArrayValue(resp.IntegerValue(int64(rank)), resp.DoubleValue(score))
+	}
+	return resp.IntegerValue(int64(rank))
+}
+
+// Handles "ZCOUNT key min max" and "ZLEXCOUNT key min max"
+func (c *core) zcountCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	lex := strings.ToLower(args[0]) == "zlexcount"
+	var sr storage.ScoreRange
+	var lr storage.LexRange
+	var errReply resp.Value
+	var ok bool
+	if lex {
+		lr, errReply, ok = parseLexRange(args[2], args[3])
+	} else {
+		sr, errReply, ok = parseScoreRange(args[2], args[3])
+	}
+	if !ok {
+		return errReply
+	}
+	var n int
+	err := c.viewZSet(cl, args[1], func(z *storage.ZSet) {
+		switch {
+		case z == nil:
+		case lex:
+			n = z.LexCount(lr)
+		default:
+			n = z.Count(sr)
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.IntegerValue(int64(n))
+}
+
+const (
+	rangeByRank = iota
+	rangeByScore
+	rangeByLex
+)
+
+// A parsed range of a ZRANGE family command
+type zrange struct {
+	by      int
+	reverse bool
+	// Ranks for rangeByRank
+	start, stop int
+	score       storage.ScoreRange
+	lex         storage.LexRange
+	// LIMIT offset count, a negative count means all
+	offset, count int
+}
+
+// Converts start and stop ranks with negative and out of range values into
+// 0 based ranks. ok is false for an empty range.
+func normalizeRanks(start, stop, n int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start = max(start, 0)
+	if start > stop || start >= n {
+		return 0, 0, false
+	}
+	return start, min(stop, n-1), true
+}
+
+func (r *zrange) members(z *storage.ZSet) []storage.ZMember {
+	if z == nil || r.offset < 0 {
+		return nil
+	}
+	switch r.by {
+	case rangeByScore:
+		return z.RangeByScore(r.score, r.reverse, r.offset, r.count)
+	case rangeByLex:
+		return z.RangeByLex(r.lex, r.reverse, r.offset, r.count)
+	}
+	start, stop, ok := normalizeRanks(r.start, r.stop, z.Len())
+	if !ok {
+		return nil
+	}
+	return z.RangeByRank(start, stop, r.reverse)
+}
+
+// Handles ZRANGE, ZRANGESTORE, ZREVRANGE, ZRANGEBYSCORE, ZREVRANGEBYSCORE,
+// ZRANGEBYLEX and ZREVRANGEBYLEX:
+//
+//	ZRANGE key start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+//	ZRANGESTORE dst src min max [BYSCORE | BYLEX] [REV] [LIMIT offset count]
+//
+// The older commands are ZRANGE with some of the options implied.
+func (c *core) zrangeCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	name := strings.ToLower(args[0])
+	var dst string
+	if name == "zrangestore" {
+		dst = args[1]
+		args = args[1:]
+	}
+	key, from, to := args[1], args[2], args[3]
+	r := zrange{count: -1}
+	switch name {
+	case "zrevrange":
+		r.reverse = true
+	case "zrangebyscore", "zrevrangebyscore":
+		r.by = rangeByScore
+	case "zrangebylex", "zrevrangebylex":
+		r.by = rangeByLex
+	}
+	if strings.HasPrefix(name, "zrevrangeby") {
+		r.reverse = true
+	}
+	generic := name == "zrange" || name == "zrangestore"
+	withScores, limited := false, false
+	opts := args[4:]
+	for i := 0; i < len(opts); i++ {
+		opt := strings.ToLower(opts[i])
+		switch {
+		case opt == "withscores" && name != "zrangestore":
+			withScores = true
+		case opt == "limit" && name != "zrevrange" && i+2 < len(opts):
+			offset, ok1 := parseInt(opts[i+1])
+			count, ok2 := parseInt(opts[i+2])
+			if !ok1 || !ok2 {
+				return notInteger()
+			}
+			r.offset, r.count = int(offset), int(count)
+			limited = true
+			i += 2
+		case opt == "byscore" && generic:
+			r.by = rangeByScore
+		case opt == "bylex" && generic:
+			r.by = rangeByLex
+		case opt == "rev" && generic:
+			r.reverse = true
+		default:
+			return syntaxError()
+		}
+	}
+	if limited && r.by == rangeByRank {
+		return resp.Errorf("syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if withScores && r.by == rangeByLex {
+		return resp.Errorf("syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	if r.reverse && r.by != rangeByRank {
+		// Reverse score and lex ranges are given as max, min
+		from, to = to, from
+	}
+	var errReply resp.Value
+	ok := true
+	switch r.by {
+	case rangeByRank:
+		var ok1, ok2 bool
+		r.start, ok1 = parseIndex(from)
+		r.stop, ok2 = parseIndex(to)
+		if !ok1 || !ok2 {
+			return notInteger()
+		}
+	case rangeByScore:
+		r.score, errReply, ok = parseScoreRange(from, to)
+	case rangeByLex:
+		r.lex, errReply, ok = parseLexRange(from, to)
+	}
+	if !ok {
+		return errReply
+	}
+
+	if dst == "" {
+		var members []storage.ZMember
+		err := c.viewZSet(cl, key, func(z *storage.ZSet) {
+			members = r.members(z)
+		})
+		if err != nil {
+			return errorReply(err)
+		}
+		return zmembersReply(cl, members, withScores)
+	}
+	var n int
+	var changed bool
+	err := c.db(cl).Update([]string{dst, key}, func(tx *storage.Tx) error {
+		z, err := tx.ZSet(key, false)
+		if err != nil {
+			return err
+		}
+		n, changed = storeZSet(tx, dst, r.members(z))
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if n > 0 {
+		c.signalKeyAsReady(cl, dst)
+	}
+	if !changed {
+		cl.propagateAs = []string{}
+	}
+	return resp.IntegerValue(int64(n))
+}
+
+// Replaces whatever dst holds with a sorted set of members, or deletes it
+// when there are none. It returns the number of members and whether the
+// keyspace changed, it doesn't when dst is missing and stays missing.
+func storeZSet(tx *storage.Tx, dst string, members []storage.ZMember) (int, bool) {
+	deleted := tx.Delete(dst)
+	if len(members) == 0 {
+		return 0, deleted
+	}
+	z := storage.NewZSet()
+	for _, m := range members {
+		z.Add(m.Member, m.Score)
+	}
+	tx.Put(dst, storage.TypeZSet, z)
+	return z.Len(), true
+}
+
+// Handles ZREMRANGEBYRANK, ZREMRANGEBYSCORE and ZREMRANGEBYLEX
+func (c *core) zremrangeCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	name := strings.ToLower(args[0])
+	var start, stop int
+	var sr storage.ScoreRange
+	var lr storage.LexRange
+	var errReply resp.Value
+	ok := true
+	switch name {
+	case "zremrangebyrank":
+		var ok1, ok2 bool
+		start, ok1 = parseIndex(args[2])
+		stop, ok2 = parseIndex(args[3])
+		if !ok1 || !ok2 {
+			return notInteger()
+		}
+	case "zremrangebyscore":
+		sr, errReply, ok = parseScoreRange(args[2], args[3])
+	case "zremrangebylex":
+		lr, errReply, ok = parseLexRange(args[2], args[3])
+	}
+	if !ok {
+		return errReply
+	}
+	var removed int
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		z, err := tx.ZSet(args[1], false)
+		if err != nil || z == nil {
+			return err
+		}
+		switch name {
+		case "zremrangebyrank":
+			if start, stop, ok := normalizeRanks(start, stop, z.Len()); ok {
+				removed = z.RemoveRangeByRank(start, stop)
+			}
+		case "zremrangebyscore":
+			removed = z.RemoveRangeByScore(sr)
+		case "zremrangebylex":
+			removed = z.RemoveRangeByLex(lr)
+		}
+		tx.DeleteIfEmpty(args[1])
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if removed == 0 {
+		cl.propagateAs = []string{}
+	}
+	return resp.IntegerValue(int64(removed))
+}
+
+// Handles "ZPOPMIN key [count]" and "ZPOPMAX key [count]"
+func (c *core) zpopCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	if len(args) > 3 {
+		return syntaxError()
+	}
+	highest := strings.ToLower(args[0]) == "zpopmax"
+	count := 1
+	if len(args) == 3 {
+		n, ok := parseInt(args[2])
+		if !ok || n < 0 {
+			return resp.Errorf("value is out of range, must be positive")
+		}
+		count = int(n)
+	}
+	var popped []storage.ZMember
+	err := c.db(cl).Update([]string{args[1]}, func(tx *storage.Tx) error {
+		z, err := tx.ZSet(args[1], false)
+		if err != nil || z == nil {
+			return err
+		}
+		popped = z.Pop(count, highest)
+		tx.DeleteIfEmpty(args[1])
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if len(popped) == 0 {
+		cl.propagateAs = []string{}
+	}
+	if len(args) == 2 {
+		// Without count the reply is a flat member, score pair in RESP3 too
+		if len(popped) == 0 {
+			return resp.ArrayValue()
+		}
+		return resp.ArrayValue(resp.BulkValue(popped[0].Member), resp.DoubleValue(popped[0].Score))
+	}
+	return zmembersReply(cl, popped, true)
+}
+
+// Handles "BZPOPMIN key [key ...] timeout" and BZPOPMAX
+func (c *core) bzpopCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	timeout, errReply, ok := parseTimeout(args[len(args)-1])
+	if !ok {
+		return errReply
+	}
+	name := strings.ToLower(args[0])
+	highest := name == "bzpopmax"
+	keys := args[1 : len(args)-1]
+	return c.block(ctx, cl, keys, timeout, func() (blockedResult, bool) {
+		var key string
+		var popped []storage.ZMember
+		err := c.db(cl).Update(keys, func(tx *storage.Tx) error {
+			for _, k := range keys {
+				z, err := tx.ZSet(k, false)
+				if err != nil {
+					return err
+				}
+				if z == nil {
+					continue
+				}
+				key, popped = k, z.Pop(1, highest)
+				tx.DeleteIfEmpty(k)
+				return nil
+			}
+			return nil
+		})
+		if err != nil {
+			return blockedResult{reply: errorReply(err)}, true
+		}
+		if len(popped) == 0 {
+			return blockedResult{}, false
+		}
+		return blockedResult{
+			reply:     resp.ArrayValue(resp.BulkValue(key), resp.BulkValue(popped[0].Member), resp.DoubleValue(popped[0].Score)),
+			propagate: []string{strings.TrimPrefix(name, "b"), key},
+		}, true
+	})
+}
+
+// Reads a sorted set or a set as input of ZUNION, ZINTER and ZDIFF. Set
+// members count with a score of 1. A missing key gives nil.
+func zsetInput(tx *storage.Tx, key string) (map[string]float64, error) {
+	e := tx.Lookup(key)
+	if e == nil {
+		return nil, nil
+	}
+	res := make(map[string]float64)
+	switch e.Type {
+	case storage.TypeZSet:
+		e.Value.(*storage.ZSet).Each(func(m string, score float64) bool {
+			res[m] = score
+			return true
+		})
+	case storage.TypeSet:
+		for _, m := range e.Value.(*storage.Set).Members() {
+			res[m] = 1
+		}
+	default:
+		return nil, storage.ErrWrongType
+	}
+	return res, nil
+}
+
+// Combines the scores of a member present in several inputs
+func aggregate(how string, a, b float64) float64 {
+	switch how {
+	case "min":
+		return math.Min(a, b)
+	case "max":
+		return math.Max(a, b)
+	}
+	sum := a + b
+	// inf + -inf
+	if math.IsNaN(sum) {
+		return 0
+	}
+	return sum
+}
+
+// Computes the union, intersection or difference of the inputs, sorted by
+// score.
+func zsetAlgebra(tx *storage.Tx, op string, keys []string, weights []float64, how string) ([]storage.ZMember, error) {
+	inputs := make([]map[string]float64, len(keys))
+	for i, key := range keys {
+		in, err := zsetInput(tx, key)
+		if err != nil {
+			return nil, err
+		}
+		inputs[i] = in
+	}
+	weighted := func(i int, score float64) float64 {
+		w := score * weights[i]
+		if math.IsNaN(w) {
+			return 0
+		}
+		return w
+	}
+	res := make(map[string]float64)
+	switch op {
+	case "zunion":
+		for i, in := range inputs {
+			for m, score := range in {
+				if cur, ok := res[m]; ok {
+					res[m] = aggregate(how, cur, weighted(i, score))
+				} else {
+					res[m] = weighted(i, score)
+				}
+			}
+		}
+	case "zinter":
+	members:
+		for m, score := range inputs[0] {
+			total := weighted(0, score)
+			for i, in := range inputs[1:] {
+				s, ok := in[m]
+				if !ok {
+					continue members
+				}
+				total = aggregate(how, total, weighted(i+1, s))
+			}
+			res[m] = total
+		}
+	case "zdiff":
+	diff:
+		for m, score := range inputs[0] {
+			for _, in := range inputs[1:] {
+				if _, ok := in[m]; ok {
+					continue diff
+				}
+			}
+			res[m] = score
+		}
+	}
+	members := make([]storage.ZMember, 0, len(res))
+	for m, score := range res {
+		members = append(members, storage.ZMember{Member: m, Score: score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		a, b := members[i], members[j]
+		return a.Score < b.Score || (a.Score == b.Score && a.Member < b.Member)
+	})
+	return members, nil
+}
+
+// Handles ZUNION, ZINTER and ZDIFF and their *STORE variants:
+//
+//	ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM | MIN | MAX]
+//	ZUNION numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM | MIN | MAX] [WITHSCORES]
+//
+// ZDIFF and ZDIFFSTORE take neither WEIGHTS nor AGGREGATE.
+func (c *core) zsetAlgebraCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	name := strings.ToLower(args[0])
+	op := strings.TrimSuffix(name, "store")
+	var dst string
+	rest := args[1:]
+	if op != name {
+		dst = rest[0]
+		rest = rest[1:]
+	}
+	numkeys, ok := parseInt(rest[0])
+	if !ok {
+		return notInteger()
+	}
+	if numkeys < 1 {
+		return resp.Errorf("at least 1 input key is needed for '%s' command", name)
+	}
+	if numkeys > int64(len(rest)-1) {
+		return syntaxError()
+	}
+	keys := rest[1 : 1+numkeys]
+	opts := rest[1+numkeys:]
+	weights := make([]float64, len(keys))
+	for i := range weights {
+		weights[i] = 1
+	}
+	how := "sum"
+	withScores := false
+	for i := 0; i < len(opts); i++ {
+		opt := strings.ToLower(opts[i])
+		switch {
+		case opt == "weights" && op != "zdiff" && i+len(keys) < len(opts):
+			for j := range weights {
+				w, ok := parseFloat(opts[i+1+j])
+				if !ok {
+					return resp.Errorf("weight value is not a float")
+				}
+				weights[j] = w
+			}
+			i += len(keys)
+		case opt == "aggregate" && op != "zdiff" && i+1 < len(opts):
+			how = strings.ToLower(opts[i+1])
+			if how != "sum" && how != "min" && how != "max" {
+				return syntaxError()
+			}
+			i++
+		case opt == "withscores" && dst == "":
+			withScores = true
+		default:
+			return syntaxError()
+		}
+	}
+
+	if dst == "" {
+		var members []storage.ZMember
+		err := c.db(cl).View(keys, func(tx *storage.Tx) (err error) {
+			members, err = zsetAlgebra(tx, op, keys, weights, how)
+			return err
+		})
+		if err != nil {
+			return errorReply(err)
+		}
+		return zmembersReply(cl, members, withScores)
+	}
+	var n int
+	var changed bool
+	err := c.db(cl).Update(append([]string{dst}, keys...), func(tx *storage.Tx) error {
+		members, err := zsetAlgebra(tx, op, keys, weights, how)
+		if err != nil {
+			return err
+		}
+		n, changed = storeZSet(tx, dst, members)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if n > 0 {
+		c.signalKeyAsReady(cl, dst)
+	}
+	if !changed {
+		cl.propagateAs = []string{}
+	}
+	return resp.IntegerValue(int64(n))
+}
+
+// Handles "ZSCAN key cursor [MATCH pattern] [COUNT count]". Scores are
+// replied as bulk strings, in RESP3 too.
+func (c *core) zscanCommand(ctx context.Context, cl *Client, args []string) resp.Value {
+	cursor, ok := parseScanCursor(args[2])
+	if !ok {
+		return resp.Errorf("invalid cursor")
+	}
+	opts, errReply, ok := parseScanOptions(args[3:], false, false)
+	if !ok {
+		return errReply
+	}
+	var items []resp.Value
+	var next uint64
+	err := c.viewZSet(cl, args[1], func(z *storage.ZSet) {
+		if z == nil {
+			return
+		}
+		next = scanElements(cursor, opts.count, z.Scan, func(m string) {
+			if !opts.matches(m) {
+				return
+			}
+			score, _ := z.Score(m)
+			items = append(items, resp.BulkValue(m), resp.BulkValue(resp.FormatDouble(score)))
+		})
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return scanReply(next, items)
+}
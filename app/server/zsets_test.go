@@ -0,0 +1,106 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestZSetCommands(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"zadd", "z", "1", "a", "2", "b", "3", "c"}, ":3\r\n"},
+		{[]string{"object", "encoding", "z"}, "$8\r\nlistpack\r\n"},
+		{[]string{"zadd", "z", "xx", "nx", "1", "a"}, "-ERR XX and NX options at the same time are not compatible\r\n"},
+		{[]string{"zadd", "z", "gt", "lt", "1", "a"}, "-ERR GT, LT, and/or NX options at the same time are not compatible\r\n"},
+		{[]string{"zadd", "z", "incr", "1", "a", "2", "b"}, "-ERR INCR option supports a single increment-element pair\r\n"},
+		{[]string{"zadd", "z", "x", "a"}, "-ERR value is not a valid float\r\n"},
+		{[]string{"zadd", "z", "1", "a", "2"}, "-ERR syntax error\r\n"},
+		{[]string{"zadd", "z", "ch", "gt", "0", "a", "5", "b", "4", "d"}, ":2\r\n"},
+		{[]string{"zadd", "z", "nx", "incr", "1", "a"}, "$-1\r\n"},
+		{[]string{"zadd", "missing", "xx", "1", "a"}, ":0\r\n"},
+		{[]string{"type", "missing"}, "+none\r\n"},
+		{[]string{"zincrby", "z", "0.5", "a"}, "$3\r\n1.5\r\n"},
+		{[]string{"zadd", "z", "incr", "+inf", "a"}, "$3\r\ninf\r\n"},
+		{[]string{"zadd", "z", "incr", "-inf", "a"}, "-ERR resulting score is not a number (NaN)\r\n"},
+		{[]string{"zadd", "z", "1", "a"}, ":0\r\n"},
+		{[]string{"zrange", "z", "0", "-1", "withscores"}, "*8\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\nd\r\n$1\r\n4\r\n$1\r\nb\r\n$1\r\n5\r\n"},
+		{[]string{"zrevrange", "z", "0", "1"}, "*2\r\n$1\r\nb\r\n$1\r\nd\r\n"},
+		{[]string{"zrange", "z", "(3", "+inf", "byscore"}, "*2\r\n$1\r\nd\r\n$1\r\nb\r\n"},
+		{[]string{"zrange", "z", "+inf", "-inf", "byscore", "rev", "limit", "1", "2"}, "*2\r\n$1\r\nd\r\n$1\r\nc\r\n"},
+		{[]string{"zrange", "z", "0", "1", "limit", "0", "1"}, "-ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX\r\n"},
+		{[]string{"zrange", "z", "-", "+", "bylex", "withscores"}, "-ERR syntax error, WITHSCORES not supported in combination with BYLEX\r\n"},
+		{[]string{"zrangebyscore", "z", "x", "1"}, "-ERR min or max is not a float\r\n"},
+		{[]string{"zrevrangebyscore", "z", "3", "1", "withscores"}, "*4\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{[]string{"zcount", "z", "(1", "4"}, ":2\r\n"},
+		{[]string{"zscore", "z", "d"}, "$1\r\n4\r\n"},
+		{[]string{"zmscore", "z", "d", "x"}, "*2\r\n$1\r\n4\r\n$-1\r\n"},
+		{[]string{"zrank", "z", "d"}, ":2\r\n"},
+		{[]string{"zrevrank", "z", "d", "withscore"}, "*2\r\n:1\r\n$1\r\n4\r\n"},
+		{[]string{"zrank", "z", "x", "withscore"}, "*-1\r\n"},
+		{[]string{"zrank", "z", "x"}, "$-1\r\n"},
+		{[]string{"zrangestore", "dst", "z", "1", "2"}, ":2\r\n"},
+		{[]string{"zrange", "dst", "0", "-1"}, "*2\r\n$1\r\nc\r\n$1\r\nd\r\n"},
+		{[]string{"zrangestore", "dst", "z", "9", "10"}, ":0\r\n"},
+		{[]string{"type", "dst"}, "+none\r\n"},
+		{[]string{"zrem", "z", "a", "x"}, ":1\r\n"},
+		{[]string{"zremrangebyrank", "z", "-1", "-1"}, ":1\r\n"},
+		{[]string{"zremrangebyscore", "z", "-inf", "(4"}, ":1\r\n"},
+		{[]string{"zcard", "z"}, ":1\r\n"},
+		{[]string{"zpopmin", "z", "-1"}, "-ERR value is out of range, must be positive\r\n"},
+		{[]string{"zpopmax", "z"}, "*2\r\n$1\r\nd\r\n$1\r\n4\r\n"},
+		{[]string{"zpopmin", "z"}, "*0\r\n"},
+		{[]string{"type", "z"}, "+none\r\n"},
+		{[]string{"zadd", "l", "0", "a", "0", "b", "0", "c", "0", "d"}, ":4\r\n"},
+		{[]string{"zrangebylex", "l", "(a", "[c"}, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{[]string{"zrevrangebylex", "l", "+", "-", "limit", "0", "1"}, "*1\r\n$1\r\nd\r\n"},
+		{[]string{"zlexcount", "l", "-", "(c"}, ":2\r\n"},
+		{[]string{"zlexcount", "l", "a", "c"}, "-ERR min or max not valid string range item\r\n"},
+		{[]string{"zremrangebylex", "l", "[b", "+"}, ":3\r\n"},
+		{[]string{"bzpopmin", "missing", "l", "0"}, "*3\r\n$1\r\nl\r\n$1\r\na\r\n$1\r\n0\r\n"},
+		{[]string{"bzpopmax", "missing", "0.01"}, "*-1\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+}
+
+func TestZSetAlgebra(t *testing.T) {
+	ms := newTestMaster()
+	cl := newTestClient()
+	do(ms, cl, "zadd", "a", "1", "x", "2", "y")
+	do(ms, cl, "zadd", "b", "3", "y", "4", "z")
+	do(ms, cl, "sadd", "s", "x")
+	steps := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"zunion", "2", "a", "b", "withscores"}, "*6\r\n$1\r\nx\r\n$1\r\n1\r\n$1\r\nz\r\n$1\r\n4\r\n$1\r\ny\r\n$1\r\n5\r\n"},
+		{[]string{"zinter", "2", "a", "b", "aggregate", "max", "withscores"}, "*2\r\n$1\r\ny\r\n$1\r\n3\r\n"},
+		{[]string{"zinter", "2", "a", "b", "weights", "2", "0.5", "withscores"}, "*2\r\n$1\r\ny\r\n$3\r\n5.5\r\n"},
+		{[]string{"zdiff", "2", "a", "b"}, "*1\r\n$1\r\nx\r\n"},
+		{[]string{"zinter", "2", "a", "s", "withscores"}, "*2\r\n$1\r\nx\r\n$1\r\n2\r\n"},
+		{[]string{"zunionstore", "dst", "2", "a", "b", "aggregate", "min"}, ":3\r\n"},
+		{[]string{"zrange", "dst", "0", "-1", "withscores"}, "*6\r\n$1\r\nx\r\n$1\r\n1\r\n$1\r\ny\r\n$1\r\n2\r\n$1\r\nz\r\n$1\r\n4\r\n"},
+		{[]string{"zinterstore", "dst", "2", "a", "missing"}, ":0\r\n"},
+		{[]string{"type", "dst"}, "+none\r\n"},
+		{[]string{"zdiffstore", "dst", "1", "b"}, ":2\r\n"},
+		{[]string{"zunion", "0", "a"}, "-ERR at least 1 input key is needed for 'zunion' command\r\n"},
+		{[]string{"zunion", "3", "a", "b"}, "-ERR syntax error\r\n"},
+		{[]string{"zunion", "2", "a", "b", "weights", "1", "x"}, "-ERR weight value is not a float\r\n"},
+		{[]string{"zdiff", "2", "a", "b", "aggregate", "sum"}, "-ERR syntax error\r\n"},
+		{[]string{"set", "str", "v"}, "+OK\r\n"},
+		{[]string{"zunion", "2", "a", "str"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	}
+	for _, s := range steps {
+		got := do(ms, cl, s.args...)
+		if got != s.expected {
+			t.Errorf("%q: unexpected reply. Expected: %q, Got: %q", s.args, s.expected, got)
+		}
+	}
+}
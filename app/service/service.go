@@ -11,13 +11,13 @@ type ServerService struct {
 	sv server.Server
 }
 
-func NewServerService(port int, logger slog.Logger, kv storage.KeyValue, replica server.Replica) *ServerService {
+func NewServerService(port int, logger slog.Logger, dbs []*storage.KeyValue, replica server.Replica, persistence server.Persistence) *ServerService {
 	var s server.Server
-	cfg := server.NewConfig(port, &logger, kv, replica)
+	cfg := server.NewConfig(port, &logger, dbs, replica, persistence)
 	if replica.MasterHost != "" && replica.MasterPort != "" {
-		s = *server.NewSlaveServer(cfg)
+		s = server.NewSlaveServer(cfg)
 	} else {
-		s = *server.NewMasterServer(cfg)
+		s = server.NewMasterServer(cfg)
 	}
 	return &ServerService{
 		sv: s,
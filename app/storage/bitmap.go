@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Bitmaps are plain string values. Bit 0 is the most significant bit of the
+// first byte, and bits past the end of the string read as 0.
+
+// GetBit returns the bit of s at offset.
+func GetBit(s string, offset int64) int {
+	i := offset >> 3
+	if i >= int64(len(s)) {
+		return 0
+	}
+	return int(s[i]>>(7-offset&7)) & 1
+}
+
+// Grows b with zero bytes to hold at least n bytes
+func growBytes(b []byte, n int64) []byte {
+	if int64(len(b)) >= n {
+		return b
+	}
+	return append(b, make([]byte, n-int64(len(b)))...)
+}
+
+// SetBit sets the bit of b at offset, growing b as needed, and returns the
+// updated bytes and the previous bit.
+func SetBit(b []byte, offset int64, bit int) ([]byte, int) {
+	b = growBytes(b, offset>>3+1)
+	i, mask := offset>>3, byte(1)<<(7-offset&7)
+	old := 0
+	if b[i]&mask != 0 {
+		old = 1
+	}
+	if bit == 1 {
+		b[i] |= mask
+	} else {
+		b[i] &^= mask
+	}
+	return b, old
+}
+
+// BitCount returns the number of set bits of s from bit start to bit end,
+// both inclusive and within the string.
+func BitCount(s string, start, end int64) int64 {
+	if start > end {
+		return 0
+	}
+	first, last := start>>3, end>>3
+	var n int
+	for i := first; i <= last; i++ {
+		b := s[i]
+		if i == first {
+			b &= 0xff >> (start & 7)
+		}
+		if i == last {
+			b &= 0xff << (7 - end&7)
+		}
+		n += bits.OnesCount8(b)
+	}
+	return int64(n)
+}
+
+// BitPos returns the offset of the first bit set to bit in s from bit start
+// to bit end, both inclusive and within the string, or -1. When looking for
+// a 0 in a range that runs to the end of the string, the bit right after
+// the string counts as a 0.
+func BitPos(s string, bit int, start, end int64, toEnd bool) int64 {
+	for i := start; i <= end; {
+		b := s[i>>3]
+		if bit == 0 {
+			b = ^b
+		}
+		// Skip whole bytes without a match
+		if i&7 == 0 && i+7 <= end && b == 0 {
+			i += 8
+			continue
+		}
+		if int(b>>(7-i&7))&1 == 1 {
+			return i
+		}
+		i++
+	}
+	if bit == 0 && toEnd {
+		return end + 1
+	}
+	return -1
+}
+
+// BitOp combines the sources bit by bit with AND, OR, XOR or NOT, which
+// takes a single source. Shorter sources are padded with zero bytes. The
+// result is as long as the longest source.
+func BitOp(op string, srcs []string) string {
+	n := 0
+	for _, s := range srcs {
+		n = max(n, len(s))
+	}
+	res := make([]byte, n)
+	if op == "not" {
+		for i := range res {
+			res[i] = ^srcs[0][i]
+		}
+		return string(res)
+	}
+	for i := range res {
+		var acc byte
+		for j, s := range srcs {
+			var b byte
+			if i < len(s) {
+				b = s[i]
+			}
+			switch {
+			case j == 0:
+				acc = b
+			case op == "and":
+				acc &= b
+			case op == "or":
+				acc |= b
+			case op == "xor":
+				acc ^= b
+			}
+		}
+		res[i] = acc
+	}
+	return string(res)
+}
+
+// BitfieldType is the integer type of a BITFIELD operation: signed with 1
+// to 64 bits or unsigned with 1 to 63 bits.
+type BitfieldType struct {
+	Signed bool
+	Bits   int
+}
+
+// Overflow behaviors of BITFIELD SET and INCRBY
+type BitfieldOverflow int
+
+const (
+	// Wrap around, like integer arithmetic in C
+	OverflowWrap BitfieldOverflow = iota
+	// Saturate at the smallest or greatest value
+	OverflowSat
+	// Leave the value unchanged and report a failure
+	OverflowFail
+)
+
+// GetBitfield reads the integer of type t stored at bit offset of s.
+func GetBitfield(s string, offset int64, t BitfieldType) int64 {
+	var v uint64
+	for i := int64(0); i < int64(t.Bits); i++ {
+		v = v<<1 | uint64(GetBit(s, offset+i))
+	}
+	if t.Signed && t.Bits < 64 && v&(1<<(t.Bits-1)) != 0 {
+		// Sign extension
+		v |= math.MaxUint64 << t.Bits
+	}
+	return int64(v)
+}
+
+// SetBitfield writes the low bits of v as an integer of type t at bit
+// offset of b, growing b as needed.
+func SetBitfield(b []byte, offset int64, t BitfieldType, v int64) []byte {
+	b = growBytes(b, (offset+int64(t.Bits)+7)>>3)
+	for i := 0; i < t.Bits; i++ {
+		bit := int(uint64(v)>>(t.Bits-1-i)) & 1
+		b, _ = SetBit(b, offset+int64(i), bit)
+	}
+	return b
+}
+
+// Limits of the values of t
+func (t BitfieldType) limits() (lo, hi int64) {
+	if t.Signed {
+		hi = int64(uint64(1)<<(t.Bits-1) - 1)
+		return -hi - 1, hi
+	}
+	return 0, int64(uint64(1)<<t.Bits - 1)
+}
+
+// Truncates v to the width of t
+func (t BitfieldType) wrap(v int64) int64 {
+	if t.Bits == 64 {
+		return v
+	}
+	u := uint64(v) & (uint64(1)<<t.Bits - 1)
+	if t.Signed && u&(1<<(t.Bits-1)) != 0 {
+		u |= math.MaxUint64 << t.Bits
+	}
+	return int64(u)
+}
+
+// Add returns old + incr as a value of type t, handling overflow as
+// requested. ok is false when the result overflowed and the overflow
+// behavior is OverflowFail.
+func (t BitfieldType) Add(old, incr int64, overflow BitfieldOverflow) (int64, bool) {
+	lo, hi := t.limits()
+	over, under := false, false
+	if t.Signed {
+		// Within int64 the sum is exact unless it overflows int64 itself
+		sum := old + incr
+		switch {
+		case incr > 0 && (sum < old || sum > hi):
+			over = true
+		case incr < 0 && (sum > old || sum < lo):
+			under = true
+		}
+	} else {
+		// Unsigned values are below 2^63, so the sum can't overflow uint64
+		sum := int64(uint64(old) + uint64(incr))
+		switch {
+		case incr > 0 && (sum < 0 || sum > hi):
+			over = true
+		case incr < 0 && uint64(-incr) > uint64(old):
+			under = true
+		}
+	}
+	if !over && !under {
+		return old + incr, true
+	}
+	switch overflow {
+	case OverflowSat:
+		if over {
+			return hi, true
+		}
+		return lo, true
+	case OverflowFail:
+		return 0, false
+	}
+	return t.wrap(old + incr), true
+}
+
+// Fit returns v as a value of type t, handling a value out of the range of
+// t like an overflow of Add.
+func (t BitfieldType) Fit(v int64, overflow BitfieldOverflow) (int64, bool) {
+	lo, hi := t.limits()
+	if v >= lo && v <= hi {
+		return v, true
+	}
+	switch overflow {
+	case OverflowSat:
+		if v > hi {
+			return hi, true
+		}
+		return lo, true
+	case OverflowFail:
+		return 0, false
+	}
+	return t.wrap(v), true
+}
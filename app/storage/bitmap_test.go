@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBits(t *testing.T) {
+	b, old := SetBit(nil, 9, 1)
+	if old != 0 || string(b) != "\x00\x40" {
+		t.Errorf("Unexpected bytes after setting bit 9: %q", b)
+	}
+	if b, old = SetBit(b, 9, 0); old != 1 || string(b) != "\x00\x00" {
+		t.Errorf("Unexpected bytes after clearing bit 9: %q, old: %d", b, old)
+	}
+	s := "\xff\xf0\x00"
+	if GetBit(s, 11) != 1 || GetBit(s, 12) != 0 || GetBit(s, 100) != 0 {
+		t.Error("Unexpected bits read")
+	}
+	if n := BitCount(s, 4, 13); n != 8 {
+		t.Errorf("Unexpected count of bits 4 to 13: %d", n)
+	}
+	cases := []struct {
+		bit        int
+		start, end int64
+		toEnd      bool
+		expected   int64
+	}{
+		{0, 0, 23, true, 12},
+		{1, 12, 23, false, -1},
+		{0, 0, 7, false, -1},
+		{0, 0, 7, true, 8},
+		{1, 3, 23, false, 3},
+	}
+	for _, c := range cases {
+		if got := BitPos(s, c.bit, c.start, c.end, c.toEnd); got != c.expected {
+			t.Errorf("Unexpected position of %d from %d to %d: %d", c.bit, c.start, c.end, got)
+		}
+	}
+}
+
+func TestBitOp(t *testing.T) {
+	srcs := []string{"\xf0\x0f", "\x3c"}
+	cases := map[string]string{
+		"and": "\x30\x00",
+		"or":  "\xfc\x0f",
+		"xor": "\xcc\x0f",
+		"not": "\x0f\xf0",
+	}
+	for op, expected := range cases {
+		if got := BitOp(op, srcs); got != expected {
+			t.Errorf("Unexpected result of %s: %q", op, got)
+		}
+	}
+}
+
+func TestBitfield(t *testing.T) {
+	i8 := BitfieldType{Signed: true, Bits: 8}
+	u4 := BitfieldType{Bits: 4}
+	b := SetBitfield(nil, 4, i8, -2)
+	if string(b) != "\x0f\xe0" || GetBitfield(string(b), 4, i8) != -2 || GetBitfield(string(b), 4, u4) != 15 {
+		t.Errorf("Unexpected bitfield bytes: %q", b)
+	}
+	i64 := BitfieldType{Signed: true, Bits: 64}
+	if got := GetBitfield(string(SetBitfield(nil, 3, i64, math.MinInt64)), 3, i64); got != math.MinInt64 {
+		t.Errorf("Unexpected i64 read back: %d", got)
+	}
+
+	cases := []struct {
+		typ       BitfieldType
+		old, incr int64
+		overflow  BitfieldOverflow
+		expected  int64
+		ok        bool
+	}{
+		{i8, 100, 27, OverflowWrap, 127, true},
+		{i8, 100, 28, OverflowWrap, -128, true},
+		{i8, 100, 28, OverflowSat, 127, true},
+		{i8, -100, -100, OverflowSat, -128, true},
+		{i8, 100, 28, OverflowFail, 0, false},
+		{u4, 3, -4, OverflowWrap, 15, true},
+		{u4, 3, -4, OverflowSat, 0, true},
+		{u4, 15, math.MaxInt64, OverflowSat, 15, true},
+		{i64, math.MaxInt64, 1, OverflowWrap, math.MinInt64, true},
+		{i64, math.MaxInt64, 1, OverflowSat, math.MaxInt64, true},
+		{BitfieldType{Bits: 63}, math.MaxInt64, math.MinInt64, OverflowFail, 0, false},
+	}
+	for _, c := range cases {
+		got, ok := c.typ.Add(c.old, c.incr, c.overflow)
+		if got != c.expected || ok != c.ok {
+			t.Errorf("%+v: %d + %d: unexpected result %d, %v", c.typ, c.old, c.incr, got, ok)
+		}
+	}
+	if v, ok := u4.Fit(-1, OverflowWrap); !ok || v != 15 {
+		t.Errorf("Unexpected fit of -1 in u4: %d", v)
+	}
+}
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ValueType is the data type of a stored value, as reported by TYPE.
+type ValueType uint8
+
+const (
+	TypeString ValueType = iota
+	TypeList
+	TypeSet
+	TypeZSet
+	TypeHash
+	TypeStream
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeList:
+		return "list"
+	case TypeSet:
+		return "set"
+	case TypeZSet:
+		return "zset"
+	case TypeHash:
+		return "hash"
+	case TypeStream:
+		return "stream"
+	}
+	return "unknown"
+}
+
+// ParseValueType returns the type of the name TYPE reports for it.
+func ParseValueType(name string) (ValueType, bool) {
+	for t := TypeString; t <= TypeStream; t++ {
+		if strings.EqualFold(name, t.String()) {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// Redis' names for the internal representations of values
+const (
+	EncodingRaw       = "raw"
+	EncodingInt       = "int"
+	EncodingEmbStr    = "embstr"
+	EncodingListpack  = "listpack"
+	EncodingQuicklist = "quicklist"
+	EncodingIntset    = "intset"
+	EncodingHashtable = "hashtable"
+	EncodingSkiplist  = "skiplist"
+	EncodingStream    = "stream"
+)
+
+// Strings up to this length are "embstr" encoded in Redis
+const embstrSizeLimit = 44
+
+// Encoder is implemented by every non string value, it reports which
+// internal representation the value currently uses.
+type Encoder interface {
+	Encoding() string
+}
+
+var ErrWrongType = &StorageError{"WRONGTYPE Operation against a key holding the wrong kind of value"}
+
+// Entry is a single key of the keyspace: its typed value, expiry and the
+// access statistics reported by OBJECT IDLETIME and OBJECT FREQ.
+type Entry struct {
+	Type ValueType
+	// string for TypeString, otherwise a pointer to the type's structure
+	Value any
+	// Unix time in milliseconds, 0 when the key has no TTL
+	expireAt int64
+	// Unix time in milliseconds of the last access
+	access atomic.Int64
+	// Logarithmic access frequency counter, see touch
+	freq atomic.Uint32
+	// Snapshots taken before the entry was created, see Snapshot
+	gen uint64
+}
+
+func newEntry(typ ValueType, value any, now int64) *Entry {
+	e := &Entry{Type: typ, Value: value, gen: entryGen.Load()}
+	e.access.Store(now)
+	e.freq.Store(lfuInitVal)
+	return e
+}
+
+// ExpireAt returns the unix time in milliseconds the key expires at, or 0.
+func (e *Entry) ExpireAt() int64 {
+	return e.expireAt
+}
+
+// Clone returns a deep copy of the entry with the same TTL, as a new key
+// accessed at now.
+func (e *Entry) Clone(now int64) *Entry {
+	var v any
+	switch val := e.Value.(type) {
+	case *List:
+		v = val.Clone()
+	case *Hash:
+		v = val.Clone()
+	case *Set:
+		v = val.Clone()
+	case *ZSet:
+		v = val.Clone()
+	case *Stream:
+		v = val.Clone()
+	default:
+		// Strings are immutable
+		v = val
+	}
+	c := newEntry(e.Type, v, now)
+	c.expireAt = e.expireAt
+	return c
+}
+
+func (e *Entry) Encoding() string {
+	if e.Type == TypeString {
+		return stringEncoding(e.Value.(string))
+	}
+	if enc, ok := e.Value.(Encoder); ok {
+		return enc.Encoding()
+	}
+	return EncodingRaw
+}
+
+func stringEncoding(s string) string {
+	if len(s) <= 20 {
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return EncodingInt
+		}
+	}
+	if len(s) <= embstrSizeLimit {
+		return EncodingEmbStr
+	}
+	return EncodingRaw
+}
+
+// IdleTime returns the milliseconds passed since the key was last accessed.
+func (e *Entry) IdleTime(now int64) int64 {
+	return max(now-e.access.Load(), 0)
+}
+
+// Freq returns the logarithmic access frequency counter, decayed to now.
+func (e *Entry) Freq(now int64) uint32 {
+	return lfuDecay(e.freq.Load(), e.access.Load(), now)
+}
+
+const (
+	lfuInitVal   = 5
+	lfuLogFactor = 10
+	// Minutes without access that decrement the counter by one
+	lfuDecayTime = 1
+)
+
+// Records an access to the entry, updating its LFU counter the way Redis
+// does: the counter decays with the time since the previous access, then is
+// incremented with a probability that falls as it grows.
+func (e *Entry) touch(now int64) {
+	counter := lfuDecay(e.freq.Load(), e.access.Load(), now)
+	if counter < 255 {
+		base := float64(counter) - lfuInitVal
+		if base < 0 {
+			base = 0
+		}
+		if rand.Float64() < 1.0/(base*lfuLogFactor+1) {
+			counter++
+		}
+	}
+	e.freq.Store(counter)
+	e.access.Store(now)
+}
+
+func lfuDecay(counter uint32, access, now int64) uint32 {
+	periods := uint32((now - access) / 60000 / lfuDecayTime)
+	if periods >= counter {
+		return 0
+	}
+	return counter - periods
+}
@@ -0,0 +1,87 @@
+package storage
+
+import "time"
+
+const (
+	// Keys with a TTL sampled from a shard in one round of the active cycle
+	activeExpireSample = 20
+	// Another round is done on the shard while more than this percentage
+	// of the sample turned out to be expired
+	activeExpireAcceptable = 25
+	// Time a single cycle may spend, so it never stalls clients for long
+	activeExpireBudget = 25 * time.Millisecond
+)
+
+// Current unix time in milliseconds, replaced in tests
+var nowMs = func() int64 {
+	return time.Now().UnixMilli()
+}
+
+// ActiveExpireCycle deletes keys whose TTL is over but that nobody accessed.
+// Like Redis, it samples keys with a TTL from every shard and keeps going on
+// a shard while a large part of the sample was expired, within a time budget.
+// It returns the number of deleted keys.
+func (kv *KeyValue) ActiveExpireCycle() int {
+	start := time.Now()
+	deleted := 0
+	for _, sh := range kv.shards {
+		if time.Since(start) > activeExpireBudget {
+			break
+		}
+		sh.mu.Lock()
+		for {
+			now := nowMs()
+			sampled, expired := 0, 0
+			// Map iteration starts at a random position, which gives
+			// the random sample
+			for key, e := range sh.expires {
+				if sampled == activeExpireSample {
+					break
+				}
+				sampled++
+				if e.expireAt <= now {
+					kv.expire(sh, key)
+					expired++
+				}
+			}
+			deleted += expired
+			if sampled == 0 || expired*100 <= sampled*activeExpireAcceptable || time.Since(start) > activeExpireBudget {
+				break
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return deleted
+}
+
+// Expires returns the number of keys that have a TTL.
+func (kv *KeyValue) Expires() int {
+	n := 0
+	for _, sh := range kv.shards {
+		sh.mu.RLock()
+		n += len(sh.expires)
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
+// AvgTTL returns the average time to live in milliseconds of the keys that
+// have a TTL, or 0 when none has.
+func (kv *KeyValue) AvgTTL() int64 {
+	var sum, n int64
+	now := nowMs()
+	for _, sh := range kv.shards {
+		sh.mu.RLock()
+		for _, e := range sh.expires {
+			if ttl := e.expireAt - now; ttl > 0 {
+				sum += ttl
+				n++
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / n
+}
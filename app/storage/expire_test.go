@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func withClock(t *testing.T, now *int64) {
+	orig := nowMs
+	nowMs = func() int64 { return *now }
+	t.Cleanup(func() { nowMs = orig })
+}
+
+func TestExpire_OverwriteDropsTTL(t *testing.T) {
+	now := int64(1000)
+	withClock(t, &now)
+	kv := NewKeyValue()
+	kv.SetVariable("k", "v1", map[string]string{"px": "100"})
+	kv.SetVariable("k", "v2", nil)
+	now += 200
+	if v, err := kv.GetVariable("k"); err != nil || v != "v2" {
+		t.Errorf("Key overwritten without TTL must not expire, got %q, %v", v, err)
+	}
+}
+
+func TestExpire_Lazy(t *testing.T) {
+	now := int64(1000)
+	withClock(t, &now)
+	kv := NewKeyValue()
+	kv.SetVariable("k", "v", map[string]string{"px": "100"})
+	now += 99
+	if _, err := kv.GetVariable("k"); err != nil {
+		t.Errorf("Key expired too early")
+	}
+	now++
+	if _, err := kv.GetVariable("k"); err == nil {
+		t.Errorf("Expired key is still visible")
+	}
+}
+
+func TestExpire_ActiveCycle(t *testing.T) {
+	now := int64(1000)
+	withClock(t, &now)
+	kv := NewShardedKeyValue(1)
+	for i := 0; i < 1000; i++ {
+		kv.SetVariable(strconv.Itoa(i), "v", map[string]string{"px": "10"})
+	}
+	kv.SetVariable("persistent", "v", nil)
+	now += 10
+	deleted := kv.ActiveExpireCycle()
+	if deleted != 1000 || kv.Len() != 1 || kv.Expires() != 0 {
+		t.Errorf("Unexpected state after active expire: deleted %d, keys %d, expires %d", deleted, kv.Len(), kv.Expires())
+	}
+}
+
+func TestExpire_OnExpire(t *testing.T) {
+	now := int64(1000)
+	withClock(t, &now)
+	kv := NewShardedKeyValue(1)
+	var expired []string
+	kv.OnExpire(func(key string, fields []string) {
+		expired = append(expired, fmt.Sprint(key, fields))
+	})
+	kv.SetVariable("lazy", "v", map[string]string{"px": "10"})
+	kv.SetVariable("active", "v", map[string]string{"px": "10"})
+	kv.Update([]string{"h"}, func(tx *Tx) error {
+		h, _ := tx.Hash("h", true)
+		h.Set("a", "1")
+		h.Set("b", "2")
+		h.SetFieldExpireAt("a", 1010)
+		return nil
+	})
+	now += 10
+	// Reads only hide what expired
+	kv.View([]string{"lazy", "h"}, func(tx *Tx) error {
+		tx.Exists("lazy")
+		tx.Hash("h", false)
+		return nil
+	})
+	if len(expired) != 0 {
+		t.Fatalf("Reads deleted expired keys: %q", expired)
+	}
+	kv.Update([]string{"lazy", "h"}, func(tx *Tx) error {
+		tx.Exists("lazy")
+		tx.Hash("h", false)
+		return nil
+	})
+	kv.ActiveExpireCycle()
+	if !reflect.DeepEqual(expired, []string{"lazy[]", "h[a]", "active[]"}) {
+		t.Errorf("Unexpected expired keys: %q", expired)
+	}
+}
+
+func TestExpire_KeepExpired(t *testing.T) {
+	now := int64(1000)
+	withClock(t, &now)
+	kv := NewKeyValue()
+	kv.KeepExpired()
+	kv.SetVariable("k", "v", map[string]string{"px": "10"})
+	kv.Update([]string{"h"}, func(tx *Tx) error {
+		h, _ := tx.Hash("h", true)
+		h.Set("a", "1")
+		h.SetFieldExpireAt("a", 1010)
+		return nil
+	})
+	now += 10
+	kv.View([]string{"k", "h"}, func(tx *Tx) error {
+		if h, _ := tx.Hash("h", false); tx.Exists("k") || h != nil {
+			t.Error("Reads see keys whose TTL is over")
+		}
+		return nil
+	})
+	// Writes act like master did, which didn't delete them yet
+	kv.Update([]string{"k", "h"}, func(tx *Tx) error {
+		if h, _ := tx.Hash("h", false); !tx.Exists("k") || h == nil || !h.Exists("a") {
+			t.Error("Writes don't see keys whose TTL is over")
+		}
+		return nil
+	})
+	if kv.Len() != 2 {
+		t.Errorf("Expired keys were deleted, %d left", kv.Len())
+	}
+}
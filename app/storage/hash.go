@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"slices"
+)
+
+const (
+	// Hashes switch from the compact encoding to a map once they have more
+	// fields or longer values than this, like Redis' hash-max-listpack-*
+	hashMaxListpackEntries = 128
+	hashMaxListpackValue   = 64
+)
+
+type hashField struct {
+	name  string
+	value string
+	// Unix time in milliseconds, 0 when the field has no TTL
+	expireAt int64
+}
+
+// Hash maps fields to values. Small hashes keep their fields in a slice in
+// insertion order, like Redis' listpack encoding; they are converted to a
+// map once they grow. Fields may have their own TTL, see SetFieldExpireAt.
+type Hash struct {
+	// Fields of a compact hash, nil once converted to a map
+	list []hashField
+	dict map[string]*hashField
+	// The fields of dict again, for HSCAN
+	keys keyTable
+	// Number of fields with a TTL
+	volatile int
+}
+
+func NewHash() *Hash {
+	return &Hash{list: make([]hashField, 0, 4)}
+}
+
+func (h *Hash) Len() int {
+	if h.dict != nil {
+		return len(h.dict)
+	}
+	return len(h.list)
+}
+
+func (h *Hash) Encoding() string {
+	if h.dict != nil {
+		return EncodingHashtable
+	}
+	return EncodingListpack
+}
+
+func (h *Hash) find(field string) *hashField {
+	if h.dict != nil {
+		return h.dict[field]
+	}
+	for i := range h.list {
+		if h.list[i].name == field {
+			return &h.list[i]
+		}
+	}
+	return nil
+}
+
+func (h *Hash) convert() {
+	h.dict = make(map[string]*hashField, len(h.list))
+	for i := range h.list {
+		f := h.list[i]
+		h.dict[f.name] = &f
+		h.keys.add(f.name)
+	}
+	h.list = nil
+}
+
+func (h *Hash) Get(field string) (string, bool) {
+	f := h.find(field)
+	if f == nil {
+		return "", false
+	}
+	return f.value, true
+}
+
+func (h *Hash) Exists(field string) bool {
+	return h.find(field) != nil
+}
+
+// Set stores the value of field, dropping its TTL, and reports whether the
+// field is new.
+func (h *Hash) Set(field, value string) bool {
+	if f := h.find(field); f != nil {
+		f.value = value
+		if f.expireAt != 0 {
+			f.expireAt = 0
+			h.volatile--
+		}
+		return false
+	}
+	if h.dict == nil && (len(h.list) >= hashMaxListpackEntries ||
+		len(field) > hashMaxListpackValue || len(value) > hashMaxListpackValue) {
+		h.convert()
+	}
+	if h.dict != nil {
+		h.dict[field] = &hashField{name: field, value: value}
+		h.keys.add(field)
+	} else {
+		h.list = append(h.list, hashField{name: field, value: value})
+	}
+	return true
+}
+
+// Delete removes field and reports whether it existed.
+func (h *Hash) Delete(field string) bool {
+	if h.dict != nil {
+		f, ok := h.dict[field]
+		if ok {
+			h.dropVolatile(f)
+			delete(h.dict, field)
+			h.keys.remove(field)
+		}
+		return ok
+	}
+	for i := range h.list {
+		if h.list[i].name == field {
+			h.dropVolatile(&h.list[i])
+			h.list = append(h.list[:i], h.list[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Hash) dropVolatile(f *hashField) {
+	if f.expireAt != 0 {
+		h.volatile--
+	}
+}
+
+// Each calls fn for every field until fn returns false. Compact hashes are
+// visited in insertion order, others in no particular order.
+func (h *Hash) Each(fn func(field, value string) bool) {
+	if h.dict != nil {
+		for _, f := range h.dict {
+			if !fn(f.name, f.value) {
+				return
+			}
+		}
+		return
+	}
+	for _, f := range h.list {
+		if !fn(f.name, f.value) {
+			return
+		}
+	}
+}
+
+// Scan calls fn with the fields of one bucket and returns the cursor of the
+// next, 0 once all were visited. Fields that exist for the whole scan are
+// visited at least once, like by SCAN. Compact hashes are visited whole in
+// one call, which returns 0.
+func (h *Hash) Scan(cursor uint64, fn func(field string)) uint64 {
+	if h.dict != nil {
+		return h.keys.scan(cursor, fn)
+	}
+	for _, f := range h.list {
+		fn(f.name)
+	}
+	return 0
+}
+
+// FieldExpireAt returns the unix time in milliseconds field expires at, or
+// 0 when it has no TTL. ok is false when the field does not exist.
+func (h *Hash) FieldExpireAt(field string) (at int64, ok bool) {
+	f := h.find(field)
+	if f == nil {
+		return 0, false
+	}
+	return f.expireAt, true
+}
+
+// SetFieldExpireAt sets the expiry time of an existing field, 0 removes
+// its TTL. It reports whether the field exists.
+func (h *Hash) SetFieldExpireAt(field string, at int64) bool {
+	f := h.find(field)
+	if f == nil {
+		return false
+	}
+	if f.expireAt == 0 && at != 0 {
+		h.volatile++
+	} else if f.expireAt != 0 && at == 0 {
+		h.volatile--
+	}
+	f.expireAt = at
+	return true
+}
+
+func (h *Hash) hasExpired(now int64) bool {
+	if h.volatile == 0 {
+		return false
+	}
+	expired := false
+	h.eachField(func(f *hashField) bool {
+		expired = f.expireAt != 0 && f.expireAt <= now
+		return !expired
+	})
+	return expired
+}
+
+func (h *Hash) eachField(fn func(f *hashField) bool) {
+	if h.dict != nil {
+		for _, f := range h.dict {
+			if !fn(f) {
+				return
+			}
+		}
+		return
+	}
+	for i := range h.list {
+		if !fn(&h.list[i]) {
+			return
+		}
+	}
+}
+
+// Deletes the fields whose TTL is over and returns their names
+func (h *Hash) expireFields(now int64) []string {
+	var expired []string
+	h.eachField(func(f *hashField) bool {
+		if f.expireAt != 0 && f.expireAt <= now {
+			expired = append(expired, f.name)
+		}
+		return true
+	})
+	for _, name := range expired {
+		h.Delete(name)
+	}
+	return expired
+}
+
+// Clone returns a deep copy of the hash, field TTLs included.
+func (h *Hash) Clone() *Hash {
+	c := &Hash{volatile: h.volatile}
+	if h.dict == nil {
+		c.list = slices.Clone(h.list)
+		return c
+	}
+	c.dict = make(map[string]*hashField, len(h.dict))
+	for name, f := range h.dict {
+		cf := *f
+		c.dict[name] = &cf
+	}
+	c.keys = h.keys.clone()
+	return c
+}
+
+// Returns a copy of the hash without the fields whose TTL is over
+func (h *Hash) withoutExpired(now int64) *Hash {
+	c := NewHash()
+	if h.dict != nil {
+		c.convert()
+	}
+	h.eachField(func(f *hashField) bool {
+		if f.expireAt == 0 || f.expireAt > now {
+			c.Set(f.name, f.value)
+			c.SetFieldExpireAt(f.name, f.expireAt)
+		}
+		return true
+	})
+	return c
+}
+
+// Hash returns the hash stored at key. With create, a missing key is set to
+// a new empty hash; otherwise nil is returned for it. A key of another type
+// fails with ErrWrongType.
+//
+// Fields whose TTL is over are invisible: Update deletes them, View gets a
+// copy of the hash without them. Updates of a keyspace that keeps expired
+// keys see them.
+func (tx *Tx) Hash(key string, create bool) (*Hash, error) {
+	e := tx.lookupOwned(key, TypeHash)
+	if e != nil && e.Type != TypeHash {
+		return nil, ErrWrongType
+	}
+	var h *Hash
+	if e != nil {
+		h = e.Value.(*Hash)
+		if h.hasExpired(tx.now) && !(tx.writable && tx.kv.keepExpired) {
+			if tx.writable {
+				fields := h.expireFields(tx.now)
+				if tx.kv.onExpire != nil {
+					tx.kv.onExpire(key, fields)
+				}
+				tx.DeleteIfEmpty(key)
+			} else {
+				h = h.withoutExpired(tx.now)
+			}
+			if h.Len() == 0 {
+				h = nil
+			}
+		}
+	}
+	if h == nil && create {
+		h = NewHash()
+		tx.Put(key, TypeHash, h)
+	}
+	return h, nil
+}
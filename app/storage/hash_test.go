@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHash_Encoding(t *testing.T) {
+	h := NewHash()
+	for i := 0; i < hashMaxListpackEntries; i++ {
+		h.Set(strconv.Itoa(i), "v")
+	}
+	if h.Encoding() != EncodingListpack {
+		t.Errorf("Unexpected encoding. Expected: %q, Got: %q", EncodingListpack, h.Encoding())
+	}
+	h.Set("one-more", "v")
+	if h.Encoding() != EncodingHashtable || h.Len() != hashMaxListpackEntries+1 {
+		t.Errorf("Unexpected encoding after growing. Expected: %q, Got: %q", EncodingHashtable, h.Encoding())
+	}
+	if v, ok := h.Get("5"); !ok || v != "v" {
+		t.Errorf("Field lost by the conversion")
+	}
+
+	h = NewHash()
+	h.Set("f", strings.Repeat("x", hashMaxListpackValue+1))
+	if h.Encoding() != EncodingHashtable {
+		t.Errorf("Unexpected encoding for a long value. Expected: %q, Got: %q", EncodingHashtable, h.Encoding())
+	}
+}
+
+func TestHash_FieldExpiry(t *testing.T) {
+	now := int64(1000)
+	withClock(t, &now)
+	kv := NewKeyValue()
+	kv.Update([]string{"h"}, func(tx *Tx) error {
+		h, _ := tx.Hash("h", true)
+		h.Set("a", "1")
+		h.Set("b", "2")
+		h.SetFieldExpireAt("a", 1100)
+		return nil
+	})
+	now += 100
+	kv.View([]string{"h"}, func(tx *Tx) error {
+		h, _ := tx.Hash("h", false)
+		if h.Exists("a") || h.Len() != 1 {
+			t.Errorf("Expired field is still visible")
+		}
+		return nil
+	})
+	kv.Update([]string{"h"}, func(tx *Tx) error {
+		h, _ := tx.Hash("h", false)
+		h.SetFieldExpireAt("b", 1100)
+		return nil
+	})
+	kv.Update([]string{"h"}, func(tx *Tx) error {
+		if h, _ := tx.Hash("h", false); h != nil || tx.Exists("h") {
+			t.Errorf("Hash without fields left must be deleted")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"slices"
+)
+
+// Buckets of a keyTable when the first key is added
+const keyTableInitSize = 4
+
+// Seed of the hashes that place keys into the buckets of a keyTable
+var keySeed = maphash.MakeSeed()
+
+type keySlot struct {
+	hash uint64
+	key  string
+}
+
+// keyTable is a chained hash table of the keys of a shard, like Redis'
+// dict. The entries live in the shard's map; the table only exists so SCAN
+// can walk the keys bucket by bucket with a cursor. Hashes, sets and sorted
+// sets keep one of their members for HSCAN, SSCAN and ZSCAN the same way.
+//
+// The table grows and shrinks by powers of two. Like in Redis, the keys are
+// moved to the new table incrementally, a bucket on every change, so for a
+// while both tables are in use.
+type keyTable struct {
+	// tables[1] is only set while rehashing into it
+	tables [2][][]keySlot
+	used   [2]int
+	// Next bucket of tables[0] to move while rehashing
+	rehashIdx int
+}
+
+func (t *keyTable) rehashing() bool {
+	return t.tables[1] != nil
+}
+
+func (t *keyTable) len() int {
+	return t.used[0] + t.used[1]
+}
+
+// Starts rehashing into a table of size buckets
+func (t *keyTable) resize(size int) {
+	if len(t.tables[0]) == 0 {
+		t.tables[0] = make([][]keySlot, size)
+		return
+	}
+	t.tables[1] = make([][]keySlot, size)
+	t.rehashIdx = 0
+}
+
+// Moves the next non empty bucket to the new table, visiting at most ten
+// empty ones on the way, and finishes the rehash once all keys moved
+func (t *keyTable) rehashStep() {
+	if !t.rehashing() {
+		return
+	}
+	src, dst := t.tables[0], t.tables[1]
+	for empty := 10; t.rehashIdx < len(src) && len(src[t.rehashIdx]) == 0; empty-- {
+		if empty == 0 {
+			return
+		}
+		t.rehashIdx++
+	}
+	if t.rehashIdx < len(src) {
+		mask := uint64(len(dst) - 1)
+		for _, s := range src[t.rehashIdx] {
+			dst[s.hash&mask] = append(dst[s.hash&mask], s)
+		}
+		t.used[0] -= len(src[t.rehashIdx])
+		t.used[1] += len(src[t.rehashIdx])
+		src[t.rehashIdx] = nil
+		t.rehashIdx++
+	}
+	if t.used[0] == 0 {
+		t.tables[0], t.tables[1] = dst, nil
+		t.used[0], t.used[1] = t.used[1], 0
+	}
+}
+
+// Adds a key that is not in the table yet
+func (t *keyTable) add(key string) {
+	t.rehashStep()
+	if !t.rehashing() && t.used[0] >= len(t.tables[0]) {
+		t.resize(max(keyTableInitSize, 2*len(t.tables[0])))
+	}
+	i := 0
+	if t.rehashing() {
+		i = 1
+	}
+	h := maphash.String(keySeed, key)
+	b := &t.tables[i][h&uint64(len(t.tables[i])-1)]
+	*b = append(*b, keySlot{h, key})
+	t.used[i]++
+}
+
+func (t *keyTable) remove(key string) {
+	t.rehashStep()
+	h := maphash.String(keySeed, key)
+	for i := range t.tables {
+		if len(t.tables[i]) == 0 {
+			continue
+		}
+		b := &t.tables[i][h&uint64(len(t.tables[i])-1)]
+		for j, s := range *b {
+			if s.hash != h || s.key != key {
+				continue
+			}
+			last := len(*b) - 1
+			(*b)[j] = (*b)[last]
+			(*b)[last] = keySlot{}
+			*b = (*b)[:last]
+			t.used[i]--
+			t.shrinkIfSparse()
+			return
+		}
+	}
+}
+
+// Returns a copy of the table with the same buckets, so a cursor of the
+// original continues in the copy
+func (t *keyTable) clone() keyTable {
+	c := *t
+	for i, table := range t.tables {
+		if table == nil {
+			continue
+		}
+		c.tables[i] = make([][]keySlot, len(table))
+		for j, b := range table {
+			c.tables[i][j] = slices.Clone(b)
+		}
+	}
+	return c
+}
+
+// Shrinks the table once less than an eighth of its buckets is used
+func (t *keyTable) shrinkIfSparse() {
+	size := len(t.tables[0])
+	if t.rehashing() || size <= keyTableInitSize || t.used[0]*8 >= size {
+		return
+	}
+	t.resize(max(keyTableInitSize, 1<<bits.Len(uint(t.used[0]))))
+}
+
+// Calls fn with the keys of the bucket at cursor v and returns the cursor
+// of the next bucket, 0 when the scan is complete. This is Redis' dictScan:
+// the cursor is incremented in its reversed bits, so the buckets already
+// visited are the same in a larger or smaller table and keys are not missed
+// when the table is resized between calls. While rehashing, the bucket of
+// the smaller table is visited together with all buckets of the larger one
+// it expands to. fn must not modify the table.
+func (t *keyTable) scan(v uint64, fn func(key string)) uint64 {
+	if t.len() == 0 {
+		return 0
+	}
+	emit := func(b []keySlot) {
+		for _, s := range b {
+			fn(s.key)
+		}
+	}
+	next := func(v, mask uint64) uint64 {
+		// Set the bits above the mask, so incrementing the reversed
+		// cursor carries over them into the masked bits
+		v |= ^mask
+		return bits.Reverse64(bits.Reverse64(v) + 1)
+	}
+	small, large := t.tables[0], t.tables[1]
+	if !t.rehashing() {
+		m := uint64(len(small) - 1)
+		emit(small[v&m])
+		return next(v, m)
+	}
+	if len(small) > len(large) {
+		small, large = large, small
+	}
+	m0, m1 := uint64(len(small)-1), uint64(len(large)-1)
+	emit(small[v&m0])
+	for {
+		emit(large[v&m1])
+		v = next(v, m1)
+		if v&(m0^m1) == 0 {
+			return v
+		}
+	}
+}
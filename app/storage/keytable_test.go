@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Scans t calling change between calls, and checks every key in stable
+// was returned
+func scanWhileChanging(t *testing.T, tbl *keyTable, stable []string, change func(step int)) {
+	t.Helper()
+	seen := make(map[string]bool)
+	var cursor uint64
+	for step := 0; ; step++ {
+		cursor = tbl.scan(cursor, func(key string) { seen[key] = true })
+		if cursor == 0 {
+			break
+		}
+		change(step)
+	}
+	for _, k := range stable {
+		if !seen[k] {
+			t.Fatalf("Key %q was not returned by the scan", k)
+		}
+	}
+}
+
+func TestKeyTable_AddRemove(t *testing.T) {
+	var tbl keyTable
+	for i := 0; i < 1000; i++ {
+		tbl.add(strconv.Itoa(i))
+	}
+	for i := 0; i < 1000; i += 2 {
+		tbl.remove(strconv.Itoa(i))
+	}
+	if tbl.len() != 500 {
+		t.Errorf("Unexpected number of keys. Expected: 500, Got: %d", tbl.len())
+	}
+	n := 0
+	var cursor uint64
+	for {
+		cursor = tbl.scan(cursor, func(key string) {
+			if k, _ := strconv.Atoi(key); k%2 == 0 {
+				t.Errorf("Removed key %q returned", key)
+			}
+			n++
+		})
+		if cursor == 0 {
+			break
+		}
+	}
+	if n != 500 {
+		t.Errorf("Unexpected number of scanned keys. Expected: 500, Got: %d", n)
+	}
+}
+
+func TestKeyTable_ScanWhileGrowing(t *testing.T) {
+	var tbl keyTable
+	var stable []string
+	for i := 0; i < 100; i++ {
+		stable = append(stable, "k"+strconv.Itoa(i))
+		tbl.add(stable[i])
+	}
+	next := 0
+	scanWhileChanging(t, &tbl, stable, func(step int) {
+		// Grows the table several times early in the scan
+		for i := 0; i < 50 && step < 20; i++ {
+			tbl.add("new" + strconv.Itoa(next))
+			next++
+		}
+	})
+}
+
+func TestKeyTable_ScanWhileShrinking(t *testing.T) {
+	var tbl keyTable
+	var stable []string
+	for i := 0; i < 20; i++ {
+		stable = append(stable, "k"+strconv.Itoa(i))
+		tbl.add(stable[i])
+	}
+	for i := 0; i < 2000; i++ {
+		tbl.add("tmp" + strconv.Itoa(i))
+	}
+	removed := 0
+	scanWhileChanging(t, &tbl, stable, func(step int) {
+		for i := 0; i < 100 && removed < 2000; i++ {
+			tbl.remove("tmp" + strconv.Itoa(removed))
+			removed++
+		}
+	})
+	// Further changes finish moving the keys to the smaller table
+	for i := 0; i < 300; i++ {
+		tbl.add("x")
+		tbl.remove("x")
+	}
+	if tbl.rehashing() || len(tbl.tables[0]) >= 2048 || tbl.len() != 20 {
+		t.Errorf("Table did not shrink: %d buckets", len(tbl.tables[0]))
+	}
+}
+
+func TestKeyValue_Scan(t *testing.T) {
+	kv := NewShardedKeyValue(8)
+	for i := 0; i < 300; i++ {
+		kv.SetVariable(strconv.Itoa(i), "v", nil)
+	}
+	kv.SetVariable("expired", "v", map[string]string{"px": "-1"})
+	seen := make(map[string]int)
+	var cursor uint64
+	for {
+		cursor = kv.Scan(cursor, func(key string, e *Entry) { seen[key]++ })
+		if cursor == 0 {
+			break
+		}
+		// Keys added during the scan may or may not be returned
+		kv.SetVariable("new"+strconv.FormatUint(cursor, 10), "v", nil)
+	}
+	for i := 0; i < 300; i++ {
+		if seen[strconv.Itoa(i)] == 0 {
+			t.Fatalf("Key %d was not returned by the scan", i)
+		}
+	}
+	if seen["expired"] != 0 {
+		t.Error("Expired key returned by the scan")
+	}
+}
+
+func TestHash_ScanContinuesInClone(t *testing.T) {
+	h := NewHash()
+	for i := 0; i < 300; i++ {
+		h.Set("f"+strconv.Itoa(i), "v")
+	}
+	seen := make(map[string]bool)
+	cursor := h.Scan(0, func(f string) { seen[f] = true })
+	// A cursor stays valid in a copy, e.g. the one of a snapshot
+	c := h.Clone()
+	for cursor != 0 {
+		cursor = c.Scan(cursor, func(f string) { seen[f] = true })
+	}
+	if len(seen) != 300 {
+		t.Errorf("Unexpected number of scanned fields. Expected: 300, Got: %d", len(seen))
+	}
+}
@@ -1,12 +1,94 @@
 package storage
 
 import (
+	"math/bits"
+	"math/rand"
+	"slices"
+	"sort"
 	"strconv"
-	"time"
+	"sync"
+	"sync/atomic"
 )
 
+// Number of shards of a keyspace created by NewKeyValue
+const DefaultShards = 64
+
+// KeyValue is a keyspace safe for concurrent use. Keys are spread over
+// lock-striped shards by their hash, so commands touching different keys
+// rarely wait for each other. Commands that touch several keys lock all
+// involved shards through Update or View and see them atomically.
 type KeyValue struct {
-	data map[string]string
+	shards []*shard
+	mask   uint32
+	// Generation of the newest running snapshot, 0 when there is none
+	cowGen atomic.Uint64
+	// Generations of the running snapshots, oldest first. Changed with all
+	// shards locked.
+	snapshots []uint64
+	// See OnExpire and KeepExpired, both set before the keyspace is used
+	onExpire    func(key string, fields []string)
+	keepExpired bool
+}
+
+// OnExpire sets fn to be called whenever keys or hash fields are deleted
+// because their TTL is over, by an access or by the active cycle. fields
+// is nil when the whole key expired. fn runs with the shard of the key
+// locked and must not use the keyspace.
+func (kv *KeyValue) OnExpire(fn func(key string, fields []string)) {
+	kv.onExpire = fn
+}
+
+// KeepExpired makes keys and hash fields whose TTL is over stay until they
+// are deleted explicitly, like on a Redis replica, which waits for the DEL
+// of its master. Reads don't see them, writes do, so they act like master
+// did when it executed them.
+func (kv *KeyValue) KeepExpired() {
+	kv.keepExpired = true
+}
+
+// KeepsExpired reports whether KeepExpired was called.
+func (kv *KeyValue) KeepsExpired() bool {
+	return kv.keepExpired
+}
+
+// Deletes a key whose TTL is over and reports it to the OnExpire hook
+func (kv *KeyValue) expire(sh *shard, key string) {
+	sh.remove(key)
+	if kv.onExpire != nil {
+		kv.onExpire(key, nil)
+	}
+}
+
+type shard struct {
+	mu   sync.RWMutex
+	data map[string]*Entry
+	// Expiry index: the entries that have a TTL
+	expires map[string]*Entry
+	// The keys again, in buckets SCAN can walk
+	keys keyTable
+	// The running snapshots that still need the keys as they were when
+	// they were taken
+	snapshots []*shardSnapshot
+}
+
+// Stores e under key, adding the key to the scan table when it is new
+func (sh *shard) store(key string, e *Entry) {
+	sh.preserve()
+	if _, ok := sh.data[key]; !ok {
+		sh.keys.add(key)
+	}
+	sh.data[key] = e
+}
+
+// Removes the key together with its TTL
+func (sh *shard) remove(key string) {
+	if _, ok := sh.data[key]; !ok {
+		return
+	}
+	sh.preserve()
+	delete(sh.data, key)
+	delete(sh.expires, key)
+	sh.keys.remove(key)
 }
 
 type StorageError struct {
@@ -17,31 +99,475 @@ func (e *StorageError) Error() string {
 	return e.s
 }
 
-func NewKeyValue() KeyValue {
-	return KeyValue{
-		data: make(map[string]string),
+func NewKeyValue() *KeyValue {
+	return NewShardedKeyValue(DefaultShards)
+}
+
+// NewShardedKeyValue creates a keyspace with n shards, rounded up to a power of two.
+func NewShardedKeyValue(n int) *KeyValue {
+	size := 1
+	for size < n {
+		size <<= 1
+	}
+	kv := &KeyValue{
+		shards: make([]*shard, size),
+		mask:   uint32(size - 1),
+	}
+	for i := range kv.shards {
+		kv.shards[i] = &shard{
+			data:    make(map[string]*Entry),
+			expires: make(map[string]*Entry),
+		}
+	}
+	return kv
+}
+
+// NewDatabases creates n keyspaces with the default number of shards, the
+// logical databases of a server.
+func NewDatabases(n int) []*KeyValue {
+	dbs := make([]*KeyValue, n)
+	for i := range dbs {
+		dbs[i] = NewKeyValue()
+	}
+	return dbs
+}
+
+// 32 bit FNV-1a
+func hashKey(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h
+}
+
+func (kv *KeyValue) shardIndex(key string) int {
+	return int(hashKey(key) & kv.mask)
+}
+
+func (kv *KeyValue) shardFor(key string) *shard {
+	return kv.shards[kv.shardIndex(key)]
+}
+
+// Returns indexes of the shards holding keys, sorted and without duplicates.
+// Shards are always locked in index order, so two multi-key operations can't
+// deadlock each other.
+func (kv *KeyValue) shardIndexes(keys []string) []int {
+	idx := make([]int, 0, len(keys))
+	seen := make(map[int]bool, len(keys))
+	for _, k := range keys {
+		i := kv.shardIndex(k)
+		if !seen[i] {
+			seen[i] = true
+			idx = append(idx, i)
+		}
+	}
+	sort.Ints(idx)
+	return idx
+}
+
+// Tx gives access to the keys an Update or View call was started with.
+// Touching any other key is not protected by a lock and must be avoided.
+//
+// Keys whose TTL is over are invisible. Inside Update they are also deleted
+// on access; View can't modify the shard and leaves that to the active
+// expire cycle.
+type Tx struct {
+	kv       *KeyValue
+	writable bool
+	now      int64
+}
+
+func (kv *KeyValue) newTx(writable bool) *Tx {
+	return &Tx{kv: kv, writable: writable, now: nowMs()}
+}
+
+// Returns the entry of the key, or nil when it does not exist. A key whose
+// TTL is over is expired first, unless the keyspace keeps such keys for
+// writes.
+func (tx *Tx) find(sh *shard, key string) *Entry {
+	e, ok := sh.data[key]
+	if !ok {
+		return nil
+	}
+	if e.expireAt != 0 && e.expireAt <= tx.now {
+		if !tx.writable {
+			return nil
+		}
+		if tx.kv.keepExpired {
+			return e
+		}
+		tx.kv.expire(sh, key)
+		return nil
+	}
+	return e
+}
+
+// Lookup returns the entry of the key, or nil when it does not exist,
+// and records the access for OBJECT IDLETIME and OBJECT FREQ.
+func (tx *Tx) Lookup(key string) *Entry {
+	e := tx.find(tx.kv.shardFor(key), key)
+	if e != nil {
+		e.touch(tx.now)
 	}
+	return e
+}
+
+// Peek is Lookup without recording the access.
+func (tx *Tx) Peek(key string) *Entry {
+	return tx.find(tx.kv.shardFor(key), key)
+}
+
+// Get returns the string value of the key. It fails with ErrWrongType when
+// the key holds another type.
+func (tx *Tx) Get(key string) (string, bool, error) {
+	e := tx.Lookup(key)
+	if e == nil {
+		return "", false, nil
+	}
+	if e.Type != TypeString {
+		return "", false, ErrWrongType
+	}
+	return e.Value.(string), true, nil
+}
+
+func (tx *Tx) Exists(key string) bool {
+	return tx.find(tx.kv.shardFor(key), key) != nil
+}
+
+// Put stores a value of any type, replacing whatever the key held and
+// dropping its TTL.
+func (tx *Tx) Put(key string, typ ValueType, value any) *Entry {
+	sh := tx.kv.shardFor(key)
+	e := newEntry(typ, value, tx.now)
+	sh.store(key, e)
+	delete(sh.expires, key)
+	return e
+}
+
+// PutExpireAt is Put with the TTL set to unix time at in milliseconds, 0
+// for none. Unlike with SetExpireAt, a time that is already over is kept,
+// so a keyspace that keeps expired keys can load them.
+func (tx *Tx) PutExpireAt(key string, typ ValueType, value any, at int64) {
+	sh := tx.kv.shardFor(key)
+	e := tx.Put(key, typ, value)
+	if at != 0 {
+		e.expireAt = at
+		sh.expires[key] = e
+	}
+}
+
+// PutEntry stores an existing entry under key, keeping its TTL. It is used
+// to move values between keys and databases.
+func (tx *Tx) PutEntry(key string, e *Entry) {
+	sh := tx.kv.shardFor(key)
+	sh.store(key, e)
+	if e.expireAt != 0 {
+		sh.expires[key] = e
+	} else {
+		delete(sh.expires, key)
+	}
+}
+
+// Set stores the value and drops any TTL the key had, like SET does.
+func (tx *Tx) Set(key, value string) {
+	tx.Put(key, TypeString, value)
+}
+
+// SetKeepTTL stores the value and leaves the TTL of the key unchanged.
+func (tx *Tx) SetKeepTTL(key, value string) {
+	sh := tx.kv.shardFor(key)
+	old := tx.find(sh, key)
+	e := tx.Put(key, TypeString, value)
+	if old != nil && old.expireAt != 0 {
+		e.expireAt = old.expireAt
+		sh.expires[key] = e
+	}
+}
+
+// Delete removes the key and reports whether it existed.
+func (tx *Tx) Delete(key string) bool {
+	sh := tx.kv.shardFor(key)
+	ok := tx.find(sh, key) != nil
+	sh.remove(key)
+	return ok
+}
+
+// Unlink removes the key like Delete, but a large value is freed by a
+// background goroutine instead of the caller.
+func (tx *Tx) Unlink(key string) bool {
+	sh := tx.kv.shardFor(key)
+	e := tx.find(sh, key)
+	if e == nil {
+		return false
+	}
+	sh.remove(key)
+	// A snapshot may still have to save the value
+	if freeEffort(e.Value) > lazyfreeThreshold && !tx.kv.shared(e) {
+		freeLater(e.Value)
+	}
+	return true
+}
+
+// Rename moves the entry of src to dst together with its TTL, replacing
+// whatever dst held. It reports false when src does not exist.
+func (tx *Tx) Rename(src, dst string) bool {
+	e := tx.find(tx.kv.shardFor(src), src)
+	if e == nil {
+		return false
+	}
+	if src != dst {
+		tx.Delete(src)
+		tx.PutEntry(dst, e)
+	}
+	return true
+}
+
+// ExpireAt returns the unix time in milliseconds at which the key expires,
+// or -1 when it has no TTL. ok is false when the key does not exist.
+func (tx *Tx) ExpireAt(key string) (at int64, ok bool) {
+	e := tx.find(tx.kv.shardFor(key), key)
+	if e == nil {
+		return 0, false
+	}
+	if e.expireAt == 0 {
+		return -1, true
+	}
+	return e.expireAt, true
+}
+
+// SetExpireAt sets the expiry time of an existing key, in unix milliseconds.
+// A time that is already over deletes the key right away.
+func (tx *Tx) SetExpireAt(key string, at int64) bool {
+	sh := tx.kv.shardFor(key)
+	e := tx.find(sh, key)
+	if e == nil {
+		return false
+	}
+	if at <= tx.now {
+		sh.remove(key)
+		return true
+	}
+	e = tx.own(sh, key, e)
+	e.expireAt = at
+	sh.expires[key] = e
+	return true
+}
+
+// Persist removes the TTL of the key and reports whether it had one.
+func (tx *Tx) Persist(key string) bool {
+	sh := tx.kv.shardFor(key)
+	e := tx.find(sh, key)
+	if e == nil || e.expireAt == 0 {
+		return false
+	}
+	e = tx.own(sh, key, e)
+	e.expireAt = 0
+	delete(sh.expires, key)
+	return true
+}
+
+// Now returns the time, in unix milliseconds, the transaction evaluates TTLs
+// against. It does not move while the transaction runs.
+func (tx *Tx) Now() int64 {
+	return tx.now
+}
+
+// Update runs fn with all shards holding keys locked for writing.
+func (kv *KeyValue) Update(keys []string, fn func(tx *Tx) error) error {
+	idx := kv.shardIndexes(keys)
+	for _, i := range idx {
+		kv.shards[i].mu.Lock()
+	}
+	defer func() {
+		for j := len(idx) - 1; j >= 0; j-- {
+			kv.shards[idx[j]].mu.Unlock()
+		}
+	}()
+	return fn(kv.newTx(true))
+}
+
+// View runs fn with all shards holding keys locked for reading. fn must not
+// modify the keyspace.
+func (kv *KeyValue) View(keys []string, fn func(tx *Tx) error) error {
+	idx := kv.shardIndexes(keys)
+	for _, i := range idx {
+		kv.shards[i].mu.RLock()
+	}
+	defer func() {
+		for j := len(idx) - 1; j >= 0; j-- {
+			kv.shards[idx[j]].mu.RUnlock()
+		}
+	}()
+	return fn(kv.newTx(false))
+}
+
+// UpdateAll runs fn with the whole keyspace locked for writing.
+func (kv *KeyValue) UpdateAll(fn func(tx *Tx) error) error {
+	for _, sh := range kv.shards {
+		sh.mu.Lock()
+	}
+	defer func() {
+		for j := len(kv.shards) - 1; j >= 0; j-- {
+			kv.shards[j].mu.Unlock()
+		}
+	}()
+	return fn(kv.newTx(true))
+}
+
+// Flush removes all keys. With async the values are freed by a background
+// goroutine; otherwise they are left to the garbage collector right away.
+func (kv *KeyValue) Flush(async bool) {
+	old := make([]map[string]*Entry, 0, len(kv.shards))
+	kv.UpdateAll(func(tx *Tx) error {
+		// A snapshot may still have to save the values
+		async = async && kv.cowGen.Load() == 0
+		for _, sh := range kv.shards {
+			sh.preserve()
+			old = append(old, sh.data)
+			sh.data = make(map[string]*Entry)
+			sh.expires = make(map[string]*Entry)
+			sh.keys = keyTable{}
+		}
+		return nil
+	})
+	if async {
+		for _, m := range old {
+			if len(m) > 0 {
+				freeLater(m)
+			}
+		}
+	}
+}
+
+// Swap exchanges the keys of kv and other, like SWAPDB does with two
+// databases. Both must have the same number of shards. Callers that lock
+// several keyspaces must always do so in the same order, kv is locked first.
+func (kv *KeyValue) Swap(other *KeyValue) {
+	shards := append(slices.Clone(kv.shards), other.shards...)
+	for _, sh := range shards {
+		sh.mu.Lock()
+	}
+	defer func() {
+		for j := len(shards) - 1; j >= 0; j-- {
+			shards[j].mu.Unlock()
+		}
+	}()
+	for i, sh := range kv.shards {
+		o := other.shards[i]
+		sh.preserve()
+		o.preserve()
+		sh.data, o.data = o.data, sh.data
+		sh.expires, o.expires = o.expires, sh.expires
+		sh.keys, o.keys = o.keys, sh.keys
+	}
+}
+
+// RandomKey returns a random key that did not expire, or false when there
+// is none. Shards are tried from a random one on; within a shard the random
+// start of map iteration picks the key.
+func (kv *KeyValue) RandomKey() (string, bool) {
+	start := rand.Intn(len(kv.shards))
+	for i := range kv.shards {
+		sh := kv.shards[(start+i)&int(kv.mask)]
+		sh.mu.RLock()
+		now := nowMs()
+		for key, e := range sh.data {
+			if e.expireAt == 0 || e.expireAt > now {
+				sh.mu.RUnlock()
+				return key, true
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	return "", false
+}
+
+// Scan calls fn with the keys of one bucket of the keyspace and returns
+// the cursor to pass to the next call, 0 once the scan is complete. A scan
+// starts with cursor 0. Like Redis' kvstore, the cursor holds the shard in
+// its low bits and the cursor of the shard's keyTable above them, so keys
+// that exist during the whole scan are returned at least once even when
+// shards grow or shrink between calls. Keys whose TTL is over are skipped.
+func (kv *KeyValue) Scan(cursor uint64, fn func(key string, e *Entry)) uint64 {
+	shardBits := bits.Len32(kv.mask)
+	i := int(cursor & uint64(kv.mask))
+	sh := kv.shards[i]
+	sh.mu.RLock()
+	now := nowMs()
+	next := sh.keys.scan(cursor>>shardBits, func(key string) {
+		if e := sh.data[key]; e.expireAt == 0 || e.expireAt > now {
+			fn(key, e)
+		}
+	})
+	sh.mu.RUnlock()
+	if next == 0 {
+		// Continue with the next shard
+		if i++; i == len(kv.shards) {
+			return 0
+		}
+	}
+	return next<<shardBits | uint64(i)
+}
+
+// Each calls fn with every key whose TTL is not over. Shards are visited
+// one after another, so the keys are not a point in time snapshot under
+// concurrent writes.
+func (kv *KeyValue) Each(fn func(key string, e *Entry)) {
+	for _, sh := range kv.shards {
+		sh.mu.RLock()
+		now := nowMs()
+		for key, e := range sh.data {
+			if e.expireAt == 0 || e.expireAt > now {
+				fn(key, e)
+			}
+		}
+		sh.mu.RUnlock()
+	}
+}
+
+// Len returns the number of keys. Shards are counted one after another,
+// so the result is not a point in time snapshot under concurrent writes.
+func (kv *KeyValue) Len() int {
+	n := 0
+	for _, sh := range kv.shards {
+		sh.mu.RLock()
+		n += len(sh.data)
+		sh.mu.RUnlock()
+	}
+	return n
 }
 
 func (s *KeyValue) SetVariable(k, v string, args map[string]string) error {
+	var at int64
 	px, ok := args["px"]
 	if ok {
-		t, err := strconv.Atoi(px)
+		t, err := strconv.ParseInt(px, 10, 64)
 		if err != nil {
 			return err
 		}
-		timer := time.NewTimer(time.Duration(int64(t)) * time.Millisecond)
-		go func() {
-			<-timer.C
-			s.DeleteVariable(k)
-		}()
+		at = nowMs() + t
 	}
-	s.data[k] = v
-	return nil
+	return s.Update([]string{k}, func(tx *Tx) error {
+		tx.Set(k, v)
+		if at != 0 {
+			tx.SetExpireAt(k, at)
+		}
+		return nil
+	})
 }
 
 func (s *KeyValue) GetVariable(key string) (string, error) {
-	v, ok := s.data[key]
+	var v string
+	var ok bool
+	err := s.View([]string{key}, func(tx *Tx) (err error) {
+		v, ok, err = tx.Get(key)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
 	if !ok {
 		return "", &StorageError{"key not found"}
 	}
@@ -49,5 +575,8 @@ func (s *KeyValue) GetVariable(key string) (string, error) {
 }
 
 func (s *KeyValue) DeleteVariable(key string) {
-	delete(s.data, key)
+	s.Update([]string{key}, func(tx *Tx) error {
+		tx.Delete(key)
+		return nil
+	})
 }
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyValue_ConcurrentWrites(t *testing.T) {
+	kv := NewKeyValue()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(g*1000 + i)
+				kv.SetVariable(key, key, nil)
+				kv.GetVariable(key)
+				if i%2 == 0 {
+					kv.DeleteVariable(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if kv.Len() != 4000 {
+		t.Errorf("Unexpected number of keys. Expected: 4000, Got: %d", kv.Len())
+	}
+}
+
+func TestKeyValue_MultiKeyUpdate(t *testing.T) {
+	kv := NewShardedKeyValue(4)
+	kv.SetVariable("a", "100", nil)
+	kv.SetVariable("b", "0", nil)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			from, to := "a", "b"
+			if g%2 == 1 {
+				from, to = "b", "a"
+			}
+			for i := 0; i < 500; i++ {
+				kv.Update([]string{from, to}, func(tx *Tx) error {
+					f, _, _ := tx.Get(from)
+					d, _, _ := tx.Get(to)
+					fn, _ := strconv.Atoi(f)
+					dn, _ := strconv.Atoi(d)
+					if fn > 0 {
+						tx.Set(from, strconv.Itoa(fn-1))
+						tx.Set(to, strconv.Itoa(dn+1))
+					}
+					return nil
+				})
+			}
+		}(g)
+	}
+	wg.Wait()
+	a, _ := kv.GetVariable("a")
+	b, _ := kv.GetVariable("b")
+	an, _ := strconv.Atoi(a)
+	bn, _ := strconv.Atoi(b)
+	if an+bn != 100 {
+		t.Errorf("Multi key update is not atomic: a=%s b=%s", a, b)
+	}
+}
+
+func TestKeyValue_WrongType(t *testing.T) {
+	kv := NewKeyValue()
+	kv.Update([]string{"l"}, func(tx *Tx) error {
+		tx.Put("l", TypeList, nil)
+		return nil
+	})
+	if _, err := kv.GetVariable("l"); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+	kv.SetVariable("l", "now a string", nil)
+	if v, err := kv.GetVariable("l"); err != nil || v != "now a string" {
+		t.Errorf("Unexpected value %q, error %v", v, err)
+	}
+}
+
+func TestKeyValue_RenameKeepsEntry(t *testing.T) {
+	kv := NewKeyValue()
+	kv.Update([]string{"a", "b"}, func(tx *Tx) error {
+		l, _ := tx.List("a", true)
+		l.PushBack("x")
+		tx.SetExpireAt("a", tx.Now()+10000)
+		tx.Set("b", "old")
+		if !tx.Rename("a", "b") || tx.Rename("a", "c") {
+			t.Error("Unexpected result of Rename")
+		}
+		e := tx.Peek("b")
+		if e == nil || e.Type != TypeList || e.ExpireAt() != tx.Now()+10000 || tx.Exists("a") {
+			t.Errorf("Unexpected entry after Rename: %+v", e)
+		}
+		return nil
+	})
+}
+
+func TestEntry_Clone(t *testing.T) {
+	kv := NewKeyValue()
+	kv.Update([]string{"s", "h", "x"}, func(tx *Tx) error {
+		s, _ := tx.Stream("s", true)
+		s.Add(StreamID{1, 0}, []string{"f", "v"})
+		s.Add(StreamID{2, 0}, []string{"f", "v"})
+		g, _ := s.CreateGroup("g", MinStreamID, 0)
+		c, _ := g.CreateConsumer("alice", 0)
+		s.ReadGroup(g, c, 1, false, 0)
+		tx.SetExpireAt("s", tx.Now()+5000)
+
+		cs := tx.Peek("s").Clone(tx.Now())
+		if cs.ExpireAt() != tx.Now()+5000 {
+			t.Errorf("Clone lost the TTL: %d", cs.ExpireAt())
+		}
+		copied := cs.Value.(*Stream)
+		cg := copied.Group("g")
+		cc := cg.Consumer("alice")
+		if cg == g || cc == c || cg.PendingLen() != 1 || cc.PendingCount() != 1 || cg.Pending(StreamID{1, 0}).Consumer != cc {
+			t.Error("Consumer group was not copied")
+		}
+		// Changes to the copy don't reach the original
+		copied.Delete(StreamID{1, 0})
+		cg.Ack(StreamID{1, 0})
+		if s.Len() != 2 || g.PendingLen() != 1 || c.PendingCount() != 1 {
+			t.Error("Original stream changed with its copy")
+		}
+
+		h, _ := tx.Hash("h", true)
+		h.Set("f", "v")
+		h.SetFieldExpireAt("f", tx.Now()+1000)
+		ch := tx.Peek("h").Clone(tx.Now()).Value.(*Hash)
+		if at, _ := ch.FieldExpireAt("f"); at != tx.Now()+1000 {
+			t.Errorf("Copy lost the field TTL: %d", at)
+		}
+		ch.Set("f", "w")
+		if v, _ := h.Get("f"); v != "v" {
+			t.Errorf("Original hash changed with its copy: %q", v)
+		}
+		return nil
+	})
+}
+
+func TestKeyValue_LazyFree(t *testing.T) {
+	kv := NewKeyValue()
+	freed := lazyfree.freed.Load()
+	kv.Update([]string{"big", "small"}, func(tx *Tx) error {
+		s, _ := tx.LookupSet("big", true)
+		for i := 0; i < 1000; i++ {
+			s.Add("m" + strconv.Itoa(i))
+		}
+		tx.Set("small", "v")
+		tx.Unlink("big")
+		tx.Unlink("small")
+		return nil
+	})
+	for i := 0; i < 100; i++ {
+		kv.SetVariable(strconv.Itoa(i), "v", nil)
+	}
+	kv.Flush(true)
+	if kv.Len() != 0 {
+		t.Errorf("Keys left after Flush: %d", kv.Len())
+	}
+	// The big set and the 100 flushed keys are freed in the background
+	deadline := time.Now().Add(time.Second)
+	for lazyfree.freed.Load()-freed < 101 || lazyfree.pending.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Values not freed: %d", lazyfree.freed.Load()-freed)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestKeyValue_RandomKey(t *testing.T) {
+	kv := NewKeyValue()
+	if _, ok := kv.RandomKey(); ok {
+		t.Error("RandomKey of an empty keyspace")
+	}
+	kv.SetVariable("a", "1", nil)
+	kv.SetVariable("b", "1", map[string]string{"px": "-1"})
+	for i := 0; i < 10; i++ {
+		if k, ok := kv.RandomKey(); !ok || k != "a" {
+			t.Errorf("Unexpected random key %q", k)
+		}
+	}
+}
+
+func TestKeyValue_Swap(t *testing.T) {
+	a, b := NewKeyValue(), NewKeyValue()
+	for i := range 100 {
+		key := strconv.Itoa(i)
+		a.Update([]string{key}, func(tx *Tx) error {
+			tx.Set(key, "a")
+			tx.SetExpireAt(key, tx.Now()+10000)
+			return nil
+		})
+	}
+	b.Update([]string{"b"}, func(tx *Tx) error {
+		tx.Set("b", "b")
+		return nil
+	})
+	a.Swap(b)
+	if a.Len() != 1 || a.Expires() != 0 || b.Len() != 100 || b.Expires() != 100 {
+		t.Fatalf("Unexpected sizes after Swap: %d/%d and %d/%d", a.Len(), a.Expires(), b.Len(), b.Expires())
+	}
+	if b.AvgTTL() <= 9000 || a.AvgTTL() != 0 {
+		t.Errorf("Unexpected average TTLs after Swap: %d and %d", b.AvgTTL(), a.AvgTTL())
+	}
+	var scanned int
+	for cursor := uint64(0); ; {
+		cursor = b.Scan(cursor, func(string, *Entry) { scanned++ })
+		if cursor == 0 {
+			break
+		}
+	}
+	if scanned != 100 {
+		t.Errorf("Scanned %d keys after Swap, expected 100", scanned)
+	}
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Values with more elements than this are freed in the background by
+// UNLINK and FLUSHALL ASYNC, like Redis' LAZYFREE_THRESHOLD
+const lazyfreeThreshold = 64
+
+// lazyfree tears down values removed from the keyspace on a background
+// goroutine, like Redis' lazyfree thread. Walking a large container to drop
+// all its references is left to it, so the command that removed the value
+// returns right away.
+var lazyfree = struct {
+	mu    sync.Mutex
+	queue []any
+	wake  chan struct{}
+	start sync.Once
+	// Values queued but not freed yet, and values freed so far
+	pending atomic.Int64
+	freed   atomic.Int64
+}{wake: make(chan struct{}, 1)}
+
+// Returns the work freeing a value takes: the number of its elements
+func freeEffort(v any) int {
+	if l, ok := v.(Lengther); ok {
+		return l.Len()
+	}
+	return 1
+}
+
+// Queues a value, or a map of entries removed together, to be freed in the
+// background. Nothing else may reference it any more.
+func freeLater(v any) {
+	lazyfree.start.Do(func() { go runLazyfree() })
+	n := int64(1)
+	if m, ok := v.(map[string]*Entry); ok {
+		n = int64(len(m))
+	}
+	lazyfree.pending.Add(n)
+	lazyfree.mu.Lock()
+	lazyfree.queue = append(lazyfree.queue, v)
+	lazyfree.mu.Unlock()
+	select {
+	case lazyfree.wake <- struct{}{}:
+	default:
+	}
+}
+
+func runLazyfree() {
+	for range lazyfree.wake {
+		for {
+			lazyfree.mu.Lock()
+			queue := lazyfree.queue
+			lazyfree.queue = nil
+			lazyfree.mu.Unlock()
+			if len(queue) == 0 {
+				break
+			}
+			for _, v := range queue {
+				if m, ok := v.(map[string]*Entry); ok {
+					for _, e := range m {
+						freeValue(e.Value)
+						lazyfree.pending.Add(-1)
+						lazyfree.freed.Add(1)
+					}
+					clear(m)
+					continue
+				}
+				freeValue(v)
+				lazyfree.pending.Add(-1)
+				lazyfree.freed.Add(1)
+			}
+		}
+	}
+}
+
+// Drops the references a container holds, so its parts become garbage one
+// by one
+func freeValue(v any) {
+	switch v := v.(type) {
+	case *List:
+		for n := v.head; n != nil; {
+			next := n.next
+			n.prev, n.next, n.items = nil, nil, nil
+			n = next
+		}
+		v.head, v.tail, v.length = nil, nil, 0
+	case *Hash:
+		clear(v.dict)
+		v.list, v.dict, v.keys = nil, nil, keyTable{}
+	case *Set:
+		clear(v.members)
+		v.ints, v.members, v.keys = nil, nil, keyTable{}
+	case *ZSet:
+		clear(v.dict)
+		v.dict, v.zsl, v.keys = nil, nil, keyTable{}
+	case *Stream:
+		clear(v.nodes)
+		clear(v.groups)
+		v.nodes, v.groups, v.length = nil, nil, 0
+	}
+}
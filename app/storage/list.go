@@ -0,0 +1,417 @@
+package storage
+
+const (
+	// Elements per node of the list, like Redis' quicklist nodes
+	listNodeSize = 128
+	// A list that fits into a single node of at most this many bytes is
+	// reported as listpack encoded, like list-max-listpack-size -2 does.
+	listpackMaxBytes = 8 * 1024
+)
+
+// List is a deque of strings stored as a doubly linked list of fixed size
+// chunks, similar to Redis' quicklist. Pushing and popping at both ends is
+// O(1), access by index walks the chunks from the nearest end.
+type List struct {
+	head   *listNode
+	tail   *listNode
+	length int
+}
+
+type listNode struct {
+	prev  *listNode
+	next  *listNode
+	items []string
+}
+
+func NewList() *List {
+	return &List{}
+}
+
+func (l *List) Len() int {
+	return l.length
+}
+
+func (l *List) Encoding() string {
+	if l.head != l.tail {
+		return EncodingQuicklist
+	}
+	size := 0
+	if l.head != nil {
+		for _, v := range l.head.items {
+			size += len(v)
+		}
+	}
+	if size > listpackMaxBytes {
+		return EncodingQuicklist
+	}
+	return EncodingListpack
+}
+
+func (l *List) PushFront(v string) {
+	if l.head == nil || len(l.head.items) >= listNodeSize {
+		n := &listNode{next: l.head, items: make([]string, 0, listNodeSize)}
+		if l.head != nil {
+			l.head.prev = n
+		} else {
+			l.tail = n
+		}
+		l.head = n
+	}
+	l.head.items = append(l.head.items, "")
+	copy(l.head.items[1:], l.head.items)
+	l.head.items[0] = v
+	l.length++
+}
+
+func (l *List) PushBack(v string) {
+	if l.tail == nil || len(l.tail.items) >= listNodeSize {
+		n := &listNode{prev: l.tail, items: make([]string, 0, listNodeSize)}
+		if l.tail != nil {
+			l.tail.next = n
+		} else {
+			l.head = n
+		}
+		l.tail = n
+	}
+	l.tail.items = append(l.tail.items, v)
+	l.length++
+}
+
+func (l *List) PopFront() (string, bool) {
+	if l.length == 0 {
+		return "", false
+	}
+	n := l.head
+	v := n.items[0]
+	n.items[0] = ""
+	n.items = n.items[1:]
+	l.length--
+	if len(n.items) == 0 {
+		l.unlink(n)
+	}
+	return v, true
+}
+
+func (l *List) PopBack() (string, bool) {
+	if l.length == 0 {
+		return "", false
+	}
+	n := l.tail
+	v := n.items[len(n.items)-1]
+	n.items = n.items[:len(n.items)-1]
+	l.length--
+	if len(n.items) == 0 {
+		l.unlink(n)
+	}
+	return v, true
+}
+
+func (l *List) unlink(n *listNode) {
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else {
+		l.head = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	} else {
+		l.tail = n.prev
+	}
+}
+
+// NormalizeIndex turns a possibly negative index into an offset from the
+// head. ok is false when the index is out of range.
+func (l *List) NormalizeIndex(i int) (int, bool) {
+	if i < 0 {
+		i += l.length
+	}
+	return i, i >= 0 && i < l.length
+}
+
+// Returns the node holding the element at offset i and the position in it
+func (l *List) locate(i int) (*listNode, int) {
+	if i < l.length/2 {
+		for n := l.head; n != nil; n = n.next {
+			if i < len(n.items) {
+				return n, i
+			}
+			i -= len(n.items)
+		}
+		return nil, 0
+	}
+	i = l.length - 1 - i
+	for n := l.tail; n != nil; n = n.prev {
+		if i < len(n.items) {
+			return n, len(n.items) - 1 - i
+		}
+		i -= len(n.items)
+	}
+	return nil, 0
+}
+
+// Index returns the element at index i, negative indexes count from the tail.
+func (l *List) Index(i int) (string, bool) {
+	i, ok := l.NormalizeIndex(i)
+	if !ok {
+		return "", false
+	}
+	n, pos := l.locate(i)
+	return n.items[pos], true
+}
+
+// Set replaces the element at index i and reports whether it was in range.
+func (l *List) Set(i int, v string) bool {
+	i, ok := l.NormalizeIndex(i)
+	if !ok {
+		return false
+	}
+	n, pos := l.locate(i)
+	n.items[pos] = v
+	return true
+}
+
+// Converts LRANGE style start and stop indexes into a [start, stop] range of
+// offsets. ok is false for an empty range.
+func (l *List) rangeOffsets(start, stop int) (int, int, bool) {
+	if start < 0 {
+		start += l.length
+	}
+	if stop < 0 {
+		stop += l.length
+	}
+	start = max(start, 0)
+	stop = min(stop, l.length-1)
+	if start > stop || start >= l.length {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+// Range returns the elements between start and stop inclusive, with the
+// LRANGE semantics for negative and out of range indexes.
+func (l *List) Range(start, stop int) []string {
+	start, stop, ok := l.rangeOffsets(start, stop)
+	if !ok {
+		return []string{}
+	}
+	res := make([]string, 0, stop-start+1)
+	n, pos := l.locate(start)
+	for ; n != nil && len(res) < cap(res); n = n.next {
+		for ; pos < len(n.items) && len(res) < cap(res); pos++ {
+			res = append(res, n.items[pos])
+		}
+		pos = 0
+	}
+	return res
+}
+
+// Each calls fn for every element from head to tail until fn returns false.
+func (l *List) Each(fn func(v string) bool) {
+	for n := l.head; n != nil; n = n.next {
+		for _, v := range n.items {
+			if !fn(v) {
+				return
+			}
+		}
+	}
+}
+
+// Clone returns a deep copy of the list with the same node layout.
+func (l *List) Clone() *List {
+	c := &List{length: l.length}
+	for n := l.head; n != nil; n = n.next {
+		cn := &listNode{prev: c.tail, items: append(make([]string, 0, listNodeSize), n.items...)}
+		if c.tail != nil {
+			c.tail.next = cn
+		} else {
+			c.head = cn
+		}
+		c.tail = cn
+	}
+	return c
+}
+
+// Rebuilds the list from elements, packing them into full nodes
+func (l *List) reset(elements []string) {
+	l.head, l.tail, l.length = nil, nil, 0
+	for _, v := range elements {
+		l.PushBack(v)
+	}
+}
+
+// Trim keeps only the elements between start and stop, like LTRIM.
+func (l *List) Trim(start, stop int) {
+	start, stop, ok := l.rangeOffsets(start, stop)
+	if !ok {
+		l.reset(nil)
+		return
+	}
+	for i := 0; i < start; i++ {
+		l.PopFront()
+	}
+	for i := l.length - 1; i > stop-start; i-- {
+		l.PopBack()
+	}
+}
+
+// Remove deletes elements equal to v, like LREM: count > 0 removes the
+// first count occurrences from the head, count < 0 the first -count from the
+// tail and count 0 all of them. It returns the number of removed elements.
+func (l *List) Remove(count int, v string) int {
+	elements := make([]string, 0, l.length)
+	l.Each(func(e string) bool {
+		elements = append(elements, e)
+		return true
+	})
+	removed := 0
+	keep := make([]bool, len(elements))
+	if count >= 0 {
+		for i, e := range elements {
+			keep[i] = e != v || (count > 0 && removed == count)
+			if !keep[i] {
+				removed++
+			}
+		}
+	} else {
+		for i := len(elements) - 1; i >= 0; i-- {
+			keep[i] = elements[i] != v || removed == -count
+			if !keep[i] {
+				removed++
+			}
+		}
+	}
+	if removed == 0 {
+		return 0
+	}
+	res := elements[:0]
+	for i, e := range elements {
+		if keep[i] {
+			res = append(res, e)
+		}
+	}
+	l.reset(res)
+	return removed
+}
+
+// Insert adds v before or after the first occurrence of pivot and returns
+// the new length, or -1 when pivot is not in the list.
+func (l *List) Insert(before bool, pivot, v string) int {
+	for n := l.head; n != nil; n = n.next {
+		for pos, e := range n.items {
+			if e != pivot {
+				continue
+			}
+			if !before {
+				pos++
+			}
+			n.items = append(n.items, "")
+			copy(n.items[pos+1:], n.items[pos:])
+			n.items[pos] = v
+			l.length++
+			if len(n.items) > 2*listNodeSize {
+				l.split(n)
+			}
+			return l.length
+		}
+	}
+	return -1
+}
+
+// Splits an oversized node in two halves
+func (l *List) split(n *listNode) {
+	half := len(n.items) / 2
+	m := &listNode{prev: n, next: n.next, items: append(make([]string, 0, listNodeSize), n.items[half:]...)}
+	n.items = n.items[:half:half]
+	if n.next != nil {
+		n.next.prev = m
+	} else {
+		l.tail = m
+	}
+	n.next = m
+}
+
+// Positions returns the indexes of elements equal to v, like LPOS. rank
+// selects the first match to report: 1 is the first from the head, -1 the
+// first from the tail. count limits the number of matches, 0 means all,
+// and maxlen limits the number of compared elements, 0 means all.
+func (l *List) Positions(v string, rank, count, maxlen int) []int {
+	var res []int
+	compared := 0
+	skip := rank
+	if rank < 0 {
+		skip = -rank
+	}
+	skip--
+	match := func(i int, e string) bool {
+		if maxlen > 0 && compared >= maxlen {
+			return false
+		}
+		compared++
+		if e == v {
+			if skip > 0 {
+				skip--
+			} else {
+				res = append(res, i)
+				if count > 0 && len(res) == count {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	if rank > 0 {
+		i := 0
+		l.Each(func(e string) bool {
+			ok := match(i, e)
+			i++
+			return ok
+		})
+		return res
+	}
+	i := l.length - 1
+	for n := l.tail; n != nil; n = n.prev {
+		for pos := len(n.items) - 1; pos >= 0; pos-- {
+			if !match(i, n.items[pos]) {
+				return res
+			}
+			i--
+		}
+	}
+	return res
+}
+
+// List returns the list stored at key. With create, a missing key is set to
+// a new empty list; otherwise nil is returned for it. A key of another type
+// fails with ErrWrongType.
+func (tx *Tx) List(key string, create bool) (*List, error) {
+	e := tx.lookupOwned(key, TypeList)
+	if e == nil {
+		if !create {
+			return nil, nil
+		}
+		l := NewList()
+		tx.Put(key, TypeList, l)
+		return l, nil
+	}
+	if e.Type != TypeList {
+		return nil, ErrWrongType
+	}
+	return e.Value.(*List), nil
+}
+
+// Lengther is implemented by every container value.
+type Lengther interface {
+	Len() int
+}
+
+// DeleteIfEmpty removes key when it holds an empty container, since Redis
+// never keeps empty lists, sets, hashes or sorted sets around.
+func (tx *Tx) DeleteIfEmpty(key string) {
+	e := tx.Peek(key)
+	if e == nil || e.Type == TypeString || e.Type == TypeStream {
+		return
+	}
+	if l, ok := e.Value.(Lengther); ok && l.Len() == 0 {
+		tx.Delete(key)
+	}
+}
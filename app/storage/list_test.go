@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// Builds a list spanning several nodes, pushing alternately to both ends
+func newTestList(n int) (*List, []string) {
+	l := NewList()
+	var front, back []string
+	for i := 0; i < n; i++ {
+		v := strconv.Itoa(i)
+		if i%2 == 0 {
+			l.PushBack(v)
+			back = append(back, v)
+		} else {
+			l.PushFront(v)
+			front = append([]string{v}, front...)
+		}
+	}
+	return l, append(front, back...)
+}
+
+func TestList_PushPopAcrossNodes(t *testing.T) {
+	l, expected := newTestList(1000)
+	if l.Len() != len(expected) {
+		t.Fatalf("Unexpected length. Expected: %d, Got: %d", len(expected), l.Len())
+	}
+	if got := l.Range(0, -1); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected elements. Expected: %q, Got: %q", expected, got)
+	}
+	for _, i := range []int{0, 127, 128, 500, 999, -1, -129} {
+		j := i
+		if j < 0 {
+			j += len(expected)
+		}
+		if v, ok := l.Index(i); !ok || v != expected[j] {
+			t.Errorf("Unexpected element at %d. Expected: %q, Got: %q", i, expected[j], v)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if _, ok := l.PopBack(); !ok {
+			t.Fatalf("Unexpected empty list after %d pops", i)
+		}
+	}
+	if _, ok := l.PopFront(); ok || l.head != nil || l.tail != nil {
+		t.Errorf("Unexpected leftover nodes in an empty list")
+	}
+}
+
+func TestList_RangeTrimRemove(t *testing.T) {
+	l, all := newTestList(300)
+	if got := l.Range(250, 1000); !reflect.DeepEqual(got, all[250:]) {
+		t.Errorf("Unexpected range. Expected: %q, Got: %q", all[250:], got)
+	}
+	if got := l.Range(-5, 2); len(got) != 0 {
+		t.Errorf("Unexpected non empty range: %q", got)
+	}
+	l.Trim(100, -101)
+	if got := l.Range(0, -1); !reflect.DeepEqual(got, all[100:200]) {
+		t.Errorf("Unexpected trimmed list. Expected: %q, Got: %q", all[100:200], got)
+	}
+
+	l = NewList()
+	for _, v := range []string{"a", "b", "a", "c", "a"} {
+		l.PushBack(v)
+	}
+	if n := l.Remove(-2, "a"); n != 2 {
+		t.Errorf("Unexpected number of removed elements. Expected: 2, Got: %d", n)
+	}
+	if got := l.Range(0, -1); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("Unexpected list after LREM: %q", got)
+	}
+	if got := l.Positions("a", -1, 0, 0); !reflect.DeepEqual(got, []int{0}) {
+		t.Errorf("Unexpected positions: %v", got)
+	}
+}
@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+)
+
+const (
+	// Sets of integers only stay intset encoded up to this many members,
+	// like Redis' set-max-intset-entries
+	setMaxIntsetEntries = 512
+	// Other small sets are reported as listpack encoded, like Redis'
+	// set-max-listpack-*
+	setMaxListpackEntries = 128
+	setMaxListpackValue   = 64
+)
+
+// Set is an unordered collection of unique strings. Sets of integers are
+// kept as a sorted slice of int64, like Redis' intset encoding, until a
+// member that is not an integer is added or they grow too large.
+type Set struct {
+	// Members of an intset encoded set, nil once converted to a map
+	ints    []int64
+	members map[string]struct{}
+	// The members of the map again, for SSCAN
+	keys keyTable
+	// The map encoded set is still small enough to count as listpack
+	listpack bool
+}
+
+func NewSet() *Set {
+	return &Set{ints: make([]int64, 0, 4)}
+}
+
+// Parses a member that an intset can hold: the canonical decimal form of
+// an int64, so the member reads back exactly as it was added.
+func intsetMember(m string) (int64, bool) {
+	if len(m) == 0 || len(m) > 20 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(m, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != m {
+		return 0, false
+	}
+	return n, true
+}
+
+func (s *Set) Len() int {
+	if s.members != nil {
+		return len(s.members)
+	}
+	return len(s.ints)
+}
+
+func (s *Set) Encoding() string {
+	switch {
+	case s.members == nil:
+		return EncodingIntset
+	case s.listpack:
+		return EncodingListpack
+	}
+	return EncodingHashtable
+}
+
+// Converts an intset into a map. The result counts as listpack when the
+// new member m still fits into one.
+func (s *Set) convert(m string) {
+	s.members = make(map[string]struct{}, len(s.ints)+1)
+	for _, n := range s.ints {
+		m := strconv.FormatInt(n, 10)
+		s.members[m] = struct{}{}
+		s.keys.add(m)
+	}
+	s.listpack = len(s.ints) < setMaxListpackEntries && len(m) <= setMaxListpackValue
+	s.ints = nil
+}
+
+// Add inserts m and reports whether it is new.
+func (s *Set) Add(m string) bool {
+	if s.members == nil {
+		if n, ok := intsetMember(m); ok {
+			i, found := slices.BinarySearch(s.ints, n)
+			if found {
+				return false
+			}
+			if len(s.ints) < setMaxIntsetEntries {
+				s.ints = slices.Insert(s.ints, i, n)
+				return true
+			}
+		}
+		s.convert(m)
+	}
+	if _, ok := s.members[m]; ok {
+		return false
+	}
+	s.members[m] = struct{}{}
+	s.keys.add(m)
+	if s.listpack && (len(s.members) > setMaxListpackEntries || len(m) > setMaxListpackValue) {
+		s.listpack = false
+	}
+	return true
+}
+
+// Remove deletes m and reports whether it was a member.
+func (s *Set) Remove(m string) bool {
+	if s.members != nil {
+		_, ok := s.members[m]
+		if ok {
+			delete(s.members, m)
+			s.keys.remove(m)
+		}
+		return ok
+	}
+	n, ok := intsetMember(m)
+	if !ok {
+		return false
+	}
+	i, found := slices.BinarySearch(s.ints, n)
+	if found {
+		s.ints = slices.Delete(s.ints, i, i+1)
+	}
+	return found
+}
+
+func (s *Set) Contains(m string) bool {
+	if s.members != nil {
+		_, ok := s.members[m]
+		return ok
+	}
+	n, ok := intsetMember(m)
+	if !ok {
+		return false
+	}
+	_, found := slices.BinarySearch(s.ints, n)
+	return found
+}
+
+// Members returns all members. An intset returns them in ascending order,
+// other sets in no particular order.
+func (s *Set) Members() []string {
+	res := make([]string, 0, s.Len())
+	if s.members != nil {
+		for m := range s.members {
+			res = append(res, m)
+		}
+		return res
+	}
+	for _, n := range s.ints {
+		res = append(res, strconv.FormatInt(n, 10))
+	}
+	return res
+}
+
+// Scan calls fn with the members of one bucket and returns the cursor of
+// the next, 0 once all were visited, like Hash.Scan. Intset and listpack
+// encoded sets are visited whole in one call.
+func (s *Set) Scan(cursor uint64, fn func(member string)) uint64 {
+	if s.Encoding() == EncodingHashtable {
+		return s.keys.scan(cursor, fn)
+	}
+	for _, m := range s.Members() {
+		fn(m)
+	}
+	return 0
+}
+
+// Clone returns a deep copy of the set in the same encoding.
+func (s *Set) Clone() *Set {
+	return &Set{ints: slices.Clone(s.ints), members: maps.Clone(s.members), keys: s.keys.clone(), listpack: s.listpack}
+}
+
+// LookupSet returns the set stored at key, like List and Hash do for their
+// types. With create, a missing key is set to a new empty set; otherwise nil
+// is returned for it. A key of another type fails with ErrWrongType.
+func (tx *Tx) LookupSet(key string, create bool) (*Set, error) {
+	e := tx.lookupOwned(key, TypeSet)
+	if e == nil {
+		if !create {
+			return nil, nil
+		}
+		s := NewSet()
+		tx.Put(key, TypeSet, s)
+		return s, nil
+	}
+	if e.Type != TypeSet {
+		return nil, ErrWrongType
+	}
+	return e.Value.(*Set), nil
+}
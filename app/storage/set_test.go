@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestSet_Intset(t *testing.T) {
+	s := NewSet()
+	for _, m := range []string{"3", "-1", "10", "3"} {
+		s.Add(m)
+	}
+	if s.Encoding() != EncodingIntset {
+		t.Errorf("Unexpected encoding. Expected: %q, Got: %q", EncodingIntset, s.Encoding())
+	}
+	if got := s.Members(); !reflect.DeepEqual(got, []string{"-1", "3", "10"}) {
+		t.Errorf("Unexpected members: %q", got)
+	}
+	// Not the canonical form of an integer, so it can't be kept in an intset
+	s.Add("010")
+	if s.Encoding() != EncodingListpack || !s.Contains("010") || !s.Contains("10") || s.Len() != 4 {
+		t.Errorf("Unexpected set after adding a non integer member: %q, %q", s.Encoding(), s.Members())
+	}
+
+	s = NewSet()
+	for i := 0; i <= setMaxIntsetEntries; i++ {
+		s.Add(strconv.Itoa(i))
+	}
+	if s.Encoding() != EncodingHashtable || s.Len() != setMaxIntsetEntries+1 {
+		t.Errorf("Unexpected encoding of a large set. Expected: %q, Got: %q", EncodingHashtable, s.Encoding())
+	}
+	if !s.Remove("7") || s.Contains("7") {
+		t.Errorf("Member not removed")
+	}
+}
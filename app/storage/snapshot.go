@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"maps"
+	"slices"
+	"sync/atomic"
+)
+
+// Generation of entries: the number of snapshots taken before they were
+// created. An entry older than a running snapshot may still have to be
+// saved by it and is copied before it is modified, see Tx.own.
+var entryGen atomic.Uint64
+
+// Snapshot is a point in time view of several keyspaces for saving them
+// while clients keep writing, like the copy of memory a forked Redis
+// process saves. Nothing is copied when it is taken: a shard copies its
+// map of keys before the first change after that, and entries are copied
+// before they are modified, so the snapshot keeps seeing them as they were.
+// Each copies the map of a shard that didn't change yet itself.
+type Snapshot struct {
+	dbs []*KeyValue
+	gen uint64
+	// What it holds of every shard per database
+	shards [][]*shardSnapshot
+	// Unix time in milliseconds it was taken at
+	time int64
+	// Keys and keys with a TTL per database, counted when it was taken
+	sizes [][2]int
+}
+
+// The keys of a shard as they were when a snapshot was taken, nil until
+// they are copied
+type shardSnapshot struct {
+	data map[string]*Entry
+}
+
+// Copies the keys for the running snapshots that didn't get them yet. They
+// all saw the map as it is, so they share one copy. It must be called with
+// the shard locked for writing before the map of keys changes.
+func (sh *shard) preserve() {
+	var data map[string]*Entry
+	for _, ss := range sh.snapshots {
+		if ss.data == nil {
+			if data == nil {
+				data = maps.Clone(sh.data)
+			}
+			ss.data = data
+		}
+	}
+}
+
+// Stops keeping the keys for ss
+func (sh *shard) forget(ss *shardSnapshot) {
+	sh.snapshots = slices.DeleteFunc(sh.snapshots, func(o *shardSnapshot) bool { return o == ss })
+}
+
+// TakeSnapshot starts a snapshot of dbs. The keyspaces are locked only to
+// mark their shards, which doesn't depend on the number of keys. Several
+// snapshots can run at once. Release must be called once the snapshot is
+// no longer needed.
+func TakeSnapshot(dbs []*KeyValue) *Snapshot {
+	s := &Snapshot{dbs: dbs, shards: make([][]*shardSnapshot, len(dbs)), sizes: make([][2]int, len(dbs))}
+	lockAll(dbs)
+	defer unlockAll(dbs)
+	s.time = nowMs()
+	s.gen = entryGen.Add(1)
+	for i, kv := range dbs {
+		kv.snapshots = append(kv.snapshots, s.gen)
+		kv.cowGen.Store(s.gen)
+		for _, sh := range kv.shards {
+			ss := &shardSnapshot{}
+			sh.snapshots = append(sh.snapshots, ss)
+			s.shards[i] = append(s.shards[i], ss)
+			s.sizes[i][0] += len(sh.data)
+			s.sizes[i][1] += len(sh.expires)
+		}
+	}
+	return s
+}
+
+// Locks the shards of dbs for writing in the order every caller uses
+func lockAll(dbs []*KeyValue) {
+	for _, kv := range dbs {
+		for _, sh := range kv.shards {
+			sh.mu.Lock()
+		}
+	}
+}
+
+func unlockAll(dbs []*KeyValue) {
+	for i := len(dbs) - 1; i >= 0; i-- {
+		for j := len(dbs[i].shards) - 1; j >= 0; j-- {
+			dbs[i].shards[j].mu.Unlock()
+		}
+	}
+}
+
+// Time returns the unix time in milliseconds the snapshot was taken at.
+func (s *Snapshot) Time() int64 {
+	return s.time
+}
+
+// Databases returns the number of keyspaces in the snapshot.
+func (s *Snapshot) Databases() int {
+	return len(s.dbs)
+}
+
+// Size returns the number of keys and of keys with a TTL database db had
+// when the snapshot was taken, including keys whose TTL was over already.
+func (s *Snapshot) Size(db int) (keys, expires int) {
+	return s.sizes[db][0], s.sizes[db][1]
+}
+
+// Each calls fn with the keys database db had when the snapshot was taken,
+// leaving out the ones whose TTL was over. It stops at the first error fn
+// returns. The entries must not be modified, and every database can only
+// be walked once.
+func (s *Snapshot) Each(db int, fn func(key string, e *Entry) error) error {
+	for i, sh := range s.dbs[db].shards {
+		ss := s.shards[db][i]
+		sh.mu.Lock()
+		sh.preserve()
+		sh.forget(ss)
+		data := ss.data
+		ss.data = nil
+		sh.mu.Unlock()
+		for key, e := range data {
+			if e.expireAt != 0 && e.expireAt <= s.time {
+				continue
+			}
+			if err := fn(key, e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Release ends the snapshot. Once no snapshot runs, entries are modified in
+// place again.
+func (s *Snapshot) Release() {
+	lockAll(s.dbs)
+	defer unlockAll(s.dbs)
+	for i, kv := range s.dbs {
+		for j, sh := range kv.shards {
+			sh.forget(s.shards[i][j])
+		}
+		kv.snapshots = slices.DeleteFunc(kv.snapshots, func(gen uint64) bool { return gen == s.gen })
+		// Entries created after the newest snapshot left are needed by none
+		var gen uint64
+		if n := len(kv.snapshots); n > 0 {
+			gen = kv.snapshots[n-1]
+		}
+		kv.cowGen.Store(gen)
+	}
+}
+
+// Reports whether a running snapshot may still need the entry
+func (kv *KeyValue) shared(e *Entry) bool {
+	gen := kv.cowGen.Load()
+	return gen != 0 && e.gen < gen
+}
+
+// Returns the entry of key for a caller about to modify it. An entry a
+// running snapshot may still need is replaced by a copy first, with the
+// same TTL and access statistics.
+func (tx *Tx) own(sh *shard, key string, e *Entry) *Entry {
+	if !tx.kv.shared(e) {
+		return e
+	}
+	c := e.Clone(e.access.Load())
+	c.freq.Store(e.freq.Load())
+	sh.store(key, c)
+	if c.expireAt != 0 {
+		sh.expires[key] = c
+	}
+	return c
+}
+
+// lookupOwned is Lookup for a caller that may modify the value when it is
+// of type typ.
+func (tx *Tx) lookupOwned(key string, typ ValueType) *Entry {
+	e := tx.Lookup(key)
+	if e == nil || e.Type != typ || !tx.writable {
+		return e
+	}
+	return tx.own(tx.kv.shardFor(key), key, e)
+}
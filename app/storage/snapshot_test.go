@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Collects what a snapshot holds of database db as key to value, lists as
+// their elements
+func snapshotContents(t *testing.T, s *Snapshot, db int) map[string]any {
+	t.Helper()
+	res := make(map[string]any)
+	s.Each(db, func(key string, e *Entry) error {
+		switch v := e.Value.(type) {
+		case *List:
+			res[key] = v.Range(0, -1)
+		case *Hash:
+			f, _ := v.Get("f")
+			res[key] = f
+		default:
+			res[key] = v
+		}
+		return nil
+	})
+	return res
+}
+
+func TestSnapshot_CopyOnWrite(t *testing.T) {
+	dbs := NewDatabases(2)
+	dbs[0].Update(nil, func(tx *Tx) error {
+		tx.Set("a", "1")
+		tx.Set("b", "2")
+		tx.SetExpireAt("b", tx.Now()+100000)
+		l, _ := tx.List("l", true)
+		l.PushBack("x")
+		h, _ := tx.Hash("h", true)
+		h.Set("f", "v")
+		return nil
+	})
+	s := TakeSnapshot(dbs)
+	defer s.Release()
+	if keys, expires := s.Size(0); keys != 4 || expires != 1 {
+		t.Errorf("Unexpected size: %d keys, %d expires", keys, expires)
+	}
+	dbs[0].Update(nil, func(tx *Tx) error {
+		tx.Set("a", "changed")
+		tx.Delete("b")
+		tx.Set("new", "x")
+		l, _ := tx.List("l", false)
+		l.PushBack("y")
+		h, _ := tx.Hash("h", false)
+		h.Set("f", "changed")
+		return nil
+	})
+	dbs[0].View(nil, func(tx *Tx) error {
+		if l, _ := tx.List("l", false); !reflect.DeepEqual(l.Range(0, -1), []string{"x", "y"}) {
+			t.Errorf("Unexpected list after the snapshot: %v", l.Range(0, -1))
+		}
+		return nil
+	})
+	expected := map[string]any{"a": "1", "b": "2", "l": []string{"x"}, "h": "v"}
+	if got := snapshotContents(t, s, 0); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %v, Got: %v", expected, got)
+	}
+	if got := snapshotContents(t, s, 1); len(got) != 0 {
+		t.Errorf("Unexpected keys in db 1: %v", got)
+	}
+}
+
+func TestSnapshot_FlushAndSwap(t *testing.T) {
+	dbs := NewDatabases(2)
+	dbs[0].Update(nil, func(tx *Tx) error {
+		tx.Set("a", "1")
+		l, _ := tx.List("l", true)
+		l.PushBack("x")
+		return nil
+	})
+	dbs[1].Update(nil, func(tx *Tx) error {
+		tx.Set("b", "2")
+		return nil
+	})
+	s := TakeSnapshot(dbs)
+	defer s.Release()
+	dbs[0].Swap(dbs[1])
+	dbs[1].Update(nil, func(tx *Tx) error {
+		tx.Unlink("l")
+		return nil
+	})
+	dbs[1].Flush(true)
+	var keys []string
+	for db := range 2 {
+		for key := range snapshotContents(t, s, db) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"a", "b", "l"}) {
+		t.Errorf("Unexpected keys: %v", keys)
+	}
+}
+
+func TestSnapshot_Release(t *testing.T) {
+	dbs := NewDatabases(1)
+	dbs[0].Update(nil, func(tx *Tx) error {
+		l, _ := tx.List("l", true)
+		l.PushBack("x")
+		return nil
+	})
+	var before *List
+	dbs[0].View(nil, func(tx *Tx) error {
+		before, _ = tx.List("l", false)
+		return nil
+	})
+	TakeSnapshot(dbs).Release()
+	dbs[0].Update(nil, func(tx *Tx) error {
+		l, _ := tx.List("l", false)
+		if l != before {
+			t.Error("Values are modified in place again after Release")
+		}
+		return nil
+	})
+}
+
+func TestSnapshot_Overlapping(t *testing.T) {
+	dbs := NewDatabases(1)
+	set := func(key, value string) {
+		dbs[0].Update(nil, func(tx *Tx) error {
+			tx.Set(key, value)
+			return nil
+		})
+	}
+	set("a", "1")
+	first := TakeSnapshot(dbs)
+	set("a", "2")
+	set("b", "1")
+	second := TakeSnapshot(dbs)
+	set("a", "3")
+	set("b", "2")
+	first.Release()
+	// Entries the second snapshot holds are still copied
+	set("b", "3")
+	if got, expected := snapshotContents(t, second, 0), map[string]any{"a": "2", "b": "1"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %v, Got: %v", expected, got)
+	}
+	second.Release()
+	if dbs[0].cowGen.Load() != 0 || len(dbs[0].snapshots) != 0 || len(dbs[0].shards[0].snapshots) != 0 {
+		t.Error("Snapshots are left after Release")
+	}
+
+	first = TakeSnapshot(dbs)
+	second = TakeSnapshot(dbs)
+	set("a", "4")
+	second.Release()
+	set("a", "5")
+	if got, expected := snapshotContents(t, first, 0), map[string]any{"a": "3", "b": "3"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %v, Got: %v", expected, got)
+	}
+	first.Release()
+}
@@ -0,0 +1,345 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Entries per node of a stream, like Redis' stream-node-max-entries
+const streamNodeMaxEntries = 100
+
+// StreamID identifies an entry of a stream: the unix time in milliseconds it
+// was added at and a sequence number for entries added in the same
+// millisecond.
+type StreamID struct {
+	Ms, Seq uint64
+}
+
+var (
+	MinStreamID = StreamID{}
+	MaxStreamID = StreamID{math.MaxUint64, math.MaxUint64}
+)
+
+var ErrStreamExhausted = errors.New("The stream has exhausted the last possible ID, unable to add more items")
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Compare returns -1, 0 or 1 when id is smaller than, equal to or greater
+// than o.
+func (id StreamID) Compare(o StreamID) int {
+	switch {
+	case id.Ms < o.Ms || (id.Ms == o.Ms && id.Seq < o.Seq):
+		return -1
+	case id == o:
+		return 0
+	}
+	return 1
+}
+
+func (id StreamID) Less(o StreamID) bool {
+	return id.Compare(o) < 0
+}
+
+// Next returns the smallest ID greater than id. ok is false for the
+// greatest possible ID.
+func (id StreamID) Next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{id.Ms, id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{id.Ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// Prev returns the greatest ID smaller than id. ok is false for 0-0.
+func (id StreamID) Prev() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{id.Ms, id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{id.Ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// ParseStreamID parses "ms-seq", or "ms" alone which takes seq as the
+// sequence number.
+func ParseStreamID(s string, seq uint64) (StreamID, bool) {
+	msPart, seqPart, found := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, false
+	}
+	if found {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return StreamID{}, false
+		}
+	}
+	return StreamID{ms, seq}, true
+}
+
+// StreamEntry is an entry of a stream with its field value pairs
+// interleaved.
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string
+}
+
+// Entries are kept in nodes of up to streamNodeMaxEntries entries ordered by
+// ID, like the listpacks Redis keeps in a radix tree. Approximate trimming
+// only removes whole nodes.
+type streamNode struct {
+	entries []StreamEntry
+}
+
+func (n *streamNode) first() StreamID {
+	return n.entries[0].ID
+}
+
+func (n *streamNode) last() StreamID {
+	return n.entries[len(n.entries)-1].ID
+}
+
+// Stream is an append only log of entries with increasing IDs.
+type Stream struct {
+	nodes  []*streamNode
+	length int
+	// ID of the last entry ever added, entries can't be added below it
+	LastID StreamID
+	// Greatest ID removed by XDEL
+	MaxDeletedID StreamID
+	// Number of entries ever added
+	EntriesAdded uint64
+	groups       map[string]*ConsumerGroup
+}
+
+func NewStream() *Stream {
+	return &Stream{}
+}
+
+func (s *Stream) Len() int {
+	return s.length
+}
+
+// Nodes returns the number of nodes the entries are kept in.
+func (s *Stream) Nodes() int {
+	return len(s.nodes)
+}
+
+func (s *Stream) Encoding() string {
+	return EncodingStream
+}
+
+// NextID returns the ID XADD generates for an entry added at ms: ms-0, or
+// the one after the last ID when ms isn't later than it.
+func (s *Stream) NextID(ms uint64) (StreamID, error) {
+	if ms > s.LastID.Ms {
+		return StreamID{ms, 0}, nil
+	}
+	id, ok := s.LastID.Next()
+	if !ok {
+		return id, ErrStreamExhausted
+	}
+	return id, nil
+}
+
+// Add appends an entry. id must be greater than LastID.
+func (s *Stream) Add(id StreamID, fields []string) {
+	var n *streamNode
+	if len(s.nodes) > 0 {
+		n = s.nodes[len(s.nodes)-1]
+	}
+	if n == nil || len(n.entries) >= streamNodeMaxEntries {
+		n = &streamNode{entries: make([]StreamEntry, 0, 4)}
+		s.nodes = append(s.nodes, n)
+	}
+	n.entries = append(n.entries, StreamEntry{ID: id, Fields: fields})
+	s.length++
+	s.LastID = id
+	s.EntriesAdded++
+}
+
+// First returns the entry with the smallest ID.
+func (s *Stream) First() (StreamEntry, bool) {
+	if s.length == 0 {
+		return StreamEntry{}, false
+	}
+	return s.nodes[0].entries[0], true
+}
+
+// Last returns the entry with the greatest ID.
+func (s *Stream) Last() (StreamEntry, bool) {
+	if s.length == 0 {
+		return StreamEntry{}, false
+	}
+	n := s.nodes[len(s.nodes)-1]
+	return n.entries[len(n.entries)-1], true
+}
+
+// Index of the first node holding IDs >= id
+func (s *Stream) seek(id StreamID) int {
+	return sort.Search(len(s.nodes), func(i int) bool {
+		return !s.nodes[i].last().Less(id)
+	})
+}
+
+// Range returns the entries with IDs from start to end, both inclusive,
+// greatest first when reverse is set. A count above 0 limits the number of
+// entries.
+func (s *Stream) Range(start, end StreamID, reverse bool, count int) []StreamEntry {
+	var res []StreamEntry
+	if end.Less(start) {
+		return res
+	}
+	full := func() bool { return count > 0 && len(res) >= count }
+	if !reverse {
+		for i := s.seek(start); i < len(s.nodes) && !full(); i++ {
+			for _, e := range s.nodes[i].entries {
+				if end.Less(e.ID) || full() {
+					return res
+				}
+				if !e.ID.Less(start) {
+					res = append(res, e)
+				}
+			}
+		}
+		return res
+	}
+	last := s.seek(end)
+	if last == len(s.nodes) {
+		last--
+	}
+	for i := last; i >= 0 && !full(); i-- {
+		entries := s.nodes[i].entries
+		for j := len(entries) - 1; j >= 0; j-- {
+			e := entries[j]
+			if e.ID.Less(start) || full() {
+				return res
+			}
+			if !end.Less(e.ID) {
+				res = append(res, e)
+			}
+		}
+	}
+	return res
+}
+
+// Get returns the entry with the given ID.
+func (s *Stream) Get(id StreamID) (StreamEntry, bool) {
+	entries := s.Range(id, id, false, 1)
+	if len(entries) == 0 {
+		return StreamEntry{}, false
+	}
+	return entries[0], true
+}
+
+// Delete removes the entry with the given ID and reports whether it
+// existed.
+func (s *Stream) Delete(id StreamID) bool {
+	i := s.seek(id)
+	if i == len(s.nodes) {
+		return false
+	}
+	n := s.nodes[i]
+	j := sort.Search(len(n.entries), func(j int) bool { return !n.entries[j].ID.Less(id) })
+	if j == len(n.entries) || n.entries[j].ID != id {
+		return false
+	}
+	n.entries = append(n.entries[:j], n.entries[j+1:]...)
+	if len(n.entries) == 0 {
+		s.nodes = append(s.nodes[:i], s.nodes[i+1:]...)
+	}
+	s.length--
+	if s.MaxDeletedID.Less(id) {
+		s.MaxDeletedID = id
+	}
+	return true
+}
+
+// Removes entries from the start of the stream while remove reports true
+// for them. Approximate trimming stops at the first node that can't be
+// removed as a whole. A limit above 0 caps the number of removed entries.
+func (s *Stream) trim(remove func(e StreamEntry, left int) bool, approx bool, limit int) int {
+	removed := 0
+	for len(s.nodes) > 0 {
+		n := s.nodes[0]
+		if approx {
+			if !remove(n.entries[len(n.entries)-1], s.length-len(n.entries)+1) ||
+				(limit > 0 && removed+len(n.entries) > limit) {
+				break
+			}
+			s.nodes = s.nodes[1:]
+			s.length -= len(n.entries)
+			removed += len(n.entries)
+			continue
+		}
+		j := 0
+		for j < len(n.entries) && remove(n.entries[j], s.length) && (limit <= 0 || removed < limit) {
+			j++
+			s.length--
+			removed++
+		}
+		if j < len(n.entries) {
+			n.entries = n.entries[j:]
+			break
+		}
+		s.nodes = s.nodes[1:]
+	}
+	return removed
+}
+
+// TrimMaxLen removes the oldest entries until at most maxlen are left. When
+// approx is set only whole nodes are removed, so more may be left.
+func (s *Stream) TrimMaxLen(maxlen int, approx bool, limit int) int {
+	return s.trim(func(e StreamEntry, left int) bool { return left > maxlen }, approx, limit)
+}
+
+// TrimMinID removes the entries with IDs below minid. When approx is set
+// only whole nodes are removed, so some may be left.
+func (s *Stream) TrimMinID(minid StreamID, approx bool, limit int) int {
+	return s.trim(func(e StreamEntry, left int) bool { return e.ID.Less(minid) }, approx, limit)
+}
+
+// Clone returns a deep copy of the stream with its consumer groups. Entry
+// fields are never modified in place, so they are shared.
+func (s *Stream) Clone() *Stream {
+	c := *s
+	c.nodes = make([]*streamNode, len(s.nodes))
+	for i, n := range s.nodes {
+		c.nodes[i] = &streamNode{entries: slices.Clone(n.entries)}
+	}
+	c.groups = nil
+	for name, g := range s.groups {
+		if c.groups == nil {
+			c.groups = make(map[string]*ConsumerGroup, len(s.groups))
+		}
+		c.groups[name] = g.clone()
+	}
+	return &c
+}
+
+// Stream returns the stream stored at key. With create, a missing key is
+// set to a new empty stream; otherwise nil is returned for it. A key of
+// another type fails with ErrWrongType.
+func (tx *Tx) Stream(key string, create bool) (*Stream, error) {
+	e := tx.lookupOwned(key, TypeStream)
+	if e == nil {
+		if !create {
+			return nil, nil
+		}
+		s := NewStream()
+		tx.Put(key, TypeStream, s)
+		return s, nil
+	}
+	if e.Type != TypeStream {
+		return nil, ErrWrongType
+	}
+	return e.Value.(*Stream), nil
+}
@@ -0,0 +1,318 @@
+package storage
+
+import (
+	"slices"
+	"sort"
+)
+
+// EntriesRead of a group whose position in the stream isn't known, e.g.
+// after entries were deleted
+const InvalidEntriesRead = -1
+
+// PendingEntry is an entry delivered to a consumer of a group that wasn't
+// acknowledged yet.
+type PendingEntry struct {
+	ID       StreamID
+	Consumer *Consumer
+	// Unix time in milliseconds of the last delivery
+	DeliveryTime  int64
+	DeliveryCount uint64
+}
+
+// Consumer is a member of a consumer group, it owns the entries delivered
+// to it until they are acknowledged or claimed by another consumer.
+type Consumer struct {
+	Name string
+	// Unix time in milliseconds of the last attempted interaction
+	SeenTime int64
+	// Unix time in milliseconds of the last successful interaction, -1 when
+	// there was none
+	ActiveTime int64
+	pending    map[StreamID]*PendingEntry
+}
+
+// PendingCount returns the number of entries the consumer owns.
+func (c *Consumer) PendingCount() int {
+	return len(c.pending)
+}
+
+// ConsumerGroup delivers every entry of a stream to only one of its
+// consumers and keeps the delivered entries pending until acknowledged.
+type ConsumerGroup struct {
+	Name string
+	// ID of the last entry delivered to the group
+	LastID StreamID
+	// Logical read counter: the number of entries of the stream up to
+	// LastID, or InvalidEntriesRead
+	EntriesRead int64
+	// The pending entries list ordered by ID
+	pel       []*PendingEntry
+	consumers map[string]*Consumer
+}
+
+// CreateGroup adds a group that starts reading after lastID. It reports
+// false when a group of that name already exists.
+func (s *Stream) CreateGroup(name string, lastID StreamID, entriesRead int64) (*ConsumerGroup, bool) {
+	if s.groups == nil {
+		s.groups = make(map[string]*ConsumerGroup)
+	}
+	if _, ok := s.groups[name]; ok {
+		return nil, false
+	}
+	g := &ConsumerGroup{
+		Name:        name,
+		LastID:      lastID,
+		EntriesRead: entriesRead,
+		consumers:   make(map[string]*Consumer),
+	}
+	s.groups[name] = g
+	return g, true
+}
+
+// Group returns the group of that name or nil.
+func (s *Stream) Group(name string) *ConsumerGroup {
+	return s.groups[name]
+}
+
+func (s *Stream) DestroyGroup(name string) bool {
+	_, ok := s.groups[name]
+	delete(s.groups, name)
+	return ok
+}
+
+// Groups returns the groups of the stream ordered by name.
+func (s *Stream) Groups() []*ConsumerGroup {
+	res := make([]*ConsumerGroup, 0, len(s.groups))
+	for _, g := range s.groups {
+		res = append(res, g)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// Reports whether entries were deleted from the stream at or after start.
+// Logical read counters can't be derived from IDs across such a gap.
+func (s *Stream) hasTombstones(start StreamID) bool {
+	if s.length == 0 || s.MaxDeletedID == MinStreamID {
+		return false
+	}
+	return !s.MaxDeletedID.Less(start)
+}
+
+// Estimates the logical read counter of a group that read up to id, the
+// number of entries added to the stream up to id. It returns
+// InvalidEntriesRead when entries were deleted in between.
+func (s *Stream) entriesUpTo(id StreamID) int64 {
+	if s.EntriesAdded == 0 {
+		return 0
+	}
+	if s.length == 0 || !id.Less(s.LastID) {
+		return int64(s.EntriesAdded)
+	}
+	first, _ := s.First()
+	if s.MaxDeletedID == MinStreamID || s.MaxDeletedID.Less(first.ID) {
+		switch id.Compare(first.ID) {
+		case -1:
+			return int64(s.EntriesAdded) - int64(s.length)
+		case 0:
+			return int64(s.EntriesAdded) - int64(s.length) + 1
+		}
+	}
+	return InvalidEntriesRead
+}
+
+// Lag returns the number of entries of the stream not yet delivered to the
+// group. ok is false when it can't be known.
+func (s *Stream) Lag(g *ConsumerGroup) (lag int64, ok bool) {
+	if s.EntriesAdded == 0 {
+		return 0, true
+	}
+	read := g.EntriesRead
+	if read == InvalidEntriesRead || s.hasTombstones(g.LastID) {
+		read = s.entriesUpTo(g.LastID)
+	}
+	if read == InvalidEntriesRead {
+		return 0, false
+	}
+	return int64(s.EntriesAdded) - read, true
+}
+
+// SetGroupID moves the group to read after id. A negative entriesRead is
+// replaced by InvalidEntriesRead.
+func (s *Stream) SetGroupID(g *ConsumerGroup, id StreamID, entriesRead int64) {
+	g.LastID = id
+	g.EntriesRead = max(entriesRead, InvalidEntriesRead)
+}
+
+// Moves the group past an entry it just delivered
+func (s *Stream) advanceGroup(g *ConsumerGroup, id StreamID) {
+	if !g.LastID.Less(id) {
+		return
+	}
+	if g.EntriesRead != InvalidEntriesRead && !s.hasTombstones(id) {
+		g.EntriesRead++
+	} else {
+		g.EntriesRead = s.entriesUpTo(id)
+	}
+	g.LastID = id
+}
+
+// ReadGroup delivers up to count entries the group didn't see yet to
+// consumer c, all of them when count is 0. Unless noack is set, they are
+// added to the pending entries of c.
+func (s *Stream) ReadGroup(g *ConsumerGroup, c *Consumer, count int, noack bool, now int64) []StreamEntry {
+	start, ok := g.LastID.Next()
+	if !ok {
+		return nil
+	}
+	entries := s.Range(start, MaxStreamID, false, count)
+	for _, e := range entries {
+		s.advanceGroup(g, e.ID)
+		if noack {
+			continue
+		}
+		if p := g.Pending(e.ID); p != nil {
+			// Delivered before the group was moved back with SETID
+			g.Claim(p, c)
+			p.DeliveryTime = now
+			p.DeliveryCount++
+			continue
+		}
+		g.AddPending(e.ID, c, now)
+	}
+	if len(entries) > 0 {
+		c.ActiveTime = now
+	}
+	return entries
+}
+
+// Returns a deep copy of the group, its pending entries pointing to the
+// copies of their consumers
+func (g *ConsumerGroup) clone() *ConsumerGroup {
+	c := &ConsumerGroup{
+		Name:        g.Name,
+		LastID:      g.LastID,
+		EntriesRead: g.EntriesRead,
+		pel:         make([]*PendingEntry, len(g.pel)),
+		consumers:   make(map[string]*Consumer, len(g.consumers)),
+	}
+	for name, cons := range g.consumers {
+		c.consumers[name] = &Consumer{
+			Name:       cons.Name,
+			SeenTime:   cons.SeenTime,
+			ActiveTime: cons.ActiveTime,
+			pending:    make(map[StreamID]*PendingEntry, len(cons.pending)),
+		}
+	}
+	for i, p := range g.pel {
+		cp := *p
+		cp.Consumer = c.consumers[p.Consumer.Name]
+		cp.Consumer.pending[cp.ID] = &cp
+		c.pel[i] = &cp
+	}
+	return c
+}
+
+// Consumer returns the consumer of that name or nil.
+func (g *ConsumerGroup) Consumer(name string) *Consumer {
+	return g.consumers[name]
+}
+
+// CreateConsumer adds a consumer. It reports false and returns the existing
+// consumer when one of that name exists already.
+func (g *ConsumerGroup) CreateConsumer(name string, now int64) (*Consumer, bool) {
+	if c, ok := g.consumers[name]; ok {
+		return c, false
+	}
+	c := &Consumer{Name: name, SeenTime: now, ActiveTime: -1, pending: make(map[StreamID]*PendingEntry)}
+	g.consumers[name] = c
+	return c, true
+}
+
+// DeleteConsumer removes a consumer together with the entries pending for
+// it and returns their number. ok is false when there is no such consumer.
+func (g *ConsumerGroup) DeleteConsumer(name string) (pending int, ok bool) {
+	c, ok := g.consumers[name]
+	if !ok {
+		return 0, false
+	}
+	pending = len(c.pending)
+	if pending > 0 {
+		g.pel = slices.DeleteFunc(g.pel, func(p *PendingEntry) bool { return p.Consumer == c })
+	}
+	delete(g.consumers, name)
+	return pending, true
+}
+
+// Consumers returns the consumers of the group ordered by name.
+func (g *ConsumerGroup) Consumers() []*Consumer {
+	res := make([]*Consumer, 0, len(g.consumers))
+	for _, c := range g.consumers {
+		res = append(res, c)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// PendingLen returns the number of entries pending in the group.
+func (g *ConsumerGroup) PendingLen() int {
+	return len(g.pel)
+}
+
+// Index of the first pending entry with an ID >= id
+func (g *ConsumerGroup) seekPending(id StreamID) int {
+	return sort.Search(len(g.pel), func(i int) bool { return !g.pel[i].ID.Less(id) })
+}
+
+// Pending returns the pending entry with the given ID or nil.
+func (g *ConsumerGroup) Pending(id StreamID) *PendingEntry {
+	i := g.seekPending(id)
+	if i < len(g.pel) && g.pel[i].ID == id {
+		return g.pel[i]
+	}
+	return nil
+}
+
+// PendingRange returns the pending entries with IDs from start to end, of
+// consumer c only unless it is nil. A count above 0 limits their number.
+func (g *ConsumerGroup) PendingRange(start, end StreamID, count int, c *Consumer) []*PendingEntry {
+	var res []*PendingEntry
+	for i := g.seekPending(start); i < len(g.pel) && !end.Less(g.pel[i].ID); i++ {
+		if count > 0 && len(res) >= count {
+			break
+		}
+		if c == nil || g.pel[i].Consumer == c {
+			res = append(res, g.pel[i])
+		}
+	}
+	return res
+}
+
+// AddPending adds an entry delivered to c at now to the pending entries.
+// The entry must not be pending already.
+func (g *ConsumerGroup) AddPending(id StreamID, c *Consumer, now int64) *PendingEntry {
+	p := &PendingEntry{ID: id, Consumer: c, DeliveryTime: now, DeliveryCount: 1}
+	i := g.seekPending(id)
+	g.pel = slices.Insert(g.pel, i, p)
+	c.pending[id] = p
+	return p
+}
+
+// Claim transfers a pending entry to consumer c.
+func (g *ConsumerGroup) Claim(p *PendingEntry, c *Consumer) {
+	delete(p.Consumer.pending, p.ID)
+	p.Consumer = c
+	c.pending[p.ID] = p
+}
+
+// Ack removes an entry from the pending entries and reports whether it was
+// pending.
+func (g *ConsumerGroup) Ack(id StreamID) bool {
+	i := g.seekPending(id)
+	if i == len(g.pel) || g.pel[i].ID != id {
+		return false
+	}
+	delete(g.pel[i].Consumer.pending, id)
+	g.pel = slices.Delete(g.pel, i, i+1)
+	return true
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestConsumerGroup_ReadAndAck(t *testing.T) {
+	s := newTestStream(5)
+	g, _ := s.CreateGroup("g", MinStreamID, 0)
+	if _, ok := s.CreateGroup("g", MinStreamID, 0); ok {
+		t.Error("Created group g twice")
+	}
+	alice, _ := g.CreateConsumer("alice", 1)
+	bob, _ := g.CreateConsumer("bob", 1)
+
+	if got := ids(s.ReadGroup(g, alice, 2, false, 10)); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("Unexpected entries delivered to alice: %v", got)
+	}
+	if got := ids(s.ReadGroup(g, bob, 0, false, 20)); len(got) != 3 || got[0] != 3 {
+		t.Fatalf("Unexpected entries delivered to bob: %v", got)
+	}
+	if got := s.ReadGroup(g, bob, 0, false, 30); len(got) != 0 {
+		t.Errorf("Delivered entries twice: %v", ids(got))
+	}
+	if g.PendingLen() != 5 || alice.PendingCount() != 2 || bob.PendingCount() != 3 {
+		t.Errorf("Unexpected pending counts. Group: %d, alice: %d, bob: %d", g.PendingLen(), alice.PendingCount(), bob.PendingCount())
+	}
+	if p := g.Pending(StreamID{4, 0}); p == nil || p.Consumer != bob || p.DeliveryTime != 20 || p.DeliveryCount != 1 {
+		t.Errorf("Unexpected pending entry: %+v", p)
+	}
+
+	if !g.Ack(StreamID{1, 0}) || g.Ack(StreamID{1, 0}) {
+		t.Error("Unexpected result of acknowledging 1-0 twice")
+	}
+	g.Claim(g.Pending(StreamID{3, 0}), alice)
+	if got := g.PendingRange(MinStreamID, MaxStreamID, 0, alice); len(got) != 2 || got[1].ID != (StreamID{3, 0}) {
+		t.Errorf("Unexpected pending entries of alice after the claim: %v", got)
+	}
+	if n, ok := g.DeleteConsumer("bob"); !ok || n != 2 || g.PendingLen() != 2 {
+		t.Errorf("Unexpected result of deleting bob: %d, %v, %d left", n, ok, g.PendingLen())
+	}
+}
+
+func TestConsumerGroup_Lag(t *testing.T) {
+	s := newTestStream(5)
+	g, _ := s.CreateGroup("g", MinStreamID, InvalidEntriesRead)
+	c, _ := g.CreateConsumer("c", 0)
+	if lag, ok := s.Lag(g); !ok || lag != 5 {
+		t.Errorf("Unexpected lag of a new group: %d, %v", lag, ok)
+	}
+	s.ReadGroup(g, c, 2, true, 0)
+	if lag, ok := s.Lag(g); !ok || lag != 3 || g.EntriesRead != 2 {
+		t.Errorf("Unexpected lag after reading: %d, %v, read: %d", lag, ok, g.EntriesRead)
+	}
+	s.Delete(StreamID{4, 0})
+	if _, ok := s.Lag(g); ok {
+		t.Error("Lag is known across a deleted entry")
+	}
+	s.ReadGroup(g, c, 0, true, 0)
+	if lag, ok := s.Lag(g); !ok || lag != 0 {
+		t.Errorf("Unexpected lag after reading all: %d, %v", lag, ok)
+	}
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"math"
+	"testing"
+)
+
+// Returns a stream with entries 1-0 to n-0
+func newTestStream(n int) *Stream {
+	s := NewStream()
+	for i := 1; i <= n; i++ {
+		s.Add(StreamID{uint64(i), 0}, []string{"f", "v"})
+	}
+	return s
+}
+
+func ids(entries []StreamEntry) []uint64 {
+	res := make([]uint64, len(entries))
+	for i, e := range entries {
+		res[i] = e.ID.Ms
+	}
+	return res
+}
+
+func TestStreamID(t *testing.T) {
+	s := NewStream()
+	s.Add(StreamID{5, 3}, nil)
+	if id, _ := s.NextID(4); id != (StreamID{5, 4}) {
+		t.Errorf("Unexpected ID for an earlier time: %s", id)
+	}
+	if id, _ := s.NextID(6); id != (StreamID{6, 0}) {
+		t.Errorf("Unexpected ID for a later time: %s", id)
+	}
+	s.Add(StreamID{math.MaxUint64, math.MaxUint64}, nil)
+	if _, err := s.NextID(0); err != ErrStreamExhausted {
+		t.Errorf("Unexpected error for an exhausted stream: %v", err)
+	}
+	if id, ok := ParseStreamID("7", 9); !ok || id != (StreamID{7, 9}) {
+		t.Errorf("Unexpected ID parsed from ms alone: %s", id)
+	}
+	for _, invalid := range []string{"", "-", "1-", "a-1", "1-2-3", "-1"} {
+		if _, ok := ParseStreamID(invalid, 0); ok {
+			t.Errorf("%q: parsed as a valid ID", invalid)
+		}
+	}
+}
+
+func TestStream_Range(t *testing.T) {
+	s := newTestStream(250)
+	cases := []struct {
+		start, end uint64
+		reverse    bool
+		count      int
+		expected   []uint64
+	}{
+		{98, 102, false, 0, []uint64{98, 99, 100, 101, 102}},
+		{98, 102, true, 3, []uint64{102, 101, 100}},
+		{249, 300, false, 0, []uint64{249, 250}},
+		{0, 1, true, 0, []uint64{1}},
+		{260, 300, true, 0, []uint64{}},
+		{5, 4, false, 0, []uint64{}},
+	}
+	for _, c := range cases {
+		got := ids(s.Range(StreamID{c.start, 0}, StreamID{c.end, 0}, c.reverse, c.count))
+		if len(got) != len(c.expected) {
+			t.Errorf("%v: unexpected entries. Expected: %v, Got: %v", c, c.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.expected[i] {
+				t.Errorf("%v: unexpected entries. Expected: %v, Got: %v", c, c.expected, got)
+				break
+			}
+		}
+	}
+}
+
+func TestStream_Delete(t *testing.T) {
+	s := newTestStream(150)
+	for i := 1; i <= 100; i++ {
+		if !s.Delete(StreamID{uint64(i), 0}) {
+			t.Fatalf("Entry %d-0 was not deleted", i)
+		}
+	}
+	if s.Delete(StreamID{1, 0}) {
+		t.Error("Deleted entry 1-0 twice")
+	}
+	if first, _ := s.First(); first.ID != (StreamID{101, 0}) || s.Len() != 50 {
+		t.Errorf("Unexpected stream after deletes. First: %s, Len: %d", first.ID, s.Len())
+	}
+	if s.MaxDeletedID != (StreamID{100, 0}) || s.LastID != (StreamID{150, 0}) {
+		t.Errorf("Unexpected IDs after deletes. MaxDeleted: %s, Last: %s", s.MaxDeletedID, s.LastID)
+	}
+}
+
+func TestStream_Trim(t *testing.T) {
+	s := newTestStream(250)
+	if n := s.TrimMaxLen(180, true, 0); n != 0 || s.Len() != 250 {
+		t.Errorf("Approximate trim removed a partial node: %d", n)
+	}
+	if n := s.TrimMaxLen(150, true, 0); n != 100 || s.Len() != 150 {
+		t.Errorf("Approximate trim didn't remove the first node: %d", n)
+	}
+	if n := s.TrimMaxLen(120, false, 0); n != 30 || s.Len() != 120 {
+		t.Errorf("Unexpected exact trim: %d", n)
+	}
+	if n := s.TrimMinID(StreamID{200, 0}, true, 0); n != 0 {
+		t.Errorf("Approximate MINID trim removed a partial node: %d", n)
+	}
+	if n := s.TrimMinID(StreamID{200, 0}, false, 0); n != 69 {
+		t.Errorf("Unexpected exact MINID trim: %d", n)
+	}
+	if first, _ := s.First(); first.ID != (StreamID{200, 0}) {
+		t.Errorf("Unexpected first entry after trimming: %s", first.ID)
+	}
+	if n := s.TrimMaxLen(0, false, 0); n != 51 || s.Len() != 0 {
+		t.Errorf("Unexpected trim to zero: %d", n)
+	}
+}
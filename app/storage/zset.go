@@ -0,0 +1,528 @@
+package storage
+
+import (
+	"math"
+	"math/rand"
+)
+
+const (
+	// Small sorted sets are reported as listpack encoded, like Redis'
+	// zset-max-listpack-*
+	zsetMaxListpackEntries = 128
+	zsetMaxListpackValue   = 64
+
+	zskiplistMaxLevel = 32
+	// Probability of a node to get one more level
+	zskiplistP = 0.25
+)
+
+// ZMember is a member of a sorted set with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// ScoreRange is an interval of scores, each bound may be exclusive.
+type ScoreRange struct {
+	Min, Max     float64
+	MinEx, MaxEx bool
+}
+
+func (r *ScoreRange) gteMin(score float64) bool {
+	if r.MinEx {
+		return score > r.Min
+	}
+	return score >= r.Min
+}
+
+func (r *ScoreRange) lteMax(score float64) bool {
+	if r.MaxEx {
+		return score < r.Max
+	}
+	return score <= r.Max
+}
+
+func (r *ScoreRange) empty() bool {
+	return r.Min > r.Max || (r.Min == r.Max && (r.MinEx || r.MaxEx))
+}
+
+// LexRange is an interval of members compared byte by byte, used when all
+// members have the same score. MinInf and MaxInf stand for "-" and "+".
+type LexRange struct {
+	Min, Max       string
+	MinEx, MaxEx   bool
+	MinInf, MaxInf bool
+}
+
+func (r *LexRange) gteMin(m string) bool {
+	switch {
+	case r.MinInf:
+		return true
+	case r.MinEx:
+		return m > r.Min
+	}
+	return m >= r.Min
+}
+
+func (r *LexRange) lteMax(m string) bool {
+	switch {
+	case r.MaxInf:
+		return true
+	case r.MaxEx:
+		return m < r.Max
+	}
+	return m <= r.Max
+}
+
+func (r *LexRange) empty() bool {
+	if r.MinInf || r.MaxInf {
+		return false
+	}
+	return r.Min > r.Max || (r.Min == r.Max && (r.MinEx || r.MaxEx))
+}
+
+// Skiplist ordered by score, then member, with spans for rank queries.
+// It follows the design of Redis' zskiplist.
+type zskiplist struct {
+	header *zskiplistNode
+	tail   *zskiplistNode
+	length int
+	level  int
+}
+
+type zskiplistNode struct {
+	member   string
+	score    float64
+	backward *zskiplistNode
+	level    []zskiplistLevel
+}
+
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	// Number of nodes the forward link skips over
+	span int
+}
+
+func newZskiplist() *zskiplist {
+	return &zskiplist{
+		header: &zskiplistNode{level: make([]zskiplistLevel, zskiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+// Reports whether the node sorts before score and member
+func (n *zskiplistNode) less(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+func (zsl *zskiplist) insert(score float64, member string) {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	var rank [zskiplistMaxLevel]int
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	level := randomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+	x = &zskiplistNode{member: member, score: score, level: make([]zskiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+}
+
+func (zsl *zskiplist) deleteNode(x *zskiplistNode, update *[zskiplistMaxLevel]*zskiplistNode) {
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+func (zsl *zskiplist) delete(score float64, member string) bool {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+	zsl.deleteNode(x, &update)
+	return true
+}
+
+// Returns the 1 based rank of the element, 0 when it is not in the list
+func (zsl *zskiplist) rank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && (x.level[i].forward.less(score, member) ||
+			(x.level[i].forward.score == score && x.level[i].forward.member == member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// Returns the node at the 1 based rank
+func (zsl *zskiplist) byRank(rank int) *zskiplistNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// Returns the first node after the ones for which before holds, given that
+// those all come first
+func (zsl *zskiplist) first(before func(n *zskiplistNode) bool) *zskiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && before(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward
+}
+
+// Returns the last node for which notAfter holds
+func (zsl *zskiplist) last(notAfter func(n *zskiplistNode) bool) *zskiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && notAfter(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	if x == zsl.header {
+		return nil
+	}
+	return x
+}
+
+// ZSet is a sorted set: unique members ordered by score, then by member.
+// Like in Redis it is a skiplist for ordered access plus a map from member
+// to score.
+type ZSet struct {
+	dict map[string]float64
+	// The members of dict again, for ZSCAN
+	keys keyTable
+	zsl  *zskiplist
+	// Still small enough to count as listpack encoded
+	listpack bool
+}
+
+func NewZSet() *ZSet {
+	return &ZSet{dict: make(map[string]float64), zsl: newZskiplist(), listpack: true}
+}
+
+func (z *ZSet) Len() int {
+	return len(z.dict)
+}
+
+func (z *ZSet) Encoding() string {
+	if z.listpack {
+		return EncodingListpack
+	}
+	return EncodingSkiplist
+}
+
+func (z *ZSet) Score(member string) (float64, bool) {
+	s, ok := z.dict[member]
+	return s, ok
+}
+
+// Add sets the score of member and reports whether the member is new.
+func (z *ZSet) Add(member string, score float64) bool {
+	old, exists := z.dict[member]
+	if exists {
+		if old != score {
+			z.zsl.delete(old, member)
+			z.zsl.insert(score, member)
+			z.dict[member] = score
+		}
+		return false
+	}
+	z.zsl.insert(score, member)
+	z.dict[member] = score
+	z.keys.add(member)
+	if z.listpack && (len(z.dict) > zsetMaxListpackEntries || len(member) > zsetMaxListpackValue) {
+		z.listpack = false
+	}
+	return true
+}
+
+// Scan calls fn with the members of one bucket and returns the cursor of
+// the next, 0 once all were visited, like Hash.Scan. Listpack encoded
+// sorted sets are visited whole in one call, in order of their scores.
+func (z *ZSet) Scan(cursor uint64, fn func(member string)) uint64 {
+	if !z.listpack {
+		return z.keys.scan(cursor, fn)
+	}
+	z.Each(func(member string, score float64) bool {
+		fn(member)
+		return true
+	})
+	return 0
+}
+
+// Clone returns a deep copy of the sorted set in the same encoding.
+func (z *ZSet) Clone() *ZSet {
+	c := NewZSet()
+	z.Each(func(member string, score float64) bool {
+		c.Add(member, score)
+		return true
+	})
+	c.listpack = z.listpack
+	return c
+}
+
+// Remove deletes member and reports whether it existed.
+func (z *ZSet) Remove(member string) bool {
+	score, ok := z.dict[member]
+	if !ok {
+		return false
+	}
+	z.zsl.delete(score, member)
+	delete(z.dict, member)
+	z.keys.remove(member)
+	return true
+}
+
+// Rank returns the 0 based position of member, counted from the lowest
+// score or, with reverse, from the highest.
+func (z *ZSet) Rank(member string, reverse bool) (int, bool) {
+	score, ok := z.dict[member]
+	if !ok {
+		return 0, false
+	}
+	rank := z.zsl.rank(score, member) - 1
+	if reverse {
+		rank = z.Len() - 1 - rank
+	}
+	return rank, true
+}
+
+// Collects up to limit members starting at node x, walking backwards with
+// reverse, while inRange holds. limit < 0 means no limit.
+func collect(x *zskiplistNode, reverse bool, limit int, inRange func(n *zskiplistNode) bool) []ZMember {
+	var res []ZMember
+	for x != nil && limit != 0 && inRange(x) {
+		res = append(res, ZMember{x.member, x.score})
+		limit--
+		if reverse {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return res
+}
+
+// Walks offset nodes from x in the direction of the range
+func skip(x *zskiplistNode, reverse bool, offset int) *zskiplistNode {
+	for ; x != nil && offset > 0; offset-- {
+		if reverse {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return x
+}
+
+// RangeByRank returns the members between the 0 based ranks start and stop
+// inclusive, which must be in range. With reverse ranks count from the
+// highest score.
+func (z *ZSet) RangeByRank(start, stop int, reverse bool) []ZMember {
+	if start > stop || start >= z.Len() {
+		return nil
+	}
+	rank := start + 1
+	if reverse {
+		rank = z.Len() - start
+	}
+	return collect(z.zsl.byRank(rank), reverse, stop-start+1, func(*zskiplistNode) bool { return true })
+}
+
+// Returns the first node of the score range, or the last one with reverse
+func (z *ZSet) scoreRangeStart(r *ScoreRange, reverse bool) *zskiplistNode {
+	if r.empty() {
+		return nil
+	}
+	if reverse {
+		return z.zsl.last(func(n *zskiplistNode) bool { return r.lteMax(n.score) })
+	}
+	return z.zsl.first(func(n *zskiplistNode) bool { return !r.gteMin(n.score) })
+}
+
+// RangeByScore returns the members with a score in r, skipping offset of
+// them and returning at most limit, or all with a negative limit. With
+// reverse the highest scores come first.
+func (z *ZSet) RangeByScore(r ScoreRange, reverse bool, offset, limit int) []ZMember {
+	x := skip(z.scoreRangeStart(&r, reverse), reverse, offset)
+	return collect(x, reverse, limit, func(n *zskiplistNode) bool {
+		return r.gteMin(n.score) && r.lteMax(n.score)
+	})
+}
+
+func (z *ZSet) lexRangeStart(r *LexRange, reverse bool) *zskiplistNode {
+	if r.empty() {
+		return nil
+	}
+	if reverse {
+		return z.zsl.last(func(n *zskiplistNode) bool { return r.lteMax(n.member) })
+	}
+	return z.zsl.first(func(n *zskiplistNode) bool { return !r.gteMin(n.member) })
+}
+
+// RangeByLex is RangeByScore for members in a lexicographical range. The
+// result is only meaningful when all members have the same score.
+func (z *ZSet) RangeByLex(r LexRange, reverse bool, offset, limit int) []ZMember {
+	x := skip(z.lexRangeStart(&r, reverse), reverse, offset)
+	return collect(x, reverse, limit, func(n *zskiplistNode) bool {
+		return r.gteMin(n.member) && r.lteMax(n.member)
+	})
+}
+
+// Returns the number of nodes from first to last inclusive, found by rank
+func (z *ZSet) countBetween(first, last *zskiplistNode) int {
+	if first == nil || last == nil {
+		return 0
+	}
+	n := z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+	return max(n, 0)
+}
+
+// Count returns the number of members with a score in r.
+func (z *ZSet) Count(r ScoreRange) int {
+	return z.countBetween(z.scoreRangeStart(&r, false), z.scoreRangeStart(&r, true))
+}
+
+// LexCount returns the number of members in the lexicographical range r.
+func (z *ZSet) LexCount(r LexRange) int {
+	return z.countBetween(z.lexRangeStart(&r, false), z.lexRangeStart(&r, true))
+}
+
+func (z *ZSet) removeAll(members []ZMember) int {
+	for _, m := range members {
+		z.Remove(m.Member)
+	}
+	return len(members)
+}
+
+// RemoveRangeByRank deletes the members between the 0 based ranks start and
+// stop inclusive and returns how many were deleted.
+func (z *ZSet) RemoveRangeByRank(start, stop int) int {
+	return z.removeAll(z.RangeByRank(start, stop, false))
+}
+
+func (z *ZSet) RemoveRangeByScore(r ScoreRange) int {
+	return z.removeAll(z.RangeByScore(r, false, 0, -1))
+}
+
+func (z *ZSet) RemoveRangeByLex(r LexRange) int {
+	return z.removeAll(z.RangeByLex(r, false, 0, -1))
+}
+
+// Pop removes and returns up to count members with the lowest scores, or
+// the highest ones with highest.
+func (z *ZSet) Pop(count int, highest bool) []ZMember {
+	res := z.RangeByRank(0, count-1, highest)
+	z.removeAll(res)
+	return res
+}
+
+// Each calls fn for every member in score order until fn returns false.
+func (z *ZSet) Each(fn func(member string, score float64) bool) {
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		if !fn(x.member, x.score) {
+			return
+		}
+	}
+}
+
+// FullScoreRange contains every score.
+var FullScoreRange = ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)}
+
+// ZSet returns the sorted set stored at key. With create, a missing key is
+// set to a new empty sorted set; otherwise nil is returned for it. A key of
+// another type fails with ErrWrongType.
+func (tx *Tx) ZSet(key string, create bool) (*ZSet, error) {
+	e := tx.lookupOwned(key, TypeZSet)
+	if e == nil {
+		if !create {
+			return nil, nil
+		}
+		z := NewZSet()
+		tx.Put(key, TypeZSet, z)
+		return z, nil
+	}
+	if e.Type != TypeZSet {
+		return nil, ErrWrongType
+	}
+	return e.Value.(*ZSet), nil
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestZSet_OrderAndRank(t *testing.T) {
+	z := NewZSet()
+	var expected []ZMember
+	for _, i := range rand.Perm(1000) {
+		m := ZMember{"m" + strconv.Itoa(i), float64(i % 100)}
+		z.Add(m.Member, m.Score)
+		expected = append(expected, m)
+	}
+	sort.Slice(expected, func(i, j int) bool {
+		a, b := expected[i], expected[j]
+		return a.Score < b.Score || (a.Score == b.Score && a.Member < b.Member)
+	})
+	for i, m := range expected {
+		if rank, ok := z.Rank(m.Member, false); !ok || rank != i {
+			t.Fatalf("Unexpected rank of %q. Expected: %d, Got: %d", m.Member, i, rank)
+		}
+	}
+	got := z.RangeByRank(10, 14, false)
+	for i, m := range got {
+		if m != expected[10+i] {
+			t.Errorf("Unexpected member at rank %d. Expected: %v, Got: %v", 10+i, expected[10+i], m)
+		}
+	}
+	if got := z.RangeByRank(0, 0, true); got[0] != expected[len(expected)-1] {
+		t.Errorf("Unexpected highest member: %v", got[0])
+	}
+	if z.Encoding() != EncodingSkiplist {
+		t.Errorf("Unexpected encoding. Expected: %q, Got: %q", EncodingSkiplist, z.Encoding())
+	}
+}
+
+func TestZSet_ScoreRanges(t *testing.T) {
+	z := NewZSet()
+	for i := 1; i <= 10; i++ {
+		z.Add(strconv.Itoa(i), float64(i))
+	}
+	z.Add("5", 50)
+	cases := []struct {
+		r        ScoreRange
+		expected int
+	}{
+		{ScoreRange{Min: 2, Max: 4}, 3},
+		{ScoreRange{Min: 2, Max: 4, MinEx: true, MaxEx: true}, 1},
+		{ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)}, 10},
+		{ScoreRange{Min: 4.5, Max: 5.5}, 0},
+		{ScoreRange{Min: 7, Max: 3}, 0},
+		{ScoreRange{Min: 3, Max: 3, MaxEx: true}, 0},
+	}
+	for _, c := range cases {
+		if n := z.Count(c.r); n != c.expected {
+			t.Errorf("Unexpected count in %+v. Expected: %d, Got: %d", c.r, c.expected, n)
+		}
+		if n := len(z.RangeByScore(c.r, true, 0, -1)); n != c.expected {
+			t.Errorf("Unexpected reverse range size in %+v. Expected: %d, Got: %d", c.r, c.expected, n)
+		}
+	}
+	got := z.RangeByScore(ScoreRange{Min: 1, Max: 100}, true, 1, 2)
+	if len(got) != 2 || got[0].Member != "10" || got[1].Member != "9" {
+		t.Errorf("Unexpected reverse range with limit: %v", got)
+	}
+	if n := z.RemoveRangeByScore(ScoreRange{Min: 9, Max: 100}); n != 3 || z.Len() != 7 {
+		t.Errorf("Unexpected removal. Expected: 3, Got: %d", n)
+	}
+	if popped := z.Pop(2, false); len(popped) != 2 || popped[0].Member != "1" || z.Len() != 5 {
+		t.Errorf("Unexpected popped members: %v", popped)
+	}
+}
+
+func TestZSet_LexRanges(t *testing.T) {
+	z := NewZSet()
+	for _, m := range []string{"a", "b", "c", "d", "e"} {
+		z.Add(m, 0)
+	}
+	cases := []struct {
+		r        LexRange
+		expected int
+	}{
+		{LexRange{MinInf: true, MaxInf: true}, 5},
+		{LexRange{Min: "b", Max: "d"}, 3},
+		{LexRange{Min: "b", Max: "d", MinEx: true}, 2},
+		{LexRange{Min: "bb", MaxInf: true}, 3},
+		{LexRange{Min: "d", Max: "b"}, 0},
+	}
+	for _, c := range cases {
+		if n := z.LexCount(c.r); n != c.expected {
+			t.Errorf("Unexpected count in %+v. Expected: %d, Got: %d", c.r, c.expected, n)
+		}
+		if n := len(z.RangeByLex(c.r, false, 0, -1)); n != c.expected {
+			t.Errorf("Unexpected range size in %+v. Expected: %d, Got: %d", c.r, c.expected, n)
+		}
+	}
+}